@@ -0,0 +1,211 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//GetUsage returns the account's usage against its quotas
+func (c *Client) GetUsage(ctx context.Context, name string, opts ...CallOption) (types.Usage, error) {
+	var result types.Usage
+	err := c.do(ctx, newCall("GET", listPath(name, "usage"), opts), &result)
+	return result, err
+}
+
+//GetSettings returns the account's settings
+func (c *Client) GetSettings(ctx context.Context, name string, opts ...CallOption) (types.UserSettings, error) {
+	var result types.UserSettings
+	err := c.do(ctx, newCall("GET", listPath(name, "settings"), opts), &result)
+	return result, err
+}
+
+//UpdateSettings changes the settings set in update and returns every setting
+func (c *Client) UpdateSettings(ctx context.Context, name string, update types.SettingsUpdate, opts ...CallOption) (types.UserSettings, error) {
+	var result types.UserSettings
+	cl, err := newCall("POST", listPath(name, "settings"), opts).withJSON(update)
+	if err != nil {
+		return result, err
+	}
+	err = c.do(ctx, cl, &result)
+	return result, err
+}
+
+//PreviewDigest returns the account's digest as it would be emailed now
+func (c *Client) PreviewDigest(ctx context.Context, name string, opts ...CallOption) (types.DigestPreview, error) {
+	var result types.DigestPreview
+	err := c.do(ctx, newCall("GET", listPath(name, "digest"), opts), &result)
+	return result, err
+}
+
+//snooze sends a snooze request
+func (c *Client) snooze(ctx context.Context, name string, id int, body interface{}, opts []CallOption) (types.TodoData, error) {
+	var result types.TodoData
+	cl, err := newCall("POST", listPath(name, "id", strconv.Itoa(id), "snooze"), opts).withJSON(body)
+	if err != nil {
+		return result, err
+	}
+	err = c.do(ctx, cl, &result)
+	return result, err
+}
+
+//Snooze moves the reminder of a todo item to until
+func (c *Client) Snooze(ctx context.Context, name string, id int, until time.Time, opts ...CallOption) (types.TodoData, error) {
+	return c.snooze(ctx, name, id, map[string]time.Time{"until": until}, opts)
+}
+
+//SnoozeMinutes moves the reminder of a todo item to the given number of minutes from now
+func (c *Client) SnoozeMinutes(ctx context.Context, name string, id int, minutes int, opts ...CallOption) (types.TodoData, error) {
+	return c.snooze(ctx, name, id, map[string]int{"minutes": minutes}, opts)
+}
+
+//Dismiss stops the current reminder of a todo item from being sent
+func (c *Client) Dismiss(ctx context.Context, name string, id int, opts ...CallOption) (types.ListStatus, error) {
+	var result types.ListStatus
+	err := c.do(ctx, newCall("POST", listPath(name, "id", strconv.Itoa(id), "dismiss"), opts), &result)
+	return result, err
+}
+
+//AddWebhook subscribes the URL of hook to the list's changes.  The secret is generated if hook has none, and is only returned here
+func (c *Client) AddWebhook(ctx context.Context, name string, hook types.Webhook, opts ...CallOption) (types.Webhook, error) {
+	var result types.Webhook
+	body := map[string]interface{}{"url": hook.URL, "events": hook.Events}
+	if hook.Secret != "" {
+		body["secret"] = hook.Secret
+	}
+	cl, err := newCall("POST", listPath(name, "webhooks"), opts).withJSON(body)
+	if err != nil {
+		return result, err
+	}
+	err = c.do(ctx, cl, &result)
+	return result, err
+}
+
+//GetWebhooks returns the list's webhooks
+func (c *Client) GetWebhooks(ctx context.Context, name string, opts ...CallOption) ([]types.Webhook, error) {
+	var result []types.Webhook
+	err := c.do(ctx, newCall("GET", listPath(name, "webhooks"), opts), &result)
+	return result, err
+}
+
+//UpdateWebhook changes the fields of a webhook set in update
+func (c *Client) UpdateWebhook(ctx context.Context, name string, id int, update types.WebhookUpdate, opts ...CallOption) (types.Webhook, error) {
+	var result types.Webhook
+	cl, err := newCall("POST", listPath(name, "webhooks", strconv.Itoa(id)), opts).withJSON(update)
+	if err != nil {
+		return result, err
+	}
+	err = c.do(ctx, cl, &result)
+	return result, err
+}
+
+//RemoveWebhook removes a webhook and its deliveries
+func (c *Client) RemoveWebhook(ctx context.Context, name string, id int, opts ...CallOption) (types.ListStatus, error) {
+	var result types.ListStatus
+	err := c.do(ctx, newCall("DELETE", listPath(name, "webhooks", strconv.Itoa(id)), opts), &result)
+	return result, err
+}
+
+//GetDeliveries returns the most recent deliveries to a webhook
+func (c *Client) GetDeliveries(ctx context.Context, name string, id int, opts ...CallOption) ([]types.WebhookDelivery, error) {
+	var result []types.WebhookDelivery
+	err := c.do(ctx, newCall("GET", listPath(name, "webhooks", strconv.Itoa(id), "deliveries"), opts), &result)
+	return result, err
+}
+
+//Redeliver queues the payload of a delivery to a webhook to be posted again
+func (c *Client) Redeliver(ctx context.Context, name string, id int, delivery int64, opts ...CallOption) (types.WebhookDelivery, error) {
+	var result types.WebhookDelivery
+	path := listPath(name, "webhooks", strconv.Itoa(id), "deliveries", strconv.FormatInt(delivery, 10), "redeliver")
+	err := c.do(ctx, newCall("POST", path, opts), &result)
+	return result, err
+}
+
+//IssueFeedToken creates the secret token of the list's read-only calendar feed, replacing any earlier token
+func (c *Client) IssueFeedToken(ctx context.Context, name string, opts ...CallOption) (types.FeedToken, error) {
+	var result types.FeedToken
+	err := c.do(ctx, newCall("POST", listPath(name, "feed"), opts), &result)
+	return result, err
+}
+
+//RevokeFeedToken stops the list's feed token from working
+func (c *Client) RevokeFeedToken(ctx context.Context, name string, opts ...CallOption) (types.ListStatus, error) {
+	return c.remove(ctx, name, "feed", nil, opts)
+}
+
+//Feed returns the iCalendar feed of the list a feed token belongs to
+func (c *Client) Feed(ctx context.Context, token string, opts ...CallOption) ([]byte, error) {
+	return c.doBytes(ctx, newCall("GET", "/feed/"+token+".ics", opts))
+}
+
+//IssueCalDAVToken creates the secret password for signing in to the list over CalDAV, replacing any earlier password
+func (c *Client) IssueCalDAVToken(ctx context.Context, name string, opts ...CallOption) (types.CalDAVToken, error) {
+	var result types.CalDAVToken
+	err := c.do(ctx, newCall("POST", listPath(name, "caldav"), opts), &result)
+	return result, err
+}
+
+//RevokeCalDAVToken stops the list's CalDAV password from working
+func (c *Client) RevokeCalDAVToken(ctx context.Context, name string, opts ...CallOption) (types.ListStatus, error) {
+	return c.remove(ctx, name, "caldav", nil, opts)
+}
+
+//IssueInboundAddress creates the secret email address that adds mail to the list, replacing any earlier address
+func (c *Client) IssueInboundAddress(ctx context.Context, name string, opts ...CallOption) (types.InboundAddress, error) {
+	var result types.InboundAddress
+	err := c.do(ctx, newCall("POST", listPath(name, "inbound"), opts), &result)
+	return result, err
+}
+
+//RevokeInboundAddress stops the list's inbound email address from working
+func (c *Client) RevokeInboundAddress(ctx context.Context, name string, opts ...CallOption) (types.ListStatus, error) {
+	return c.remove(ctx, name, "inbound", nil, opts)
+}
+
+//PostInbound adds a raw RFC 5322 message to the list of the inbound address to, or if to is empty, of the first inbound address in its headers
+func (c *Client) PostInbound(ctx context.Context, to string, message []byte, opts ...CallOption) (types.TodoData, error) {
+	var result types.TodoData
+	cl := newCall("POST", "/inbound", opts)
+	if to != "" {
+		cl.query.Set("to", to)
+	}
+	cl.body, cl.contentType = message, "message/rfc822"
+	err := c.do(ctx, cl, &result)
+	return result, err
+}
+
+//GetAudit returns the audit records across every account matching filter, newest first.  It needs a client made WithToken and the admin token
+func (c *Client) GetAudit(ctx context.Context, filter types.AuditFilter, opts ...CallOption) ([]types.AuditRecord, error) {
+	var result []types.AuditRecord
+	cl := newCall("GET", "/admin/audit", opts)
+	if filter.Name != "" {
+		cl.query.Set("user", filter.Name)
+	}
+	if filter.Actor != "" {
+		cl.query.Set("actor", filter.Actor)
+	}
+	if filter.TodoID != 0 {
+		cl.query.Set("todo_id", strconv.Itoa(filter.TodoID))
+	}
+	if !filter.From.IsZero() {
+		cl.query.Set("from", filter.From.Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		cl.query.Set("to", filter.To.Format(time.RFC3339))
+	}
+	if filter.Limit != 0 {
+		cl.query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	err := c.do(ctx, cl, &result)
+	return result, err
+}
+
+//GetMetrics returns the service's metrics, by name.  It needs a client made WithToken and the admin token
+func (c *Client) GetMetrics(ctx context.Context, opts ...CallOption) (map[string]json.RawMessage, error) {
+	var result map[string]json.RawMessage
+	err := c.do(ctx, newCall("GET", "/admin/metrics", opts), &result)
+	return result, err
+}
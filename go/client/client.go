@@ -1,256 +1,314 @@
+//Package client is the Go client for the shale API.  A Client has a method for every endpoint of the REST API; the CalDAV and WebSocket APIs are left to CalDAV and WebSocket clients.
+//
+//	c := client.New("http://localhost:8080", client.WithToken(token))
+//	todo, err := c.AddTodo(ctx, "tom", types.TodoData{Title: "Buy milk"})
+//	if client.IsCode(err, client.CodeQuotaExceeded) {
+//		...
+//	}
+//
+//Failed requests return an *Error decoded from the server's problem body.  Requests that are safe to repeat are retried on network errors, 429 responses, and 5xx responses: every GET, and POST and DELETE requests sent with an idempotency key
 package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
+	mathrand "math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/bdlm/log"
+	"github.com/shale/go/types"
 )
 
-//Get todos:  curl -vv 73.78.155.49:8080/todo/tom
-func runGetTodos() {
-	route := "http://localhost:8080/todo/jpw"
-	req, err := http.NewRequest("GET", route, nil)
-	if err != nil {
-		log.Infof("Error %v", err)
-	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Infof("Error %v", err)
-	}
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
-	log.Infof(string(body))
+//Retry defaults
+const (
+	DefaultRetries    = 2
+	DefaultMinBackoff = 200 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+)
+
+//Client calls the shale API at a base URL.  It is safe for concurrent use
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	headers    http.Header
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	autoKeys   bool
+	err        error
 }
 
-//Get Active:  curl -vv 73.78.155.49:8080/todo/tom/active/1
-func runGetActives() {
-	route := "http://localhost:8080/todo/jpw/active/1"
-	req, err := http.NewRequest("GET", route, nil)
-	if err != nil {
-		log.Infof("Error %v", err)
-	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Infof("Error %v", err)
+//Option configures a Client
+type Option func(*Client)
+
+//WithHTTPClient sends requests with hc instead of http.DefaultClient
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
 	}
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
-	log.Infof(string(body))
 }
 
-//ByPriority:  curl -vv 73.78.155.49:8080/todo/tom/highs/6
-func runGetByPriority() {
-	route := "http://localhost:8080/todo/jpw/pri/2"
-	req, err := http.NewRequest("GET", route, nil)
-	if err != nil {
-		log.Infof("Error %v", err)
-	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Infof("Error %v", err)
-	}
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
-	log.Infof(string(body))
+//WithToken sends token as a bearer token in the Authorization header of every request, as the admin API requires
+func WithToken(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
 }
 
-//ByCategory:  curl -vv 73.78.155.49:8080/todo/tom/cat/shopping
-func runGetByCategory() {
-	route := "http://localhost:8080/todo/jpw/cat/shopping"
-	req, err := http.NewRequest("GET", route, nil)
-	if err != nil {
-		log.Infof("Error %v", err)
-	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Infof("Error %v", err)
+//WithHeader sends a header with every request, such as the credentials of a gateway in front of the API
+func WithHeader(key string, value string) Option {
+	return func(c *Client) {
+		c.headers.Set(key, value)
 	}
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
-	log.Infof(string(body))
 }
 
-//ByID:  curl -vv 73.78.155.49:8080/todo/tom/id/4
-func runGetByID() {
-	route := "http://localhost:8080/todo/jpw/id/4"
-	req, err := http.NewRequest("GET", route, nil)
-	if err != nil {
-		log.Infof("Error %v", err)
-	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Infof("Error %v", err)
+//WithRetries sets how many times a failed request that is safe to repeat is retried.  Zero turns retries off
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		c.retries = retries
 	}
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
-	log.Infof(string(body))
 }
 
-//Add:
-//curl -v -X POST localhost:8080/todo/tom/add --data "{\"title\": \"#2\", \"item_priority\": 5, \"category\": \"shopping\"}"
-//curl -v -X POST localhost:8080/todo/tom/add --data "{\"title\": \"Brand New\", \"body\": \"This is fun\", \"item_priority\": 8, \"category\": \"entertainment\"}"
-func runAdd() {
-	route := "http://localhost:8080/todo/jpw/add"
-	payload := map[string]interface{}{
-		"title":         "Test Add",
-		"item_priority": 3,
-		"category":      "Tests",
-	}
-	byteMap, err := json.Marshal(payload)
-	if err != nil {
-		log.Errorf("Error: %v", err)
-	}
-	resp, err := http.Post(route, "application/json", bytes.NewBuffer(byteMap))
-	if err != nil {
-		log.Errorf("Error: %v", err)
+//WithBackoff sets the wait before the first retry, which doubles with each retry up to max.  A Retry-After header from the server overrides it
+func WithBackoff(min time.Duration, max time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff, c.maxBackoff = min, max
 	}
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	log.Infof("result: %v", result)
 }
 
-//Change Title:  curl -v -X POST localhost:8080/todo/tom/ctitle/4 --data "{\"title\": \"Changed TITLE\"}"
-func runChangeTitle() {
-	route := "http://localhost:8080/todo/jpw/ctitle/2"
-	payload := map[string]interface{}{
-		"title": "Changed Title",
+//WithIdempotencyKeys sends a new idempotency key with every POST and DELETE request that is not given one, so that they too are retried.  The server keeps the response to each key for its IDEMPOTENCY_TTL, a day by default
+func WithIdempotencyKeys() Option {
+	return func(c *Client) {
+		c.autoKeys = true
 	}
-	byteMap, err := json.Marshal(payload)
-	if err != nil {
-		log.Errorf("Error: %v", err)
+}
+
+//New creates a client for the API at baseURL, such as "http://localhost:8080".  An invalid base URL is reported by every call
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		httpClient: http.DefaultClient,
+		headers:    make(http.Header),
+		retries:    DefaultRetries,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+	}
+	u, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err == nil && (u.Scheme == "" || u.Host == "") {
+		err = fmt.Errorf("base URL %q must have a scheme and host", baseURL)
+	}
+	c.baseURL, c.err = strings.TrimRight(baseURL, "/"), err
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+//call is a single API request
+type call struct {
+	method      string
+	path        string
+	query       url.Values
+	header      http.Header
+	body        []byte
+	contentType string
+}
+
+//CallOption sets an option on a single call
+type CallOption func(*call)
+
+//DryRun asks the endpoint to report what it would do without doing it.  Only the endpoints that document ?dry_run=true accept it
+func DryRun() CallOption {
+	return func(cl *call) {
+		cl.query.Set("dry_run", "true")
 	}
-	resp, err := http.Post(route, "application/json", bytes.NewBuffer(byteMap))
-	if err != nil {
-		log.Errorf("Error: %v", err)
+}
+
+//IfMatch makes a change conditional on the todo item still being at the version of todo, as returned by an earlier call.  If it has changed since, the call fails with CodePrecondition
+func IfMatch(todo types.TodoData) CallOption {
+	return func(cl *call) {
+		cl.header.Set("If-Match", fmt.Sprintf(`"%d.%d"`, todo.ID, todo.Version))
 	}
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	log.Infof("result: %v", result)
 }
 
-//Change Priority:  curl -v -X POST localhost:8080/todo/tom/cpri/4 --data "{\"item_priority\": 2}"
-func runChangePriority() {
-	route := "http://localhost:8080/todo/jpw/cpri/2"
-	payload := map[string]interface{}{
-		"item_priority": 9,
+//IdempotencyKey sends key as the Idempotency-Key of a POST or DELETE request, which makes it safe to retry
+func IdempotencyKey(key string) CallOption {
+	return func(cl *call) {
+		cl.header.Set("Idempotency-Key", key)
 	}
-	byteMap, err := json.Marshal(payload)
-	if err != nil {
-		log.Errorf("Error: %v", err)
+}
+
+//newCall creates a call to path, relative to the base URL
+func newCall(method string, path string, opts []CallOption) *call {
+	cl := &call{method: method, path: path, query: make(url.Values), header: make(http.Header)}
+	for _, opt := range opts {
+		opt(cl)
 	}
-	resp, err := http.Post(route, "application/json", bytes.NewBuffer(byteMap))
+	return cl
+}
+
+//withJSON sets the body of a call to v encoded as JSON
+func (cl *call) withJSON(v interface{}) (*call, error) {
+	body, err := json.Marshal(v)
 	if err != nil {
-		log.Errorf("Error: %v", err)
+		return cl, err
 	}
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	log.Infof("result: %v", result)
+	cl.body, cl.contentType = body, "application/json"
+	return cl, nil
 }
 
-//Change Active:  curl -v -X POST localhost:8080/todo/tom/cactive/2 --data "{\"active\": false}"
-func runChangeActive() {
-	route := "http://localhost:8080/todo/jpw/cactive/2"
-	payload := map[string]interface{}{
-		"active": false,
+//listPath returns the path of an endpoint on a user's list
+func listPath(name string, parts ...string) string {
+	path := "/todo/" + url.PathEscape(name)
+	for _, part := range parts {
+		path += "/" + url.PathEscape(part)
 	}
-	byteMap, err := json.Marshal(payload)
+	return path
+}
+
+//do sends a call and decodes the JSON response into out, if it is not nil.  A 204 response leaves out as it is
+func (c *Client) do(ctx context.Context, cl *call, out interface{}) error {
+	resp, err := c.send(ctx, cl)
 	if err != nil {
-		log.Errorf("Error: %v", err)
+		return err
 	}
-	resp, err := http.Post(route, "application/json", bytes.NewBuffer(byteMap))
-	if err != nil {
-		log.Errorf("Error: %v", err)
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response to %s %s: %v", cl.method, cl.path, err)
 	}
-	var result map[string]interface{}
-	json.NewDecoder(resp.Body).Decode(&result)
-	log.Infof("result: %v", result)
+	return nil
 }
 
-//Remove Title:  curl -v -X DELETE localhost:8080/todo/tom/rmtitle --data "{\"title\": \"#2\"}"
-func runRemoveTitle() {
-	route := "http://localhost:8080/todo/jpw/rmtitle"
-	payload := map[string]interface{}{
-		"title": "#2",
-	}
-	byteMap, err := json.Marshal(payload)
-	if err != nil {
-		log.Errorf("Error: %v", err)
-	}
-	req, err := http.NewRequest("DELETE", route, bytes.NewBuffer(byteMap))
+//doBytes sends a call and returns the response body as it is
+func (c *Client) doBytes(ctx context.Context, cl *call) ([]byte, error) {
+	resp, err := c.send(ctx, cl)
 	if err != nil {
-		log.Infof("Error %v", err)
+		return nil, err
 	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Infof("Error %v", err)
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+//send sends a call, retrying it if that is safe, and returns a successful response, whose body the caller must close.  Problem responses are returned as an *Error
+func (c *Client) send(ctx context.Context, cl *call) (*http.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if cl.method != "GET" && c.autoKeys && cl.header.Get("Idempotency-Key") == "" {
+		key, err := newKey()
+		if err != nil {
+			return nil, err
+		}
+		cl.header.Set("Idempotency-Key", key)
+	}
+	retryable := cl.method == "GET" || cl.header.Get("Idempotency-Key") != ""
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, cl)
+		if ctx.Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+		wait, retry := c.backoff(attempt), false
+		switch {
+		case err != nil:
+			retry = true
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			retry = true
+			if after, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && after >= 0 {
+				wait = time.Duration(after) * time.Second
+			}
+		}
+		if !retry || !retryable || attempt >= c.retries {
+			if err != nil {
+				return nil, err
+			}
+			if isProblem(resp) || (resp.StatusCode >= 400 && !isJSON(resp)) {
+				defer resp.Body.Close()
+				return nil, decodeError(resp)
+			}
+			return resp, nil
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
 	}
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
-	log.Infof(string(body))
 }
 
-//Remove Priority:  curl -v -X DELETE localhost:8080/todo/tom/rmpri --data "{\"item_priority\": 0}"
-func runRemovePriority() {
-	route := "http://localhost:8080/todo/jpw/rmpri"
-	payload := map[string]interface{}{
-		"item_priority": 9,
+//attempt sends a call once
+func (c *Client) attempt(ctx context.Context, cl *call) (*http.Response, error) {
+	target := c.baseURL + cl.path
+	if len(cl.query) > 0 {
+		target += "?" + cl.query.Encode()
 	}
-	byteMap, err := json.Marshal(payload)
-	if err != nil {
-		log.Errorf("Error: %v", err)
+	var body io.Reader
+	if cl.body != nil {
+		body = bytes.NewReader(cl.body)
 	}
-	req, err := http.NewRequest("DELETE", route, bytes.NewBuffer(byteMap))
+	req, err := http.NewRequest(cl.method, target, body)
 	if err != nil {
-		log.Infof("Error %v", err)
+		return nil, err
 	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Infof("Error %v", err)
+	req = req.WithContext(ctx)
+	for key, values := range c.headers {
+		req.Header[key] = values
 	}
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
-	log.Infof(string(body))
-}
-
-//Remove id:  curl -v -X DELETE localhost:8080/todo/tom/rmid --data "{\"id\": 8}"
-func runRemoveID() {
-	route := "http://localhost:8080/todo/jpw/rmid"
-	payload := map[string]interface{}{
-		"id": 10,
+	for key, values := range cl.header {
+		req.Header[key] = values
 	}
-	byteMap, err := json.Marshal(payload)
-	if err != nil {
-		log.Errorf("Error: %v", err)
+	if cl.contentType != "" {
+		req.Header.Set("Content-Type", cl.contentType)
 	}
-	req, err := http.NewRequest("DELETE", route, bytes.NewBuffer(byteMap))
-	if err != nil {
-		log.Infof("Error %v", err)
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
 	}
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Infof("Error %v", err)
+	return c.httpClient.Do(req)
+}
+
+//backoff returns the wait before retry attempt+1: the minimum backoff doubled for each earlier retry, up to the maximum, less up to a fifth at random so that clients spread out
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := float64(c.minBackoff) * math.Pow(2, float64(attempt))
+	if wait > float64(c.maxBackoff) {
+		wait = float64(c.maxBackoff)
 	}
-	defer res.Body.Close()
-	body, _ := ioutil.ReadAll(res.Body)
-	log.Infof(string(body))
+	return time.Duration(wait * (1 - mathrand.Float64()/5))
+}
+
+//isJSON reports whether a response has a JSON body, which failed batches and imports do
+func isJSON(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json")
+}
+
+//isProblem reports whether a response has an RFC 7807 problem body
+func isProblem(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "application/problem+json")
 }
 
-// Run runs each of the functional tests in this package
-func Run() {
-	runGetTodos()
-	//runGetActives()
-	//runGetByPriority()
-	//runGetByCategory()
-	//runGetByID()
-	//runAdd()
-	//runChangeTitle()
-	//runChangePriority()
-	//runChangeActive()
-	//runRemoveTitle()
-	//runRemovePriority()
-	//runRemoveID()
-	runGetTodos()
+//newKey returns a random idempotency key
+func newKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
 }
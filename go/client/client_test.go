@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//flakyServer fails the first failures requests it receives with status, then answers with body
+type flakyServer struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	body     string
+	requests []*http.Request
+}
+
+func (s *flakyServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	fail := len(s.requests) <= s.failures
+	s.mu.Unlock()
+	if fail {
+		resp.Header().Set("Content-Type", "application/problem+json")
+		resp.WriteHeader(s.status)
+		json.NewEncoder(resp).Encode(types.Problem{Status: s.status, Code: CodeInternal, Detail: "try again"})
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Write([]byte(s.body))
+}
+
+func (s *flakyServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func newTestClient(url string, opts ...Option) *Client {
+	return New(url, append([]Option{WithBackoff(time.Millisecond, 5*time.Millisecond)}, opts...)...)
+}
+
+func TestRetryGet(t *testing.T) {
+	s := &flakyServer{failures: 2, status: http.StatusServiceUnavailable, body: `[{"id": 1, "title": "Buy milk"}]`}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	todos, err := newTestClient(server.URL).GetTodos(context.Background(), "tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(todos) != 1 || todos[0].Title != "Buy milk" {
+		t.Errorf("got %+v", todos)
+	}
+	if s.count() != 3 {
+		t.Errorf("sent %d requests, want 3", s.count())
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	s := &flakyServer{failures: 10, status: http.StatusInternalServerError}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	_, err := newTestClient(server.URL, WithRetries(1)).GetTodos(context.Background(), "tom")
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusInternalServerError || apiErr.Detail != "try again" {
+		t.Errorf("got %v, want the server's problem", err)
+	}
+	if s.count() != 2 {
+		t.Errorf("sent %d requests, want 2", s.count())
+	}
+}
+
+func TestRetryWrites(t *testing.T) {
+	s := &flakyServer{failures: 1, status: http.StatusTooManyRequests, body: `{}`}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	//A write without an idempotency key is not repeated
+	_, err := newTestClient(server.URL).RemoveByID(context.Background(), "tom", 1)
+	if err == nil || s.count() != 1 {
+		t.Errorf("got %v after %d requests, want the 429 after 1", err, s.count())
+	}
+
+	s = &flakyServer{failures: 1, status: http.StatusTooManyRequests, body: `{}`}
+	server = httptest.NewServer(s)
+	defer server.Close()
+	if _, err := newTestClient(server.URL, WithIdempotencyKeys()).RemoveByID(context.Background(), "tom", 1); err != nil {
+		t.Fatal(err)
+	}
+	if s.count() != 2 {
+		t.Fatalf("sent %d requests, want 2", s.count())
+	}
+	key := s.requests[0].Header.Get("Idempotency-Key")
+	if key == "" || s.requests[1].Header.Get("Idempotency-Key") != key {
+		t.Errorf("retry sent key %q after %q, want the same key", s.requests[1].Header.Get("Idempotency-Key"), key)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		first := len(times) == 1
+		mu.Unlock()
+		if first {
+			resp.Header().Set("Retry-After", "1")
+			resp.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	if _, err := newTestClient(server.URL).GetTodos(context.Background(), "tom"); err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 2 || times[1].Sub(times[0]) < time.Second {
+		t.Errorf("retried after %v, want the second that Retry-After asked for", times[len(times)-1].Sub(times[0]))
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	s := &flakyServer{failures: 10, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := New(server.URL, WithRetries(100), WithBackoff(time.Hour, time.Hour))
+	if _, err := c.GetTodos(ctx, "tom"); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want the context's error", err)
+	}
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/shale/go/types"
+)
+
+//Error codes the server returns in the code field of a problem, as defined by the service package
+const (
+	CodeNotFound         = "not_found"
+	CodeValidation       = "validation_failed"
+	CodeConflict         = "conflict"
+	CodePrecondition     = "precondition_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeInternal         = "internal"
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeRateLimited      = "rate_limited"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeNotApplied       = "not_applied"
+	CodeKeyReused        = "idempotency_key_reused"
+)
+
+//maxErrorBody caps how much of an error response is read
+const maxErrorBody = 1 << 16
+
+//Error is a request the server refused or failed.  The embedded Problem is decoded from the response body, and for responses without one, such as those of a proxy, describes the status alone
+type Error struct {
+	StatusCode int
+	types.Problem
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("shale: %d %s: %s", e.StatusCode, e.Code, e.Detail)
+	for _, field := range e.Errors {
+		if field.Field != "" {
+			msg += fmt.Sprintf("; %s: %s", field.Field, field.Message)
+		} else {
+			msg += "; " + field.Message
+		}
+	}
+	return msg
+}
+
+//IsCode reports whether err is an *Error with the given code
+func IsCode(err error, code string) bool {
+	apiErr, ok := err.(*Error)
+	return ok && apiErr.Code == code
+}
+
+//decodeError reads the problem body of a failed response
+func decodeError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	body, _ := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxErrorBody))
+	if isProblem(resp) && json.Unmarshal(body, &e.Problem) == nil && e.Code != "" {
+		return e
+	}
+	e.Problem = types.Problem{
+		Title:  http.StatusText(resp.StatusCode),
+		Status: resp.StatusCode,
+		Code:   statusCode(resp.StatusCode),
+		Detail: strings.TrimSpace(string(body)),
+	}
+	if e.Detail == "" {
+		e.Detail = e.Title
+	}
+	return e
+}
+
+//statusCode guesses the error code of a response without a problem body from its status
+func statusCode(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePrecondition
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return CodeUnauthorized
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeValidation
+}
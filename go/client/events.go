@@ -0,0 +1,90 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/shale/go/types"
+)
+
+//EventReset is the type of the event sent in place of events that were missed and are no longer buffered.  A client receiving it should reload the list
+const EventReset = "reset"
+
+//EventStream reads the changes to a list as they happen
+type EventStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+
+	//LastID is the ID of the last event read, to resume from after reconnecting
+	LastID int64
+}
+
+//StreamEvents opens a stream of the changes to the list.  A non-zero lastID resumes after that event.  The stream stays open until it is closed, ctx is done, or the server drops it
+func (c *Client) StreamEvents(ctx context.Context, name string, lastID int64, opts ...CallOption) (*EventStream, error) {
+	cl := newCall("GET", listPath(name, "events"), opts)
+	if lastID > 0 {
+		cl.header.Set("Last-Event-ID", strconv.FormatInt(lastID, 10))
+	}
+	cl.header.Set("Accept", "text/event-stream")
+	resp, err := c.send(ctx, cl)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	return &EventStream{body: resp.Body, scanner: scanner, LastID: lastID}, nil
+}
+
+//Next blocks until the next event arrives.  It returns io.EOF when the server ends the stream; call StreamEvents with LastID to resume.  A reset event has only its Type set
+func (s *EventStream) Next() (types.Event, error) {
+	var eventType, id string
+	var data []string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			if len(data) == 0 {
+				//A comment or retry field alone, such as a heartbeat
+				eventType, id = "", ""
+				continue
+			}
+			var event types.Event
+			if eventType != EventReset {
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &event); err != nil {
+					return event, err
+				}
+			}
+			if eventType != "" {
+				event.Type = eventType
+			}
+			if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+				s.LastID = n
+			}
+			return event, nil
+		}
+		field, value := line, ""
+		if colon := strings.IndexByte(line, ':'); colon >= 0 {
+			field, value = line[:colon], strings.TrimPrefix(line[colon+1:], " ")
+		}
+		switch field {
+		case "event":
+			eventType = value
+		case "id":
+			id = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		return types.Event{}, err
+	}
+	return types.Event{}, io.EOF
+}
+
+//Close closes the stream
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
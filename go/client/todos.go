@@ -0,0 +1,299 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//newTodo is the body of a request adding a todo item, which takes only the fields a client may set
+type newTodo struct {
+	Title        string     `json:"title"`
+	Body         string     `json:"body,omitempty"`
+	Category     string     `json:"category,omitempty"`
+	Priority     int        `json:"item_priority,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Due          *time.Time `json:"due,omitempty"`
+	RemindAt     *time.Time `json:"remind_at,omitempty"`
+	RemindBefore *int       `json:"remind_before,omitempty"`
+	Recurrence   string     `json:"recurrence,omitempty"`
+}
+
+//addBody returns the fields of todo that a request adding it may send
+func addBody(todo types.TodoData) newTodo {
+	return newTodo{
+		Title:        todo.Title,
+		Body:         todo.Body,
+		Category:     todo.Category,
+		Priority:     todo.Priority,
+		Tags:         todo.Tags,
+		Due:          todo.Due,
+		RemindAt:     todo.RemindAt,
+		RemindBefore: todo.RemindBefore,
+		Recurrence:   todo.Recurrence,
+	}
+}
+
+//patchBody returns the fields set by a patch.  The nullable fields are only sent when they are set, since null clears them
+func patchBody(patch types.TodoPatch) map[string]interface{} {
+	body := make(map[string]interface{})
+	if patch.Title != nil {
+		body["title"] = *patch.Title
+	}
+	if patch.Body != nil {
+		body["body"] = *patch.Body
+	}
+	if patch.Category != nil {
+		body["category"] = *patch.Category
+	}
+	if patch.Priority != nil {
+		body["item_priority"] = *patch.Priority
+	}
+	if patch.Active != nil {
+		body["active"] = *patch.Active
+	}
+	if patch.Tags != nil {
+		body["tags"] = *patch.Tags
+	}
+	if patch.Due.Set {
+		body["due"] = patch.Due.Value
+	}
+	if patch.RemindAt.Set {
+		body["remind_at"] = patch.RemindAt.Value
+	}
+	if patch.RemindBefore.Set {
+		body["remind_before"] = patch.RemindBefore.Value
+	}
+	if patch.Recurrence != nil {
+		body["recurrence"] = *patch.Recurrence
+	}
+	return body
+}
+
+//getTodos gets a list of todo items.  An empty list is nil
+func (c *Client) getTodos(ctx context.Context, path string, opts []CallOption) ([]types.TodoData, error) {
+	var result []types.TodoData
+	err := c.do(ctx, newCall("GET", path, opts), &result)
+	return result, err
+}
+
+//GetTodos returns every todo item on the list
+func (c *Client) GetTodos(ctx context.Context, name string, opts ...CallOption) ([]types.TodoData, error) {
+	return c.getTodos(ctx, listPath(name), opts)
+}
+
+//GetActives returns the active or the inactive todo items
+func (c *Client) GetActives(ctx context.Context, name string, active bool, opts ...CallOption) ([]types.TodoData, error) {
+	return c.getTodos(ctx, listPath(name, "active", strconv.FormatBool(active)), opts)
+}
+
+//GetTodosByPriority returns the todo items at the given priority or higher, where a lower number is a higher priority
+func (c *Client) GetTodosByPriority(ctx context.Context, name string, priority int, opts ...CallOption) ([]types.TodoData, error) {
+	return c.getTodos(ctx, listPath(name, "highs", strconv.Itoa(priority)), opts)
+}
+
+//GetTodosByCategory returns the todo items in a category
+func (c *Client) GetTodosByCategory(ctx context.Context, name string, category string, opts ...CallOption) ([]types.TodoData, error) {
+	return c.getTodos(ctx, listPath(name, "cat", category), opts)
+}
+
+//GetTodoByID returns a todo item
+func (c *Client) GetTodoByID(ctx context.Context, name string, id int, opts ...CallOption) (types.TodoData, error) {
+	var result types.TodoData
+	err := c.do(ctx, newCall("GET", listPath(name, "id", strconv.Itoa(id)), opts), &result)
+	return result, err
+}
+
+//AddTodo adds a todo item and returns it as added.  Only the fields a client may set are sent
+func (c *Client) AddTodo(ctx context.Context, name string, todo types.TodoData, opts ...CallOption) (types.TodoData, error) {
+	var result types.TodoData
+	cl, err := newCall("POST", listPath(name, "add"), opts).withJSON(addBody(todo))
+	if err != nil {
+		return result, err
+	}
+	err = c.do(ctx, cl, &result)
+	return result, err
+}
+
+//QuickAdd adds the todo item described by a line of text, such as "Buy milk tomorrow 5pm #shopping !2", and returns what the line parsed to with the item added.  With DryRun the line is only parsed
+func (c *Client) QuickAdd(ctx context.Context, name string, text string, opts ...CallOption) (types.QuickAddResult, error) {
+	var result types.QuickAddResult
+	cl, err := newCall("POST", listPath(name, "quickadd"), opts).withJSON(map[string]string{"text": text})
+	if err != nil {
+		return result, err
+	}
+	err = c.do(ctx, cl, &result)
+	return result, err
+}
+
+//change sends one field of a todo item to a change endpoint and returns the item as changed
+func (c *Client) change(ctx context.Context, name string, endpoint string, id int, field string, value interface{}, opts []CallOption) (types.TodoData, error) {
+	var result types.TodoData
+	cl, err := newCall("POST", listPath(name, endpoint, strconv.Itoa(id)), opts).withJSON(map[string]interface{}{field: value})
+	if err != nil {
+		return result, err
+	}
+	err = c.do(ctx, cl, &result)
+	return result, err
+}
+
+//ChangeTitle changes the title of a todo item
+func (c *Client) ChangeTitle(ctx context.Context, name string, id int, title string, opts ...CallOption) (types.TodoData, error) {
+	return c.change(ctx, name, "ctitle", id, "title", title, opts)
+}
+
+//ChangePriority changes the priority of a todo item
+func (c *Client) ChangePriority(ctx context.Context, name string, id int, priority int, opts ...CallOption) (types.TodoData, error) {
+	return c.change(ctx, name, "cpri", id, "item_priority", priority, opts)
+}
+
+//ChangeActive marks a todo item active or inactive
+func (c *Client) ChangeActive(ctx context.Context, name string, id int, active bool, opts ...CallOption) (types.TodoData, error) {
+	return c.change(ctx, name, "cactive", id, "active", active, opts)
+}
+
+//remove sends a request to a remove endpoint
+func (c *Client) remove(ctx context.Context, name string, endpoint string, body interface{}, opts []CallOption) (types.ListStatus, error) {
+	var result types.ListStatus
+	cl := newCall("DELETE", listPath(name, endpoint), opts)
+	if body != nil {
+		var err error
+		if cl, err = cl.withJSON(body); err != nil {
+			return result, err
+		}
+	}
+	err := c.do(ctx, cl, &result)
+	return result, err
+}
+
+//RemoveByTitle moves the todo items with a title to the trash.  With DryRun it lists them instead
+func (c *Client) RemoveByTitle(ctx context.Context, name string, title string, opts ...CallOption) (types.ListStatus, error) {
+	return c.remove(ctx, name, "rmtitle", map[string]string{"title": title}, opts)
+}
+
+//RemoveByPriority moves the todo items with a priority to the trash.  With DryRun it lists them instead
+func (c *Client) RemoveByPriority(ctx context.Context, name string, priority int, opts ...CallOption) (types.ListStatus, error) {
+	return c.remove(ctx, name, "rmpri", map[string]int{"item_priority": priority}, opts)
+}
+
+//RemoveInactive moves the inactive todo items to the trash.  With DryRun it lists them instead
+func (c *Client) RemoveInactive(ctx context.Context, name string, opts ...CallOption) (types.ListStatus, error) {
+	return c.remove(ctx, name, "rminactive", nil, opts)
+}
+
+//RemoveByID moves a todo item to the trash.  With DryRun it returns the item instead
+func (c *Client) RemoveByID(ctx context.Context, name string, id int, opts ...CallOption) (types.ListStatus, error) {
+	return c.remove(ctx, name, "rmid", map[string]int{"id": id}, opts)
+}
+
+//GetHistory returns every recorded change to a todo item, oldest first
+func (c *Client) GetHistory(ctx context.Context, name string, id int, opts ...CallOption) ([]types.AuditRecord, error) {
+	var result []types.AuditRecord
+	err := c.do(ctx, newCall("GET", listPath(name, "id", strconv.Itoa(id), "history"), opts), &result)
+	return result, err
+}
+
+//CreateOp is a batch operation adding todo
+func CreateOp(todo types.TodoData) types.BatchOp {
+	body, _ := json.Marshal(addBody(todo))
+	return types.BatchOp{Op: "create", Todo: body}
+}
+
+//UpdateOp is a batch operation setting the fields of patch on a todo item.  A non-zero version makes it conditional on the item still being at that version
+func UpdateOp(id int, version int, patch types.TodoPatch) types.BatchOp {
+	body, _ := json.Marshal(patchBody(patch))
+	return types.BatchOp{Op: "update", ID: id, Version: version, Todo: body}
+}
+
+//DeleteOp is a batch operation moving a todo item to the trash.  A non-zero version makes it conditional on the item still being at that version
+func DeleteOp(id int, version int) types.BatchOp {
+	return types.BatchOp{Op: "delete", ID: id, Version: version}
+}
+
+//Batch runs a list of operations, built with CreateOp, UpdateOp, and DeleteOp, in one transaction.  A batch whose operations fail is not an error: the response reports the outcome of each operation, and whether an atomic batch was applied
+func (c *Client) Batch(ctx context.Context, name string, batch types.BatchRequest, opts ...CallOption) (types.BatchResponse, error) {
+	var result types.BatchResponse
+	cl, err := newCall("POST", listPath(name, "batch"), opts).withJSON(batch)
+	if err != nil {
+		return result, err
+	}
+	err = c.do(ctx, cl, &result)
+	return result, err
+}
+
+//UpdateWhere sets the fields of set on every todo item matching filter.  With DryRun it lists the items instead
+func (c *Client) UpdateWhere(ctx context.Context, name string, filter types.Filter, set types.TodoPatch, opts ...CallOption) (types.ListStatus, error) {
+	var result types.ListStatus
+	cl, err := newCall("POST", listPath(name, "update"), opts).withJSON(map[string]interface{}{"filter": filter, "set": patchBody(set)})
+	if err != nil {
+		return result, err
+	}
+	err = c.do(ctx, cl, &result)
+	return result, err
+}
+
+//Export returns every todo item as a file in a format: csv, json, or todotxt
+func (c *Client) Export(ctx context.Context, name string, format string, opts ...CallOption) ([]byte, error) {
+	cl := newCall("GET", listPath(name, "export"), opts)
+	cl.query.Set("format", format)
+	return c.doBytes(ctx, cl)
+}
+
+//ImportOptions controls how a file is imported
+type ImportOptions struct {
+	//OnDuplicate is "skip", the default, to leave items whose title is already on the list alone, or "upsert" to update them
+	OnDuplicate string
+
+	//Map maps the columns of a CSV file to fields, as "column:field,..."
+	Map string
+}
+
+//Import adds the todo items in a file in a format: csv, json, or todotxt.  With DryRun it reports what would be imported instead
+func (c *Client) Import(ctx context.Context, name string, format string, file []byte, options ImportOptions, opts ...CallOption) (types.ImportResult, error) {
+	var result types.ImportResult
+	cl := newCall("POST", listPath(name, "import"), opts)
+	cl.query.Set("format", format)
+	if options.OnDuplicate != "" {
+		cl.query.Set("on_duplicate", options.OnDuplicate)
+	}
+	if options.Map != "" {
+		cl.query.Set("map", options.Map)
+	}
+	cl.body, cl.contentType = file, "application/octet-stream"
+	err := c.do(ctx, cl, &result)
+	return result, err
+}
+
+//Undo reverts the most recent change to the list
+func (c *Client) Undo(ctx context.Context, name string, opts ...CallOption) (types.OpResult, error) {
+	var result types.OpResult
+	err := c.do(ctx, newCall("POST", listPath(name, "undo"), opts), &result)
+	return result, err
+}
+
+//Redo reapplies the change most recently undone
+func (c *Client) Redo(ctx context.Context, name string, opts ...CallOption) (types.OpResult, error) {
+	var result types.OpResult
+	err := c.do(ctx, newCall("POST", listPath(name, "redo"), opts), &result)
+	return result, err
+}
+
+//GetTrash returns the todo items in the trash, most recently removed first
+func (c *Client) GetTrash(ctx context.Context, name string, opts ...CallOption) ([]types.TodoData, error) {
+	return c.getTodos(ctx, listPath(name, "trash"), opts)
+}
+
+//RestoreTodo moves a todo item out of the trash
+func (c *Client) RestoreTodo(ctx context.Context, name string, id int, opts ...CallOption) (types.TodoData, error) {
+	var result types.TodoData
+	err := c.do(ctx, newCall("POST", listPath(name, "trash", "restore", strconv.Itoa(id)), opts), &result)
+	return result, err
+}
+
+//EmptyTrash permanently deletes the todo items in the trash
+func (c *Client) EmptyTrash(ctx context.Context, name string, opts ...CallOption) (types.ListStatus, error) {
+	return c.remove(ctx, name, "trash", nil, opts)
+}
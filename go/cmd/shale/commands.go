@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shale/go/client"
+	"github.com/shale/go/types"
+)
+
+var lsCommand = &command{
+	name:    "ls",
+	args:    "",
+	summary: "List todo items",
+	define: func(fs *flag.FlagSet) runFunc {
+		active := fs.Bool("active", false, "list only items that are not done")
+		inactive := fs.Bool("done", false, "list only items that are done")
+		category := fs.String("cat", "", "list only items in `category`")
+		priority := fs.Int("pri", 0, "list only items with `priority`")
+		trash := fs.Bool("trash", false, "list the items in the trash instead")
+		return func(ctx context.Context, s *session, args []string) error {
+			if len(args) > 0 {
+				return usageError{"shale: ls takes no arguments"}
+			}
+			if *active && *inactive {
+				return usageError{"shale: give only one of --active and --done"}
+			}
+			c, cfg, err := s.connect()
+			if err != nil {
+				return err
+			}
+			//The server filters by one field at a time, so the most selective is sent and the rest are applied here
+			byPriority := flagSet(fs, "pri")
+			var todos []types.TodoData
+			switch {
+			case *trash:
+				todos, err = c.GetTrash(ctx, cfg.User)
+			case byPriority:
+				todos, err = c.GetTodosByPriority(ctx, cfg.User, *priority)
+			case *category != "":
+				todos, err = c.GetTodosByCategory(ctx, cfg.User, *category)
+			case *active || *inactive:
+				todos, err = c.GetActives(ctx, cfg.User, *active)
+			default:
+				todos, err = c.GetTodos(ctx, cfg.User)
+			}
+			if err != nil {
+				return err
+			}
+			var matched []types.TodoData
+			for _, todo := range todos {
+				if (byPriority && todo.Priority != *priority) ||
+					(*category != "" && todo.Category != *category) ||
+					(*active && !todo.Active) || (*inactive && todo.Active) {
+					continue
+				}
+				matched = append(matched, todo)
+			}
+			return printTodos(s.out, cfg.Output, matched)
+		}
+	},
+}
+
+var addCommand = &command{
+	name:    "add",
+	args:    "<title>",
+	summary: "Add a todo item",
+	define: func(fs *flag.FlagSet) runFunc {
+		var todo types.TodoData
+		fs.StringVar(&todo.Category, "c", "", "shorthand for --cat")
+		fs.StringVar(&todo.Category, "cat", "", "`category` of the item")
+		fs.IntVar(&todo.Priority, "p", 0, "shorthand for --pri")
+		fs.IntVar(&todo.Priority, "pri", 0, "`priority` of the item")
+		fs.StringVar(&todo.Body, "b", "", "shorthand for --body")
+		fs.StringVar(&todo.Body, "body", "", "`text` of the item")
+		tags := fs.String("tags", "", "comma separated `tags`")
+		due := fs.String("due", "", "when the item is due, as `YYYY-MM-DD[ HH:MM]` in local time or RFC 3339")
+		quick := fs.Bool("q", false, "shorthand for --quick")
+		fs.BoolVar(quick, "quick", false, "read the due date, category, tags, priority, and repeats from the title, as in \"Buy milk tomorrow 5pm #shopping !2\"")
+		dryRun := fs.Bool("dry-run", false, "with --quick, show what would be added without adding it")
+		return func(ctx context.Context, s *session, args []string) error {
+			title := strings.Join(args, " ")
+			if title == "" {
+				return usageError{"shale: add needs a title"}
+			}
+			if *dryRun && !*quick {
+				return usageError{"shale: --dry-run needs --quick"}
+			}
+			c, cfg, err := s.connect()
+			if err != nil {
+				return err
+			}
+			if *quick {
+				if flagSet(fs, "c", "cat", "p", "pri", "b", "body", "tags", "due") {
+					return usageError{"shale: --quick reads every field from the title"}
+				}
+				var opts []client.CallOption
+				if *dryRun {
+					opts = append(opts, client.DryRun())
+				}
+				result, err := c.QuickAdd(ctx, cfg.User, title, opts...)
+				if err != nil {
+					return err
+				}
+				if result.Todo == nil {
+					return printParsed(s.out, cfg.Output, result)
+				}
+				return printTodos(s.out, cfg.Output, []types.TodoData{*result.Todo})
+			}
+			todo.Title, todo.Tags = title, splitList(*tags)
+			if todo.Due, err = parseDue(*due); err != nil {
+				return err
+			}
+			added, err := c.AddTodo(ctx, cfg.User, todo)
+			if err != nil {
+				return err
+			}
+			return printTodos(s.out, cfg.Output, []types.TodoData{added})
+		}
+	},
+}
+
+var doneCommand = &command{
+	name:    "done",
+	args:    "<id>...",
+	summary: "Mark todo items done",
+	define: func(fs *flag.FlagSet) runFunc {
+		undo := fs.Bool("undo", false, "mark the items not done instead")
+		return func(ctx context.Context, s *session, args []string) error {
+			ids, err := parseIDs(args)
+			if err != nil {
+				return err
+			}
+			c, cfg, err := s.connect()
+			if err != nil {
+				return err
+			}
+			var todos []types.TodoData
+			for _, id := range ids {
+				todo, err := c.ChangeActive(ctx, cfg.User, id, *undo)
+				if err != nil {
+					printTodos(s.out, cfg.Output, todos)
+					return fmt.Errorf("todo %d: %v", id, err)
+				}
+				todos = append(todos, todo)
+			}
+			return printTodos(s.out, cfg.Output, todos)
+		}
+	},
+}
+
+var editCommand = &command{
+	name:    "edit",
+	args:    "<id>",
+	summary: "Change a todo item, in $EDITOR unless fields are given as flags",
+	define: func(fs *flag.FlagSet) runFunc {
+		var patch types.TodoPatch
+		title := fs.String("title", "", "new `title`")
+		category := fs.String("c", "", "shorthand for --cat")
+		fs.StringVar(category, "cat", "", "new `category`")
+		priority := fs.Int("p", 0, "shorthand for --pri")
+		fs.IntVar(priority, "pri", 0, "new `priority`")
+		body := fs.String("b", "", "shorthand for --body")
+		fs.StringVar(body, "body", "", "new `text`")
+		tags := fs.String("tags", "", "new comma separated `tags`")
+		due := fs.String("due", "", "new due date, as `YYYY-MM-DD[ HH:MM]` in local time or RFC 3339, or none to clear it")
+		active := fs.Bool("active", false, "whether the item is not yet done")
+		return func(ctx context.Context, s *session, args []string) error {
+			ids, err := parseIDs(args)
+			if err != nil {
+				return err
+			}
+			if len(ids) != 1 {
+				return usageError{"shale: edit takes one id"}
+			}
+			c, cfg, err := s.connect()
+			if err != nil {
+				return err
+			}
+			todo, err := c.GetTodoByID(ctx, cfg.User, ids[0])
+			if err != nil {
+				return err
+			}
+			fs.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "title":
+					patch.Title = title
+				case "c", "cat":
+					patch.Category = category
+				case "p", "pri":
+					patch.Priority = priority
+				case "b", "body":
+					patch.Body = body
+				case "tags":
+					list := splitList(*tags)
+					patch.Tags = &list
+				case "active":
+					patch.Active = active
+				case "due":
+					patch.Due.Set = true
+				}
+			})
+			if patch.Due.Set {
+				if patch.Due.Value, err = parseDue(*due); err != nil {
+					return err
+				}
+			}
+			if patch == (types.TodoPatch{}) {
+				changed, err := editInEditor(todo, &patch)
+				if err != nil || !changed {
+					return err
+				}
+			}
+			edited, err := update(ctx, c, cfg.User, todo, patch)
+			if err != nil {
+				return err
+			}
+			return printTodos(s.out, cfg.Output, []types.TodoData{edited})
+		}
+	},
+}
+
+var rmCommand = &command{
+	name:    "rm",
+	args:    "<id>...",
+	summary: "Move todo items to the trash",
+	define: func(fs *flag.FlagSet) runFunc {
+		dryRun := fs.Bool("dry-run", false, "show what would be removed without removing it")
+		return func(ctx context.Context, s *session, args []string) error {
+			ids, err := parseIDs(args)
+			if err != nil {
+				return err
+			}
+			c, cfg, err := s.connect()
+			if err != nil {
+				return err
+			}
+			var opts []client.CallOption
+			if *dryRun {
+				opts = append(opts, client.DryRun())
+			}
+			var statuses []types.ListStatus
+			for _, id := range ids {
+				status, err := c.RemoveByID(ctx, cfg.User, id, opts...)
+				if err != nil {
+					printStatuses(s.out, cfg.Output, statuses)
+					return fmt.Errorf("todo %d: %v", id, err)
+				}
+				statuses = append(statuses, status)
+			}
+			return printStatuses(s.out, cfg.Output, statuses)
+		}
+	},
+}
+
+var undoCommand = &command{
+	name:    "undo",
+	args:    "",
+	summary: "Revert the most recent change",
+	define: func(fs *flag.FlagSet) runFunc {
+		return func(ctx context.Context, s *session, args []string) error {
+			return replay(ctx, s, args, "undo", "Undid", (*client.Client).Undo)
+		}
+	},
+}
+
+var redoCommand = &command{
+	name:    "redo",
+	args:    "",
+	summary: "Reapply the change most recently undone",
+	define: func(fs *flag.FlagSet) runFunc {
+		return func(ctx context.Context, s *session, args []string) error {
+			return replay(ctx, s, args, "redo", "Redid", (*client.Client).Redo)
+		}
+	},
+}
+
+//replay runs an undo or redo
+func replay(ctx context.Context, s *session, args []string, name string, verb string, op func(*client.Client, context.Context, string, ...client.CallOption) (types.OpResult, error)) error {
+	if len(args) > 0 {
+		return usageError{"shale: " + name + " takes no arguments"}
+	}
+	c, cfg, err := s.connect()
+	if err != nil {
+		return err
+	}
+	result, err := op(c, ctx, cfg.User)
+	if err != nil {
+		return err
+	}
+	return printOp(s.out, cfg.Output, verb, result)
+}
+
+//update applies patch to todo, failing if it has changed since it was read
+func update(ctx context.Context, c *client.Client, name string, todo types.TodoData, patch types.TodoPatch) (types.TodoData, error) {
+	resp, err := c.Batch(ctx, name, types.BatchRequest{
+		Mode: "atomic",
+		Ops:  []types.BatchOp{client.UpdateOp(todo.ID, todo.Version, patch)},
+	})
+	if err != nil {
+		return todo, err
+	}
+	if len(resp.Results) != 1 {
+		return todo, fmt.Errorf("shale: unexpected batch response for todo %d", todo.ID)
+	}
+	result := resp.Results[0]
+	if result.Error != nil {
+		return todo, &client.Error{StatusCode: result.Status, Problem: *result.Error}
+	}
+	if result.Todo == nil {
+		return todo, fmt.Errorf("shale: todo %d was not updated", todo.ID)
+	}
+	return *result.Todo, nil
+}
+
+//parseIDs reads todo ids from the command line
+func parseIDs(args []string) ([]int, error) {
+	if len(args) == 0 {
+		return nil, usageError{"shale: give the id of a todo item"}
+	}
+	ids := make([]int, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil || id <= 0 {
+			return nil, usageError{fmt.Sprintf("shale: bad id %q", arg)}
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+//dueLayouts are the layouts accepted for due dates given in local time
+var dueLayouts = []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+
+//parseDue reads a due date.  A date alone is due at the end of the day, as in Quick Add.  Empty or none is no due date
+func parseDue(value string) (*time.Time, error) {
+	if value == "" || value == "none" {
+		return nil, nil
+	}
+	if due, err := time.Parse(time.RFC3339, value); err == nil {
+		return &due, nil
+	}
+	for _, layout := range dueLayouts {
+		if due, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			if layout == "2006-01-02" {
+				due = due.Add(23*time.Hour + 59*time.Minute)
+			}
+			return &due, nil
+		}
+	}
+	return nil, usageError{fmt.Sprintf("shale: bad due date %q: use YYYY-MM-DD, YYYY-MM-DD HH:MM, or RFC 3339", value)}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		none  bool
+		err   bool
+	}{
+		{value: "", none: true},
+		{value: "none", none: true},
+		{value: "2020-05-06", want: time.Date(2020, 5, 6, 23, 59, 0, 0, time.Local)},
+		{value: "2020-05-06 17:30", want: time.Date(2020, 5, 6, 17, 30, 0, 0, time.Local)},
+		{value: "2020-05-06T17:30", want: time.Date(2020, 5, 6, 17, 30, 0, 0, time.Local)},
+		{value: "2020-05-06T17:30:00Z", want: time.Date(2020, 5, 6, 17, 30, 0, 0, time.UTC)},
+		{value: "2020-05-06T17:30:00+02:00", want: time.Date(2020, 5, 6, 15, 30, 0, 0, time.UTC)},
+		{value: "tomorrow", err: true},
+		{value: "2020-13-01", err: true},
+		{value: "06/05/2020", err: true},
+		{value: "2020-05-06 5pm", err: true},
+	}
+	for _, test := range tests {
+		due, err := parseDue(test.value)
+		switch {
+		case test.err:
+			if _, ok := err.(usageError); !ok {
+				t.Errorf("parseDue(%q): got %v %v, want a usage error", test.value, due, err)
+			}
+		case err != nil:
+			t.Errorf("parseDue(%q): %v", test.value, err)
+		case test.none:
+			if due != nil {
+				t.Errorf("parseDue(%q): got %v, want no due date", test.value, due)
+			}
+		case due == nil || !due.Equal(test.want):
+			t.Errorf("parseDue(%q): got %v, want %v", test.value, due, test.want)
+		case test.want.Location() == time.Local && due.Location() != time.Local:
+			t.Errorf("parseDue(%q): got %v, want local time", test.value, due.Location())
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+//shells lists the shells completion scripts are written for
+var shells = []string{"bash", "zsh", "fish"}
+
+var completionCommand = &command{
+	name:    "completion",
+	args:    "<bash|zsh|fish>",
+	summary: "Print a shell completion script",
+	define: func(fs *flag.FlagSet) runFunc {
+		return func(ctx context.Context, s *session, args []string) error {
+			if len(args) != 1 {
+				return usageError{"shale: completion needs a shell: bash, zsh, or fish"}
+			}
+			switch args[0] {
+			case "bash":
+				writeBash(s.out)
+			case "zsh":
+				fmt.Fprintf(s.out, "autoload -U +X bashcompinit && bashcompinit\n")
+				writeBash(s.out)
+			case "fish":
+				writeFish(s.out)
+			default:
+				return usageError{fmt.Sprintf("shale: unknown shell %q: must be bash, zsh, or fish", args[0])}
+			}
+			return nil
+		}
+	},
+}
+
+//commandFlags returns the flags of a command, shared flags last
+func commandFlags(cmd *command) []*flag.Flag {
+	fs, _ := newFlagSet(cmd, &session{}, ioutil.Discard)
+	shared := make(map[string]bool)
+	for _, name := range []string{"config", "url", "user", "o", "output"} {
+		shared[name] = true
+	}
+	var own, common []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) {
+		if shared[f.Name] {
+			common = append(common, f)
+		} else {
+			own = append(own, f)
+		}
+	})
+	return append(own, common...)
+}
+
+//flagName returns how a flag is written on the command line
+func flagName(f *flag.Flag) string {
+	if len(f.Name) == 1 {
+		return "-" + f.Name
+	}
+	return "--" + f.Name
+}
+
+//takesValue reports whether a flag needs a value
+func takesValue(f *flag.Flag) bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !bf.IsBoolFlag()
+}
+
+//writeBash writes the bash completion script
+func writeBash(w io.Writer) {
+	var names []string
+	var cases bytes.Buffer
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+		var flags, valued []string
+		for _, f := range commandFlags(cmd) {
+			flags = append(flags, flagName(f))
+			if takesValue(f) {
+				valued = append(valued, flagName(f))
+			}
+		}
+		fmt.Fprintf(&cases, "    %s) flags=%q; valued=%q ;;\n", cmd.name, strings.Join(flags, " "), " "+strings.Join(valued, " ")+" ")
+	}
+	fmt.Fprintf(w, `_shale() {
+  local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]} flags valued
+  if [ "$COMP_CWORD" -eq 1 ]; then
+    COMPREPLY=($(compgen -W %q -- "$cur"))
+    return
+  fi
+  case "$prev" in
+    -o|--output) COMPREPLY=($(compgen -W %q -- "$cur")); return ;;
+    --config) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+  esac
+  case "${COMP_WORDS[1]}" in
+%s  esac
+  if [ "${COMP_WORDS[1]}" = completion ]; then
+    COMPREPLY=($(compgen -W %q -- "$cur"))
+    return
+  fi
+  case "$valued" in
+    *" $prev "*) return ;;
+  esac
+  if [[ "$cur" == -* ]]; then
+    COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+  fi
+}
+complete -F _shale shale
+`, strings.Join(append(names, "help"), " "), strings.Join(outputs, " "), cases.String(), strings.Join(shells, " "))
+}
+
+//writeFish writes the fish completion script
+func writeFish(w io.Writer) {
+	fmt.Fprintf(w, "complete -c shale -f\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "complete -c shale -n __fish_use_subcommand -a %s -d %s\n", cmd.name, fishQuote(cmd.summary))
+	}
+	fmt.Fprintf(w, "complete -c shale -n '__fish_seen_subcommand_from completion' -a %q\n", strings.Join(shells, " "))
+	for _, cmd := range commands {
+		for _, f := range commandFlags(cmd) {
+			option := "-l"
+			if len(f.Name) == 1 {
+				option = "-s"
+			}
+			_, usage := flag.UnquoteUsage(f)
+			line := fmt.Sprintf("complete -c shale -n '__fish_seen_subcommand_from %s' %s %s -d %s", cmd.name, option, f.Name, fishQuote(usage))
+			if takesValue(f) {
+				line += " -r"
+			}
+			switch f.Name {
+			case "o", "output":
+				line += fmt.Sprintf(" -a %q", strings.Join(outputs, " "))
+			case "config":
+				line += " -F"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+//fishQuote quotes s for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/shale/go/client"
+)
+
+//defaultURL is the server used when none is configured
+const defaultURL = "http://localhost:8080"
+
+//Config is the config file, which holds the server to use and the credentials to send it
+type Config struct {
+	URL     string            `json:"url,omitempty"`
+	User    string            `json:"user,omitempty"`
+	Token   string            `json:"token,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Output  string            `json:"output,omitempty"`
+}
+
+//session holds the flags shared by every command and the settings they resolve to
+type session struct {
+	configPath string
+	url        string
+	user       string
+	output     string
+	out        io.Writer
+}
+
+//defaultConfigPath returns where the config file is read from when neither --config nor $SHALE_CONFIG is set
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return filepath.Join(".shale", "config.json")
+	}
+	return filepath.Join(dir, "shale", "config.json")
+}
+
+//path returns the config file to use, and whether it was chosen explicitly and so must exist
+func (s *session) path() (string, bool) {
+	if s.configPath != "" {
+		return s.configPath, true
+	}
+	if path := os.Getenv("SHALE_CONFIG"); path != "" {
+		return path, true
+	}
+	return defaultConfigPath(), false
+}
+
+//readConfig reads the config file alone.  A missing default config file is empty
+func (s *session) readConfig() (Config, error) {
+	var cfg Config
+	path, explicit := s.path()
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+//config returns the config file overridden by the environment and then by flags
+func (s *session) config() (Config, error) {
+	cfg, err := s.readConfig()
+	if err != nil {
+		return cfg, err
+	}
+	for _, v := range []struct {
+		field *string
+		env   string
+		flag  string
+	}{
+		{&cfg.URL, "SHALE_URL", s.url},
+		{&cfg.User, "SHALE_USER", s.user},
+		{&cfg.Token, "SHALE_TOKEN", ""},
+		{&cfg.Output, "SHALE_OUTPUT", s.output},
+	} {
+		if value := os.Getenv(v.env); value != "" {
+			*v.field = value
+		}
+		if v.flag != "" {
+			*v.field = v.flag
+		}
+	}
+	if cfg.URL == "" {
+		cfg.URL = defaultURL
+	}
+	if cfg.Output == "" {
+		cfg.Output = outputTable
+	}
+	if !validOutput(cfg.Output) {
+		return cfg, usageError{fmt.Sprintf("shale: bad output format %q: must be table, json, or csv", cfg.Output)}
+	}
+	return cfg, nil
+}
+
+//connect returns a client for the configured server and the list to manage
+func (s *session) connect() (*client.Client, Config, error) {
+	cfg, err := s.config()
+	if err != nil {
+		return nil, cfg, err
+	}
+	if cfg.User == "" {
+		return nil, cfg, fmt.Errorf("shale: no list to manage: set --user, $SHALE_USER, or user in the config file")
+	}
+	opts := []client.Option{client.WithIdempotencyKeys()}
+	if cfg.Token != "" {
+		opts = append(opts, client.WithToken(cfg.Token))
+	}
+	for key, value := range cfg.Headers {
+		opts = append(opts, client.WithHeader(key, value))
+	}
+	return client.New(cfg.URL, opts...), cfg, nil
+}
+
+var configCommand = &command{
+	name:    "config",
+	args:    "",
+	summary: "Show the settings in use, or save --url, --user, --token, and --header to the config file",
+	define: func(fs *flag.FlagSet) runFunc {
+		token := fs.String("token", "", "bearer token to send with every request")
+		var headers headerFlag
+		fs.Var(&headers, "header", "header to send with every request, as `name:value`; repeat for more, and give an empty value to remove one")
+		return func(ctx context.Context, s *session, args []string) error {
+			if len(args) > 0 {
+				return usageError{"shale: config takes no arguments"}
+			}
+			if s.url == "" && s.user == "" && *token == "" && len(headers) == 0 {
+				return showConfig(s)
+			}
+			cfg, err := s.readConfig()
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if s.url != "" {
+				if u, err := url.Parse(s.url); err != nil || u.Scheme == "" || u.Host == "" {
+					return usageError{fmt.Sprintf("shale: bad URL %q: must have a scheme and host, as in %s", s.url, defaultURL)}
+				}
+				cfg.URL = s.url
+			}
+			if s.user != "" {
+				cfg.User = s.user
+			}
+			if *token != "" {
+				cfg.Token = *token
+			}
+			for _, h := range headers {
+				if cfg.Headers == nil {
+					cfg.Headers = make(map[string]string)
+				}
+				if h[1] == "" {
+					delete(cfg.Headers, h[0])
+				} else {
+					cfg.Headers[h[0]] = h[1]
+				}
+			}
+			path, _ := s.path()
+			if err := writeConfig(path, cfg); err != nil {
+				return err
+			}
+			fmt.Fprintf(s.out, "Saved %s\n", path)
+			return nil
+		}
+	},
+}
+
+//showConfig prints the settings in use, hiding credentials
+func showConfig(s *session) error {
+	cfg, err := s.config()
+	if err != nil {
+		return err
+	}
+	path, _ := s.path()
+	fmt.Fprintf(s.out, "config: %s\nurl:    %s\nuser:   %s\noutput: %s\n", path, cfg.URL, cfg.User, cfg.Output)
+	if cfg.Token != "" {
+		fmt.Fprintf(s.out, "token:  (set)\n")
+	}
+	names := make([]string, 0, len(cfg.Headers))
+	for name := range cfg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.out, "header: %s: (set)\n", name)
+	}
+	return nil
+}
+
+//writeConfig saves the config file, readable only by its owner since it holds credentials
+func writeConfig(path string, cfg Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+//headerFlag collects repeated --header flags
+type headerFlag [][2]string
+
+func (h *headerFlag) String() string {
+	return ""
+}
+
+//Set adds a header given as name:value
+func (h *headerFlag) Set(value string) error {
+	colon := strings.IndexByte(value, ':')
+	if colon <= 0 {
+		return fmt.Errorf("must be name:value")
+	}
+	*h = append(*h, [2]string{strings.TrimSpace(value[:colon]), strings.TrimSpace(value[colon+1:])})
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSessionConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	file := `{"url": "http://file.example.com", "user": "file", "token": "file-token", "headers": {"X-A": "1"}, "output": "csv"}`
+	if err := ioutil.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		session session
+		env     map[string]string
+		want    Config
+		err     bool
+	}{
+		{
+			name: "defaults",
+			want: Config{URL: defaultURL, Output: outputTable},
+		},
+		{
+			name:    "config file",
+			session: session{configPath: path},
+			want:    Config{URL: "http://file.example.com", User: "file", Token: "file-token", Headers: map[string]string{"X-A": "1"}, Output: outputCSV},
+		},
+		{
+			name: "config file from the environment",
+			env:  map[string]string{"SHALE_CONFIG": path},
+			want: Config{URL: "http://file.example.com", User: "file", Token: "file-token", Headers: map[string]string{"X-A": "1"}, Output: outputCSV},
+		},
+		{
+			name:    "environment over config file",
+			session: session{configPath: path},
+			env:     map[string]string{"SHALE_URL": "http://env.example.com", "SHALE_TOKEN": "env-token", "SHALE_OUTPUT": "json"},
+			want:    Config{URL: "http://env.example.com", User: "file", Token: "env-token", Headers: map[string]string{"X-A": "1"}, Output: outputJSON},
+		},
+		{
+			name:    "flags over environment",
+			session: session{configPath: path, url: "http://flag.example.com", user: "flag", output: "table"},
+			env:     map[string]string{"SHALE_URL": "http://env.example.com", "SHALE_USER": "env", "SHALE_OUTPUT": "json"},
+			want:    Config{URL: "http://flag.example.com", User: "flag", Token: "file-token", Headers: map[string]string{"X-A": "1"}, Output: outputTable},
+		},
+		{
+			name:    "--config over $SHALE_CONFIG",
+			session: session{configPath: path},
+			env:     map[string]string{"SHALE_CONFIG": filepath.Join(dir, "missing.json")},
+			want:    Config{URL: "http://file.example.com", User: "file", Token: "file-token", Headers: map[string]string{"X-A": "1"}, Output: outputCSV},
+		},
+		{
+			name:    "missing explicit config file",
+			session: session{configPath: filepath.Join(dir, "missing.json")},
+			err:     true,
+		},
+		{
+			name:    "bad output format",
+			session: session{output: "yaml"},
+			err:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			//The default config file is looked for in an empty directory
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			for _, env := range []string{"SHALE_CONFIG", "SHALE_URL", "SHALE_USER", "SHALE_TOKEN", "SHALE_OUTPUT"} {
+				t.Setenv(env, test.env[env])
+			}
+			cfg, err := test.session.config()
+			if test.err {
+				if err == nil {
+					t.Errorf("got %+v, want an error", cfg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg, test.want) {
+				t.Errorf("got %+v, want %+v", cfg, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//editable is the part of a todo item opened in the editor
+type editable struct {
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	Category   string     `json:"category"`
+	Priority   int        `json:"item_priority"`
+	Active     bool       `json:"active"`
+	Tags       []string   `json:"tags"`
+	Due        *time.Time `json:"due"`
+	Recurrence string     `json:"recurrence"`
+}
+
+//editor returns the command line of the user's editor
+func editor() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+//editInEditor opens todo in the user's editor as JSON and sets the fields changed there on patch.  It reports whether anything changed
+func editInEditor(todo types.TodoData, patch *types.TodoPatch) (bool, error) {
+	before, err := json.MarshalIndent(editable{
+		Title:      todo.Title,
+		Body:       todo.Body,
+		Category:   todo.Category,
+		Priority:   todo.Priority,
+		Active:     todo.Active,
+		Tags:       todo.Tags,
+		Due:        todo.Due,
+		Recurrence: todo.Recurrence,
+	}, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	file, err := ioutil.TempFile("", fmt.Sprintf("shale-%d-*.json", todo.ID))
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(append(before, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+	args := append(editor(), file.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return false, fmt.Errorf("shale: running %s: %v", args[0], err)
+	}
+	after, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		return false, err
+	}
+	changes, err := changedFields(before, after)
+	if err != nil {
+		return false, fmt.Errorf("shale: reading the edited item: %v", err)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(os.Stderr, "shale: nothing changed")
+		return false, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(changes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(patch); err != nil {
+		return false, fmt.Errorf("shale: reading the edited item: %v", err)
+	}
+	return true, nil
+}
+
+//changedFields returns the fields of the JSON object after whose values differ from before, as a JSON object, or nil if none do
+func changedFields(before []byte, after []byte) ([]byte, error) {
+	var old, edited map[string]json.RawMessage
+	if err := json.Unmarshal(before, &old); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(after, &edited); err != nil {
+		return nil, err
+	}
+	changes := make(map[string]json.RawMessage)
+	for field, value := range edited {
+		var was, is interface{}
+		json.Unmarshal(old[field], &was)
+		if err := json.Unmarshal(value, &is); err != nil {
+			return nil, err
+		}
+		if _, ok := old[field]; !ok || !reflect.DeepEqual(was, is) {
+			changes[field] = value
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(changes)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestChangedFields(t *testing.T) {
+	before := `{"id": 1, "title": "Buy milk", "item_priority": 2, "tags": ["a", "b"], "due": null, "active": true}`
+	tests := []struct {
+		name  string
+		after string
+		want  map[string]interface{}
+	}{
+		{name: "unchanged", after: before},
+		{name: "reformatted", after: `{"active":true,"due":null,"tags":["a","b"],"item_priority":2.0,"title":"Buy milk","id":1}`},
+		{name: "removed fields are ignored", after: `{"title": "Buy milk"}`},
+		{
+			name:  "changed",
+			after: `{"id": 1, "title": "Buy oat milk", "item_priority": 2, "tags": ["b", "a"], "due": null, "active": false}`,
+			want:  map[string]interface{}{"title": "Buy oat milk", "tags": []interface{}{"b", "a"}, "active": false},
+		},
+		{
+			name:  "added",
+			after: `{"id": 1, "title": "Buy milk", "item_priority": 2, "tags": ["a", "b"], "due": null, "active": true, "body": "", "due_at": null}`,
+			want:  map[string]interface{}{"body": "", "due_at": nil},
+		},
+		{
+			name:  "set from null",
+			after: `{"due": "2020-05-06T17:00:00Z"}`,
+			want:  map[string]interface{}{"due": "2020-05-06T17:00:00Z"},
+		},
+	}
+	for _, test := range tests {
+		changes, err := changedFields([]byte(before), []byte(test.after))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if test.want == nil {
+			if changes != nil {
+				t.Errorf("%s: got %s, want no changes", test.name, changes)
+			}
+			continue
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(changes, &got); err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %s, want %v", test.name, changes, test.want)
+		}
+	}
+
+	for _, after := range []string{"", "[1]", `{"title": }`} {
+		if _, err := changedFields([]byte(before), []byte(after)); err == nil {
+			t.Errorf("%q was read", after)
+		}
+	}
+}
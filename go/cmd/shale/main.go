@@ -0,0 +1,173 @@
+//Command shale manages a todo list from the command line, using the API's Go client.
+//
+//	shale ls --active --cat work
+//	shale add "Buy milk" -c shopping -p 2
+//	shale done 4
+//	shale edit 4
+//	shale rm 4
+//
+//The server URL and credentials are read from a config file; see shale help config.  Run shale help for every command
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+//command is a subcommand of shale
+type command struct {
+	name    string
+	args    string
+	summary string
+
+	//define registers the command's flags and returns the function that runs it with their values
+	define func(fs *flag.FlagSet) runFunc
+}
+
+//runFunc runs a command with its positional arguments
+type runFunc func(ctx context.Context, s *session, args []string) error
+
+//usageError is a command used wrongly, which exits with status 2
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+//commands lists every command, in the order shown by help
+var commands []*command
+
+func init() {
+	commands = []*command{lsCommand, addCommand, doneCommand, editCommand, rmCommand, undoCommand, redoCommand, configCommand, completionCommand}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+//run runs the command line args and returns the exit status
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				fs, _ := newFlagSet(cmd, &session{}, stdout)
+				fs.Usage()
+				return 0
+			}
+		}
+		usage(stdout)
+		return 0
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(stderr, "shale: unknown command %q\n\n", args[0])
+		usage(stderr)
+		return 2
+	}
+	s := &session{out: stdout}
+	fs, runCmd := newFlagSet(cmd, s, stderr)
+	positional, err := parseArgs(fs, args[1:])
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := runCmd(ctx, s, positional); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		if _, ok := err.(usageError); ok {
+			fmt.Fprintf(stderr, "Run 'shale help %s' for usage.\n", cmd.name)
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+//findCommand returns the command with the given name, or nil
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+//usage prints the list of commands
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: shale <command> [flags] [args]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-11s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nEvery command accepts --config, --url, --user, and -o/--output.  Run shale help <command> for its flags.\n")
+}
+
+//newFlagSet returns the flags of a command, including the flags shared by every command, which are set on s
+func newFlagSet(cmd *command, s *session, w io.Writer) (*flag.FlagSet, runFunc) {
+	fs := flag.NewFlagSet("shale "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.StringVar(&s.configPath, "config", "", "config file (default $SHALE_CONFIG or "+defaultConfigPath()+")")
+	fs.StringVar(&s.url, "url", "", "server URL, overriding $SHALE_URL and the config file")
+	fs.StringVar(&s.user, "user", "", "list to manage, overriding $SHALE_USER and the config file")
+	fs.StringVar(&s.output, "o", "", "output format: table, json, or csv")
+	fs.StringVar(&s.output, "output", "", "output format: table, json, or csv")
+	runCmd := cmd.define(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(w, "Usage: %s\n\n%s\n\nFlags:\n", strings.TrimSpace("shale "+cmd.name+" [flags] "+cmd.args), cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs, runCmd
+}
+
+//parseArgs parses flags given before, between, or after the positional arguments, which it returns.  Everything after "--" is positional
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+//flagSet reports whether any of the named flags was given
+func flagSet(fs *flag.FlagSet, names ...string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		for _, name := range names {
+			if f.Name == name {
+				set = true
+			}
+		}
+	})
+	return set
+}
+
+//splitList splits a comma separated list, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shale/go/format"
+	"github.com/shale/go/types"
+)
+
+//Output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+//outputs lists every output format
+var outputs = []string{outputTable, outputJSON, outputCSV}
+
+//validOutput reports whether output is a supported output format
+func validOutput(output string) bool {
+	for _, o := range outputs {
+		if o == output {
+			return true
+		}
+	}
+	return false
+}
+
+//printTodos prints todo items.  JSON and CSV match the files exported by the API
+func printTodos(w io.Writer, output string, todos []types.TodoData) error {
+	if output != outputTable {
+		return format.Encode(w, output, todos)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPRI\tDONE\tCATEGORY\tDUE\tTITLE\tTAGS")
+	for _, todo := range todos {
+		done, due := "", ""
+		if !todo.Active {
+			done = "x"
+		}
+		if todo.Due != nil {
+			due = todo.Due.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\t%s\n", todo.ID, todo.Priority, done, cell(todo.Category), due, cell(todo.Title), cell(strings.Join(todo.Tags, ",")))
+	}
+	return tw.Flush()
+}
+
+//cell keeps a value on one line of a table
+func cell(s string) string {
+	return strings.NewReplacer("\n", " ", "\r", " ", "\t", " ").Replace(s)
+}
+
+//printStatuses prints the results of removals.  A dry run lists the items that would be removed
+func printStatuses(w io.Writer, output string, statuses []types.ListStatus) error {
+	switch output {
+	case outputJSON:
+		return printJSON(w, statuses)
+	case outputCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"status", "info", "affected", "dry_run"})
+		for _, status := range statuses {
+			cw.Write([]string{status.Status, status.Info, strconv.FormatInt(status.Affected, 10), strconv.FormatBool(status.DryRun)})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	var todos []types.TodoData
+	for _, status := range statuses {
+		fmt.Fprintln(w, status.Info)
+		todos = append(todos, status.Todos...)
+	}
+	if len(todos) > 0 {
+		fmt.Fprintln(w)
+		return printTodos(w, output, todos)
+	}
+	return nil
+}
+
+//printOp prints the result of an undo or redo
+func printOp(w io.Writer, output string, verb string, result types.OpResult) error {
+	switch output {
+	case outputJSON:
+		return printJSON(w, result)
+	case outputCSV:
+		return printTodos(w, output, result.Todos)
+	}
+	fmt.Fprintf(w, "%s %s\n\n", verb, result.Op)
+	return printTodos(w, output, result.Todos)
+}
+
+//printParsed prints what Quick Add read from a line, without adding it
+func printParsed(w io.Writer, output string, parsed types.QuickAddResult) error {
+	if output == outputJSON {
+		return printJSON(w, parsed)
+	}
+	todo := types.TodoData{Title: parsed.Parsed.Title, Category: parsed.Parsed.Category, Tags: parsed.Parsed.Tags, Active: true, Recurrence: parsed.Parsed.Recurrence}
+	if parsed.Parsed.Priority != nil {
+		todo.Priority = *parsed.Parsed.Priority
+	}
+	if parsed.Parsed.Due != nil {
+		due := parsed.Parsed.Due.In(time.Local)
+		todo.Due = &due
+	}
+	return printTodos(w, output, []types.TodoData{todo})
+}
+
+//printJSON prints v as indented JSON
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+func TestPrintTodos(t *testing.T) {
+	due := time.Date(2020, 5, 6, 17, 0, 0, 0, time.UTC)
+	before := 30
+	todos := []types.TodoData{
+		{ID: 1, Title: "Buy milk", Body: "Semi-skimmed,\n\"two\" pints", Category: "shopping", Priority: 2, Active: true, Tags: []string{"a", "b"}, Due: &due, RemindBefore: &before},
+		{ID: 2, Title: "Call mum", Active: false, Recurrence: "FREQ=WEEKLY"},
+	}
+
+	var out bytes.Buffer
+	if err := printTodos(&out, outputCSV, todos); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,title,body,category,item_priority,active,tags,due,remind_at,remind_before,recurrence,publish_date\n" +
+		"1,Buy milk,\"Semi-skimmed,\n\"\"two\"\" pints\",shopping,2,true,\"a,b\",2020-05-06T17:00:00Z,,30,,\n" +
+		"2,Call mum,,,0,false,,,,,FREQ=WEEKLY,\n"
+	if out.String() != want {
+		t.Errorf("got CSV\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := printTodos(&out, outputJSON, todos); err != nil {
+		t.Fatal(err)
+	}
+	var got []types.TodoData
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	if !reflect.DeepEqual(got, todos) {
+		t.Errorf("got JSON %s, want %+v", out.String(), todos)
+	}
+
+	out.Reset()
+	if err := printTodos(&out, outputJSON, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "[]\n" {
+		t.Errorf("got %q for no todos, want an empty array", out.String())
+	}
+}
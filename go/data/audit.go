@@ -0,0 +1,179 @@
+package data
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+)
+
+//SystemActor is recorded as the actor of writes not made on behalf of a request, such as purging the trash
+const SystemActor = "system"
+
+//Audited fields of a todo item, as recorded in the field column of the audit log
+const (
+	FieldTitle        = "title"
+	FieldBody         = "body"
+	FieldCategory     = "category"
+	FieldPriority     = "item_priority"
+	FieldActive       = "active"
+	FieldTags         = "tags"
+	FieldDeleted      = "deleted_at"
+	FieldDue          = "due"
+	FieldRemindAt     = "remind_at"
+	FieldRemindBefore = "remind_before"
+	FieldRecurrence   = "recurrence"
+)
+
+//maxAuditRecords caps the number of audit records returned by one query
+const maxAuditRecords = 1000
+
+//auditColumns lists the Audit columns in the order scanAudit expects them
+const auditColumns = "id, acct_name, todo_id, op, field, old_value, new_value, actor, request_id, created_at"
+
+//As returns a copy of the store whose writes are recorded in the audit log as made by actor, while handling the request with the given ID
+func (store *StoreType) As(actor string, requestID string) *StoreType {
+	scoped := *store
+	scoped.actor = actor
+	scoped.requestID = requestID
+	return &scoped
+}
+
+//auditFields returns the audited fields of a todo item as they are recorded in the audit log.  A nil todo has no values
+func auditFields(todo *types.TodoData) map[string]*string {
+	values := make(map[string]*string)
+	if todo == nil {
+		return values
+	}
+	str := func(s string) *string { return &s }
+	values[FieldTitle] = str(todo.Title)
+	values[FieldBody] = str(todo.Body)
+	values[FieldCategory] = str(todo.Category)
+	values[FieldPriority] = str(strconv.Itoa(todo.Priority))
+	values[FieldActive] = str(strconv.FormatBool(todo.Active))
+	values[FieldTags] = str(strings.Join(todo.Tags, ","))
+	values[FieldRecurrence] = str(todo.Recurrence)
+	if todo.DeletedAt.Valid {
+		values[FieldDeleted] = str(todo.DeletedAt.Time.UTC().Format(time.RFC3339))
+	}
+	if todo.Due != nil {
+		values[FieldDue] = str(todo.Due.UTC().Format(time.RFC3339))
+	}
+	if todo.RemindAt != nil {
+		values[FieldRemindAt] = str(todo.RemindAt.UTC().Format(time.RFC3339))
+	}
+	if todo.RemindBefore != nil {
+		values[FieldRemindBefore] = str(strconv.Itoa(*todo.RemindBefore))
+	}
+	return values
+}
+
+//auditOrder is the order in which the fields of a change are recorded
+var auditOrder = []string{FieldTitle, FieldBody, FieldCategory, FieldPriority, FieldActive, FieldTags, FieldDue, FieldRemindAt, FieldRemindBefore, FieldRecurrence, FieldDeleted}
+
+//audit appends a record to the audit log for every field changed, in the same transaction as the change
+func (store *StoreType) audit(tx *sql.Tx, name string, op string, changes []types.Change) error {
+	actor := store.actor
+	if actor == "" {
+		actor = SystemActor
+	}
+	for _, change := range changes {
+		todo := change.After
+		if todo == nil {
+			todo = change.Before
+		}
+		before, after := auditFields(change.Before), auditFields(change.After)
+		for _, field := range auditOrder {
+			oldValue, newValue := before[field], after[field]
+			if oldValue == nil && newValue == nil || oldValue != nil && newValue != nil && *oldValue == *newValue {
+				continue
+			}
+			_, err := tx.Exec(`
+INSERT INTO Audit (acct_name, todo_id, op, field, old_value, new_value, actor, request_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				name, todo.ID, op, field, oldValue, newValue, actor, store.requestID)
+			if err != nil {
+				log.Errorf("Error writing audit log: %v", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//scanAudit reads every audit record from a result set and closes it
+func scanAudit(results *sql.Rows) ([]types.AuditRecord, error) {
+	defer results.Close()
+	var records []types.AuditRecord
+	for results.Next() {
+		var record types.AuditRecord
+		var oldValue, newValue sql.NullString
+		err := results.Scan(&record.ID, &record.Name, &record.TodoID, &record.Op, &record.Field, &oldValue, &newValue, &record.Actor, &record.RequestID, &record.CreatedAt)
+		if err != nil {
+			log.Warnf("Error selecting audit record: %v", err)
+			return nil, err
+		}
+		if oldValue.Valid {
+			record.OldValue = &oldValue.String
+		}
+		if newValue.Valid {
+			record.NewValue = &newValue.String
+		}
+		records = append(records, record)
+	}
+	return records, results.Err()
+}
+
+//SelectHistory returns every change recorded for a todo item, oldest first, including changes made while it was in the trash and its purge
+func (store *StoreType) SelectHistory(id int, name string) ([]types.AuditRecord, error) {
+	results, err := store.DAO.Query(`SELECT `+auditColumns+` FROM Audit WHERE acct_name = ? AND todo_id = ? ORDER BY id`, name, id)
+	if err != nil {
+		log.Errorf("Error querying audit log: %v", err)
+		return nil, err
+	}
+	return scanAudit(results)
+}
+
+//SelectAudit returns the audit records matching filter across every account, newest first.  At most filter.Limit records are returned, capped at 1000
+func (store *StoreType) SelectAudit(filter types.AuditFilter) ([]types.AuditRecord, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Name != "" {
+		conditions = append(conditions, "acct_name = ?")
+		args = append(args, filter.Name)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.TodoID != 0 {
+		conditions = append(conditions, "todo_id = ?")
+		args = append(args, filter.TodoID)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.To)
+	}
+	query := `SELECT ` + auditColumns + ` FROM Audit`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	limit := filter.Limit
+	if limit <= 0 || limit > maxAuditRecords {
+		limit = maxAuditRecords
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+	results, err := store.DAO.Query(query, args...)
+	if err != nil {
+		log.Errorf("Error querying audit log: %v", err)
+		return nil, err
+	}
+	return scanAudit(results)
+}
@@ -0,0 +1,189 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+)
+
+//Kinds of operation in a batch
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+//BatchOp is a single write in a batch, already validated by the caller.  Todo is the item to create and Patch the fields to update
+type BatchOp struct {
+	Op      string
+	ID      int
+	Version int
+	Todo    types.TodoData
+	Patch   types.TodoPatch
+}
+
+//BatchOutcome is the result of a single operation in a batch: the todo item as written, or the reason the operation failed
+type BatchOutcome struct {
+	Todo types.TodoData
+	Err  error
+}
+
+//errBatchAborted rolls back an atomic batch after one of its operations has failed
+var errBatchAborted = errors.New("batch aborted")
+
+//Batch runs a list of writes to an account's list in a single transaction, returning the outcome of each.  An atomic batch stops at the first failed operation and is rolled back as a whole, so its outcomes end with the failure.  Otherwise each failed operation is rolled back on its own and the rest are committed.  The batch is undone as a single operation
+func (store *StoreType) Batch(name string, ops []BatchOp, atomic bool) ([]BatchOutcome, error) {
+	return store.batch(name, OpBatch, ops, atomic)
+}
+
+//Import runs the writes that import a file as an atomic batch, recorded as a single import operation
+func (store *StoreType) Import(name string, ops []BatchOp) ([]BatchOutcome, error) {
+	return store.batch(name, OpImport, ops, true)
+}
+
+//batch runs a list of writes as the given operation
+func (store *StoreType) batch(name string, opName string, ops []BatchOp, atomic bool) ([]BatchOutcome, error) {
+	var outcomes []BatchOutcome
+	_, err := store.write(name, opName, func(tx *sql.Tx) ([]types.Change, error) {
+		var room *allowance
+		for _, op := range ops {
+			if op.Op == BatchCreate {
+				var err error
+				if room, err = store.lockAllowance(tx, name); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+		var changes []types.Change
+		for _, op := range ops {
+			if !atomic {
+				if _, err := tx.Exec(`SAVEPOINT batch_op`); err != nil {
+					log.Errorf("Error setting savepoint: %v", err)
+					return nil, err
+				}
+			}
+			change, err := runBatchOp(tx, name, op, room)
+			if err != nil {
+				outcomes = append(outcomes, BatchOutcome{Err: err})
+				if atomic {
+					return nil, errBatchAborted
+				}
+				if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT batch_op`); err != nil {
+					log.Errorf("Error rolling back to savepoint: %v", err)
+					return nil, err
+				}
+				continue
+			}
+			outcomes = append(outcomes, BatchOutcome{Todo: *change.After})
+			changes = append(changes, change)
+		}
+		return changes, nil
+	})
+	if err == errBatchAborted {
+		return outcomes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return outcomes, nil
+}
+
+//runBatchOp applies a single batch operation within the batch's transaction.  room, which batches that create items must hold, counts the items on the list as they are added and removed
+func runBatchOp(tx *sql.Tx, name string, op BatchOp, room *allowance) (types.Change, error) {
+	switch op.Op {
+	case BatchCreate:
+		if err := room.check(); err != nil {
+			return types.Change{}, err
+		}
+		op.Todo.Name = name
+		change, err := insertTodo(tx, op.Todo)
+		if err == nil {
+			room.used++
+		}
+		return change, err
+	case BatchUpdate:
+		before, err := lockListed(tx, op.ID, name, op.Version)
+		if err != nil {
+			return types.Change{}, err
+		}
+		return patchTodo(tx, before, op.Patch)
+	case BatchDelete:
+		changes, err := updateByID(tx, op.ID, name, op.Version, "deleted_at", time.Now())
+		if err != nil {
+			return types.Change{}, err
+		}
+		if room != nil {
+			room.used--
+		}
+		return changes[0], nil
+	}
+	return types.Change{}, fmt.Errorf("unknown batch operation %q", op.Op)
+}
+
+//patchTodo sets the fields of a patch on a locked todo item
+func patchTodo(tx *sql.Tx, before types.TodoData, patch types.TodoPatch) (types.Change, error) {
+	target := before
+	patch.Apply(&target)
+	after, err := writeState(tx, target)
+	if err != nil {
+		return types.Change{}, err
+	}
+	return types.Change{Before: &before, After: &after}, nil
+}
+
+//PatchByID sets the fields of a patch on a todo item and returns the updated item.  A non-zero version makes the update conditional on the item still being at that version
+func (store *StoreType) PatchByID(id int, patch types.TodoPatch, name string, version int) (types.TodoData, error) {
+	changes, err := store.write(name, OpUpdate, func(tx *sql.Tx) ([]types.Change, error) {
+		before, err := lockListed(tx, id, name, version)
+		if err != nil {
+			return nil, err
+		}
+		change, err := patchTodo(tx, before, patch)
+		if err != nil {
+			return nil, err
+		}
+		return []types.Change{change}, nil
+	})
+	if err != nil {
+		return types.TodoData{}, err
+	}
+	return *changes[0].After, nil
+}
+
+//UpdateWhere sets the fields of a patch on every todo item of an account matching filter, and returns the items as updated
+func (store *StoreType) UpdateWhere(filter types.Filter, patch types.TodoPatch, name string) ([]types.TodoData, error) {
+	where, args := whereFilter(filter, name)
+	changes, err := store.write(name, OpUpdate, func(tx *sql.Tx) ([]types.Change, error) {
+		results, err := tx.Query(`SELECT `+todoColumns+` FROM Todos`+where+` FOR UPDATE`, args...)
+		if err != nil {
+			log.Errorf("Error querying mysql: %v", err)
+			return nil, err
+		}
+		before, err := scanTodos(results)
+		if err != nil {
+			return nil, err
+		}
+		changes := make([]types.Change, 0, len(before))
+		for _, todo := range before {
+			change, err := patchTodo(tx, todo, patch)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, change)
+		}
+		return changes, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	updated := make([]types.TodoData, 0, len(changes))
+	for _, change := range changes {
+		updated = append(updated, *change.After)
+	}
+	return updated, nil
+}
@@ -0,0 +1,179 @@
+package data
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+)
+
+//calendarColumns lists the columns of a calendar item, in the order scanCalendarItem expects them
+const calendarColumns = todoColumns + ", ical_uid, dav_name"
+
+//scanCalendarItem reads a single calendar item selected with calendarColumns
+func scanCalendarItem(row scanner) (types.CalendarItem, error) {
+	var item types.CalendarItem
+	var uid, href sql.NullString
+	todo, err := scanTodo(row, &uid, &href)
+	if err != nil {
+		return item, err
+	}
+	item.Todo = todo
+	item.UID = uid.String
+	item.Href = href.String
+	if item.Href == "" {
+		item.Href = strconv.Itoa(todo.ID) + ".ics"
+	}
+	return item, nil
+}
+
+//SelectCalendar returns every todo item on an account's list as a calendar item
+func (store *StoreType) SelectCalendar(name string) ([]types.CalendarItem, error) {
+	results, err := store.DAO.Query(`SELECT `+calendarColumns+` FROM Todos WHERE acct_name = ? AND deleted_at IS NULL ORDER BY id`, name)
+	if err != nil {
+		log.Errorf("Error querying mysql: %v", err)
+		return nil, err
+	}
+	defer results.Close()
+	var items []types.CalendarItem
+	for results.Next() {
+		item, err := scanCalendarItem(results)
+		if err != nil {
+			log.Warnf("Error selecting single row: %v", err)
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, results.Err()
+}
+
+//SelectCalendarItem returns the calendar item stored at href, or ErrNotFound.  Items not created through CalDAV are found at <id>.ics
+func (store *StoreType) SelectCalendarItem(href string, name string) (types.CalendarItem, error) {
+	id, _ := strconv.Atoi(strings.TrimSuffix(href, ".ics"))
+	item, err := scanCalendarItem(store.DAO.QueryRow(`
+SELECT `+calendarColumns+` FROM Todos WHERE acct_name = ? AND deleted_at IS NULL AND (dav_name = ? OR (dav_name IS NULL AND id = ?))`, name, href, id))
+	if err == sql.ErrNoRows {
+		return item, ErrNotFound
+	}
+	if err != nil {
+		log.Errorf("Error querying mysql: %v", err)
+	}
+	return item, err
+}
+
+//InsertCalendarItem adds a todo item created by a CalDAV client, recording the UID and resource name the client chose.  An item in the trash that was stored under the same name gives it up, and is found by its ID from then on
+func (store *StoreType) InsertCalendarItem(item types.CalendarItem) (types.CalendarItem, error) {
+	changes, err := store.write(item.Todo.Name, OpInsert, func(tx *sql.Tx) ([]types.Change, error) {
+		room, err := store.lockAllowance(tx, item.Todo.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := room.check(); err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`UPDATE Todos SET dav_name = NULL WHERE acct_name = ? AND dav_name = ? AND deleted_at IS NOT NULL`, item.Todo.Name, item.Href)
+		if err != nil {
+			log.Errorf("Error releasing calendar item name: %v", err)
+			return nil, err
+		}
+		change, err := insertTodo(tx, item.Todo)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`UPDATE Todos SET ical_uid = ?, dav_name = ? WHERE id = ?`, item.UID, item.Href, change.After.ID)
+		if err != nil {
+			log.Errorf("Error setting calendar item names: %v", err)
+			return nil, err
+		}
+		return []types.Change{change}, nil
+	})
+	if err != nil {
+		return item, err
+	}
+	item.Todo = *changes[0].After
+	return item, nil
+}
+
+//hashToken returns the form in which a feed, CalDAV, or inbound email token is stored, so that the tokens cannot be read back from the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//SetFeedToken replaces the calendar feed token of an account, revoking any earlier token
+func (store *StoreType) SetFeedToken(name string, token string) error {
+	_, err := store.DAO.Exec(`
+INSERT INTO FeedTokens (acct_name, token_hash, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+ON DUPLICATE KEY UPDATE token_hash = VALUES(token_hash), created_at = CURRENT_TIMESTAMP`, name, hashToken(token))
+	if err != nil {
+		log.Errorf("Error setting feed token: %v", err)
+	}
+	return err
+}
+
+//DeleteFeedToken revokes the calendar feed token of an account, returning ErrNotFound if it has none
+func (store *StoreType) DeleteFeedToken(name string) error {
+	res, err := store.DAO.Exec(`DELETE FROM FeedTokens WHERE acct_name = ?`, name)
+	if err != nil {
+		log.Errorf("Error deleting feed token: %v", err)
+		return err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+//SelectFeedOwner returns the account a calendar feed token belongs to, or ErrNotFound if the token is not valid
+func (store *StoreType) SelectFeedOwner(token string) (string, error) {
+	var name string
+	err := store.DAO.QueryRow(`SELECT acct_name FROM FeedTokens WHERE token_hash = ?`, hashToken(token)).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		log.Errorf("Error selecting feed token: %v", err)
+	}
+	return name, err
+}
+
+//SetCalDAVToken replaces the CalDAV password of an account, revoking any earlier password
+func (store *StoreType) SetCalDAVToken(name string, token string) error {
+	_, err := store.DAO.Exec(`
+INSERT INTO CalDAVTokens (acct_name, token_hash, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+ON DUPLICATE KEY UPDATE token_hash = VALUES(token_hash), created_at = CURRENT_TIMESTAMP`, name, hashToken(token))
+	if err != nil {
+		log.Errorf("Error setting CalDAV token: %v", err)
+	}
+	return err
+}
+
+//DeleteCalDAVToken revokes the CalDAV password of an account, returning ErrNotFound if it has none
+func (store *StoreType) DeleteCalDAVToken(name string) error {
+	res, err := store.DAO.Exec(`DELETE FROM CalDAVTokens WHERE acct_name = ?`, name)
+	if err != nil {
+		log.Errorf("Error deleting CalDAV token: %v", err)
+		return err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+//SelectCalDAVOwner returns the account a CalDAV password belongs to, or ErrNotFound if the password is not valid
+func (store *StoreType) SelectCalDAVOwner(token string) (string, error) {
+	var name string
+	err := store.DAO.QueryRow(`SELECT acct_name FROM CalDAVTokens WHERE token_hash = ?`, hashToken(token)).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		log.Errorf("Error selecting CalDAV token: %v", err)
+	}
+	return name, err
+}
@@ -4,101 +4,197 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/bdlm/log"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/shale/go/events"
 	"github.com/shale/go/types"
 )
 
+//ErrNotFound is returned when a todo item does not exist for the given account
+var ErrNotFound = errors.New("todo item not found")
+
+//ErrStale is returned when a conditional write finds the todo item at a different version than expected
+var ErrStale = errors.New("todo item has been modified")
+
 //Store is the interface defining the object for db functions
 type Store interface {
-	InsertTodo() error
+	InsertTodo(todo types.TodoData) (types.TodoData, error)
 	SelectAllTodos(name string) ([]types.TodoData, error)
-	SelectByPriority(priority int, name string) error
-	SelectNonPriority(name string) error
-	SelectByCategory(category string, name string) error
-	SelectByID(id int, name string) error
-	DeleteByTitle(title int, name string) error
-	DeleteByPriority(priority int, name string) error
-	DeleteInactive(name string) error
-	DeleteByID(id int, name string) error
-	UpdateTitle(id int, newTitle string, name string)
-	UpdatePriority(id int, newPriority int, name string)
-	UpdateActive(id int, newActive bool, name string) error
+	SelectActives(active bool, name string) ([]types.TodoData, error)
+	SelectByPriority(priority int, name string) ([]types.TodoData, error)
+	SelectNonPriority(name string) ([]types.TodoData, error)
+	SelectByCategory(category string, name string) ([]types.TodoData, error)
+	SelectByID(id int, name string) (types.TodoData, error)
+	DeleteByTitle(title string, name string) (int64, error)
+	DeleteByPriority(priority int, name string) (int64, error)
+	DeleteInactive(name string) (int64, error)
+	DeleteByID(id int, name string, version int) (int64, error)
+	SelectWhere(filter types.Filter, name string) ([]types.TodoData, error)
+	DeleteWhere(filter types.Filter, name string) (int64, error)
+	UpdateWhere(filter types.Filter, patch types.TodoPatch, name string) ([]types.TodoData, error)
+	Batch(name string, ops []BatchOp, atomic bool) ([]BatchOutcome, error)
+	Import(name string, ops []BatchOp) ([]BatchOutcome, error)
+	PatchByID(id int, patch types.TodoPatch, name string, version int) (types.TodoData, error)
+	UpdateTitle(id int, newTitle string, name string, version int) (types.TodoData, error)
+	UpdatePriority(id int, newPriority int, name string, version int) (types.TodoData, error)
+	UpdateActive(id int, newActive bool, name string, version int) (types.TodoData, error)
 }
 
+var _ Store = &StoreType{}
+
 //StoreType is the struct holding the db connection
 type StoreType struct {
 	DAO *sql.DB
+
+	//MaxTodos is the number of todo items an account may hold unless its quota overrides it.  Zero is no limit
+	MaxTodos int
+
+	//UndoDepth is the number of operations kept in each account's operation log.  Zero disables undo
+	UndoDepth int
+
+	//Broker, if set, is told about every committed change to a list
+	Broker events.Broker
+
+	//Webhooks enables queueing every committed change for delivery to the account's webhooks
+	Webhooks bool
+
+	//Outbox writes the events describing every change to the outbox, in the same transaction, for a relay to publish.  Without it, events are published after the change commits, and are lost if the process dies in between
+	Outbox bool
+
+	//actor and requestID identify who is writing, for the audit log.  See As
+	actor     string
+	requestID string
 }
 
-//InsertTodo adds a brand new, fresh, shiny, little todo item to the todo list
-func (store *StoreType) InsertTodo(todo types.TodoData) error {
-	_, err := store.DAO.Exec(`
-INSERT INTO Todos (acct_name, title, body, category, item_priority, publish_date, active) VALUES (?, ?, ?, ?, ?, ?, ?)`, todo.Name, todo.Title, todo.Body, todo.Category, todo.Priority, time.Now(), true)
-	if err != nil {
-		log.Errorf("Error inserting todo item: %v", err)
-		return err
-	}
-	return nil
+//todoColumns lists the Todos columns in the order scanTodo expects them
+const todoColumns = "id, acct_name, title, body, category, item_priority, publish_date, active, tags, version, updated_at, deleted_at, due_at, remind_at, remind_before, recurrence"
+
+//scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
 }
 
-//SelectAllTodos selects all todo items from the db.  Providing a value of true for active will cause todo items to be returned only if they are actice
-func (store *StoreType) SelectAllTodos(name string) ([]types.TodoData, error) {
-	results, err := store.DAO.Query(`SELECT * FROM Todos WHERE acct_name = ?`, name)
+//scanTodo reads a single todo item selected with todoColumns.  Any columns selected after todoColumns are scanned into extra
+func scanTodo(row scanner, extra ...interface{}) (types.TodoData, error) {
+	var tag types.TodoData
+	var tags sql.NullString
+	var due, remindAt mysql.NullTime
+	var remindBefore sql.NullInt64
+	dest := []interface{}{&tag.ID, &tag.Name, &tag.Title, &tag.Body, &tag.Category, &tag.Priority, &tag.PublishDate, &tag.Active, &tags, &tag.Version, &tag.UpdatedAt, &tag.DeletedAt,
+		&due, &remindAt, &remindBefore, &tag.Recurrence}
+	err := row.Scan(append(dest, extra...)...)
 	if err != nil {
-		log.Errorf("Error querying mysql: %v", err)
-		return nil, err
+		return tag, err
+	}
+	if tags.String != "" {
+		tag.Tags = strings.Split(tags.String, ",")
+	}
+	if due.Valid {
+		tag.Due = &due.Time
 	}
+	if remindAt.Valid {
+		tag.RemindAt = &remindAt.Time
+	}
+	if remindBefore.Valid {
+		minutes := int(remindBefore.Int64)
+		tag.RemindBefore = &minutes
+	}
+	return tag, nil
+}
+
+//scanTodos reads every todo item from a result set and closes it
+func scanTodos(results *sql.Rows) ([]types.TodoData, error) {
+	defer results.Close()
 	var tags []types.TodoData
 	for results.Next() {
-		var tag types.TodoData
-		err = results.Scan(&tag.ID, &tag.Name, &tag.Title, &tag.Body, &tag.Category, &tag.Priority, &tag.PublishDate, &tag.Active)
+		tag, err := scanTodo(results)
 		if err != nil {
 			log.Warnf("Error selecting single row: %v", err)
 			return nil, err
 		}
 		tags = append(tags, tag)
 	}
-	return tags, nil
+	return tags, results.Err()
+}
+
+//InsertTodo adds a brand new, fresh, shiny, little todo item to the todo list and returns it as stored.  The item is active only if todo.Active is set
+func (store *StoreType) InsertTodo(todo types.TodoData) (types.TodoData, error) {
+	changes, err := store.write(todo.Name, OpInsert, func(tx *sql.Tx) ([]types.Change, error) {
+		room, err := store.lockAllowance(tx, todo.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := room.check(); err != nil {
+			return nil, err
+		}
+		change, err := insertTodo(tx, todo)
+		if err != nil {
+			return nil, err
+		}
+		return []types.Change{change}, nil
+	})
+	if err != nil {
+		return types.TodoData{}, err
+	}
+	return *changes[0].After, nil
+}
+
+//insertTodo adds a todo item within a transaction
+func insertTodo(tx *sql.Tx, todo types.TodoData) (types.Change, error) {
+	res, err := tx.Exec(`
+INSERT INTO Todos (acct_name, title, body, category, item_priority, publish_date, active, tags, due_at, remind_at, remind_before, recurrence) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		todo.Name, todo.Title, todo.Body, todo.Category, todo.Priority, time.Now(), todo.Active, strings.Join(todo.Tags, ","), todo.Due, todo.RemindAt, todo.RemindBefore, todo.Recurrence)
+	if err != nil {
+		log.Errorf("Error inserting todo item: %v", err)
+		return types.Change{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return types.Change{}, err
+	}
+	created, err := selectByID(tx, int(id), todo.Name)
+	if err != nil {
+		return types.Change{}, err
+	}
+	return types.Change{After: &created}, nil
+}
+
+//SelectAllTodos selects all todo items from the db.  Providing a value of true for active will cause todo items to be returned only if they are actice
+func (store *StoreType) SelectAllTodos(name string) ([]types.TodoData, error) {
+	results, err := store.DAO.Query(`SELECT `+todoColumns+` FROM Todos WHERE acct_name = ? AND deleted_at IS NULL`, name)
+	if err != nil {
+		log.Errorf("Error querying mysql: %v", err)
+		return nil, err
+	}
+	return scanTodos(results)
 }
 
 //SelectActives selects all todo items from the db.  Providing a value of true for active will cause todo items to be returned only if they are actice
 func (store *StoreType) SelectActives(active bool, name string) ([]types.TodoData, error) {
-	results, err := store.DAO.Query(`SELECT * FROM Todos WHERE active = ? AND acct_name = ?`, active, name)
+	results, err := store.DAO.Query(`SELECT `+todoColumns+` FROM Todos WHERE active = ? AND acct_name = ? AND deleted_at IS NULL`, active, name)
 	if err != nil {
 		log.Errorf("Error querying mysql: %v", err)
 		return nil, err
 	}
-	var tags []types.TodoData
-	for results.Next() {
-		var tag types.TodoData
-		err = results.Scan(&tag.ID, &tag.Name, &tag.Title, &tag.Body, &tag.Category, &tag.Priority, &tag.PublishDate, &tag.Active)
-		if err != nil {
-			log.Warnf("Error selecting single row: %v", err)
-			return nil, err
-		}
-		tags = append(tags, tag)
-	}
-	return tags, nil
+	return scanTodos(results)
 }
 
 //SelectByPriority returns all todo items at or above the priority specified ...
 func (store *StoreType) SelectByPriority(priority int, name string) ([]types.TodoData, error) {
-	results, err := store.DAO.Query(`SELECT * FROM Todos WHERE item_priority <= ? AND acct_name = ?`, priority, name)
+	results, err := store.DAO.Query(`SELECT `+todoColumns+` FROM Todos WHERE item_priority <= ? AND acct_name = ? AND deleted_at IS NULL`, priority, name)
 	if err != nil {
 		log.Errorf("Error querying mysql: %v", err)
 		return nil, err
 	}
+	all, err := scanTodos(results)
+	if err != nil {
+		return nil, err
+	}
 	var tags []types.TodoData
-	for results.Next() {
-		var tag types.TodoData
-		err = results.Scan(&tag.ID, &tag.Name, &tag.Title, &tag.Body, &tag.Category, &tag.Priority, &tag.PublishDate, &tag.Active)
-		if err != nil {
-			log.Warnf("Error selecting single row: %v", err)
-			return nil, err
-		}
+	for _, tag := range all {
 		if tag.Priority != 0 {
 			tags = append(tags, tag)
 		}
@@ -108,48 +204,35 @@ func (store *StoreType) SelectByPriority(priority int, name string) ([]types.Tod
 
 //SelectNonPriority returns all todo items that do not have a priority specified (priority == 0)
 func (store *StoreType) SelectNonPriority(name string) ([]types.TodoData, error) {
-	results, err := store.DAO.Query(`SELECT * FROM Todos WHERE item_priority = 0 AND acct_name = ?`, name)
+	results, err := store.DAO.Query(`SELECT `+todoColumns+` FROM Todos WHERE item_priority = 0 AND acct_name = ? AND deleted_at IS NULL`, name)
 	if err != nil {
 		log.Errorf("Error querying mysql: %v", err)
 		return nil, err
 	}
-	var tags []types.TodoData
-	for results.Next() {
-		var tag types.TodoData
-		err = results.Scan(&tag.ID, &tag.Name, &tag.Title, &tag.Body, &tag.Category, &tag.Priority, &tag.PublishDate, &tag.Active)
-		if err != nil {
-			log.Warnf("Error selecting single row: %v", err)
-			return nil, err
-		}
-		tags = append(tags, tag)
-	}
-	return tags, nil
+	return scanTodos(results)
 }
 
 //SelectByCategory ...
 func (store *StoreType) SelectByCategory(category string, name string) ([]types.TodoData, error) {
-	results, err := store.DAO.Query(`SELECT * FROM Todos WHERE category = ? AND acct_name = ?`, category, name)
+	results, err := store.DAO.Query(`SELECT `+todoColumns+` FROM Todos WHERE category = ? AND acct_name = ? AND deleted_at IS NULL`, category, name)
 	if err != nil {
 		log.Errorf("Error querying mysql: %v", err)
 		return nil, err
 	}
-	var tags []types.TodoData
-	for results.Next() {
-		var tag types.TodoData
-		err = results.Scan(&tag.ID, &tag.Name, &tag.Title, &tag.Body, &tag.Category, &tag.Priority, &tag.PublishDate, &tag.Active)
-		if err != nil {
-			log.Warnf("Error selecting single row: %v", err)
-			return nil, err
-		}
-		tags = append(tags, tag)
-	}
-	return tags, nil
+	return scanTodos(results)
 }
 
 //SelectByID returns the todo item associated with the given id
 func (store *StoreType) SelectByID(id int, name string) (types.TodoData, error) {
-	var tag types.TodoData
-	err := store.DAO.QueryRow(`SELECT * FROM Todos WHERE id = ? AND acct_name = ?`, id, name).Scan(&tag.ID, &tag.Name, &tag.Title, &tag.Body, &tag.Category, &tag.Priority, &tag.PublishDate, &tag.Active)
+	return selectByID(store.DAO, id, name)
+}
+
+//selectByID reads a single todo item, either directly or within a transaction
+func selectByID(tx execer, id int, name string) (types.TodoData, error) {
+	tag, err := scanTodo(tx.QueryRow(`SELECT `+todoColumns+` FROM Todos WHERE id = ? AND acct_name = ? AND deleted_at IS NULL`, id, name))
+	if err == sql.ErrNoRows {
+		return tag, ErrNotFound
+	}
 	if err != nil {
 		log.Errorf("Error querying mysql: %v", err)
 		return tag, err
@@ -157,75 +240,244 @@ func (store *StoreType) SelectByID(id int, name string) (types.TodoData, error)
 	return tag, nil
 }
 
-//DeleteByTitle deletes all todo items with the specified title
-func (store *StoreType) DeleteByTitle(title string, name string) error {
-	fmt.Printf("DEL VALUES: %s/%s\n", title, name)
-	_, err := store.DAO.Query(`DELETE FROM Todos WHERE title = ? AND acct_name = ?`, title, name)
-	return err
+//CountTodos returns the number of todo items held by an account
+func (store *StoreType) CountTodos(name string) (int, error) {
+	var count int
+	err := store.DAO.QueryRow(`SELECT count(*) FROM Todos WHERE acct_name = ? AND deleted_at IS NULL`, name).Scan(&count)
+	if err != nil {
+		log.Errorf("Error counting todos: %v", err)
+		return 0, err
+	}
+	return count, nil
 }
 
-//DeleteByPriority deletes all todo items at the given priority level
-func (store *StoreType) DeleteByPriority(priority int, name string) error {
-	_, err := store.DAO.Query(`DELETE FROM Todos WHERE item_priority = ? AND acct_name = ?`, priority, name)
-	return err
+//SelectQuota returns the quota overrides configured for an account.  Limits that are not overridden are returned as zero
+func (store *StoreType) SelectQuota(name string) (types.Quota, error) {
+	var quota types.Quota
+	var maxTodos, maxBodyBytes, maxTags sql.NullInt64
+	err := store.DAO.QueryRow(`SELECT max_todos, max_body_bytes, max_tags FROM Quotas WHERE acct_name = ?`, name).Scan(&maxTodos, &maxBodyBytes, &maxTags)
+	if err == sql.ErrNoRows {
+		return quota, nil
+	}
+	if err != nil {
+		log.Errorf("Error selecting quota: %v", err)
+		return quota, err
+	}
+	quota.MaxTodos = int(maxTodos.Int64)
+	quota.MaxBodyBytes = maxBodyBytes.Int64
+	quota.MaxTags = int(maxTags.Int64)
+	return quota, nil
 }
 
-//DeleteInactive deletes all todo items at the given priority level
-func (store *StoreType) DeleteInactive(name string) error {
-	_, err := store.DAO.Query(`DELETE FROM Todos WHERE active = false AND acct_name = ?`, name)
-	return err
+//TodoLimitError is returned by a write that would take an account past the number of todo items it may hold
+type TodoLimitError struct {
+	Limit int
+	Used  int
 }
 
-//DeleteByID deletes a todo item that has the given ID
-func (store *StoreType) DeleteByID(id int, name string) error {
-	_, err := store.DAO.Query(`DELETE FROM Todos WHERE id = ? AND acct_name = ?`, id, name)
-	return err
+func (e *TodoLimitError) Error() string {
+	return fmt.Sprintf("account holds %d of %d todo items", e.Used, e.Limit)
 }
 
-//UpdateTitle updates the title of a todo iten based on its id
-func (store *StoreType) UpdateTitle(id int, newTitle string, name string) error {
-	var count int
-	err := store.DAO.QueryRow(`SELECT count(*) FROM Todos WHERE id = ? AND acct_name = ?`, id, name).Scan(&count)
-	if count == 0 {
-		return errors.New("ID does not exist")
+//allowance tracks how many todo items an account holds within a transaction that adds some
+type allowance struct {
+	limit int
+	used  int
+}
+
+//check fails if the account has no room for another todo item
+func (a *allowance) check() error {
+	if a.limit > 0 && a.used >= a.limit {
+		return &TodoLimitError{Limit: a.limit, Used: a.used}
 	}
+	return nil
+}
 
-	_, err = store.DAO.Exec(`UPDATE Todos SET title = ? WHERE id = ? AND acct_name = ?`, newTitle, id, name)
+//lockAllowance locks the account's list against concurrent adds until the transaction ends, then counts its todo items.  It must be the first read of the transaction, so that the count includes every add committed before the lock was taken
+func (store *StoreType) lockAllowance(tx *sql.Tx, name string) (*allowance, error) {
+	_, err := tx.Exec(`INSERT INTO Lists (acct_name, change_seq) VALUES (?, 0) ON DUPLICATE KEY UPDATE acct_name = acct_name`, name)
+	if err == nil {
+		var seq int64
+		err = tx.QueryRow(`SELECT change_seq FROM Lists WHERE acct_name = ? FOR UPDATE`, name).Scan(&seq)
+	}
 	if err != nil {
-		log.Errorf("Error updating title: %v", err)
-		return err
+		log.Errorf("Error locking list: %v", err)
+		return nil, err
 	}
-	return nil
+	a := &allowance{limit: store.MaxTodos}
+	var maxTodos sql.NullInt64
+	err = tx.QueryRow(`SELECT max_todos FROM Quotas WHERE acct_name = ?`, name).Scan(&maxTodos)
+	if err != nil && err != sql.ErrNoRows {
+		log.Errorf("Error selecting quota: %v", err)
+		return nil, err
+	}
+	if maxTodos.Int64 > 0 {
+		a.limit = int(maxTodos.Int64)
+	}
+	err = tx.QueryRow(`SELECT count(*) FROM Todos WHERE acct_name = ? AND deleted_at IS NULL`, name).Scan(&a.used)
+	if err != nil {
+		log.Errorf("Error counting todos: %v", err)
+		return nil, err
+	}
+	return a, nil
 }
 
-//UpdatePriority updates the priority level of a todo iten based on its id
-func (store *StoreType) UpdatePriority(id int, newPriority int, name string) error {
-	var count int
-	err := store.DAO.QueryRow(`SELECT count(*) FROM Todos WHERE id = ? AND acct_name = ?`, id, name).Scan(&count)
-	if count == 0 {
-		return errors.New("ID does not exist")
+//DeleteByTitle deletes all todo items with the specified title and returns how many were removed
+func (store *StoreType) DeleteByTitle(title string, name string) (int64, error) {
+	return store.DeleteWhere(types.Filter{Title: &title}, name)
+}
+
+//DeleteByPriority deletes all todo items at the given priority level and returns how many were removed
+func (store *StoreType) DeleteByPriority(priority int, name string) (int64, error) {
+	return store.DeleteWhere(types.Filter{Priority: &priority}, name)
+}
+
+//DeleteInactive deletes all inactive todo items and returns how many were removed
+func (store *StoreType) DeleteInactive(name string) (int64, error) {
+	active := false
+	return store.DeleteWhere(types.Filter{Active: &active}, name)
+}
+
+//whereFilter builds the WHERE clause selecting an account's todo items that match filter
+func whereFilter(filter types.Filter, name string) (string, []interface{}) {
+	conditions := []string{"acct_name = ?", "deleted_at IS NULL"}
+	args := []interface{}{name}
+	if filter.Title != nil {
+		conditions = append(conditions, "title = ?")
+		args = append(args, *filter.Title)
 	}
+	if filter.Category != nil {
+		conditions = append(conditions, "category = ?")
+		args = append(args, *filter.Category)
+	}
+	if filter.Priority != nil {
+		conditions = append(conditions, "item_priority = ?")
+		args = append(args, *filter.Priority)
+	}
+	if filter.Active != nil {
+		conditions = append(conditions, "active = ?")
+		args = append(args, *filter.Active)
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
 
-	_, err = store.DAO.Exec(`UPDATE Todos SET item_priority = ? WHERE id = ? AND acct_name = ?`, newPriority, id, name)
+//SelectWhere returns every todo item of an account matching filter
+func (store *StoreType) SelectWhere(filter types.Filter, name string) ([]types.TodoData, error) {
+	where, args := whereFilter(filter, name)
+	results, err := store.DAO.Query(`SELECT `+todoColumns+` FROM Todos`+where, args...)
 	if err != nil {
-		log.Errorf("Error updating rating: %v", err)
-		return err
+		log.Errorf("Error querying mysql: %v", err)
+		return nil, err
 	}
-	return nil
+	return scanTodos(results)
 }
 
-//UpdateActive updates whether a todo item is active or not, based on its id
-func (store *StoreType) UpdateActive(id int, newActive bool, name string) error {
-	var count int
-	err := store.DAO.QueryRow(`SELECT count(*) FROM Todos WHERE id = ? AND acct_name = ?`, id, name).Scan(&count)
-	if count == 0 {
-		return errors.New("Title does not exist")
+//DeleteWhere moves every todo item of an account matching filter to the trash and returns how many were removed
+func (store *StoreType) DeleteWhere(filter types.Filter, name string) (int64, error) {
+	where, args := whereFilter(filter, name)
+	changes, err := store.write(name, OpDelete, func(tx *sql.Tx) ([]types.Change, error) {
+		results, err := tx.Query(`SELECT `+todoColumns+` FROM Todos`+where+` FOR UPDATE`, args...)
+		if err != nil {
+			log.Errorf("Error querying mysql: %v", err)
+			return nil, err
+		}
+		before, err := scanTodos(results)
+		if err != nil || len(before) == 0 {
+			return nil, err
+		}
+		changes := make([]types.Change, 0, len(before))
+		for i := range before {
+			after, err := setColumn(tx, before[i], "deleted_at", time.Now())
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, types.Change{Before: &before[i], After: &after})
+		}
+		return changes, nil
+	})
+	return int64(len(changes)), err
+}
+
+//DeleteByID moves a todo item that has the given ID to the trash, returning ErrNotFound if there is no such item.  A non-zero version makes the delete conditional on the item still being at that version
+func (store *StoreType) DeleteByID(id int, name string, version int) (int64, error) {
+	changes, err := store.write(name, OpDelete, func(tx *sql.Tx) ([]types.Change, error) {
+		return updateByID(tx, id, name, version, "deleted_at", time.Now())
+	})
+	return int64(len(changes)), err
+}
+
+//UpdateTitle updates the title of a todo iten based on its id and returns the updated item
+func (store *StoreType) UpdateTitle(id int, newTitle string, name string, version int) (types.TodoData, error) {
+	return store.updateColumn(id, name, version, OpUpdateTitle, "title", newTitle)
+}
+
+//UpdatePriority updates the priority level of a todo iten based on its id and returns the updated item
+func (store *StoreType) UpdatePriority(id int, newPriority int, name string, version int) (types.TodoData, error) {
+	return store.updateColumn(id, name, version, OpUpdatePriority, "item_priority", newPriority)
+}
+
+//UpdateActive updates whether a todo item is active or not, based on its id, and returns the updated item
+func (store *StoreType) UpdateActive(id int, newActive bool, name string, version int) (types.TodoData, error) {
+	return store.updateColumn(id, name, version, OpUpdateActive, "active", newActive)
+}
+
+//updateColumn sets a single column of a todo item and returns the updated item
+func (store *StoreType) updateColumn(id int, name string, version int, op string, column string, value interface{}) (types.TodoData, error) {
+	changes, err := store.write(name, op, func(tx *sql.Tx) ([]types.Change, error) {
+		return updateByID(tx, id, name, version, column, value)
+	})
+	if err != nil {
+		return types.TodoData{}, err
+	}
+	return *changes[0].After, nil
+}
+
+//lockListed locks a todo item that is on the list.  It returns ErrNotFound if the item does not exist or is in the trash, and ErrStale if a non-zero version is given and the item has moved past it
+func lockListed(tx *sql.Tx, id int, name string, version int) (types.TodoData, error) {
+	todo, err := lockTodo(tx, id, name)
+	if err != nil {
+		return todo, err
 	}
+	if todo.DeletedAt.Valid {
+		return todo, ErrNotFound
+	}
+	if version != 0 && todo.Version != version {
+		return todo, ErrStale
+	}
+	return todo, nil
+}
 
-	_, err = store.DAO.Exec(`UPDATE Todos SET active = ? WHERE id = ? AND acct_name = ?`, newActive, id, name)
+//updateByID locks a todo item that is on the list and sets one of its columns, failing as lockListed does
+func updateByID(tx *sql.Tx, id int, name string, version int, column string, value interface{}) ([]types.Change, error) {
+	before, err := lockListed(tx, id, name, version)
 	if err != nil {
-		log.Errorf("Error updating rating: %v", err)
-		return err
+		return nil, err
 	}
-	return nil
+	after, err := setColumn(tx, before, column, value)
+	if err != nil {
+		return nil, err
+	}
+	return []types.Change{{Before: &before, After: &after}}, nil
+}
+
+//setColumn sets a single column of a locked todo item, bumps its version, and returns the item as updated
+func setColumn(tx *sql.Tx, todo types.TodoData, column string, value interface{}) (types.TodoData, error) {
+	_, err := tx.Exec(`UPDATE Todos SET `+column+` = ?, version = version + 1 WHERE id = ? AND acct_name = ?`, value, todo.ID, todo.Name)
+	if err != nil {
+		log.Errorf("Error updating %s: %v", column, err)
+		return todo, err
+	}
+	return lockTodo(tx, todo.ID, todo.Name)
+}
+
+//lockTodo reads a todo item, whether on the list or in the trash, and locks it until the transaction ends
+func lockTodo(tx *sql.Tx, id int, name string) (types.TodoData, error) {
+	tag, err := scanTodo(tx.QueryRow(`SELECT `+todoColumns+` FROM Todos WHERE id = ? AND acct_name = ? FOR UPDATE`, id, name))
+	if err == sql.ErrNoRows {
+		return tag, ErrNotFound
+	}
+	if err != nil {
+		log.Errorf("Error querying mysql: %v", err)
+	}
+	return tag, err
 }
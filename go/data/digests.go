@@ -0,0 +1,85 @@
+package data
+
+import (
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/digest"
+	"github.com/shale/go/notify"
+	"github.com/shale/go/types"
+)
+
+var _ digest.Store = &StoreType{}
+
+//SelectDigestAccounts returns the settings of every account that has turned on digests and has an email address to send them to
+func (store *StoreType) SelectDigestAccounts() ([]types.UserSettings, error) {
+	results, err := store.DAO.Query(`SELECT ` + settingsColumns + ` FROM UserSettings WHERE digest AND email <> ''`)
+	if err != nil {
+		log.Errorf("Error selecting digest accounts: %v", err)
+		return nil, err
+	}
+	defer results.Close()
+	var accounts []types.UserSettings
+	for results.Next() {
+		settings, err := scanSettings(results)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, settings)
+	}
+	return accounts, results.Err()
+}
+
+//SelectDueBefore returns the active todo items due before the given time, soonest first
+func (store *StoreType) SelectDueBefore(name string, before time.Time) ([]types.TodoData, error) {
+	results, err := store.DAO.Query(`SELECT `+todoColumns+` FROM Todos WHERE acct_name = ? AND deleted_at IS NULL AND active AND due_at < ? ORDER BY due_at, id`, name, before)
+	if err != nil {
+		log.Errorf("Error querying mysql: %v", err)
+		return nil, err
+	}
+	return scanTodos(results)
+}
+
+//ClaimDigest records that this instance is sending an account's digest for a day.  A digest that failed, or whose sender has not reported back, can be claimed again once retry has passed
+func (store *StoreType) ClaimDigest(name string, date string, now time.Time, retry time.Duration) (bool, int, error) {
+	res, err := store.DAO.Exec(`INSERT IGNORE INTO Digests (acct_name, digest_date, status, attempts, claimed_at) VALUES (?, ?, ?, 1, ?)`,
+		name, date, notify.Sending, now)
+	if err != nil {
+		log.Errorf("Error claiming digest: %v", err)
+		return false, 0, err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 1 {
+		return true, 1, nil
+	}
+	res, err = store.DAO.Exec(`
+UPDATE Digests SET status = ?, attempts = attempts + 1, claimed_at = ?
+WHERE acct_name = ? AND digest_date = ? AND status IN (?, ?) AND claimed_at <= ?`,
+		notify.Sending, now, name, date, notify.Sending, notify.Failed, now.Add(-retry))
+	if err != nil {
+		log.Errorf("Error claiming digest: %v", err)
+		return false, 0, err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return false, 0, err
+	}
+	var attempts int
+	err = store.DAO.QueryRow(`SELECT attempts FROM Digests WHERE acct_name = ? AND digest_date = ?`, name, date).Scan(&attempts)
+	return err == nil, attempts, err
+}
+
+//FinishDigest records whether a claimed digest was sent
+func (store *StoreType) FinishDigest(name string, date string, status string, lastError string) error {
+	var sentAt, errorValue interface{}
+	if status == notify.Sent {
+		sentAt = time.Now()
+	}
+	if lastError != "" {
+		errorValue = lastError
+	}
+	_, err := store.DAO.Exec(`UPDATE Digests SET status = ?, sent_at = ?, last_error = ? WHERE acct_name = ? AND digest_date = ?`,
+		status, sentAt, errorValue, name, date)
+	if err != nil {
+		log.Errorf("Error recording digest: %v", err)
+	}
+	return err
+}
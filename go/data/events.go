@@ -0,0 +1,98 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/events"
+	"github.com/shale/go/types"
+)
+
+var _ events.Log = &StoreType{}
+
+//publish hands the changes made by a committed write to the event broker, and queues their deliveries to webhooks, unless they are published through the outbox.  Events are best effort: a write is not failed because its events could not be published
+func (store *StoreType) publish(name string, changes []types.Change) {
+	if store.Outbox || len(changes) == 0 || (store.Broker == nil && !store.Webhooks) {
+		return
+	}
+	evts := events.FromChanges(name, changes)
+	if store.Broker != nil {
+		if err := store.Broker.Publish(evts); err != nil {
+			log.Warnf("Error publishing events: %v", err)
+		}
+	}
+	if store.Webhooks {
+		if err := store.EnqueueDeliveries(evts); err != nil {
+			log.Warnf("Error queueing webhook deliveries: %v", err)
+		}
+	}
+}
+
+//AppendEvents adds events to the shared event log, assigning their IDs
+func (store *StoreType) AppendEvents(evts []types.Event) error {
+	for _, event := range evts {
+		todo, err := json.Marshal(event.Todo)
+		if err != nil {
+			return err
+		}
+		_, err = store.DAO.Exec(`INSERT INTO Events (acct_name, event_type, todo, created_at) VALUES (?, ?, ?, ?)`, event.Name, event.Type, todo, event.Time)
+		if err != nil {
+			log.Errorf("Error appending event: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+//SelectEventsAfter returns up to limit events from the shared event log with IDs after the given one, oldest first.  An empty name selects the events of every account
+func (store *StoreType) SelectEventsAfter(after int64, name string, limit int) ([]types.Event, error) {
+	query := `SELECT id, acct_name, event_type, todo, created_at FROM Events WHERE id > ?`
+	args := []interface{}{after}
+	if name != "" {
+		query += ` AND acct_name = ?`
+		args = append(args, name)
+	}
+	results, err := store.DAO.Query(query+` ORDER BY id LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		log.Errorf("Error querying event log: %v", err)
+		return nil, err
+	}
+	defer results.Close()
+	var evts []types.Event
+	for results.Next() {
+		var event types.Event
+		var todo []byte
+		if err := results.Scan(&event.ID, &event.Name, &event.Type, &todo, &event.Time); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(todo, &event.Todo); err != nil {
+			return nil, err
+		}
+		evts = append(evts, event)
+	}
+	return evts, results.Err()
+}
+
+//SelectEventRange returns the IDs of the oldest and newest events in the shared event log, which are zero when it is empty
+func (store *StoreType) SelectEventRange() (int64, int64, error) {
+	var oldest, newest sql.NullInt64
+	err := store.DAO.QueryRow(`SELECT MIN(id), MAX(id) FROM Events`).Scan(&oldest, &newest)
+	if err != nil {
+		log.Errorf("Error querying event log: %v", err)
+	}
+	return oldest.Int64, newest.Int64, err
+}
+
+//TrimEvents removes all but the newest keep events from the shared event log
+func (store *StoreType) TrimEvents(keep int) error {
+	_, newest, err := store.SelectEventRange()
+	if err != nil {
+		return err
+	}
+	_, err = store.DAO.Exec(`DELETE FROM Events WHERE id <= ?`, newest-int64(keep))
+	if err != nil {
+		log.Errorf("Error trimming event log: %v", err)
+	}
+	return err
+}
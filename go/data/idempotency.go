@@ -0,0 +1,82 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/go-sql-driver/mysql"
+	"github.com/shale/go/types"
+)
+
+//ClaimIdempotencyKey reserves an idempotency key for a request with the given fingerprint.  If the key is already held, the existing record is returned instead and claimed is false.  Expired keys are replaced
+func (store *StoreType) ClaimIdempotencyKey(name string, key string, fingerprint string, ttl time.Duration) (types.IdempotencyRecord, bool, error) {
+	var rec types.IdempotencyRecord
+	_, err := store.DAO.Exec(`DELETE FROM IdempotencyKeys WHERE acct_name = ? AND idem_key = ? AND expires_at < ?`, name, key, time.Now())
+	if err != nil {
+		log.Errorf("Error expiring idempotency key: %v", err)
+		return rec, false, err
+	}
+	_, err = store.DAO.Exec(`
+INSERT INTO IdempotencyKeys (acct_name, idem_key, fingerprint, status_code, expires_at) VALUES (?, ?, ?, 0, ?)`, name, key, fingerprint, time.Now().Add(ttl))
+	if err == nil {
+		return types.IdempotencyRecord{Key: key, Fingerprint: fingerprint}, true, nil
+	}
+	if mysqlErr, ok := err.(*mysql.MySQLError); !ok || mysqlErr.Number != 1062 {
+		log.Errorf("Error claiming idempotency key: %v", err)
+		return rec, false, err
+	}
+
+	var headers, body []byte
+	err = store.DAO.QueryRow(`
+SELECT idem_key, fingerprint, status_code, response_headers, response_body FROM IdempotencyKeys WHERE acct_name = ? AND idem_key = ?`, name, key).Scan(&rec.Key, &rec.Fingerprint, &rec.Status, &headers, &body)
+	if err == sql.ErrNoRows {
+		//The holder released the key between our insert and select; the client can simply retry
+		return rec, false, nil
+	}
+	if err != nil {
+		log.Errorf("Error selecting idempotency key: %v", err)
+		return rec, false, err
+	}
+	rec.Body = body
+	if len(headers) > 0 {
+		if err = json.Unmarshal(headers, &rec.Header); err != nil {
+			return rec, false, err
+		}
+	}
+	return rec, false, nil
+}
+
+//SaveIdempotentResponse stores the response produced for a claimed idempotency key so that it can be replayed
+func (store *StoreType) SaveIdempotentResponse(name string, key string, status int, header map[string]string, body []byte) error {
+	headers, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	_, err = store.DAO.Exec(`
+UPDATE IdempotencyKeys SET status_code = ?, response_headers = ?, response_body = ? WHERE acct_name = ? AND idem_key = ?`, status, headers, body, name, key)
+	if err != nil {
+		log.Errorf("Error saving idempotent response: %v", err)
+	}
+	return err
+}
+
+//ReleaseIdempotencyKey gives up a claimed idempotency key without storing a response, so that the request may be retried
+func (store *StoreType) ReleaseIdempotencyKey(name string, key string) error {
+	_, err := store.DAO.Exec(`DELETE FROM IdempotencyKeys WHERE acct_name = ? AND idem_key = ?`, name, key)
+	if err != nil {
+		log.Errorf("Error releasing idempotency key: %v", err)
+	}
+	return err
+}
+
+//PurgeIdempotencyKeys removes every expired idempotency key
+func (store *StoreType) PurgeIdempotencyKeys() (int64, error) {
+	res, err := store.DAO.Exec(`DELETE FROM IdempotencyKeys WHERE expires_at < ?`, time.Now())
+	if err != nil {
+		log.Errorf("Error purging idempotency keys: %v", err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
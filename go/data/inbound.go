@@ -0,0 +1,44 @@
+package data
+
+import (
+	"database/sql"
+
+	"github.com/bdlm/log"
+)
+
+//SetInboundToken replaces the secret of an account's inbound email address, so that mail to any earlier address is refused
+func (store *StoreType) SetInboundToken(name string, token string) error {
+	_, err := store.DAO.Exec(`
+INSERT INTO InboundTokens (acct_name, token_hash, created_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+ON DUPLICATE KEY UPDATE token_hash = VALUES(token_hash), created_at = CURRENT_TIMESTAMP`, name, hashToken(token))
+	if err != nil {
+		log.Errorf("Error setting inbound token: %v", err)
+	}
+	return err
+}
+
+//DeleteInboundToken turns off an account's inbound email address, returning ErrNotFound if it has none
+func (store *StoreType) DeleteInboundToken(name string) error {
+	res, err := store.DAO.Exec(`DELETE FROM InboundTokens WHERE acct_name = ?`, name)
+	if err != nil {
+		log.Errorf("Error deleting inbound token: %v", err)
+		return err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+//SelectInboundOwner returns the account an inbound email token belongs to, or ErrNotFound if the token is not valid
+func (store *StoreType) SelectInboundOwner(token string) (string, error) {
+	var name string
+	err := store.DAO.QueryRow(`SELECT acct_name FROM InboundTokens WHERE token_hash = ?`, hashToken(token)).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		log.Errorf("Error selecting inbound token: %v", err)
+	}
+	return name, err
+}
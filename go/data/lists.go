@@ -0,0 +1,123 @@
+package data
+
+import (
+	"database/sql"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+)
+
+//execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+//Operations that change a todo list, as recorded in the operation log
+const (
+	OpInsert         = "insert"
+	OpUpdateTitle    = "update_title"
+	OpUpdatePriority = "update_priority"
+	OpUpdateActive   = "update_active"
+	OpDelete         = "delete"
+	OpRestore        = "restore"
+	OpUndo           = "undo"
+	OpRedo           = "redo"
+	OpPurge          = "purge"
+	OpUpdate         = "update"
+	OpBatch          = "batch"
+	OpImport         = "import"
+)
+
+//withTx runs fn in a transaction, committing if it succeeds and rolling back if it fails
+func (store *StoreType) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := store.DAO.Begin()
+	if err != nil {
+		log.Errorf("Error starting transaction: %v", err)
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Errorf("Error committing transaction: %v", err)
+	}
+	return err
+}
+
+//write runs a change to an account's list in a transaction.  fn makes the change and returns the before and after state of every todo item it touched.  If anything changed, the change is recorded and logged so that it can be undone, and published once committed
+func (store *StoreType) write(name string, op string, fn func(tx *sql.Tx) ([]types.Change, error)) ([]types.Change, error) {
+	var changes []types.Change
+	err := store.withTx(func(tx *sql.Tx) error {
+		var err error
+		changes, err = fn(tx)
+		if err != nil || len(changes) == 0 {
+			return err
+		}
+		changes = coalesce(changes)
+		if err = store.recordChanges(tx, name, op, changes); err != nil {
+			return err
+		}
+		return store.logOp(tx, name, op, changes)
+	})
+	if err == nil {
+		store.publish(name, changes)
+	}
+	return changes, err
+}
+
+//coalesce merges changes to the same todo item, keeping its first before state and last after state, so that undoing the operation restores each item once
+func coalesce(changes []types.Change) []types.Change {
+	index := make(map[int]int, len(changes))
+	merged := make([]types.Change, 0, len(changes))
+	for _, change := range changes {
+		if i, ok := index[change.After.ID]; ok {
+			merged[i].After = change.After
+			continue
+		}
+		index[change.After.ID] = len(merged)
+		merged = append(merged, change)
+	}
+	return merged
+}
+
+//recordChanges does the bookkeeping that must accompany every change to a list, in the same transaction as the change
+func (store *StoreType) recordChanges(tx *sql.Tx, name string, op string, changes []types.Change) error {
+	if err := touchList(tx, name); err != nil {
+		return err
+	}
+	if store.Outbox {
+		if err := writeOutbox(tx, name, changes); err != nil {
+			return err
+		}
+	}
+	return store.audit(tx, name, op, changes)
+}
+
+//touchList records a change to an account's list by bumping its change counter
+func touchList(tx execer, name string) error {
+	_, err := tx.Exec(`
+INSERT INTO Lists (acct_name, change_seq, updated_at) VALUES (?, 1, CURRENT_TIMESTAMP)
+ON DUPLICATE KEY UPDATE change_seq = change_seq + 1, updated_at = CURRENT_TIMESTAMP`, name)
+	if err != nil {
+		log.Errorf("Error updating list state: %v", err)
+	}
+	return err
+}
+
+//SelectListState returns the change counter and last modification time of an account's list.  A list that has never been written has a zero state
+func (store *StoreType) SelectListState(name string) (types.ListState, error) {
+	var state types.ListState
+	err := store.DAO.QueryRow(`SELECT change_seq, updated_at FROM Lists WHERE acct_name = ?`, name).Scan(&state.Seq, &state.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return state, nil
+	}
+	if err != nil {
+		log.Errorf("Error selecting list state: %v", err)
+	}
+	return state, err
+}
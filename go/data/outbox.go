@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/go-sql-driver/mysql"
+	"github.com/shale/go/events"
+	"github.com/shale/go/outbox"
+	"github.com/shale/go/types"
+)
+
+var _ outbox.Store = &StoreType{}
+var _ outbox.DeliveryQueue = &StoreType{}
+
+//writeOutbox adds the events describing a write's changes to the outbox, in the write's transaction, so that they are published if and only if the write commits
+func writeOutbox(tx execer, name string, changes []types.Change) error {
+	for _, event := range events.FromChanges(name, changes) {
+		todo, err := json.Marshal(event.Todo)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`INSERT INTO Outbox (acct_name, event_type, todo, created_at) VALUES (?, ?, ?, ?)`, event.Name, event.Type, todo, event.Time)
+		if err != nil {
+			log.Errorf("Error writing outbox: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+//SelectOutbox returns up to limit undelivered events from the outbox, oldest first
+func (store *StoreType) SelectOutbox(limit int) ([]types.Event, error) {
+	results, err := store.DAO.Query(`SELECT id, acct_name, event_type, todo, created_at FROM Outbox WHERE delivered_at IS NULL ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		log.Errorf("Error selecting outbox: %v", err)
+		return nil, err
+	}
+	defer results.Close()
+	var evts []types.Event
+	for results.Next() {
+		var event types.Event
+		var todo []byte
+		if err := results.Scan(&event.ID, &event.Name, &event.Type, &todo, &event.Time); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(todo, &event.Todo); err != nil {
+			return nil, err
+		}
+		evts = append(evts, event)
+	}
+	return evts, results.Err()
+}
+
+//MarkDelivered records that outbox events have been published
+func (store *StoreType) MarkDelivered(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := []interface{}{time.Now()}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := store.DAO.Exec(`UPDATE Outbox SET delivered_at = ? WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`, args...)
+	if err != nil {
+		log.Errorf("Error marking outbox delivered: %v", err)
+	}
+	return err
+}
+
+//OutboxLag returns the number of undelivered events in the outbox and when the oldest was written
+func (store *StoreType) OutboxLag() (int64, time.Time, error) {
+	var pending int64
+	var oldest mysql.NullTime
+	err := store.DAO.QueryRow(`SELECT COUNT(*), MIN(created_at) FROM Outbox WHERE delivered_at IS NULL`).Scan(&pending, &oldest)
+	if err != nil {
+		log.Errorf("Error measuring outbox: %v", err)
+	}
+	return pending, oldest.Time, err
+}
+
+//TrimOutbox removes events delivered before the given time
+func (store *StoreType) TrimOutbox(before time.Time) error {
+	_, err := store.DAO.Exec(`DELETE FROM Outbox WHERE delivered_at < ?`, before)
+	if err != nil {
+		log.Errorf("Error trimming outbox: %v", err)
+	}
+	return err
+}
+
+//TryLock takes a named MySQL lock without waiting.  The lock belongs to a connection, which is held until release is called
+func (store *StoreType) TryLock(name string) (func(), bool, error) {
+	ctx := context.Background()
+	conn, err := store.DAO.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 0)`, name).Scan(&locked); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if locked.Int64 != 1 {
+		conn.Close()
+		return nil, false, nil
+	}
+	release := func() {
+		if _, err := conn.ExecContext(ctx, `SELECT RELEASE_LOCK(?)`, name); err != nil {
+			log.Warnf("Error releasing lock %s: %v", name, err)
+		}
+		conn.Close()
+	}
+	return release, true, nil
+}
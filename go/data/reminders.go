@@ -0,0 +1,103 @@
+package data
+
+import (
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/notify"
+	"github.com/shale/go/types"
+)
+
+var _ notify.Store = &StoreType{}
+
+//fireAtExpr computes when a todo item's reminder is due, which is NULL if it has none.  It matches TodoData.ReminderTime
+const fireAtExpr = `COALESCE(remind_at, DATE_SUB(due_at, INTERVAL remind_before MINUTE))`
+
+//SelectDueReminders returns up to limit reminders of active todo items due between now-window and now that can be claimed: not sent, dismissed, or given up on, and not claimed less than retry ago.  Reminders are ordered by when they are due and then by todo item, and only those after the given one are returned, so that a caller can page past reminders it skips
+func (store *StoreType) SelectDueReminders(now time.Time, window time.Duration, retry time.Duration, after types.Reminder, limit int) ([]types.Reminder, error) {
+	query := `
+SELECT ` + todoColumns + `, fire_at FROM (
+	SELECT ` + todoColumns + `, ` + fireAtExpr + ` AS fire_at FROM Todos
+	WHERE deleted_at IS NULL AND active AND (remind_at IS NOT NULL OR (due_at IS NOT NULL AND remind_before IS NOT NULL))
+) t
+WHERE fire_at <= ? AND fire_at > ? AND NOT EXISTS (
+	SELECT 1 FROM Reminders r WHERE r.todo_id = t.id AND r.fire_at = t.fire_at AND (r.status IN (?, ?, ?) OR r.claimed_at > ?)
+)`
+	args := []interface{}{now, now.Add(-window), notify.Sent, notify.Dismissed, notify.Dead, now.Add(-retry)}
+	if !after.FireAt.IsZero() {
+		query += ` AND (fire_at > ? OR (fire_at = ? AND id > ?))`
+		args = append(args, after.FireAt, after.FireAt, after.Todo.ID)
+	}
+	results, err := store.DAO.Query(query+`
+ORDER BY fire_at, id LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		log.Errorf("Error selecting due reminders: %v", err)
+		return nil, err
+	}
+	defer results.Close()
+	var reminders []types.Reminder
+	for results.Next() {
+		var reminder types.Reminder
+		reminder.Todo, err = scanTodo(results, &reminder.FireAt)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, results.Err()
+}
+
+//ClaimReminder records that this instance is sending a reminder.  A reminder that failed, or whose sender has not reported back, can be claimed again once retry has passed
+func (store *StoreType) ClaimReminder(reminder types.Reminder, now time.Time, retry time.Duration) (bool, int, error) {
+	res, err := store.DAO.Exec(`INSERT IGNORE INTO Reminders (todo_id, fire_at, acct_name, status, attempts, claimed_at) VALUES (?, ?, ?, ?, 1, ?)`,
+		reminder.Todo.ID, reminder.FireAt, reminder.Todo.Name, notify.Sending, now)
+	if err != nil {
+		log.Errorf("Error claiming reminder: %v", err)
+		return false, 0, err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 1 {
+		return true, 1, nil
+	}
+	res, err = store.DAO.Exec(`
+UPDATE Reminders SET status = ?, attempts = attempts + 1, claimed_at = ?
+WHERE todo_id = ? AND fire_at = ? AND status IN (?, ?) AND claimed_at <= ?`,
+		notify.Sending, now, reminder.Todo.ID, reminder.FireAt, notify.Sending, notify.Failed, now.Add(-retry))
+	if err != nil {
+		log.Errorf("Error claiming reminder: %v", err)
+		return false, 0, err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return false, 0, err
+	}
+	var attempts int
+	err = store.DAO.QueryRow(`SELECT attempts FROM Reminders WHERE todo_id = ? AND fire_at = ?`, reminder.Todo.ID, reminder.FireAt).Scan(&attempts)
+	return err == nil, attempts, err
+}
+
+//FinishReminder records whether a claimed reminder was sent
+func (store *StoreType) FinishReminder(reminder types.Reminder, status string, lastError string) error {
+	var sentAt, errorValue interface{}
+	if status == notify.Sent {
+		sentAt = time.Now()
+	}
+	if lastError != "" {
+		errorValue = lastError
+	}
+	_, err := store.DAO.Exec(`UPDATE Reminders SET status = ?, sent_at = ?, last_error = ? WHERE todo_id = ? AND fire_at = ?`,
+		status, sentAt, errorValue, reminder.Todo.ID, reminder.FireAt)
+	if err != nil {
+		log.Errorf("Error recording reminder: %v", err)
+	}
+	return err
+}
+
+//DismissReminder stops a reminder from being sent, or from being retried.  Changing the reminder or due time sets a new reminder
+func (store *StoreType) DismissReminder(reminder types.Reminder) error {
+	_, err := store.DAO.Exec(`
+INSERT INTO Reminders (todo_id, fire_at, acct_name, status, claimed_at) VALUES (?, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE status = VALUES(status)`, reminder.Todo.ID, reminder.FireAt, reminder.Todo.Name, notify.Dismissed, time.Now())
+	if err != nil {
+		log.Errorf("Error dismissing reminder: %v", err)
+	}
+	return err
+}
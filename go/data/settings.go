@@ -0,0 +1,77 @@
+package data
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+)
+
+//Defaults for an account that has not changed its settings
+const (
+	DefaultTimezone       = "UTC"
+	DefaultDigestTime     = "08:00"
+	DefaultDigestPriority = 1
+)
+
+//settingsColumns are the columns of UserSettings, in the order scanSettings reads them
+const settingsColumns = `acct_name, timezone, quiet_start, quiet_end, email, digest, digest_time, digest_priority`
+
+//scanSettings reads an account's settings from a row of settingsColumns
+func scanSettings(row scanner) (types.UserSettings, error) {
+	var settings types.UserSettings
+	err := row.Scan(&settings.Name, &settings.Timezone, &settings.QuietStart, &settings.QuietEnd, &settings.Email,
+		&settings.Digest, &settings.DigestTime, &settings.DigestPriority)
+	return settings, err
+}
+
+//SelectSettings returns the settings of an account, which are the defaults if it has never changed them
+func (store *StoreType) SelectSettings(name string) (types.UserSettings, error) {
+	settings, err := scanSettings(store.DAO.QueryRow(`SELECT `+settingsColumns+` FROM UserSettings WHERE acct_name = ?`, name))
+	if err == sql.ErrNoRows {
+		return types.UserSettings{Name: name, Timezone: DefaultTimezone, DigestTime: DefaultDigestTime, DigestPriority: DefaultDigestPriority}, nil
+	}
+	if err != nil {
+		log.Errorf("Error selecting settings: %v", err)
+	}
+	return settings, err
+}
+
+//UpdateSettings changes the given settings of an account and returns them all
+func (store *StoreType) UpdateSettings(name string, update types.SettingsUpdate) (types.UserSettings, error) {
+	settings, err := store.SelectSettings(name)
+	if err != nil {
+		return settings, err
+	}
+	if update.Timezone != nil {
+		settings.Timezone = *update.Timezone
+	}
+	if update.QuietStart != nil {
+		settings.QuietStart = *update.QuietStart
+	}
+	if update.QuietEnd != nil {
+		settings.QuietEnd = *update.QuietEnd
+	}
+	if update.Email != nil {
+		settings.Email = strings.TrimSpace(*update.Email)
+	}
+	if update.Digest != nil {
+		settings.Digest = *update.Digest
+	}
+	if update.DigestTime != nil {
+		settings.DigestTime = *update.DigestTime
+	}
+	if update.DigestPriority != nil {
+		settings.DigestPriority = *update.DigestPriority
+	}
+	_, err = store.DAO.Exec(`
+INSERT INTO UserSettings (`+settingsColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE timezone = VALUES(timezone), quiet_start = VALUES(quiet_start), quiet_end = VALUES(quiet_end), email = VALUES(email),
+	digest = VALUES(digest), digest_time = VALUES(digest_time), digest_priority = VALUES(digest_priority)`,
+		name, settings.Timezone, settings.QuietStart, settings.QuietEnd, settings.Email, settings.Digest, settings.DigestTime, settings.DigestPriority)
+	if err != nil {
+		log.Errorf("Error updating settings: %v", err)
+	}
+	return settings, err
+}
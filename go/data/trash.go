@@ -0,0 +1,88 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+)
+
+//SelectTrash returns the todo items an account has deleted but not yet purged, most recently deleted first
+func (store *StoreType) SelectTrash(name string) ([]types.TodoData, error) {
+	results, err := store.DAO.Query(`SELECT `+todoColumns+` FROM Todos WHERE acct_name = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC`, name)
+	if err != nil {
+		log.Errorf("Error querying mysql: %v", err)
+		return nil, err
+	}
+	return scanTodos(results)
+}
+
+//RestoreByID moves a todo item out of the trash and returns it, or ErrNotFound if the item is not in the trash
+func (store *StoreType) RestoreByID(id int, name string) (types.TodoData, error) {
+	changes, err := store.write(name, OpRestore, func(tx *sql.Tx) ([]types.Change, error) {
+		room, err := store.lockAllowance(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		before, err := lockTodo(tx, id, name)
+		if err != nil {
+			return nil, err
+		}
+		if !before.DeletedAt.Valid {
+			return nil, ErrNotFound
+		}
+		if err := room.check(); err != nil {
+			return nil, err
+		}
+		after, err := setColumn(tx, before, "deleted_at", nil)
+		if err != nil {
+			return nil, err
+		}
+		return []types.Change{{Before: &before, After: &after}}, nil
+	})
+	if err != nil {
+		return types.TodoData{}, err
+	}
+	return *changes[0].After, nil
+}
+
+//EmptyTrash permanently deletes every todo item in an account's trash and returns how many were removed
+func (store *StoreType) EmptyTrash(name string) (int64, error) {
+	return store.purge(`acct_name = ? AND deleted_at IS NOT NULL`, name)
+}
+
+//PurgeTrash permanently deletes todo items of every account that were moved to the trash before the given time
+func (store *StoreType) PurgeTrash(before time.Time) (int64, error) {
+	return store.purge(`deleted_at IS NOT NULL AND deleted_at < ?`, before)
+}
+
+//purge permanently deletes the todo items matching where, recording their removal in the audit log
+func (store *StoreType) purge(where string, args ...interface{}) (int64, error) {
+	var purged int64
+	err := store.withTx(func(tx *sql.Tx) error {
+		results, err := tx.Query(`SELECT `+todoColumns+` FROM Todos WHERE `+where+` FOR UPDATE`, args...)
+		if err != nil {
+			log.Errorf("Error querying mysql: %v", err)
+			return err
+		}
+		todos, err := scanTodos(results)
+		if err != nil {
+			return err
+		}
+		for i := range todos {
+			err = store.audit(tx, todos[i].Name, OpPurge, []types.Change{{Before: &todos[i]}})
+			if err != nil {
+				return err
+			}
+		}
+		res, err := tx.Exec(`DELETE FROM Todos WHERE `+where, args...)
+		if err != nil {
+			log.Errorf("Error purging trash: %v", err)
+			return err
+		}
+		purged, err = res.RowsAffected()
+		return err
+	})
+	return purged, err
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/go-sql-driver/mysql"
+	"github.com/shale/go/types"
+)
+
+//ErrNothingToUndo is returned by Undo when the operation log holds no operation that can be undone
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+//ErrNothingToRedo is returned by Redo when no operation has been undone since the last change
+var ErrNothingToRedo = errors.New("nothing to redo")
+
+//ErrConflict is returned when undoing or redoing an operation would overwrite a newer change to one of its todo items
+var ErrConflict = errors.New("todo item has changed since the operation")
+
+//logOp appends an operation to the account's operation log.  A new operation discards any undone operations, which can then no longer be redone, and the log is trimmed to the store's UndoDepth
+func (store *StoreType) logOp(tx *sql.Tx, name string, op string, changes []types.Change) error {
+	if store.UndoDepth <= 0 {
+		return nil
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM OpLog WHERE acct_name = ? AND undone = true`, name)
+	if err != nil {
+		log.Errorf("Error clearing redo log: %v", err)
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO OpLog (acct_name, op, changes, undone) VALUES (?, ?, ?, false)`, name, op, encoded)
+	if err != nil {
+		log.Errorf("Error writing operation log: %v", err)
+		return err
+	}
+	_, err = tx.Exec(`
+DELETE FROM OpLog WHERE acct_name = ? AND id <= (
+    SELECT id FROM (SELECT id FROM OpLog WHERE acct_name = ? ORDER BY id DESC LIMIT 1 OFFSET ?) AS oldest
+)`, name, name, store.UndoDepth)
+	if err != nil {
+		log.Errorf("Error trimming operation log: %v", err)
+	}
+	return err
+}
+
+//Undo reverts the most recent operation on an account's list that has not been undone, restoring every todo item it touched to its earlier state.  It fails with ErrConflict, changing nothing, if any of those items has been changed since
+func (store *StoreType) Undo(name string) (types.OpResult, error) {
+	return store.replay(name, true)
+}
+
+//Redo reapplies the operation most recently undone.  It fails with ErrConflict, changing nothing, if any of its todo items has been changed since the undo
+func (store *StoreType) Redo(name string) (types.OpResult, error) {
+	return store.replay(name, false)
+}
+
+//replay undoes or redoes a logged operation in a single transaction
+func (store *StoreType) replay(name string, undo bool) (types.OpResult, error) {
+	var result types.OpResult
+	query := `SELECT id, op, changes, undo_state FROM OpLog WHERE acct_name = ? AND undone = false ORDER BY id DESC LIMIT 1 FOR UPDATE`
+	empty, op := ErrNothingToUndo, OpUndo
+	if !undo {
+		query = `SELECT id, op, changes, undo_state FROM OpLog WHERE acct_name = ? AND undone = true ORDER BY id ASC LIMIT 1 FOR UPDATE`
+		empty, op = ErrNothingToRedo, OpRedo
+	}
+	var replayed []types.Change
+	err := store.withTx(func(tx *sql.Tx) error {
+		var id int64
+		var encodedChanges, encodedUndone []byte
+		err := tx.QueryRow(query, name).Scan(&id, &result.Op, &encodedChanges, &encodedUndone)
+		if err == sql.ErrNoRows {
+			return empty
+		}
+		if err != nil {
+			log.Errorf("Error reading operation log: %v", err)
+			return err
+		}
+		var changes []types.Change
+		if err = json.Unmarshal(encodedChanges, &changes); err != nil {
+			return err
+		}
+		var undone []types.TodoData
+		if !undo {
+			if err = json.Unmarshal(encodedUndone, &undone); err != nil || len(undone) != len(changes) {
+				return errors.New("corrupt operation log entry")
+			}
+		}
+
+		replayed = make([]types.Change, 0, len(changes))
+		for i, change := range changes {
+			current, err := lockTodo(tx, change.After.ID, name)
+			if err == ErrNotFound {
+				//The item has been purged from the trash
+				return ErrConflict
+			}
+			if err != nil {
+				return err
+			}
+			var target types.TodoData
+			if undo {
+				if current.Version != change.After.Version {
+					return ErrConflict
+				}
+				if change.Before != nil {
+					target = *change.Before
+				} else {
+					//Undoing an insert moves the item to the trash
+					target = current
+					target.DeletedAt = mysql.NullTime{Time: time.Now(), Valid: true}
+				}
+			} else {
+				if current.Version != undone[i].Version {
+					return ErrConflict
+				}
+				target = *change.After
+			}
+			state, err := writeState(tx, target)
+			if err != nil {
+				return err
+			}
+			result.Todos = append(result.Todos, state)
+			replayed = append(replayed, types.Change{Before: &current, After: &state})
+			if !undo {
+				redone := state
+				changes[i].After = &redone
+			}
+		}
+
+		if undo {
+			encodedUndone, err = json.Marshal(result.Todos)
+			if err == nil {
+				_, err = tx.Exec(`UPDATE OpLog SET undone = true, undo_state = ? WHERE id = ?`, encodedUndone, id)
+			}
+		} else {
+			encodedChanges, err = json.Marshal(changes)
+			if err == nil {
+				_, err = tx.Exec(`UPDATE OpLog SET undone = false, changes = ?, undo_state = NULL WHERE id = ?`, encodedChanges, id)
+			}
+		}
+		if err != nil {
+			log.Errorf("Error updating operation log: %v", err)
+			return err
+		}
+		return store.recordChanges(tx, name, op, replayed)
+	})
+	if err == nil {
+		store.publish(name, replayed)
+	}
+	return result, err
+}
+
+//writeState sets every user-editable column of a locked todo item to the values in target, bumps its version, and returns the item as updated
+func writeState(tx *sql.Tx, target types.TodoData) (types.TodoData, error) {
+	_, err := tx.Exec(`
+UPDATE Todos SET title = ?, body = ?, category = ?, item_priority = ?, active = ?, tags = ?, deleted_at = ?, due_at = ?, remind_at = ?, remind_before = ?, recurrence = ?, version = version + 1
+WHERE id = ? AND acct_name = ?`,
+		target.Title, target.Body, target.Category, target.Priority, target.Active, strings.Join(target.Tags, ","), target.DeletedAt, target.Due, target.RemindAt, target.RemindBefore, target.Recurrence,
+		target.ID, target.Name)
+	if err != nil {
+		log.Errorf("Error restoring todo state: %v", err)
+		return target, err
+	}
+	return lockTodo(tx, target.ID, target.Name)
+}
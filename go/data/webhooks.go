@@ -0,0 +1,277 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+	"github.com/shale/go/webhook"
+)
+
+var _ webhook.Store = &StoreType{}
+
+//webhookColumns are the columns read by scanWebhook
+const webhookColumns = `id, acct_name, url, event_types, active, created_at`
+
+//deliveryColumns are the columns read by scanDelivery
+const deliveryColumns = `d.id, d.webhook_id, d.acct_name, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at, d.response_code, d.last_error, d.created_at, d.updated_at`
+
+//maxDeliveries caps the deliveries returned by SelectDeliveries
+const maxDeliveries = 100
+
+//scanWebhook reads a webhook, without its secret
+func scanWebhook(row scanner) (types.Webhook, error) {
+	var hook types.Webhook
+	var eventTypes string
+	if err := row.Scan(&hook.ID, &hook.Name, &hook.URL, &eventTypes, &hook.Active, &hook.CreatedAt); err != nil {
+		return hook, err
+	}
+	hook.Events = []string{}
+	if eventTypes != "" {
+		hook.Events = strings.Split(eventTypes, ",")
+	}
+	return hook, nil
+}
+
+//scanDelivery reads a webhook delivery, followed by any extra columns
+func scanDelivery(row scanner, extra ...interface{}) (types.WebhookDelivery, error) {
+	var delivery types.WebhookDelivery
+	var payload []byte
+	var code sql.NullInt64
+	var lastError sql.NullString
+	dest := []interface{}{&delivery.ID, &delivery.WebhookID, &delivery.Name, &delivery.EventType, &payload, &delivery.Status, &delivery.Attempts,
+		&delivery.NextAttemptAt, &code, &lastError, &delivery.CreatedAt, &delivery.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return delivery, err
+	}
+	delivery.Payload = payload
+	delivery.ResponseCode = int(code.Int64)
+	delivery.LastError = lastError.String
+	return delivery, nil
+}
+
+//InsertWebhook subscribes a URL to the changes to an account's list
+func (store *StoreType) InsertWebhook(hook types.Webhook) (types.Webhook, error) {
+	res, err := store.DAO.Exec(`INSERT INTO Webhooks (acct_name, url, secret, event_types) VALUES (?, ?, ?, ?)`,
+		hook.Name, hook.URL, hook.Secret, strings.Join(hook.Events, ","))
+	if err != nil {
+		log.Errorf("Error inserting webhook: %v", err)
+		return hook, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return hook, err
+	}
+	created, err := store.SelectWebhook(int(id), hook.Name)
+	created.Secret = hook.Secret
+	return created, err
+}
+
+//SelectWebhooks returns the webhooks of an account, without their secrets
+func (store *StoreType) SelectWebhooks(name string) ([]types.Webhook, error) {
+	results, err := store.DAO.Query(`SELECT `+webhookColumns+` FROM Webhooks WHERE acct_name = ? ORDER BY id`, name)
+	if err != nil {
+		log.Errorf("Error selecting webhooks: %v", err)
+		return nil, err
+	}
+	defer results.Close()
+	var hooks []types.Webhook
+	for results.Next() {
+		hook, err := scanWebhook(results)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, results.Err()
+}
+
+//SelectWebhook returns a webhook of an account, without its secret, or ErrNotFound
+func (store *StoreType) SelectWebhook(id int, name string) (types.Webhook, error) {
+	hook, err := scanWebhook(store.DAO.QueryRow(`SELECT `+webhookColumns+` FROM Webhooks WHERE id = ? AND acct_name = ?`, id, name))
+	if err == sql.ErrNoRows {
+		return hook, ErrNotFound
+	}
+	if err != nil {
+		log.Errorf("Error selecting webhook: %v", err)
+	}
+	return hook, err
+}
+
+//UpdateWebhook changes the given fields of a webhook
+func (store *StoreType) UpdateWebhook(id int, name string, update types.WebhookUpdate) (types.Webhook, error) {
+	var sets []string
+	var args []interface{}
+	if update.URL != nil {
+		sets = append(sets, "url = ?")
+		args = append(args, *update.URL)
+	}
+	if update.Secret != nil {
+		sets = append(sets, "secret = ?")
+		args = append(args, *update.Secret)
+	}
+	if update.Events != nil {
+		sets = append(sets, "event_types = ?")
+		args = append(args, strings.Join(*update.Events, ","))
+	}
+	if update.Active != nil {
+		sets = append(sets, "active = ?")
+		args = append(args, *update.Active)
+	}
+	hook, err := store.SelectWebhook(id, name)
+	if err != nil || len(sets) == 0 {
+		return hook, err
+	}
+	_, err = store.DAO.Exec(`UPDATE Webhooks SET `+strings.Join(sets, ", ")+` WHERE id = ? AND acct_name = ?`, append(args, id, name)...)
+	if err != nil {
+		log.Errorf("Error updating webhook: %v", err)
+		return hook, err
+	}
+	return store.SelectWebhook(id, name)
+}
+
+//DeleteWebhook removes a webhook and its deliveries, returning ErrNotFound if there is no such webhook
+func (store *StoreType) DeleteWebhook(id int, name string) error {
+	res, err := store.DAO.Exec(`DELETE FROM Webhooks WHERE id = ? AND acct_name = ?`, id, name)
+	if err != nil {
+		log.Errorf("Error deleting webhook: %v", err)
+		return err
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+//EnqueueDeliveries queues a delivery of each event to every active webhook of its account subscribed to its type
+func (store *StoreType) EnqueueDeliveries(evts []types.Event) error {
+	now := time.Now()
+	for _, event := range evts {
+		payload, err := json.Marshal(&types.WebhookPayload{Event: event.Type, Name: event.Name, Todo: event.Todo, Time: event.Time})
+		if err != nil {
+			return err
+		}
+		_, err = store.DAO.Exec(`
+INSERT INTO WebhookDeliveries (webhook_id, acct_name, event_type, payload, next_attempt_at)
+SELECT id, acct_name, ?, ?, ? FROM Webhooks
+WHERE acct_name = ? AND active AND (event_types = '' OR FIND_IN_SET(?, event_types))`, event.Type, payload, now, event.Name, event.Type)
+		if err != nil {
+			log.Errorf("Error queueing webhook deliveries: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+//SelectDeliveries returns the most recent deliveries to a webhook, newest first
+func (store *StoreType) SelectDeliveries(webhookID int, name string) ([]types.WebhookDelivery, error) {
+	if _, err := store.SelectWebhook(webhookID, name); err != nil {
+		return nil, err
+	}
+	results, err := store.DAO.Query(`SELECT `+deliveryColumns+` FROM WebhookDeliveries d WHERE d.webhook_id = ? ORDER BY d.id DESC LIMIT ?`, webhookID, maxDeliveries)
+	if err != nil {
+		log.Errorf("Error selecting webhook deliveries: %v", err)
+		return nil, err
+	}
+	defer results.Close()
+	var deliveries []types.WebhookDelivery
+	for results.Next() {
+		delivery, err := scanDelivery(results)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, results.Err()
+}
+
+//Redeliver queues a new delivery of the payload of an earlier one, whatever became of it, returning ErrNotFound if there is no such delivery
+func (store *StoreType) Redeliver(id int64, webhookID int, name string) (types.WebhookDelivery, error) {
+	res, err := store.DAO.Exec(`
+INSERT INTO WebhookDeliveries (webhook_id, acct_name, event_type, payload, next_attempt_at)
+SELECT webhook_id, acct_name, event_type, payload, ? FROM WebhookDeliveries
+WHERE id = ? AND webhook_id = ? AND acct_name = ?`, time.Now(), id, webhookID, name)
+	if err != nil {
+		log.Errorf("Error queueing webhook redelivery: %v", err)
+		return types.WebhookDelivery{}, err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return types.WebhookDelivery{}, ErrNotFound
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return types.WebhookDelivery{}, err
+	}
+	return scanDelivery(store.DAO.QueryRow(`SELECT `+deliveryColumns+` FROM WebhookDeliveries d WHERE d.id = ?`, newID))
+}
+
+//ClaimDeliveries returns up to limit pending deliveries to active webhooks that are due, pushing back their next attempt by lease so that no other dispatcher claims them meanwhile
+func (store *StoreType) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]types.WebhookDelivery, error) {
+	results, err := store.DAO.Query(`SELECT `+deliveryColumns+`, w.url, w.secret FROM WebhookDeliveries d JOIN Webhooks w ON w.id = d.webhook_id
+WHERE d.status = ? AND d.next_attempt_at <= ? AND w.active ORDER BY d.next_attempt_at, d.id LIMIT ?`, webhook.Pending, now, limit)
+	if err != nil {
+		log.Errorf("Error selecting due webhook deliveries: %v", err)
+		return nil, err
+	}
+	var due []types.WebhookDelivery
+	for results.Next() {
+		var delivery types.WebhookDelivery
+		var url, secret string
+		delivery, err = scanDelivery(results, &url, &secret)
+		if err != nil {
+			results.Close()
+			return nil, err
+		}
+		delivery.URL = url
+		delivery.Secret = secret
+		due = append(due, delivery)
+	}
+	results.Close()
+	if err := results.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []types.WebhookDelivery
+	for _, delivery := range due {
+		//Only one dispatcher's update matches the next attempt time it read
+		res, err := store.DAO.Exec(`UPDATE WebhookDeliveries SET next_attempt_at = ? WHERE id = ? AND status = ? AND next_attempt_at = ?`,
+			now.Add(lease), delivery.ID, webhook.Pending, delivery.NextAttemptAt)
+		if err != nil {
+			log.Errorf("Error claiming webhook delivery: %v", err)
+			return claimed, err
+		}
+		if affected, err := res.RowsAffected(); err == nil && affected == 1 {
+			claimed = append(claimed, delivery)
+		}
+	}
+	return claimed, nil
+}
+
+//FinishAttempt records the outcome of an attempt to post a delivery
+func (store *StoreType) FinishAttempt(delivery types.WebhookDelivery) error {
+	var code, lastError interface{}
+	if delivery.ResponseCode != 0 {
+		code = delivery.ResponseCode
+	}
+	if delivery.LastError != "" {
+		lastError = delivery.LastError
+	}
+	_, err := store.DAO.Exec(`UPDATE WebhookDeliveries SET status = ?, attempts = ?, next_attempt_at = ?, response_code = ?, last_error = ? WHERE id = ?`,
+		delivery.Status, delivery.Attempts, delivery.NextAttemptAt, code, lastError, delivery.ID)
+	if err != nil {
+		log.Errorf("Error updating webhook delivery: %v", err)
+	}
+	return err
+}
+
+//PurgeDeliveries removes delivered and dead deliveries last updated before the given time
+func (store *StoreType) PurgeDeliveries(before time.Time) error {
+	_, err := store.DAO.Exec(`DELETE FROM WebhookDeliveries WHERE status IN (?, ?) AND updated_at < ?`, webhook.Delivered, webhook.Dead, before)
+	if err != nil {
+		log.Errorf("Error purging webhook deliveries: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+//Package digest builds and renders each account's daily summary of the todo items needing attention, and emails it at the time the account chose
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//Source holds the todo items a digest lists
+type Source interface {
+	//SelectDueBefore returns the active todo items due before the given time, soonest first
+	SelectDueBefore(name string, before time.Time) ([]types.TodoData, error)
+
+	SelectByPriority(priority int, name string) ([]types.TodoData, error)
+}
+
+//Build gathers the digest of an account for the day of now in its timezone: active items that are overdue, then those due later today, then those at the account's digest priority or higher
+func Build(source Source, settings types.UserSettings, now time.Time) (types.Digest, error) {
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	tomorrow := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+	d := types.Digest{
+		Name:         settings.Name,
+		Date:         local.Format("2006-01-02"),
+		Timezone:     loc.String(),
+		Priority:     settings.DigestPriority,
+		Overdue:      []types.TodoData{},
+		DueToday:     []types.TodoData{},
+		HighPriority: []types.TodoData{},
+	}
+
+	due, err := source.SelectDueBefore(settings.Name, tomorrow)
+	if err != nil {
+		return d, err
+	}
+	listed := make(map[int]bool)
+	for _, todo := range due {
+		listed[todo.ID] = true
+		if todo.Due.Before(now) {
+			d.Overdue = append(d.Overdue, todo)
+		} else {
+			d.DueToday = append(d.DueToday, todo)
+		}
+	}
+	if settings.DigestPriority > 0 {
+		high, err := source.SelectByPriority(settings.DigestPriority, settings.Name)
+		if err != nil {
+			return d, err
+		}
+		for _, todo := range high {
+			if todo.Active && !listed[todo.ID] {
+				d.HighPriority = append(d.HighPriority, todo)
+			}
+		}
+	}
+	return d, nil
+}
+
+//Render formats a digest as the subject and bodies of its email
+func Render(d types.Digest) (types.DigestPreview, error) {
+	preview := types.DigestPreview{Subject: subject(d), Digest: d}
+	var text bytes.Buffer
+	if err := textDigest.Execute(&text, d); err != nil {
+		return preview, err
+	}
+	preview.Text = text.String()
+	var html bytes.Buffer
+	if err := htmlDigest.Execute(&html, d); err != nil {
+		return preview, err
+	}
+	preview.HTML = html.String()
+	return preview, nil
+}
+
+//subject summarises the digest's sections for the subject line
+func subject(d types.Digest) string {
+	var counts []string
+	if n := len(d.Overdue); n > 0 {
+		counts = append(counts, fmt.Sprintf("%d overdue", n))
+	}
+	if n := len(d.DueToday); n > 0 {
+		counts = append(counts, fmt.Sprintf("%d due today", n))
+	}
+	if n := len(d.HighPriority); n > 0 {
+		counts = append(counts, fmt.Sprintf("%d high priority", n))
+	}
+	if len(counts) == 0 {
+		return "Todo digest for " + d.Date + ": nothing needs attention"
+	}
+	return "Todo digest for " + d.Date + ": " + strings.Join(counts, ", ")
+}
+
+//when formats a due time in the digest's timezone
+func when(t *time.Time, timezone string) string {
+	if t == nil {
+		return ""
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format("Mon Jan 2 15:04")
+}
+
+var (
+	textDigest = texttemplate.Must(texttemplate.New("text").Funcs(texttemplate.FuncMap{"when": when}).Parse(textTemplate))
+	htmlDigest = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap{"when": when}).Parse(htmlTemplate))
+)
+
+const textTemplate = `Todo digest for {{.Name}}, {{.Date}}
+{{if .Overdue}}
+Overdue
+{{range .Overdue}}  * {{.Title}}, due {{when .Due $.Timezone}}{{if .Category}} [{{.Category}}]{{end}}
+{{end}}{{end}}{{if .DueToday}}
+Due today
+{{range .DueToday}}  * {{.Title}}, due {{when .Due $.Timezone}}{{if .Category}} [{{.Category}}]{{end}}
+{{end}}{{end}}{{if .HighPriority}}
+Priority {{.Priority}} or higher
+{{range .HighPriority}}  * {{.Title}} (priority {{.Priority}}){{if .Due}}, due {{when .Due $.Timezone}}{{end}}{{if .Category}} [{{.Category}}]{{end}}
+{{end}}{{end}}{{if not (or .Overdue .DueToday .HighPriority)}}
+Nothing needs attention today.
+{{end}}`
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h2>Todo digest for {{.Name}}, {{.Date}}</h2>
+{{if .Overdue}}<h3 style="color: #b00020">Overdue</h3>
+<ul>
+{{range .Overdue}}<li><strong>{{.Title}}</strong>, due {{when .Due $.Timezone}}{{if .Category}} <em>{{.Category}}</em>{{end}}</li>
+{{end}}</ul>
+{{end}}{{if .DueToday}}<h3>Due today</h3>
+<ul>
+{{range .DueToday}}<li><strong>{{.Title}}</strong>, due {{when .Due $.Timezone}}{{if .Category}} <em>{{.Category}}</em>{{end}}</li>
+{{end}}</ul>
+{{end}}{{if .HighPriority}}<h3>Priority {{.Priority}} or higher</h3>
+<ul>
+{{range .HighPriority}}<li><strong>{{.Title}}</strong> (priority {{.Priority}}){{if .Due}}, due {{when .Due $.Timezone}}{{end}}{{if .Category}} <em>{{.Category}}</em>{{end}}</li>
+{{end}}</ul>
+{{end}}{{if not (or .Overdue .DueToday .HighPriority)}}<p>Nothing needs attention today.</p>
+{{end}}</body>
+</html>
+`
@@ -0,0 +1,155 @@
+package digest
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//fakeSource selects from a fixed list of todo items as the SQL store does
+type fakeSource struct {
+	todos []types.TodoData
+	err   error
+}
+
+func (s fakeSource) SelectDueBefore(name string, before time.Time) ([]types.TodoData, error) {
+	var due []types.TodoData
+	for _, todo := range s.todos {
+		if todo.Name == name && todo.Active && todo.Due != nil && todo.Due.Before(before) {
+			due = append(due, todo)
+		}
+	}
+	return due, s.err
+}
+
+func (s fakeSource) SelectByPriority(priority int, name string) ([]types.TodoData, error) {
+	var high []types.TodoData
+	for _, todo := range s.todos {
+		if todo.Name == name && todo.Priority <= priority {
+			high = append(high, todo)
+		}
+	}
+	return high, nil
+}
+
+func ids(todos []types.TodoData) []int {
+	result := []int{}
+	for _, todo := range todos {
+		result = append(result, todo.ID)
+	}
+	return result
+}
+
+func TestBuild(t *testing.T) {
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip(err)
+	}
+	//03:00 UTC on May 2 is 22:00 on May 1 in Chicago, and day 0 is April 30
+	now := time.Date(2020, 5, 2, 3, 0, 0, 0, time.UTC)
+	at := func(day int, hour int) *time.Time {
+		t := time.Date(2020, 5, day, hour, 0, 0, 0, chicago)
+		return &t
+	}
+	source := fakeSource{todos: []types.TodoData{
+		{ID: 1, Name: "tom", Active: true, Priority: 5, Due: at(0, 9)},
+		{ID: 2, Name: "tom", Active: true, Priority: 5, Due: at(1, 21)},
+		{ID: 3, Name: "tom", Active: true, Priority: 1, Due: at(1, 23)},
+		{ID: 4, Name: "tom", Active: true, Priority: 5, Due: at(2, 1)},
+		{ID: 5, Name: "tom", Active: true, Priority: 2},
+		{ID: 6, Name: "tom", Active: false, Priority: 1},
+		{ID: 7, Name: "tom", Active: false, Priority: 5, Due: at(1, 9)},
+		{ID: 8, Name: "ann", Active: true, Priority: 1, Due: at(1, 9)},
+		{ID: 9, Name: "tom", Active: true, Priority: 3},
+	}}
+	settings := types.UserSettings{Name: "tom", Timezone: "America/Chicago", DigestPriority: 2}
+	d, err := Build(source, settings, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Date != "2020-05-01" || d.Timezone != "America/Chicago" || d.Priority != 2 || d.Name != "tom" {
+		t.Errorf("digest %s %s %d %s", d.Date, d.Timezone, d.Priority, d.Name)
+	}
+	if got := ids(d.Overdue); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("overdue %v, want [1 2]", got)
+	}
+	//Item 3 is high priority but appears only under due today
+	if got := ids(d.DueToday); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("due today %v, want [3]", got)
+	}
+	if got := ids(d.HighPriority); !reflect.DeepEqual(got, []int{5}) {
+		t.Errorf("high priority %v, want [5]", got)
+	}
+
+	settings.DigestPriority = 0
+	d, err = Build(source, settings, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.HighPriority == nil || len(d.HighPriority) != 0 {
+		t.Errorf("high priority %v with no digest priority, want empty", d.HighPriority)
+	}
+}
+
+func TestBuildUnknownTimezone(t *testing.T) {
+	now := time.Date(2020, 5, 2, 3, 0, 0, 0, time.UTC)
+	due := time.Date(2020, 5, 2, 12, 0, 0, 0, time.UTC)
+	source := fakeSource{todos: []types.TodoData{{ID: 1, Name: "tom", Active: true, Due: &due}}}
+	d, err := Build(source, types.UserSettings{Name: "tom", Timezone: "Not/AZone"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Date != "2020-05-02" || d.Timezone != "UTC" {
+		t.Errorf("digest for %s in %s, want 2020-05-02 in UTC", d.Date, d.Timezone)
+	}
+	if got := ids(d.DueToday); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("due today %v, want [1]", got)
+	}
+	if d.Overdue == nil || d.HighPriority == nil {
+		t.Errorf("empty sections are nil")
+	}
+}
+
+func TestBuildError(t *testing.T) {
+	failed := errors.New("unavailable")
+	if _, err := Build(fakeSource{err: failed}, types.UserSettings{Name: "tom"}, time.Now()); err != failed {
+		t.Errorf("got %v, want the source's error", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	due := time.Date(2020, 5, 1, 14, 0, 0, 0, time.UTC)
+	d := types.Digest{
+		Name:         "tom",
+		Date:         "2020-05-01",
+		Timezone:     "UTC",
+		Overdue:      []types.TodoData{{ID: 1, Title: "Taxes <now>", Due: &due}},
+		DueToday:     []types.TodoData{},
+		HighPriority: []types.TodoData{{ID: 2, Title: "Call mum"}},
+	}
+	preview, err := Render(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Todo digest for 2020-05-01: 1 overdue, 1 high priority"; preview.Subject != want {
+		t.Errorf("subject %q, want %q", preview.Subject, want)
+	}
+	if !strings.Contains(preview.Text, "Taxes <now>") || !strings.Contains(preview.Text, "Fri May 1 14:00") {
+		t.Errorf("text body %q", preview.Text)
+	}
+	if !strings.Contains(preview.HTML, "Taxes &lt;now&gt;") || strings.Contains(preview.HTML, "<now>") {
+		t.Errorf("HTML body is not escaped: %q", preview.HTML)
+	}
+
+	empty, err := Render(types.Digest{Date: "2020-05-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Todo digest for 2020-05-01: nothing needs attention"; empty.Subject != want {
+		t.Errorf("subject %q, want %q", empty.Subject, want)
+	}
+}
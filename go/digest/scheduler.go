@@ -0,0 +1,133 @@
+package digest
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/notify"
+	"github.com/shale/go/types"
+)
+
+//Skipped is the status of a day's digest that listed nothing, and so was not sent.  Other statuses are those of reminders
+const Skipped = "skipped"
+
+//maxErrorLen caps the length of a digest's last error
+const maxErrorLen = 1024
+
+//Store holds the accounts that want digests, their todo items, and the record of the digests sent
+type Store interface {
+	Source
+
+	//SelectDigestAccounts returns the settings of every account that has turned on digests and has an email address
+	SelectDigestAccounts() ([]types.UserSettings, error)
+
+	//ClaimDigest records that an account's digest for a date is being sent, unless another instance has sent it, is sending it, or failed to send it less than retry ago.  It returns the number of attempts including this one
+	ClaimDigest(name string, date string, now time.Time, retry time.Duration) (bool, int, error)
+
+	//FinishDigest records the outcome of sending a digest
+	FinishDigest(name string, date string, status string, lastError string) error
+}
+
+//Options configure a scheduler.  Zero values select the defaults
+type Options struct {
+	//Interval is how often accounts are checked for digests to send
+	Interval time.Duration
+
+	//Retry is how long to wait before retrying a digest that failed to send, and MaxAttempts how many times to try
+	Retry       time.Duration
+	MaxAttempts int
+}
+
+//Scheduler emails each account its digest once a day, at or after the account's digest time.  A digest is claimed in the store before it is sent, so that it is sent once however many instances run a scheduler
+type Scheduler struct {
+	store  Store
+	mailer notify.Mailer
+	opts   Options
+}
+
+//NewScheduler creates a scheduler sending the digests of the accounts in store through mailer
+func NewScheduler(store Store, mailer notify.Mailer, opts Options) *Scheduler {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.Retry <= 0 {
+		opts.Retry = 5 * time.Minute
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	return &Scheduler{store: store, mailer: mailer, opts: opts}
+}
+
+//Run sends due digests every interval, until the process exits
+func (s *Scheduler) Run() {
+	for range time.Tick(s.opts.Interval) {
+		s.Poll(time.Now())
+	}
+}
+
+//Poll sends today's digest to every account whose digest time has passed and that has not had it yet.  Days are those of each account's timezone
+func (s *Scheduler) Poll(now time.Time) {
+	accounts, err := s.store.SelectDigestAccounts()
+	if err != nil {
+		log.Warnf("Error selecting digest accounts: %v", err)
+		return
+	}
+	for _, account := range accounts {
+		loc, err := time.LoadLocation(account.Timezone)
+		if err != nil {
+			loc = time.UTC
+		}
+		local := now.In(loc)
+		at, ok := notify.ParseClock(account.DigestTime)
+		if !ok || local.Hour()*60+local.Minute() < at {
+			continue
+		}
+		date := local.Format("2006-01-02")
+		claimed, attempts, err := s.store.ClaimDigest(account.Name, date, now, s.opts.Retry)
+		if err != nil {
+			log.Warnf("Error claiming digest for %s: %v", account.Name, err)
+			continue
+		}
+		if claimed {
+			s.send(account, date, now, attempts)
+		}
+	}
+}
+
+//send builds, renders, and mails a claimed digest, and records the outcome
+func (s *Scheduler) send(account types.UserSettings, date string, now time.Time, attempts int) {
+	status, err := s.mail(account, now)
+	lastError := ""
+	if err != nil {
+		status, lastError = notify.Failed, err.Error()
+		if attempts >= s.opts.MaxAttempts {
+			status = notify.Dead
+		}
+		if len(lastError) > maxErrorLen {
+			lastError = strings.ToValidUTF8(lastError[:maxErrorLen], "")
+		}
+		log.Warnf("Error sending digest for %s, attempt %d: %s", account.Name, attempts, lastError)
+	}
+	if err := s.store.FinishDigest(account.Name, date, status, lastError); err != nil {
+		log.Errorf("Error recording digest for %s: %v", account.Name, err)
+	}
+}
+
+//mail sends an account's digest, unless it lists nothing
+func (s *Scheduler) mail(account types.UserSettings, now time.Time) (string, error) {
+	d, err := Build(s.store, account, now)
+	if err != nil {
+		return "", err
+	}
+	if d.Empty() {
+		return Skipped, nil
+	}
+	rendered, err := Render(d)
+	if err != nil {
+		return "", err
+	}
+	err = s.mailer.Send(notify.Mail{To: account.Email, Subject: rendered.Subject, Text: rendered.Text, HTML: rendered.HTML})
+	return notify.Sent, err
+}
@@ -0,0 +1,127 @@
+//Package events delivers changes to todo lists to subscribers, such as the server-sent event streams of the service, through a pluggable broker
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//Event types
+const (
+	Created = "created"
+	Updated = "updated"
+	Deleted = "deleted"
+)
+
+//subscriberBuffer is the number of events a subscriber may fall behind by before it is dropped
+const subscriberBuffer = 256
+
+//Broker publishes events and delivers them to subscribers, replaying recent events to subscribers that are resuming
+type Broker interface {
+	//Publish delivers events to every subscriber of their account, on every instance sharing the broker
+	Publish(events []types.Event) error
+
+	//Subscribe starts delivery of an account's events.  lastID is the ID of the last event the subscriber saw, or zero for a new subscriber
+	Subscribe(name string, lastID int64) (*Subscription, error)
+}
+
+//Subscription receives the events of one account.  Replay holds the buffered events after the subscriber's last event, which come before any on C.  Missed is set when events after the last one have already left the buffer, so the subscriber should reload the list.  C is closed if the subscriber falls too far behind or the subscription is closed
+type Subscription struct {
+	Replay []types.Event
+	Missed bool
+	C      <-chan types.Event
+
+	c      chan types.Event
+	name   string
+	hub    *hub
+	closed bool
+}
+
+//Close stops delivery to the subscription
+func (sub *Subscription) Close() {
+	sub.hub.remove(sub)
+}
+
+//FromChanges describes the changes made by a write as events.  Moving an item to the trash deletes it and restoring it creates it again
+func FromChanges(name string, changes []types.Change) []types.Event {
+	now := time.Now()
+	events := make([]types.Event, 0, len(changes))
+	for _, change := range changes {
+		if change.After == nil {
+			continue
+		}
+		event := types.Event{Type: Updated, Name: name, Todo: *change.After, Time: now}
+		wasListed := change.Before != nil && !change.Before.DeletedAt.Valid
+		isListed := !change.After.DeletedAt.Valid
+		switch {
+		case !wasListed && !isListed:
+			continue
+		case !wasListed:
+			event.Type = Created
+		case !isListed:
+			event.Type = Deleted
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+//hub fans events out to the subscribers of each account on this instance
+type hub struct {
+	mu   sync.Mutex
+	subs map[string]map[*Subscription]bool
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[string]map[*Subscription]bool)}
+}
+
+//add registers a new subscription for an account
+func (h *hub) add(name string) *Subscription {
+	c := make(chan types.Event, subscriberBuffer)
+	sub := &Subscription{C: c, c: c, name: name, hub: h}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[name] == nil {
+		h.subs[name] = make(map[*Subscription]bool)
+	}
+	h.subs[name][sub] = true
+	return sub
+}
+
+//remove unregisters a subscription and closes its channel
+func (h *hub) remove(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(sub)
+}
+
+//drop unregisters a subscription with the lock held
+func (h *hub) drop(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.c)
+	delete(h.subs[sub.name], sub)
+	if len(h.subs[sub.name]) == 0 {
+		delete(h.subs, sub.name)
+	}
+}
+
+//deliver sends events to the subscribers of their accounts.  A subscriber too far behind to take an event is dropped, and resumes from its last event when it reconnects
+func (h *hub) deliver(events []types.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, event := range events {
+		for sub := range h.subs[event.Name] {
+			select {
+			case sub.c <- event:
+			default:
+				h.drop(sub)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//Memory is a broker for a single instance, keeping recent events in a ring buffer
+type Memory struct {
+	hub *hub
+
+	mu     sync.Mutex
+	ring   []types.Event
+	next   int
+	full   bool
+	lastID int64
+}
+
+//NewMemory creates an in-process broker that buffers the last size events for replay.  Event IDs start from the current time in microseconds, so they keep increasing across restarts and a subscriber resuming from before a restart is told it missed events rather than given wrong ones
+func NewMemory(size int) *Memory {
+	if size < 1 {
+		size = 1
+	}
+	return &Memory{
+		hub:    newHub(),
+		ring:   make([]types.Event, size),
+		lastID: time.Now().UnixNano() / int64(time.Microsecond),
+	}
+}
+
+//Publish assigns IDs to events, buffers them, and delivers them to subscribers
+func (m *Memory) Publish(events []types.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	published := make([]types.Event, len(events))
+	for i, event := range events {
+		m.lastID++
+		event.ID = m.lastID
+		m.ring[m.next] = event
+		m.next = (m.next + 1) % len(m.ring)
+		if m.next == 0 {
+			m.full = true
+		}
+		published[i] = event
+	}
+	//Deliver while holding the lock so that subscribers see events in ID order
+	m.hub.deliver(published)
+	m.mu.Unlock()
+	return nil
+}
+
+//buffered returns the buffered events, oldest first
+func (m *Memory) buffered() []types.Event {
+	if !m.full {
+		return m.ring[:m.next]
+	}
+	return append(append([]types.Event{}, m.ring[m.next:]...), m.ring[:m.next]...)
+}
+
+//Subscribe starts delivery of an account's events, replaying the buffered events after lastID
+func (m *Memory) Subscribe(name string, lastID int64) (*Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sub := m.hub.add(name)
+	if lastID == 0 {
+		return sub, nil
+	}
+	buffered := m.buffered()
+	oldest := m.lastID + 1
+	if len(buffered) > 0 {
+		oldest = buffered[0].ID
+	}
+	//An ID outside the buffer was overwritten, or came from another instance or an earlier run
+	sub.Missed = lastID < oldest-1 || lastID > m.lastID
+	for _, event := range buffered {
+		if event.ID > lastID && event.Name == name {
+			sub.Replay = append(sub.Replay, event)
+		}
+	}
+	return sub, nil
+}
@@ -0,0 +1,152 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+)
+
+//pollBatch is the most events read in a single poll
+const pollBatch = 500
+
+//reorderWindow is the number of IDs behind the newest delivered event that every poll reads again.  IDs are taken when an event is inserted but become visible when it commits, so an event can appear after others with higher IDs
+const reorderWindow = 1000
+
+//Log is a shared store of events, such as a database table, that several instances can append to and read from
+type Log interface {
+	AppendEvents(events []types.Event) error
+	SelectEventsAfter(after int64, name string, limit int) ([]types.Event, error)
+	SelectEventRange() (oldest int64, newest int64, err error)
+	TrimEvents(keep int) error
+}
+
+//Poller is a broker shared by several instances through a Log.  Every instance polls the log for new events and delivers them to its own subscribers
+type Poller struct {
+	hub  *hub
+	log  Log
+	keep int
+
+	mu      sync.Mutex
+	startID int64
+	lastID  int64
+	seen    map[int64]bool
+}
+
+//NewPoller creates a broker that publishes to log and polls it every interval.  The log is trimmed to the last keep events, which bounds how far back subscribers can resume
+func NewPoller(eventLog Log, interval time.Duration, keep int) (*Poller, error) {
+	_, newest, err := eventLog.SelectEventRange()
+	if err != nil {
+		return nil, err
+	}
+	p := &Poller{hub: newHub(), log: eventLog, keep: keep, startID: newest, lastID: newest, seen: make(map[int64]bool)}
+	go p.run(interval)
+	return p, nil
+}
+
+//Publish appends events to the log.  They are delivered, on this instance as on the others, when the log is next polled
+func (p *Poller) Publish(events []types.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return p.log.AppendEvents(events)
+}
+
+//Subscribe starts delivery of an account's events, replaying the events in the log after lastID
+func (p *Poller) Subscribe(name string, lastID int64) (*Subscription, error) {
+	//Hold the poll lock so that no event is both replayed and delivered, or neither
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sub := p.hub.add(name)
+	if lastID == 0 {
+		return sub, nil
+	}
+	oldest, newest, err := p.log.SelectEventRange()
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+	if lastID > newest || lastID < oldest-1 {
+		sub.Missed = true
+	}
+	for after := lastID; after < p.lastID; {
+		events, err := p.log.SelectEventsAfter(after, name, pollBatch)
+		if err != nil {
+			sub.Close()
+			return nil, err
+		}
+		for _, event := range events {
+			if p.delivered(event.ID) {
+				sub.Replay = append(sub.Replay, event)
+			}
+		}
+		if len(events) < pollBatch {
+			break
+		}
+		after = events[len(events)-1].ID
+	}
+	return sub, nil
+}
+
+//run polls the log until the process exits
+func (p *Poller) run(interval time.Duration) {
+	polls := 0
+	for range time.Tick(interval) {
+		p.poll()
+		polls++
+		if polls%60 == 0 {
+			if err := p.log.TrimEvents(p.keep); err != nil {
+				log.Warnf("Error trimming event log: %v", err)
+			}
+		}
+	}
+}
+
+//floor returns the ID at and below which events are no longer read by polls
+func (p *Poller) floor() int64 {
+	if floor := p.lastID - reorderWindow; floor > p.startID {
+		return floor
+	}
+	return p.startID
+}
+
+//delivered reports whether the event with the given ID has been delivered, or was in the log before this instance started polling it
+func (p *Poller) delivered(id int64) bool {
+	return id <= p.floor() || p.seen[id]
+}
+
+//poll delivers the events appended to the log since the last poll.  It reads again from reorderWindow IDs behind the newest event delivered, delivering only the events not delivered before
+func (p *Poller) poll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	floor := p.floor()
+	for id := range p.seen {
+		if id <= floor {
+			delete(p.seen, id)
+		}
+	}
+	for after := floor; ; {
+		events, err := p.log.SelectEventsAfter(after, "", pollBatch)
+		if err != nil {
+			log.Warnf("Error polling event log: %v", err)
+			return
+		}
+		var fresh []types.Event
+		for _, event := range events {
+			if p.seen[event.ID] {
+				continue
+			}
+			fresh = append(fresh, event)
+			p.seen[event.ID] = true
+			if event.ID > p.lastID {
+				p.lastID = event.ID
+			}
+		}
+		p.hub.deliver(fresh)
+		if len(events) < pollBatch {
+			return
+		}
+		after = events[len(events)-1].ID
+	}
+}
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//fakeLog is a Log whose events become visible when committed, in any order
+type fakeLog struct {
+	mu      sync.Mutex
+	visible []types.Event
+}
+
+func (l *fakeLog) commit(ids ...int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, id := range ids {
+		l.visible = append(l.visible, types.Event{ID: id, Name: "tom", Type: Updated})
+	}
+	sort.Slice(l.visible, func(i, j int) bool { return l.visible[i].ID < l.visible[j].ID })
+}
+
+func (l *fakeLog) AppendEvents(events []types.Event) error {
+	return nil
+}
+
+func (l *fakeLog) SelectEventsAfter(after int64, name string, limit int) ([]types.Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var events []types.Event
+	for _, event := range l.visible {
+		if event.ID > after && (name == "" || event.Name == name) && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (l *fakeLog) SelectEventRange() (int64, int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.visible) == 0 {
+		return 0, 0, nil
+	}
+	return l.visible[0].ID, l.visible[len(l.visible)-1].ID, nil
+}
+
+func (l *fakeLog) TrimEvents(keep int) error {
+	return nil
+}
+
+//received drains the events waiting on a subscription
+func received(sub *Subscription) []int64 {
+	var ids []int64
+	for {
+		select {
+		case event := <-sub.C:
+			ids = append(ids, event.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func equalIDs(a []int64, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPollerDeliversEventsCommittedOutOfOrder(t *testing.T) {
+	eventLog := &fakeLog{}
+	eventLog.commit(1, 2)
+	p, err := NewPoller(eventLog, time.Hour, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := p.Subscribe("tom", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	//Event 3 was inserted first but commits after event 4
+	eventLog.commit(4)
+	p.poll()
+	eventLog.commit(3, 5)
+	p.poll()
+	p.poll()
+	if got, want := received(sub), []int64{4, 3, 5}; !equalIDs(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestPollerReplaysOnlyDeliveredEvents(t *testing.T) {
+	eventLog := &fakeLog{}
+	eventLog.commit(1, 2)
+	p, err := NewPoller(eventLog, time.Hour, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventLog.commit(4)
+	p.poll()
+	//Event 3 is visible but not yet polled, so it must come on C rather than in Replay
+	eventLog.commit(3)
+	sub, err := p.Subscribe("tom", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	var replayed []int64
+	for _, event := range sub.Replay {
+		replayed = append(replayed, event.ID)
+	}
+	if want := []int64{2, 4}; !equalIDs(replayed, want) {
+		t.Errorf("replayed %v, want %v", replayed, want)
+	}
+	p.poll()
+	if got, want := received(sub), []int64{3}; !equalIDs(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestPollerForgetsEventsBehindTheWindow(t *testing.T) {
+	eventLog := &fakeLog{}
+	p, err := NewPoller(eventLog, time.Hour, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventLog.commit(1)
+	p.poll()
+	eventLog.commit(reorderWindow + 10)
+	p.poll()
+	p.poll()
+	if len(p.seen) != 1 {
+		t.Errorf("kept %d delivered IDs, want 1", len(p.seen))
+	}
+}
@@ -0,0 +1,184 @@
+package format
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//csvColumns are the columns written to exported CSV files
+var csvColumns = []string{"id", FieldTitle, FieldBody, FieldCategory, FieldPriority, FieldActive, FieldTags, FieldDue, FieldRemindAt, FieldRemindBefore, FieldRecurrence, "publish_date"}
+
+//columnAliases maps other common column names onto TodoData fields
+var columnAliases = map[string]string{
+	"priority": FieldPriority,
+}
+
+//formatTime writes an optional time as RFC 3339, or as an empty cell when it is not set
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+//encodeCSV writes a header row followed by a row for each todo item.  Tags are joined with commas in a single column, and unset times are left empty
+func encodeCSV(w io.Writer, todos []types.TodoData) error {
+	out := csv.NewWriter(w)
+	if err := out.Write(csvColumns); err != nil {
+		return err
+	}
+	for _, todo := range todos {
+		published, remindBefore := "", ""
+		if todo.PublishDate.Valid {
+			published = todo.PublishDate.Time.Format("2006-01-02")
+		}
+		if todo.RemindBefore != nil {
+			remindBefore = strconv.Itoa(*todo.RemindBefore)
+		}
+		err := out.Write([]string{
+			strconv.Itoa(todo.ID),
+			todo.Title,
+			todo.Body,
+			todo.Category,
+			strconv.Itoa(todo.Priority),
+			strconv.FormatBool(todo.Active),
+			strings.Join(todo.Tags, ","),
+			formatTime(todo.Due),
+			formatTime(todo.RemindAt),
+			remindBefore,
+			todo.Recurrence,
+			published,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+//decodeCSV reads a CSV file whose first row names its columns.  Columns are matched to fields by name, after applying the column mapping in opts
+func decodeCSV(r io.Reader, opts Options) ([]Row, error) {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+	header, err := in.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the file is empty; a header row naming the columns is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, len(header))
+	seen := make(map[string]bool)
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		field := strings.ToLower(column)
+		if mapped, ok := opts.Columns[column]; ok {
+			field = mapped
+		} else if alias, ok := columnAliases[field]; ok {
+			field = alias
+		}
+		switch field {
+		case FieldTitle, FieldBody, FieldCategory, FieldPriority, FieldActive, FieldTags, FieldDue, FieldRemindAt, FieldRemindBefore, FieldRecurrence:
+			if seen[field] {
+				return nil, fmt.Errorf("more than one column maps to %s", field)
+			}
+			seen[field] = true
+		default:
+			if !readOnly[field] {
+				return nil, fmt.Errorf("column %q does not map to a todo item field", column)
+			}
+			field = ""
+		}
+		fields[i] = field
+	}
+
+	var rows []Row
+	for {
+		record, err := in.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := in.FieldPos(0)
+		row := Row{Ref: fmt.Sprintf("line %d", line), Todo: newTodo()}
+		if len(record) != len(fields) {
+			row.Errors = append(row.Errors, types.FieldError{Message: fmt.Sprintf("Has %d columns, the header has %d", len(record), len(fields))})
+			rows = append(rows, row)
+			continue
+		}
+		for i, value := range record {
+			if msg := setField(&row.Todo, fields[i], value); msg != "" {
+				row.Errors = append(row.Errors, types.FieldError{Field: fields[i], Message: msg})
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+//setField sets a field of todo from its text in a CSV file, returning a message describing the problem if the text cannot be read
+func setField(todo *types.TodoData, field string, value string) string {
+	switch field {
+	case FieldTitle:
+		todo.Title = value
+	case FieldBody:
+		todo.Body = value
+	case FieldCategory:
+		todo.Category = value
+	case FieldPriority:
+		if strings.TrimSpace(value) == "" {
+			return ""
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Sprintf("Must be an integer, got %q", value)
+		}
+		todo.Priority = priority
+	case FieldActive:
+		if strings.TrimSpace(value) == "" {
+			return ""
+		}
+		active, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Sprintf("Must be a boolean, got %q", value)
+		}
+		todo.Active = active
+	case FieldTags:
+		todo.Tags = splitTags(value)
+	case FieldDue, FieldRemindAt:
+		var t *time.Time
+		if value = strings.TrimSpace(value); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return fmt.Sprintf("Must be an RFC 3339 timestamp, got %q", value)
+			}
+			t = &parsed
+		}
+		if field == FieldDue {
+			todo.Due = t
+		} else {
+			todo.RemindAt = t
+		}
+	case FieldRemindBefore:
+		todo.RemindBefore = nil
+		if value = strings.TrimSpace(value); value != "" {
+			minutes, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Sprintf("Must be an integer, got %q", value)
+			}
+			todo.RemindBefore = &minutes
+		}
+	case FieldRecurrence:
+		todo.Recurrence = strings.TrimSpace(value)
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+//Package format reads and writes todo lists in the file formats supported for import and export
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/shale/go/types"
+)
+
+//Supported formats
+const (
+	CSV     = "csv"
+	JSON    = "json"
+	TodoTxt = "todotxt"
+)
+
+//Formats lists every supported format
+var Formats = []string{CSV, JSON, TodoTxt}
+
+//Field names used for the columns of imported files, matching the JSON names of the TodoData fields
+const (
+	FieldTitle    = "title"
+	FieldBody     = "body"
+	FieldCategory = "category"
+	FieldPriority = "item_priority"
+	FieldActive   = "active"
+	FieldTags     = "tags"
+
+	FieldDue          = "due"
+	FieldRemindAt     = "remind_at"
+	FieldRemindBefore = "remind_before"
+	FieldRecurrence   = "recurrence"
+)
+
+//readOnly lists exported fields that are set by the server and ignored on import
+var readOnly = map[string]bool{
+	"id":           true,
+	"acct_name":    true,
+	"publish_date": true,
+	"version":      true,
+	"updated_at":   true,
+	"deleted_at":   true,
+}
+
+//Row is a single todo item read from an imported file.  Ref locates it in the file for error messages, and Errors lists any values that could not be read
+type Row struct {
+	Ref    string
+	Todo   types.TodoData
+	Errors []types.FieldError
+}
+
+//Options adjusts how a file is read
+type Options struct {
+	//Columns maps column names of a CSV file to TodoData field names, for files whose headers do not already use them
+	Columns map[string]string
+}
+
+//Valid reports whether format is supported
+func Valid(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+//ContentType returns the media type of a format
+func ContentType(format string) string {
+	switch format {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case TodoTxt:
+		return "text/plain; charset=utf-8"
+	}
+	return "application/json"
+}
+
+//Filename returns the file name suggested for an account's exported list
+func Filename(name string, format string) string {
+	switch format {
+	case CSV:
+		return name + ".csv"
+	case TodoTxt:
+		return name + ".todo.txt"
+	}
+	return name + ".json"
+}
+
+//Encode writes todo items in the given format
+func Encode(w io.Writer, format string, todos []types.TodoData) error {
+	switch format {
+	case CSV:
+		return encodeCSV(w, todos)
+	case JSON:
+		if todos == nil {
+			todos = []types.TodoData{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(todos)
+	case TodoTxt:
+		return encodeTodoTxt(w, todos)
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
+
+//Decode reads todo items in the given format.  Problems with single values are reported in each row's Errors; an error is returned only if the file cannot be read at all
+func Decode(r io.Reader, format string, opts Options) ([]Row, error) {
+	switch format {
+	case CSV:
+		return decodeCSV(r, opts)
+	case JSON:
+		return decodeJSON(r)
+	case TodoTxt:
+		return decodeTodoTxt(r)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+//newTodo returns the todo item an imported row starts from.  Imported items are active unless the file says otherwise
+func newTodo() types.TodoData {
+	return types.TodoData{Active: true}
+}
+
+//decodeJSON reads an array of todo items in the form returned by the API
+func decodeJSON(r io.Reader) ([]Row, error) {
+	var items []map[string]json.RawMessage
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&items); err != nil {
+		return nil, fmt.Errorf("malformed JSON: %v", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON array")
+	}
+	rows := make([]Row, 0, len(items))
+	for i, item := range items {
+		row := Row{Ref: fmt.Sprintf("item %d", i+1), Todo: newTodo()}
+		fields := make([]string, 0, len(item))
+		for field := range item {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			var dest interface{}
+			switch field {
+			case FieldTitle:
+				dest = &row.Todo.Title
+			case FieldBody:
+				dest = &row.Todo.Body
+			case FieldCategory:
+				dest = &row.Todo.Category
+			case FieldPriority:
+				dest = &row.Todo.Priority
+			case FieldActive:
+				dest = &row.Todo.Active
+			case FieldTags:
+				dest = &row.Todo.Tags
+			case FieldDue:
+				dest = &row.Todo.Due
+			case FieldRemindAt:
+				dest = &row.Todo.RemindAt
+			case FieldRemindBefore:
+				dest = &row.Todo.RemindBefore
+			case FieldRecurrence:
+				dest = &row.Todo.Recurrence
+			default:
+				if !readOnly[field] {
+					row.Errors = append(row.Errors, types.FieldError{Field: field, Message: "Is not a todo item field"})
+				}
+				continue
+			}
+			dec := json.NewDecoder(bytes.NewReader(item[field]))
+			if err := dec.Decode(dest); err != nil {
+				row.Errors = append(row.Errors, types.FieldError{Field: field, Message: fmt.Sprintf("Could not be read: %v", err)})
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+//splitTags splits a comma separated list of tags, dropping empty entries
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
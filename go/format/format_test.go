@@ -0,0 +1,139 @@
+package format
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//exported returns todo items as the API exports them, with every importable field set on the first
+func exported() []types.TodoData {
+	due := time.Date(2020, 5, 1, 17, 0, 0, 0, time.FixedZone("", -6*60*60))
+	remindAt := time.Date(2020, 5, 1, 9, 30, 0, 0, time.UTC)
+	before := 45
+	return []types.TodoData{
+		{ID: 7, Name: "tom", Version: 3, Title: "Taxes", Body: "Form 1040, \"schedule C\"", Category: "home", Priority: 2, Active: true, Tags: []string{"money", "urgent"}, Due: &due, RemindAt: &remindAt, RemindBefore: &before, Recurrence: "FREQ=WEEKLY;BYDAY=MO,TH"},
+		{ID: 8, Name: "tom", Version: 1, Title: "Walk the dog", Active: false},
+	}
+}
+
+//importable clears the fields of todo items that are not imported
+func importable(todos []types.TodoData) []types.TodoData {
+	var result []types.TodoData
+	for _, todo := range todos {
+		result = append(result, types.TodoData{
+			Title:        todo.Title,
+			Body:         todo.Body,
+			Category:     todo.Category,
+			Priority:     todo.Priority,
+			Active:       todo.Active,
+			Tags:         todo.Tags,
+			Due:          todo.Due,
+			RemindAt:     todo.RemindAt,
+			RemindBefore: todo.RemindBefore,
+			Recurrence:   todo.Recurrence,
+		})
+	}
+	return result
+}
+
+//sameTodos compares todo items, comparing times by instant
+func sameTodos(a []types.TodoData, b []types.TodoData) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		for _, times := range [][2]*time.Time{{x.Due, y.Due}, {x.RemindAt, y.RemindAt}} {
+			if (times[0] == nil) != (times[1] == nil) || times[0] != nil && !times[0].Equal(*times[1]) {
+				return false
+			}
+		}
+		x.Due, y.Due, x.RemindAt, y.RemindAt = nil, nil, nil, nil
+		if !reflect.DeepEqual(x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{JSON, CSV} {
+		var out bytes.Buffer
+		if err := Encode(&out, format, exported()); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		rows, err := Decode(&out, format, Options{})
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		var todos []types.TodoData
+		for _, row := range rows {
+			if len(row.Errors) > 0 {
+				t.Errorf("%s %s: %+v", format, row.Ref, row.Errors)
+			}
+			todos = append(todos, row.Todo)
+		}
+		if want := importable(exported()); !sameTodos(todos, want) {
+			t.Errorf("%s: imported %+v, want %+v", format, todos, want)
+		}
+	}
+}
+
+func TestDecodeJSONReminders(t *testing.T) {
+	rows, err := Decode(strings.NewReader(`[{"id": 7, "version": 3, "title": "Taxes", "due": "2020-05-01T17:00:00-06:00", "remind_at": null, "remind_before": 45}]`), JSON, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	todo := rows[0].Todo
+	if len(rows[0].Errors) > 0 || todo.Due == nil || !todo.Due.Equal(time.Date(2020, 5, 1, 23, 0, 0, 0, time.UTC)) || todo.RemindAt != nil || todo.RemindBefore == nil || *todo.RemindBefore != 45 {
+		t.Errorf("read %+v, %+v", todo, rows[0].Errors)
+	}
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	rows, err := Decode(strings.NewReader(`[{"title": "a", "due": "tomorrow", "remind_before": "soon", "colour": "red"}]`), JSON, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for _, problem := range rows[0].Errors {
+		fields = append(fields, problem.Field)
+	}
+	if want := []string{"colour", "due", "remind_before"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("errors on %v, want %v", fields, want)
+	}
+	if _, err := Decode(strings.NewReader(`{"title": "a"}`), JSON, Options{}); err == nil {
+		t.Errorf("an object was read as a list")
+	}
+}
+
+func TestDecodeCSV(t *testing.T) {
+	file := "\ufeffName,Priority,Due,Notes\nTaxes,2,2020-05-01T17:00:00Z,x\nDog,high,,y\nShort row\n"
+	rows, err := Decode(strings.NewReader(file), CSV, Options{Columns: map[string]string{"Name": FieldTitle, "Notes": FieldBody}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+	if todo := rows[0].Todo; todo.Title != "Taxes" || todo.Priority != 2 || todo.Due == nil || todo.Body != "x" || !todo.Active || len(rows[0].Errors) > 0 {
+		t.Errorf("line 2 read as %+v, %+v", todo, rows[0].Errors)
+	}
+	if errs := rows[1].Errors; len(errs) != 1 || errs[0].Field != FieldPriority || rows[1].Todo.Due != nil {
+		t.Errorf("line 3 errors %+v", errs)
+	}
+	if errs := rows[2].Errors; len(errs) != 1 || rows[2].Ref != "line 4" {
+		t.Errorf("line 4 %s errors %+v", rows[2].Ref, errs)
+	}
+
+	for _, file := range []string{"", "title,colour\n", "title,Title\n"} {
+		if _, err := Decode(strings.NewReader(file), CSV, Options{}); err == nil {
+			t.Errorf("header %q was accepted", file)
+		}
+	}
+}
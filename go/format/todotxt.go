@@ -0,0 +1,114 @@
+package format
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//todo.txt priorities run from (A), the highest, to (Z).  Priority 1 is written as (A)
+const maxLetterPriority = 26
+
+//Patterns for the parts of a todo.txt line
+var (
+	txtPriority = regexp.MustCompile(`^\(([A-Z])\)$`)
+	txtDate     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+)
+
+//encodeTodoTxt writes a todo.txt line for each todo item.  Inactive items are marked done with x, priorities 1 through 26 become (A) through (Z), the category becomes a +project, and tags become @contexts.  Other priorities are written as a pri: key.  todo.txt has no place for the body, so it is not written
+func encodeTodoTxt(w io.Writer, todos []types.TodoData) error {
+	out := bufio.NewWriter(w)
+	for _, todo := range todos {
+		var parts []string
+		if !todo.Active {
+			parts = append(parts, "x")
+		} else {
+			if todo.Priority >= 1 && todo.Priority <= maxLetterPriority {
+				parts = append(parts, "("+string(rune('A'+todo.Priority-1))+")")
+			}
+			if todo.PublishDate.Valid {
+				parts = append(parts, todo.PublishDate.Time.Format("2006-01-02"))
+			}
+		}
+		parts = append(parts, strings.Join(strings.Fields(todo.Title), " "))
+		if todo.Category != "" {
+			parts = append(parts, "+"+txtWord(todo.Category))
+		}
+		for _, tag := range todo.Tags {
+			parts = append(parts, "@"+txtWord(tag))
+		}
+		if todo.Priority != 0 && (!todo.Active || todo.Priority < 1 || todo.Priority > maxLetterPriority) {
+			parts = append(parts, "pri:"+strconv.Itoa(todo.Priority))
+		}
+		if _, err := out.WriteString(strings.Join(parts, " ") + "\n"); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+//txtWord makes a project or context name a single word by replacing whitespace with underscores
+func txtWord(s string) string {
+	return strings.Join(strings.Fields(s), "_")
+}
+
+//decodeTodoTxt reads a todo.txt file, one todo item per non-blank line.  A leading x marks the item inactive, (A) through (Z) set priority 1 through 26, the first +project sets the category, @contexts become tags, and a pri: key sets any other priority.  The rest of the line is the title
+func decodeTodoTxt(r io.Reader) ([]Row, error) {
+	in := bufio.NewScanner(r)
+	var rows []Row
+	for line := 1; in.Scan(); line++ {
+		words := strings.Fields(in.Text())
+		if len(words) == 0 {
+			continue
+		}
+		row := Row{Ref: fmt.Sprintf("line %d", line), Todo: newTodo()}
+		if words[0] == "x" {
+			row.Todo.Active = false
+			words = words[1:]
+		}
+		if len(words) > 0 {
+			if m := txtPriority.FindStringSubmatch(words[0]); m != nil {
+				row.Todo.Priority = int(m[1][0]-'A') + 1
+				words = words[1:]
+			}
+		}
+		//Completion and creation dates are not kept
+		for len(words) > 0 && txtDate.MatchString(words[0]) {
+			if _, err := time.Parse("2006-01-02", words[0]); err != nil {
+				break
+			}
+			words = words[1:]
+		}
+		var title []string
+		for _, word := range words {
+			switch {
+			case len(word) > 1 && word[0] == '+':
+				if row.Todo.Category == "" {
+					row.Todo.Category = word[1:]
+				} else {
+					title = append(title, word)
+				}
+			case len(word) > 1 && word[0] == '@':
+				row.Todo.Tags = append(row.Todo.Tags, word[1:])
+			case strings.HasPrefix(word, "pri:"):
+				priority, err := strconv.Atoi(word[len("pri:"):])
+				if err != nil {
+					row.Errors = append(row.Errors, types.FieldError{Field: FieldPriority, Message: fmt.Sprintf("Must be an integer, got %q", word)})
+					continue
+				}
+				row.Todo.Priority = priority
+			default:
+				title = append(title, word)
+			}
+		}
+		row.Todo.Title = strings.Join(title, " ")
+		rows = append(rows, row)
+	}
+	return rows, in.Err()
+}
@@ -0,0 +1,312 @@
+//Package ical renders todo items as iCalendar VTODO components and reads them back, as described in RFC 5545
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shale/go/types"
+)
+
+//ContentType is the media type of iCalendar data
+const ContentType = "text/calendar; charset=utf-8"
+
+//prodID identifies shale as the producer of the calendars it writes
+const prodID = "-//shale//todo//EN"
+
+//maxLineOctets is the longest content line allowed before it must be folded
+const maxLineOctets = 75
+
+//Values of the STATUS property
+const (
+	StatusNeedsAction = "NEEDS-ACTION"
+	StatusCompleted   = "COMPLETED"
+	StatusCancelled   = "CANCELLED"
+)
+
+//timeFormat is the UTC date-time format used for DTSTAMP and other timestamps
+const timeFormat = "20060102T150405Z"
+
+//ErrNoTodo is returned when calendar data holds no VTODO component
+var ErrNoTodo = errors.New("no VTODO component")
+
+//Priority converts a todo item priority to the iCalendar scale, where 0 is undefined and 1 through 9 run from highest to lowest.  Priorities outside that range are clamped to it
+func Priority(priority int) int {
+	switch {
+	case priority == 0:
+		return 0
+	case priority < 1:
+		return 1
+	case priority > 9:
+		return 9
+	}
+	return priority
+}
+
+//Status returns the STATUS of a todo item
+func Status(active bool) string {
+	if active {
+		return StatusNeedsAction
+	}
+	return StatusCompleted
+}
+
+//UID returns the UID of a calendar item, which is assigned by the server unless a client supplied its own
+func UID(item types.CalendarItem) string {
+	if item.UID != "" {
+		return item.UID
+	}
+	return fmt.Sprintf("shale-%d", item.Todo.ID)
+}
+
+//writer writes content lines, folding them and ending each with CRLF
+type writer struct {
+	out *bufio.Writer
+}
+
+//line writes a property, folding it at 75 octets without splitting a UTF-8 sequence
+func (w *writer) line(name string, value string) {
+	s := name + ":" + value
+	first := true
+	for len(s) > 0 {
+		limit := maxLineOctets
+		if !first {
+			//Continuation lines begin with a space, which counts toward the limit
+			limit--
+			w.out.WriteString(" ")
+		}
+		n := len(s)
+		if n > limit {
+			n = limit
+			for n > 0 && !utf8.RuneStart(s[n]) {
+				n--
+			}
+		}
+		w.out.WriteString(s[:n] + "\r\n")
+		s = s[n:]
+		first = false
+	}
+}
+
+//escape escapes a TEXT value
+func escape(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, ";", `\;`, -1)
+	s = strings.Replace(s, ",", `\,`, -1)
+	s = strings.Replace(s, "\r\n", `\n`, -1)
+	return strings.Replace(s, "\n", `\n`, -1)
+}
+
+//WriteCalendar writes a VCALENDAR holding a VTODO for each item.  name, if not empty, is given as the calendar's display name
+func WriteCalendar(w io.Writer, name string, items []types.CalendarItem) error {
+	out := &writer{out: bufio.NewWriter(w)}
+	out.line("BEGIN", "VCALENDAR")
+	out.line("VERSION", "2.0")
+	out.line("PRODID", prodID)
+	out.line("CALSCALE", "GREGORIAN")
+	if name != "" {
+		out.line("X-WR-CALNAME", escape(name))
+	}
+	now := time.Now().UTC().Format(timeFormat)
+	for _, item := range items {
+		todo := item.Todo
+		out.line("BEGIN", "VTODO")
+		out.line("UID", escape(UID(item)))
+		out.line("DTSTAMP", now)
+		if todo.PublishDate.Valid {
+			out.line("CREATED", todo.PublishDate.Time.UTC().Format(timeFormat))
+		}
+		if todo.UpdatedAt.Valid {
+			out.line("LAST-MODIFIED", todo.UpdatedAt.Time.UTC().Format(timeFormat))
+		}
+		out.line("SUMMARY", escape(todo.Title))
+		if todo.Body != "" {
+			out.line("DESCRIPTION", escape(todo.Body))
+		}
+		if categories := Categories(todo); len(categories) > 0 {
+			escaped := make([]string, len(categories))
+			for i, category := range categories {
+				escaped[i] = escape(category)
+			}
+			out.line("CATEGORIES", strings.Join(escaped, ","))
+		}
+		if priority := Priority(todo.Priority); priority != 0 {
+			out.line("PRIORITY", strconv.Itoa(priority))
+		}
+		out.line("STATUS", Status(todo.Active))
+		out.line("SEQUENCE", strconv.Itoa(todo.Version))
+		out.line("END", "VTODO")
+	}
+	out.line("END", "VCALENDAR")
+	return out.out.Flush()
+}
+
+//Categories returns the CATEGORIES of a todo item: its category followed by its tags
+func Categories(todo types.TodoData) []string {
+	var categories []string
+	if todo.Category != "" {
+		categories = append(categories, todo.Category)
+	}
+	return append(categories, todo.Tags...)
+}
+
+//Todo is the part of a VTODO that maps onto a todo item
+type Todo struct {
+	UID         string
+	Summary     string
+	Description string
+	Categories  []string
+	Priority    int
+	Status      string
+}
+
+//Apply sets the fields of todo from the VTODO.  The first category becomes the category and the rest become tags
+func (vtodo Todo) Apply(todo *types.TodoData) {
+	todo.Title = vtodo.Summary
+	todo.Body = vtodo.Description
+	todo.Category = ""
+	todo.Tags = nil
+	if len(vtodo.Categories) > 0 {
+		todo.Category = vtodo.Categories[0]
+		todo.Tags = vtodo.Categories[1:]
+	}
+	todo.Priority = vtodo.Priority
+	todo.Active = vtodo.Status != StatusCompleted && vtodo.Status != StatusCancelled
+}
+
+//ParseTodo reads the first VTODO component of a VCALENDAR
+func ParseTodo(r io.Reader) (Todo, error) {
+	var vtodo Todo
+	lines, err := unfold(r)
+	if err != nil {
+		return vtodo, err
+	}
+	depth, found := 0, false
+	for _, line := range lines {
+		name, value, err := splitLine(line)
+		if err != nil {
+			return vtodo, err
+		}
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VTODO") && !found:
+			depth, found = 1, true
+			continue
+		case depth == 0:
+			continue
+		case name == "BEGIN":
+			//Skip nested components such as VALARM
+			depth++
+			continue
+		case name == "END":
+			depth--
+			continue
+		case depth > 1:
+			continue
+		}
+		switch name {
+		case "UID":
+			vtodo.UID = unescape(value)
+		case "SUMMARY":
+			vtodo.Summary = unescape(value)
+		case "DESCRIPTION":
+			vtodo.Description = unescape(value)
+		case "CATEGORIES":
+			for _, category := range splitList(value) {
+				if category = strings.TrimSpace(category); category != "" {
+					vtodo.Categories = append(vtodo.Categories, category)
+				}
+			}
+		case "PRIORITY":
+			priority, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || priority < 0 || priority > 9 {
+				return vtodo, fmt.Errorf("PRIORITY must be an integer from 0 to 9, got %q", value)
+			}
+			vtodo.Priority = priority
+		case "STATUS":
+			vtodo.Status = strings.ToUpper(strings.TrimSpace(value))
+		}
+	}
+	if !found {
+		return vtodo, ErrNoTodo
+	}
+	if depth != 0 {
+		return vtodo, errors.New("VTODO component is not closed")
+	}
+	return vtodo, nil
+}
+
+//unfold reads content lines, joining folded continuation lines
+func unfold(r io.Reader) ([]string, error) {
+	in := bufio.NewScanner(r)
+	var lines []string
+	for in.Scan() {
+		line := strings.TrimRight(in.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, in.Err()
+}
+
+//splitLine splits a content line into its upper-cased property name and its value, dropping any parameters
+func splitLine(line string) (string, string, error) {
+	//Parameter values may be quoted and contain colons, so find the first colon outside quotes
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ':' && !quoted:
+			name := line[:i]
+			if semi := strings.IndexByte(name, ';'); semi >= 0 {
+				name = name[:semi]
+			}
+			return strings.ToUpper(name), line[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("malformed content line %q", line)
+}
+
+//unescape reverses escape
+func unescape(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if s[i] == 'n' || s[i] == 'N' {
+				out.WriteByte('\n')
+			} else {
+				out.WriteByte(s[i])
+			}
+			continue
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
+
+//splitList splits a list of TEXT values on the commas that are not escaped, and unescapes each value
+func splitList(s string) []string {
+	var values []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ',':
+			values = append(values, unescape(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(values, unescape(s[start:]))
+}
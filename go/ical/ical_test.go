@@ -0,0 +1,232 @@
+package ical
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/shale/go/types"
+)
+
+func TestParseTodo(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want Todo
+		err  bool
+	}{
+		{
+			name: "all fields",
+			raw: "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+				"BEGIN:VTODO\r\n" +
+				"UID:abc@example.org\r\n" +
+				"SUMMARY;LANGUAGE=en:Buy milk\\, eggs\r\n" +
+				"DESCRIPTION:Semi-skimmed\\nTwo pints\r\n" +
+				"CATEGORIES:shopping, urgent ,\\,odd\r\n" +
+				"PRIORITY:2\r\n" +
+				"status:completed\r\n" +
+				"END:VTODO\r\nEND:VCALENDAR\r\n",
+			want: Todo{UID: "abc@example.org", Summary: "Buy milk, eggs", Description: "Semi-skimmed\nTwo pints", Categories: []string{"shopping", "urgent", ",odd"}, Priority: 2, Status: StatusCompleted},
+		},
+		{
+			name: "folded lines and LF endings",
+			raw:  "BEGIN:VTODO\nSUMMARY:Buy\n  milk\nDESCRIPTION:a\n\tb\nEND:VTODO\n",
+			want: Todo{Summary: "Buy milk", Description: "ab"},
+		},
+		{
+			name: "nested VALARM and later VTODO are skipped",
+			raw: "BEGIN:VTODO\r\nSUMMARY:First\r\n" +
+				"BEGIN:VALARM\r\nDESCRIPTION:Alarm\r\nEND:VALARM\r\n" +
+				"END:VTODO\r\n" +
+				"BEGIN:VTODO\r\nSUMMARY:Second\r\nEND:VTODO\r\n",
+			want: Todo{Summary: "First"},
+		},
+		{
+			name: "quoted parameter holding a colon",
+			raw:  "BEGIN:VTODO\r\nDESCRIPTION;ALTREP=\"http://example.org/a\":Text\r\nEND:VTODO\r\n",
+			want: Todo{Description: "Text"},
+		},
+		{name: "no VTODO", raw: "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n", err: true},
+		{name: "unclosed VTODO", raw: "BEGIN:VTODO\r\nSUMMARY:Buy milk\r\n", err: true},
+		{name: "priority out of range", raw: "BEGIN:VTODO\r\nPRIORITY:10\r\nEND:VTODO\r\n", err: true},
+		{name: "priority not a number", raw: "BEGIN:VTODO\r\nPRIORITY:high\r\nEND:VTODO\r\n", err: true},
+		{name: "malformed line", raw: "BEGIN:VTODO\r\nSUMMARY\r\nEND:VTODO\r\n", err: true},
+	}
+	for _, test := range tests {
+		vtodo, err := ParseTodo(strings.NewReader(test.raw))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: got %+v, want an error", test.name, vtodo)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(vtodo, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, vtodo, test.want)
+		}
+	}
+	if _, err := ParseTodo(strings.NewReader("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")); err != ErrNoTodo {
+		t.Errorf("got %v, want ErrNoTodo", err)
+	}
+}
+
+func TestUnfold(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"A:1\r\nB:2\r\n", []string{"A:1", "B:2"}},
+		{"A:1\r\n 2\r\n\t3\r\nB:4", []string{"A:123", "B:4"}},
+		{"A:1\n\n\nB:2\n", []string{"A:1", "B:2"}},
+		{" A:1\r\n", []string{" A:1"}},
+		{"", nil},
+	}
+	for _, test := range tests {
+		lines, err := unfold(strings.NewReader(test.raw))
+		if err != nil || !reflect.DeepEqual(lines, test.want) {
+			t.Errorf("unfold(%q): got %q %v, want %q", test.raw, lines, err, test.want)
+		}
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	tests := []struct {
+		line, name, value string
+		err               bool
+	}{
+		{"SUMMARY:Buy milk", "SUMMARY", "Buy milk", false},
+		{"summary:Buy milk", "SUMMARY", "Buy milk", false},
+		{"DTSTART;TZID=Europe/London:20200506T100000", "DTSTART", "20200506T100000", false},
+		{`ATTACH;X-A="a:b";X-B=c:http://example.org`, "ATTACH", "http://example.org", false},
+		{"URL:http://example.org", "URL", "http://example.org", false},
+		{"SUMMARY:", "SUMMARY", "", false},
+		{"SUMMARY", "", "", true},
+		{`X-A="a:b"`, "", "", true},
+	}
+	for _, test := range tests {
+		name, value, err := splitLine(test.line)
+		if name != test.name || value != test.value || (err != nil) != test.err {
+			t.Errorf("splitLine(%q): got %q %q %v, want %q %q", test.line, name, value, err, test.name, test.value)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{`a\,b\;c\\d`, `a,b;c\d`},
+		{`one\ntwo\Nthree`, "one\ntwo\nthree"},
+		{`trailing\`, `trailing\`},
+		{`\x`, "x"},
+	}
+	for _, test := range tests {
+		if got := unescape(test.in); got != test.want {
+			t.Errorf("unescape(%q): got %q, want %q", test.in, got, test.want)
+		}
+		if got := unescape(escape(test.want)); got != test.want {
+			t.Errorf("unescape(escape(%q)): got %q", test.want, got)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"a", []string{"a"}},
+		{"a,b,c", []string{"a", "b", "c"}},
+		{`a\,b,c`, []string{"a,b", "c"}},
+		{`a\\,b`, []string{`a\`, "b"}},
+		{"a,,b,", []string{"a", "", "b", ""}},
+		{"", []string{""}},
+	}
+	for _, test := range tests {
+		if got := splitList(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitList(%q): got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestLineFolding(t *testing.T) {
+	tests := []string{
+		"short",
+		strings.Repeat("x", 73),
+		strings.Repeat("x", 74),
+		strings.Repeat("x", 300),
+		strings.Repeat("é", 100),
+		"x" + strings.Repeat("日本", 50),
+	}
+	for _, value := range tests {
+		var buf bytes.Buffer
+		w := &writer{out: bufio.NewWriter(&buf)}
+		w.line("SUMMARY", value)
+		w.out.Flush()
+		raw := buf.String()
+		if !strings.HasSuffix(raw, "\r\n") {
+			t.Errorf("%q: not ended with CRLF: %q", value, raw)
+			continue
+		}
+		lines := strings.Split(strings.TrimSuffix(raw, "\r\n"), "\r\n")
+		for i, line := range lines {
+			if len(line) > maxLineOctets {
+				t.Errorf("%q: line %d is %d octets", value, i, len(line))
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("%q: line %d splits a UTF-8 sequence: %q", value, i, line)
+			}
+			if i > 0 && !strings.HasPrefix(line, " ") {
+				t.Errorf("%q: continuation line %d does not begin with a space", value, i)
+			}
+		}
+		unfolded, err := unfold(strings.NewReader(raw))
+		if err != nil || len(unfolded) != 1 || unfolded[0] != "SUMMARY:"+value {
+			t.Errorf("%q: unfolded to %q %v", value, unfolded, err)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	todo := types.TodoData{
+		ID:       7,
+		Title:    "Pick up " + strings.Repeat("dry cleaning, ", 10) + "and café; done",
+		Body:     "First line\nSecond line with a back\\slash\r\nThird",
+		Category: "errands",
+		Tags:     []string{"town, centre", "weekly"},
+		Priority: 3,
+		Active:   false,
+		Version:  4,
+	}
+	var buf bytes.Buffer
+	if err := WriteCalendar(&buf, "Tom's list", []types.CalendarItem{{Todo: todo}}); err != nil {
+		t.Fatal(err)
+	}
+	vtodo, err := ParseTodo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vtodo.UID != "shale-7" || vtodo.Status != StatusCompleted {
+		t.Errorf("got UID %q and STATUS %q", vtodo.UID, vtodo.Status)
+	}
+	var got types.TodoData
+	vtodo.Apply(&got)
+	want := types.TodoData{
+		Title:    todo.Title,
+		Body:     "First line\nSecond line with a back\\slash\nThird",
+		Category: todo.Category,
+		Tags:     todo.Tags,
+		Priority: todo.Priority,
+		Active:   todo.Active,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
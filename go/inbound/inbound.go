@@ -0,0 +1,253 @@
+//Package inbound turns email into todo items.  It parses RFC 5322 messages and the secret addresses they are sent to, and runs a minimal SMTP server to receive them
+package inbound
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//maxDepth is the deepest nesting of multipart bodies searched for text
+const maxDepth = 5
+
+//Message is the part of an email that becomes a todo item
+type Message struct {
+	From    string
+	Subject string
+	Text    string
+
+	//Recipients are the addresses in the Delivered-To, X-Original-To, To, and Cc headers, in that order
+	Recipients []string
+}
+
+//wordDecoder decodes RFC 2047 encoded words in headers
+var wordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+//Parse reads a raw RFC 5322 message, preferring the plain text body and falling back to the text of an HTML body
+func Parse(r io.Reader) (*Message, error) {
+	m, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{}
+	if from, err := mail.ParseAddress(m.Header.Get("From")); err == nil {
+		msg.From = from.Address
+	}
+	msg.Subject, err = wordDecoder.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		msg.Subject = m.Header.Get("Subject")
+	}
+	for _, key := range []string{"Delivered-To", "X-Original-To", "To", "Cc"} {
+		for _, value := range m.Header[key] {
+			addresses, err := mail.ParseAddressList(value)
+			if err != nil {
+				continue
+			}
+			for _, address := range addresses {
+				msg.Recipients = append(msg.Recipients, address.Address)
+			}
+		}
+	}
+	text, isHTML, err := bodyText(m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Body, 0)
+	if err != nil {
+		return nil, err
+	}
+	if isHTML {
+		text = htmlText(text)
+	}
+	msg.Text = cleanText(text)
+	return msg, nil
+}
+
+//bodyText returns the text of a body or, for a multipart body, of its first plain text part or else its first HTML part
+func bodyText(contentType string, encoding string, body io.Reader, depth int) (string, bool, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxDepth || params["boundary"] == "" {
+			return "", false, nil
+		}
+		parts := multipart.NewReader(body, params["boundary"])
+		var fallback string
+		found := false
+		for {
+			part, err := parts.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", false, err
+			}
+			if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition == "attachment" {
+				continue
+			}
+			text, isHTML, err := bodyText(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, depth+1)
+			if err != nil {
+				return "", false, err
+			}
+			if text == "" {
+				continue
+			}
+			if !isHTML {
+				return text, false, nil
+			}
+			if !found {
+				fallback, found = text, true
+			}
+		}
+		return fallback, found, nil
+	}
+	if mediaType != "text/plain" && mediaType != "text/html" {
+		return "", false, nil
+	}
+	decoded, err := ioutil.ReadAll(decodeTransfer(encoding, body))
+	if err != nil {
+		return "", false, err
+	}
+	text, err := decodeCharset(params["charset"], decoded)
+	if err != nil {
+		return "", false, err
+	}
+	return text, mediaType == "text/html", nil
+}
+
+//decodeTransfer undoes a content transfer encoding
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	}
+	return body
+}
+
+//decodeCharset converts text in a charset to UTF-8.  Only UTF-8 and its subsets and the Latin-1 family are understood
+func decodeCharset(charset string, text []byte) (string, error) {
+	r, err := charsetReader(charset, bytes.NewReader(text))
+	if err != nil {
+		return "", err
+	}
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToValidUTF8(string(decoded), "\uFFFD"), nil
+}
+
+//charsetReader converts a charset to UTF-8
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(charset)) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return input, nil
+	case "iso-8859-1", "latin1", "iso-8859-15", "windows-1252", "cp1252":
+		//Close enough: these differ only in a few punctuation marks
+		raw, err := ioutil.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(raw))
+		for i, b := range raw {
+			runes[i] = rune(b)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", charset)
+}
+
+var (
+	htmlHidden = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlBreak  = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|tr|h[1-6])>`)
+	htmlTag    = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+//htmlText reduces HTML to its text, keeping line breaks between blocks
+func htmlText(s string) string {
+	s = htmlHidden.ReplaceAllString(s, "")
+	s = strings.Replace(s, "\n", " ", -1)
+	s = htmlBreak.ReplaceAllString(s, "\n")
+	s = htmlTag.ReplaceAllString(s, "")
+	return html.UnescapeString(s)
+}
+
+//cleanText normalizes line endings and whitespace and removes the signature, which follows a "-- " line.  Quoted-printable decoding drops the trailing space, so "--" counts too
+func cleanText(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.TrimRight(line, " ") == "--" {
+			lines = lines[:i]
+			break
+		}
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	s = strings.Join(lines, "\n")
+	return strings.TrimSpace(blankLines.ReplaceAllString(s, "\n\n"))
+}
+
+var (
+	replyPrefix   = regexp.MustCompile(`(?i)^(re|fwd?|aw|wg)\s*(\[\d+\])?\s*:\s*`)
+	priorityToken = regexp.MustCompile(`^!(\d{1,9})$`)
+)
+
+//ParseSubject turns a subject into a title, dropping reply and forward prefixes such as "Fwd:".  A token like "!2" sets the priority and is removed from the title; if there are several, the last wins
+func ParseSubject(subject string) (title string, priority int, hasPriority bool) {
+	subject = strings.TrimSpace(subject)
+	for {
+		trimmed := replyPrefix.ReplaceAllString(subject, "")
+		if trimmed == subject {
+			break
+		}
+		subject = trimmed
+	}
+	var words []string
+	for _, word := range strings.Fields(subject) {
+		if match := priorityToken.FindStringSubmatch(word); match != nil {
+			priority, _ = strconv.Atoi(match[1])
+			hasPriority = true
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " "), priority, hasPriority
+}
+
+//ErrBadAddress is returned for addresses that cannot be inbound addresses
+var ErrBadAddress = errors.New("not an inbound address")
+
+//SplitAddress splits an inbound address, token+tag@domain, into its parts.  The token and domain are lower cased, since mail servers may change their case; the tag, which is optional, is not
+func SplitAddress(address string) (token string, tag string, domain string, err error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", "", "", ErrBadAddress
+	}
+	at := strings.LastIndex(parsed.Address, "@")
+	if at < 1 {
+		return "", "", "", ErrBadAddress
+	}
+	local, domain := parsed.Address[:at], strings.ToLower(parsed.Address[at+1:])
+	token = local
+	if plus := strings.IndexByte(local, '+'); plus >= 0 {
+		token, tag = local[:plus], local[plus+1:]
+	}
+	if token == "" || !utf8.ValidString(tag) {
+		return "", "", "", ErrBadAddress
+	}
+	return strings.ToLower(token), tag, domain, nil
+}
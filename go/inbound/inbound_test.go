@@ -0,0 +1,132 @@
+package inbound
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want Message
+	}{
+		{
+			name: "plain text",
+			raw: "From: Ann <ann@example.org>\r\n" +
+				"To: tok@in.example.com, Bob <bob@example.org>\r\n" +
+				"Delivered-To: tok+home@in.example.com\r\n" +
+				"Subject: Buy milk\r\n" +
+				"\r\n" +
+				"Semi-skimmed   \r\n\r\n\r\n\r\nTwo pints\r\n-- \r\nAnn\r\n",
+			want: Message{
+				From:       "ann@example.org",
+				Subject:    "Buy milk",
+				Text:       "Semi-skimmed\n\nTwo pints",
+				Recipients: []string{"tok+home@in.example.com", "tok@in.example.com", "bob@example.org"},
+			},
+		},
+		{
+			name: "encoded subject and quoted-printable Latin-1 body",
+			raw: "From: ann@example.org\r\n" +
+				"Subject: =?UTF-8?B?Q2Fmw6k=?=\r\n" +
+				"Content-Type: text/plain; charset=iso-8859-1\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"Cr=E8me br=FBl=E9e\r\n",
+			want: Message{From: "ann@example.org", Subject: "Café", Text: "Crème brûlée"},
+		},
+		{
+			name: "plain text preferred over HTML",
+			raw: "Subject: Both\r\n" +
+				"Content-Type: multipart/alternative; boundary=b\r\n" +
+				"\r\n" +
+				"--b\r\nContent-Type: text/html\r\n\r\n<p>HTML</p>\r\n" +
+				"--b\r\nContent-Type: text/plain\r\n\r\nPlain\r\n" +
+				"--b--\r\n",
+			want: Message{Subject: "Both", Text: "Plain"},
+		},
+		{
+			name: "HTML only, with attachment",
+			raw: "Subject: HTML\r\n" +
+				"Content-Type: multipart/mixed; boundary=outer\r\n" +
+				"\r\n" +
+				"--outer\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=a.txt\r\n\r\nAttached\r\n" +
+				"--outer\r\nContent-Type: text/html\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+				"PHN0eWxlPnB7fTwvc3R5bGU+PHA+Rmlyc3QgJmFtcDsgZm9yZW1vc3Q8L3A+PHA+U2Vjb25k\r\nPC9wPg==\r\n" +
+				"--outer--\r\n",
+			want: Message{Subject: "HTML", Text: "First & foremost\nSecond"},
+		},
+		{
+			name: "no text",
+			raw: "Subject: Picture\r\n" +
+				"Content-Type: image/png\r\n" +
+				"\r\n" +
+				"PNG\r\n",
+			want: Message{Subject: "Picture"},
+		},
+	}
+	for _, test := range tests {
+		msg, err := Parse(strings.NewReader(test.raw))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*msg, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, *msg, test.want)
+		}
+	}
+
+	for _, raw := range []string{"", "not a header\r\n", "Subject: x\r\nContent-Type: text/plain; charset=koi8-r\r\n\r\nx\r\n"} {
+		if _, err := Parse(strings.NewReader(raw)); err == nil {
+			t.Errorf("%q was parsed", raw)
+		}
+	}
+}
+
+func TestParseSubject(t *testing.T) {
+	tests := []struct {
+		subject     string
+		title       string
+		priority    int
+		hasPriority bool
+	}{
+		{"Buy milk", "Buy milk", 0, false},
+		{"  Re: Fwd: RE[2]: Buy milk ", "Buy milk", 0, false},
+		{"AW: WG: Termin", "Termin", 0, false},
+		{"Taxes !2", "Taxes", 2, true},
+		{"!3 Taxes !1 now", "Taxes now", 1, true},
+		{"Taxes !high", "Taxes !high", 0, false},
+		{"Taxes!2", "Taxes!2", 0, false},
+		{"Regarding taxes", "Regarding taxes", 0, false},
+		{"Re:", "", 0, false},
+	}
+	for _, test := range tests {
+		title, priority, hasPriority := ParseSubject(test.subject)
+		if title != test.title || priority != test.priority || hasPriority != test.hasPriority {
+			t.Errorf("ParseSubject(%q): got %q %d %v, want %q %d %v", test.subject, title, priority, hasPriority, test.title, test.priority, test.hasPriority)
+		}
+	}
+}
+
+func TestSplitAddress(t *testing.T) {
+	tests := []struct {
+		address, token, tag, domain string
+		err                         error
+	}{
+		{"AbC@In.Example.com", "abc", "", "in.example.com", nil},
+		{"Ann <abc+Home@in.example.com>", "abc", "Home", "in.example.com", nil},
+		{"abc+a+b@in.example.com", "abc", "a+b", "in.example.com", nil},
+		{"abc+@in.example.com", "abc", "", "in.example.com", nil},
+		{"+home@in.example.com", "", "", "", ErrBadAddress},
+		{"abc", "", "", "", ErrBadAddress},
+		{"", "", "", "", ErrBadAddress},
+	}
+	for _, test := range tests {
+		token, tag, domain, err := SplitAddress(test.address)
+		if token != test.token || tag != test.tag || domain != test.domain || err != test.err {
+			t.Errorf("SplitAddress(%q): got %q %q %q %v, want %q %q %q %v", test.address, token, tag, domain, err, test.token, test.tag, test.domain, test.err)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package inbound
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/bdlm/log"
+)
+
+//Limits on SMTP sessions
+const (
+	maxRecipients  = 100
+	maxBadCommands = 10
+
+	//maxLineLen is the longest line accepted, counting the CRLF, as RFC 5321 allows
+	maxLineLen = 1000
+)
+
+//errLineTooLong is returned by a lineReader once a line is longer than its limit
+var errLineTooLong = errors.New("line too long")
+
+//lineReader reads from a connection, failing on the first line longer than max, so that a client cannot make the server buffer an endless line.  The start of the long line is read before the failure
+type lineReader struct {
+	net.Conn
+	max  int
+	line int
+	err  error
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.Conn.Read(p)
+	for i := 0; i < n; i++ {
+		r.line++
+		if r.line > r.max {
+			r.err = errLineTooLong
+			return i, r.err
+		}
+		if p[i] == '\n' {
+			r.line = 0
+		}
+	}
+	return n, err
+}
+
+//Handler decides which recipients the server accepts mail for, and takes the mail
+type Handler interface {
+	//Accept checks a recipient before the message is sent.  An error rejects the recipient
+	Accept(rcpt string) error
+
+	//Deliver takes a message for a recipient that was accepted
+	Deliver(from string, rcpt string, msg []byte) error
+}
+
+//SMTPError is an error with the SMTP reply code to send for it.  Other errors returned by a handler are reported as temporary failures, which the sender retries
+type SMTPError struct {
+	Code    int
+	Message string
+}
+
+func (e *SMTPError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+//Server is an SMTP server that passes the messages it receives to a handler.  It speaks enough SMTP to receive mail from a relay or a mail client, without TLS or authentication, so it belongs behind a mail server that provides them
+type Server struct {
+	//Domain is the name the server greets clients with
+	Domain string
+
+	//MaxSize is the largest message accepted, in bytes
+	MaxSize int64
+
+	//Timeout is how long the server waits for each command
+	Timeout time.Duration
+
+	Handler Handler
+}
+
+//ListenAndServe listens on addr and serves connections until listening fails
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+//Serve serves connections from ln until accepting fails
+func (s *Server) Serve(ln net.Listener) error {
+	defer ln.Close()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(c)
+	}
+}
+
+//session is the state of an SMTP conversation
+type session struct {
+	helo    bool
+	mail    bool
+	from    string
+	rcpts   []string
+	badCmds int
+}
+
+//serveConn runs an SMTP conversation
+func (s *Server) serveConn(c net.Conn) {
+	defer c.Close()
+	lines := &lineReader{Conn: c, max: maxLineLen}
+	tp := textproto.NewConn(lines)
+	reply := func(code int, format string, args ...interface{}) {
+		tp.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+	}
+	c.SetDeadline(time.Now().Add(s.Timeout))
+	reply(220, "%s ESMTP shale", s.Domain)
+	var st session
+	for st.badCmds < maxBadCommands {
+		c.SetDeadline(time.Now().Add(s.Timeout))
+		line, err := tp.ReadLine()
+		//ReadLine returns the start of a long line without the error, but it is the last thing read
+		if err == errLineTooLong || err == nil && lines.err != nil && tp.R.Buffered() == 0 {
+			reply(500, "5.5.2 Line too long")
+			return
+		}
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if space := strings.IndexByte(line, ' '); space >= 0 {
+			verb, arg = line[:space], strings.TrimSpace(line[space+1:])
+		}
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			st = session{helo: true}
+			reply(250, "%s", s.Domain)
+		case "EHLO":
+			st = session{helo: true}
+			tp.PrintfLine("250-%s", s.Domain)
+			tp.PrintfLine("250-8BITMIME")
+			tp.PrintfLine("250 SIZE %d", s.MaxSize)
+		case "MAIL":
+			from, ok := pathArg(arg, "FROM:")
+			switch {
+			case !st.helo:
+				st.badCmds++
+				reply(503, "5.5.1 Say hello first")
+			case st.mail:
+				st.badCmds++
+				reply(503, "5.5.1 Sender already given")
+			case !ok:
+				st.badCmds++
+				reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+			default:
+				st.mail, st.from = true, from
+				reply(250, "2.1.0 OK")
+			}
+		case "RCPT":
+			rcpt, ok := pathArg(arg, "TO:")
+			switch {
+			case !st.mail:
+				st.badCmds++
+				reply(503, "5.5.1 Need MAIL first")
+			case !ok || rcpt == "":
+				st.badCmds++
+				reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+			case len(st.rcpts) >= maxRecipients:
+				reply(452, "4.5.3 Too many recipients")
+			default:
+				if err := s.Handler.Accept(rcpt); err != nil {
+					st.badCmds++
+					s.replyError(reply, err, 451, "4.3.0 Try again later")
+					continue
+				}
+				st.rcpts = append(st.rcpts, rcpt)
+				reply(250, "2.1.5 OK")
+			}
+		case "DATA":
+			if len(st.rcpts) == 0 {
+				st.badCmds++
+				reply(503, "5.5.1 Need RCPT first")
+				continue
+			}
+			reply(354, "End data with <CR><LF>.<CR><LF>")
+			data := tp.DotReader()
+			msg, err := ioutil.ReadAll(io.LimitReader(data, s.MaxSize+1))
+			if err == errLineTooLong {
+				reply(500, "5.5.2 Line too long")
+				return
+			}
+			if err != nil {
+				return
+			}
+			if int64(len(msg)) > s.MaxSize {
+				if _, err := io.Copy(ioutil.Discard, data); err == errLineTooLong {
+					reply(500, "5.5.2 Line too long")
+					return
+				}
+				reply(552, "5.3.4 Message too big")
+			} else {
+				s.deliver(reply, st, msg)
+			}
+			st = session{helo: true}
+		case "RSET":
+			st = session{helo: st.helo, badCmds: st.badCmds}
+			reply(250, "2.0.0 OK")
+		case "NOOP":
+			reply(250, "2.0.0 OK")
+		case "VRFY":
+			reply(252, "2.5.0 Cannot verify")
+		case "QUIT":
+			reply(221, "2.0.0 Bye")
+			return
+		default:
+			st.badCmds++
+			reply(502, "5.5.2 Command not recognized")
+		}
+	}
+	reply(421, "4.7.0 Too many errors")
+}
+
+//deliver hands a message to the handler for every recipient.  The message is accepted if any recipient takes it
+func (s *Server) deliver(reply func(int, string, ...interface{}), st session, msg []byte) {
+	var firstErr error
+	delivered := 0
+	for _, rcpt := range st.rcpts {
+		if err := s.Handler.Deliver(st.from, rcpt, msg); err != nil {
+			log.Warnf("Error delivering inbound mail from %q to %q: %v", st.from, rcpt, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		delivered++
+	}
+	if delivered > 0 {
+		reply(250, "2.0.0 OK")
+		return
+	}
+	s.replyError(reply, firstErr, 451, "4.3.0 Try again later")
+}
+
+//replyError replies with the code of an SMTPError, or with the default for other errors
+func (s *Server) replyError(reply func(int, string, ...interface{}), err error, code int, message string) {
+	if smtpErr, ok := err.(*SMTPError); ok {
+		reply(smtpErr.Code, "%s", smtpErr.Message)
+		return
+	}
+	reply(code, "%s", message)
+}
+
+//pathArg reads the address from the argument of MAIL or RCPT, such as "FROM:<a@example.com> SIZE=100".  The null path "<>" gives an empty address
+func pathArg(arg string, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", false
+	}
+	return arg[1:end], true
+}
@@ -0,0 +1,166 @@
+package inbound
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeHandler accepts mail for addresses at example.com and records what it is given
+type fakeHandler struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (h *fakeHandler) Accept(rcpt string) error {
+	if !strings.HasSuffix(rcpt, "@example.com") {
+		return &SMTPError{Code: 550, Message: "5.1.1 No such mailbox"}
+	}
+	return nil
+}
+
+func (h *fakeHandler) Deliver(from string, rcpt string, msg []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.msgs = append(h.msgs, from+" "+rcpt+" "+string(msg))
+	return nil
+}
+
+//dial runs an SMTP session over a pipe, returning the client's end once the greeting is read
+func dial(t *testing.T, server *Server) (*textproto.Conn, chan struct{}) {
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.serveConn(conn)
+		close(done)
+	}()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	tp := textproto.NewConn(client)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+	return tp, done
+}
+
+//command sends a command and checks the reply code
+func command(t *testing.T, tp *textproto.Conn, line string, code int) string {
+	t.Helper()
+	if err := tp.PrintfLine("%s", line); err != nil {
+		t.Fatalf("%s: %v", line, err)
+	}
+	_, message, err := tp.ReadResponse(code)
+	if err != nil {
+		t.Fatalf("%s: %v", line, err)
+	}
+	return message
+}
+
+func newServer(handler Handler) *Server {
+	return &Server{Domain: "mail.example.com", MaxSize: 1 << 10, Timeout: 5 * time.Second, Handler: handler}
+}
+
+func TestSMTPSession(t *testing.T) {
+	handler := &fakeHandler{}
+	tp, done := dial(t, newServer(handler))
+	defer tp.Close()
+	if message := command(t, tp, "EHLO client.example.org", 250); !strings.Contains(message, "SIZE 1024") {
+		t.Errorf("EHLO reply %q does not give the size limit", message)
+	}
+	command(t, tp, "RCPT TO:<tok@example.com>", 503)
+	command(t, tp, "MAIL FROM:<ann@example.org> SIZE=100", 250)
+	command(t, tp, "RCPT TO:<someone@example.net>", 550)
+	command(t, tp, "RCPT TO:<tok+home@example.com>", 250)
+	command(t, tp, "DATA", 354)
+	w := tp.DotWriter()
+	w.Write([]byte("Subject: Buy milk\r\n\r\n.dotted line\r\n"))
+	w.Close()
+	if _, _, err := tp.ReadResponse(250); err != nil {
+		t.Fatal(err)
+	}
+	command(t, tp, "QUIT", 221)
+	<-done
+	want := "ann@example.org tok+home@example.com Subject: Buy milk\n\n.dotted line\n"
+	if len(handler.msgs) != 1 || handler.msgs[0] != want {
+		t.Errorf("delivered %q, want %q", handler.msgs, want)
+	}
+}
+
+func TestSMTPMessageTooBig(t *testing.T) {
+	handler := &fakeHandler{}
+	tp, done := dial(t, newServer(handler))
+	defer tp.Close()
+	command(t, tp, "HELO client.example.org", 250)
+	command(t, tp, "MAIL FROM:<>", 250)
+	command(t, tp, "RCPT TO:<tok@example.com>", 250)
+	command(t, tp, "DATA", 354)
+	w := tp.DotWriter()
+	for i := 0; i < 20; i++ {
+		w.Write([]byte(strings.Repeat("x", 100) + "\r\n"))
+	}
+	w.Close()
+	if _, _, err := tp.ReadResponse(552); err != nil {
+		t.Fatal(err)
+	}
+	command(t, tp, "QUIT", 221)
+	<-done
+	if len(handler.msgs) != 0 {
+		t.Errorf("delivered a message over the size limit")
+	}
+}
+
+func TestSMTPLongLine(t *testing.T) {
+	//998 octets and the CRLF is the longest line allowed
+	tp, done := dial(t, newServer(&fakeHandler{}))
+	command(t, tp, "NOOP "+strings.Repeat("x", 993), 250)
+	command(t, tp, "NOOP "+strings.Repeat("x", 994), 500)
+	<-done
+	tp.Close()
+
+	//A command sent in the same write as a long line is still answered
+	tp, done = dial(t, newServer(&fakeHandler{}))
+	go tp.PrintfLine("NOOP\r\nNOOP %s", strings.Repeat("x", 2000))
+	if _, _, err := tp.ReadResponse(250); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tp.ReadResponse(500); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	tp.Close()
+
+	tp, done = dial(t, newServer(&fakeHandler{}))
+	command(t, tp, "HELO client.example.org", 250)
+	command(t, tp, "MAIL FROM:<ann@example.org>", 250)
+	command(t, tp, "RCPT TO:<tok@example.com>", 250)
+	command(t, tp, "DATA", 354)
+	//The server stops reading partway through the line, so it is sent while the reply is read
+	go tp.PrintfLine("%s", strings.Repeat("x", 5000))
+	if _, _, err := tp.ReadResponse(500); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	tp.Close()
+}
+
+func TestPathArg(t *testing.T) {
+	tests := []struct {
+		arg, prefix, want string
+		ok                bool
+	}{
+		{"FROM:<a@example.com>", "FROM:", "a@example.com", true},
+		{"from: <a@example.com> SIZE=100", "FROM:", "a@example.com", true},
+		{"FROM:<>", "FROM:", "", true},
+		{"FROM:a@example.com", "FROM:", "", false},
+		{"FROM:<a@example.com", "FROM:", "", false},
+		{"TO:<a@example.com>", "FROM:", "", false},
+		{"FR", "FROM:", "", false},
+	}
+	for _, test := range tests {
+		if got, ok := pathArg(test.arg, test.prefix); got != test.want || ok != test.ok {
+			t.Errorf("pathArg(%q): got %q %v, want %q %v", test.arg, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -2,14 +2,23 @@ package main
 
 import (
 	"database/sql"
-	"fmt"
+	"net"
 	"net/http"
+	"net/smtp"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/shale/go/client"
 	"github.com/shale/go/data"
+	"github.com/shale/go/digest"
+	"github.com/shale/go/events"
+	"github.com/shale/go/inbound"
+	"github.com/shale/go/notify"
+	"github.com/shale/go/outbox"
 	"github.com/shale/go/service"
+	"github.com/shale/go/types"
+	"github.com/shale/go/webhook"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -27,9 +36,15 @@ func main() {
 	if port == "" {
 		port = ":8080"
 	}
-	test := false
-	if os.Getenv("TEST") == "true" {
-		test = true
+	limits := service.RateLimits{
+		Read:   envLimit("RATE_LIMIT_READ", "20/s:40"),
+		Write:  envLimit("RATE_LIMIT_WRITE", "5/s:10"),
+		Delete: envLimit("RATE_LIMIT_DELETE", "2/s:5"),
+	}
+	quotas := types.Quota{
+		MaxTodos:     envInt("QUOTA_MAX_TODOS", 1000),
+		MaxBodyBytes: int64(envInt("QUOTA_MAX_BODY_BYTES", 16384)),
+		MaxTags:      envInt("QUOTA_MAX_TAGS", 10),
 	}
 
 	//Set up db connection
@@ -39,23 +54,214 @@ func main() {
 		panic(err.Error())
 	}
 	defer db.Close()
-	dao := &data.StoreType{DAO: db}
-	svc := &service.ServerType{DAO: dao}
-
-	//Run a simple test client
-	go func() {
-		time.Sleep(time.Second * 10)
-		if test {
-			fmt.Printf("Running client\n")
-			client.Run()
+	dao := &data.StoreType{DAO: db, MaxTodos: quotas.MaxTodos, UndoDepth: envInt("UNDO_DEPTH", 20)}
+	switch os.Getenv("EVENT_BROKER") {
+	case "", "memory":
+		dao.Broker = events.NewMemory(envInt("EVENT_BUFFER", 1000))
+	case "mysql":
+		dao.Broker, err = events.NewPoller(dao, envDuration("EVENT_POLL_INTERVAL", time.Second), envInt("EVENT_BUFFER", 1000))
+		if err != nil {
+			log.Fatalf("Error starting event broker: %v", err)
+		}
+	default:
+		log.Fatalf("Bad EVENT_BROKER: %q", os.Getenv("EVENT_BROKER"))
+	}
+	dao.Webhooks = os.Getenv("WEBHOOKS") != "false"
+	dao.Outbox = os.Getenv("OUTBOX") != "false"
+	svc := &service.ServerType{
+		DAO:            dao,
+		Limiter:        service.NewRateLimiter(limits),
+		Quotas:         quotas,
+		IdempotencyTTL: envDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		AdminToken:     os.Getenv("ADMIN_TOKEN"),
+		Events:         dao.Broker,
+		Heartbeat:      envDuration("EVENT_HEARTBEAT", 15*time.Second),
+		SocketOrigins:  envList("WS_ALLOWED_ORIGINS"),
+
+		WebhookAllowPrivate: os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true",
+
+		InboundDomain:   os.Getenv("INBOUND_DOMAIN"),
+		InboundMaxBytes: int64(envInt("INBOUND_MAX_BYTES", 1<<20)),
+	}
+	go svc.PurgeIdempotencyKeys(time.Hour)
+	go svc.PurgeTrash(envDuration("TRASH_RETENTION", 30*24*time.Hour), time.Hour)
+	if dao.Webhooks {
+		dispatcher := webhook.NewDispatcher(dao, webhook.Options{
+			Interval:    envDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+			MaxAttempts: envInt("WEBHOOK_MAX_ATTEMPTS", 8),
+			RetryBase:   envDuration("WEBHOOK_RETRY_BASE", 30*time.Second),
+			RetryMax:    envDuration("WEBHOOK_RETRY_MAX", 6*time.Hour),
+			Timeout:     envDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+			Retention:   envDuration("WEBHOOK_RETENTION", 7*24*time.Hour),
+
+			AllowPrivate: svc.WebhookAllowPrivate,
+		})
+		go dispatcher.Run()
+	}
+	if dao.Outbox {
+		sinks := []outbox.Sink{outbox.Bus{Broker: dao.Broker}}
+		if dao.Webhooks {
+			sinks = append(sinks, outbox.Webhooks{Queue: dao})
+		}
+		if os.Getenv("OUTBOX_STDOUT") == "true" {
+			sinks = append(sinks, outbox.NewNDJSON(os.Stdout))
 		}
-	}()
+		relay := outbox.NewRelay(dao, sinks, outbox.Options{
+			Interval:  envDuration("OUTBOX_POLL_INTERVAL", 250*time.Millisecond),
+			Batch:     envInt("OUTBOX_BATCH", 500),
+			Retention: envDuration("OUTBOX_RETENTION", 24*time.Hour),
+		})
+		go relay.Run()
+	}
+	if os.Getenv("REMINDERS") != "false" {
+		scheduler := notify.NewScheduler(dao, reminderNotifiers(), notify.Options{
+			Interval:    envDuration("REMINDER_POLL_INTERVAL", 30*time.Second),
+			Window:      envDuration("REMINDER_WINDOW", 24*time.Hour),
+			Retry:       envDuration("REMINDER_RETRY", 5*time.Minute),
+			MaxAttempts: envInt("REMINDER_MAX_ATTEMPTS", 5),
+		})
+		go scheduler.Run()
+	}
+	if os.Getenv("DIGESTS") != "false" {
+		scheduler := digest.NewScheduler(dao, mailer(), digest.Options{
+			Interval:    envDuration("DIGEST_POLL_INTERVAL", time.Minute),
+			Retry:       envDuration("DIGEST_RETRY", 5*time.Minute),
+			MaxAttempts: envInt("DIGEST_MAX_ATTEMPTS", 5),
+		})
+		go scheduler.Run()
+	}
+
+	if addr := os.Getenv("INBOUND_SMTP_ADDR"); addr != "" && svc.InboundDomain != "" {
+		server := &inbound.Server{
+			Domain:  svc.InboundDomain,
+			MaxSize: svc.InboundMaxBytes,
+			Timeout: envDuration("INBOUND_SMTP_TIMEOUT", 5*time.Minute),
+			Handler: svc.InboundMail(),
+		}
+		go func() {
+			log.Infof("Starting inbound SMTP on %s", addr)
+			log.Fatal(server.ListenAndServe(addr))
+		}()
+	}
 
 	//Instantiate server and multiplexer, register endpoints, and start listening
 	mux := http.NewServeMux()
-	mux.HandleFunc("/todo/", svc.HandleTodos)
+	mux.Handle("/todo/", svc.RequestID(svc.RateLimit(svc.Idempotent(http.HandlerFunc(svc.HandleTodos)))))
+	mux.Handle("/admin/", svc.RequestID(http.HandlerFunc(svc.HandleAdmin)))
+	mux.Handle("/feed/", svc.RequestID(svc.RateLimit(http.HandlerFunc(svc.HandleFeed))))
+	mux.Handle("/caldav/", svc.RequestID(svc.RateLimit(http.HandlerFunc(svc.HandleCalDAV))))
+	mux.Handle("/inbound", svc.RequestID(svc.RateLimit(http.HandlerFunc(svc.HandleInbound))))
+	mux.HandleFunc("/.well-known/caldav", service.WellKnownCalDAV)
 	log.Infof("Starting API on port %s", port)
 	log.Fatal(http.ListenAndServe(port, mux))
 
 	log.Info("Ending service")
 }
+
+//reminderNotifiers builds the notifiers named in REMINDER_NOTIFIERS, a comma separated list of log, email, and webhook
+func reminderNotifiers() []notify.Notifier {
+	value := os.Getenv("REMINDER_NOTIFIERS")
+	if value == "" {
+		value = "log"
+	}
+	var notifiers []notify.Notifier
+	for _, name := range strings.Split(value, ",") {
+		switch strings.TrimSpace(name) {
+		case "log":
+			notifiers = append(notifiers, notify.Log{})
+		case "email":
+			notifiers = append(notifiers, notify.Email{Mailer: mailer()})
+		case "webhook":
+			url := os.Getenv("REMINDER_WEBHOOK_URL")
+			if url == "" {
+				log.Fatal("REMINDER_WEBHOOK_URL is required by the webhook notifier")
+			}
+			notifiers = append(notifiers, notify.Webhook{
+				URL:    url,
+				Secret: os.Getenv("REMINDER_WEBHOOK_SECRET"),
+				Client: &http.Client{Timeout: envDuration("WEBHOOK_TIMEOUT", 10*time.Second)},
+			})
+		default:
+			log.Fatalf("Bad REMINDER_NOTIFIERS: unknown notifier %q", name)
+		}
+	}
+	return notifiers
+}
+
+//mailer builds the mailer named by MAILER: smtp, the default, sends through the SMTP server at SMTP_ADDR, authenticating with SMTP_USERNAME and SMTP_PASSWORD if they are set, and log writes mail to the log
+func mailer() notify.Mailer {
+	switch os.Getenv("MAILER") {
+	case "", "smtp":
+	case "log":
+		return notify.LogMailer{}
+	default:
+		log.Fatalf("Bad MAILER: unknown mailer %q", os.Getenv("MAILER"))
+	}
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		addr = "localhost:25"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "shale@localhost"
+	}
+	mailer := &notify.SMTPMailer{Addr: addr, From: from}
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			log.Fatalf("Bad SMTP_ADDR: %v", err)
+		}
+		mailer.Auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return mailer
+}
+
+//envLimit reads a rate limit from the environment, falling back to def when unset
+func envLimit(key string, def string) service.Limit {
+	value := os.Getenv(key)
+	if value == "" {
+		value = def
+	}
+	limit, err := service.ParseLimit(value)
+	if err != nil {
+		log.Fatalf("Bad %s: %v", key, err)
+	}
+	return limit
+}
+
+//envInt reads an integer from the environment, falling back to def when unset
+func envInt(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Bad %s: %v", key, err)
+	}
+	return i
+}
+
+//envDuration reads a duration such as "24h" from the environment, falling back to def when unset
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Bad %s: %v", key, err)
+	}
+	return d
+}
+
+//envList reads a comma separated list from the environment
+func envList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,267 @@
+//Package notify sends reminders for todo items through pluggable notifiers, from a scheduler that any number of instances can run
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+	"github.com/shale/go/webhook"
+)
+
+//Notification is a reminder to send, with the settings of the account it is for
+type Notification struct {
+	Settings types.UserSettings
+	Reminder types.Reminder
+}
+
+//Notifier sends reminders somewhere.  A notifier that has nowhere to send a reminder, such as email for an account without an address, does nothing
+type Notifier interface {
+	Name() string
+	Notify(n Notification) error
+}
+
+//Log is a notifier that writes reminders to the log
+type Log struct{}
+
+//Name names the notifier in logs
+func (Log) Name() string {
+	return "log"
+}
+
+//Notify logs the reminder
+func (Log) Notify(n Notification) error {
+	todo := n.Reminder.Todo
+	log.Infof("Reminder for %s: todo item %d %q is due at %s", todo.Name, todo.ID, todo.Title, n.Reminder.FireAt.Format(time.RFC3339))
+	return nil
+}
+
+//Mail is an email with a plain text body and, optionally, an HTML alternative
+type Mail struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+//Mailer sends email
+type Mailer interface {
+	Send(m Mail) error
+}
+
+//LogMailer is a mailer that writes mail to the log instead of sending it, for development
+type LogMailer struct{}
+
+//Send logs the mail
+func (LogMailer) Send(m Mail) error {
+	log.Infof("Mail to %s: %s\n%s", m.To, m.Subject, m.Text)
+	return nil
+}
+
+//SMTPMailer sends email through an SMTP server.  Auth may be nil for servers that do not require it
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+//Send delivers a message through the server
+func (s *SMTPMailer) Send(m Mail) error {
+	msg, err := Message(s.From, m)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.Addr, s.Auth, s.From, []string{m.To}, msg)
+}
+
+//Message formats a mail as an RFC 5322 message
+func Message(from string, m Mail) ([]byte, error) {
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return nil, fmt.Errorf("bad recipient %q: %v", m.To, err)
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("bad sender %q: %v", from, err)
+	}
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := sender.Address[strings.LastIndex(sender.Address, "@")+1:]
+	//Line breaks in the subject would start new headers
+	subject := strings.Join(strings.Fields(m.Subject), " ")
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", sender.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if m.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, m.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	parts := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	//Clients show the last alternative they understand, so HTML goes last
+	for _, part := range []struct{ contentType, body string }{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//writeQuotedPrintable writes a body with CRLF line endings, quoted-printable encoded
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qp, strings.Replace(strings.Replace(body, "\r\n", "\n", -1), "\n", "\r\n", -1)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+//Email is a notifier that emails reminders to the address in the account's settings
+type Email struct {
+	Mailer Mailer
+}
+
+//Name names the notifier in logs
+func (Email) Name() string {
+	return "email"
+}
+
+//Notify emails the reminder, if the account has an address
+func (e Email) Notify(n Notification) error {
+	if n.Settings.Email == "" {
+		return nil
+	}
+	todo := n.Reminder.Todo
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s\n", todo.Title)
+	if todo.Body != "" {
+		fmt.Fprintf(&text, "\n%s\n", todo.Body)
+	}
+	if todo.Due != nil {
+		fmt.Fprintf(&text, "\nDue: %s\n", localTime(*todo.Due, n.Settings.Timezone))
+	}
+	if todo.Category != "" {
+		fmt.Fprintf(&text, "Category: %s\n", todo.Category)
+	}
+	return e.Mailer.Send(Mail{To: n.Settings.Email, Subject: "Reminder: " + todo.Title, Text: text.String()})
+}
+
+//localTime formats a time in a timezone, falling back to UTC for unknown zones
+func localTime(t time.Time, timezone string) string {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format("Mon Jan 2 2006 15:04 MST")
+}
+
+//Webhook is a notifier that posts reminders as JSON to a URL, signed like webhook deliveries
+type Webhook struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+//Name names the notifier in logs
+func (Webhook) Name() string {
+	return "webhook"
+}
+
+//Notify posts the reminder
+func (w Webhook) Notify(n Notification) error {
+	payload, err := json.Marshal(&n.Reminder)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shale-webhook")
+	req.Header.Set(webhook.HeaderEvent, "reminder")
+	req.Header.Set(webhook.HeaderTimestamp, timestamp)
+	req.Header.Set(webhook.HeaderSignature, webhook.Sign(w.Secret, timestamp, payload))
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return nil
+}
+
+//InQuietHours reports whether now falls within the account's quiet hours.  Quiet hours that end earlier in the day than they start run overnight
+func InQuietHours(settings types.UserSettings, now time.Time) bool {
+	start, ok := ParseClock(settings.QuietStart)
+	if !ok {
+		return false
+	}
+	end, ok := ParseClock(settings.QuietEnd)
+	if !ok || start == end {
+		return false
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+//ParseClock reads a time of day written "HH:MM" as minutes after midnight
+func ParseClock(value string) (int, bool) {
+	t, err := time.Parse("15:04", value)
+	if err != nil || len(value) != 5 {
+		return 0, false
+	}
+	return t.Hour()*60 + t.Minute(), true
+}
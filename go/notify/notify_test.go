@@ -0,0 +1,208 @@
+package notify
+
+import (
+	"bufio"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour int, minute int) time.Time {
+		return time.Date(2020, 5, 1, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		start, end, timezone string
+		now                  time.Time
+		want                 bool
+	}{
+		{"22:00", "07:00", "UTC", at(23, 0), true},
+		{"22:00", "07:00", "UTC", at(3, 0), true},
+		{"22:00", "07:00", "UTC", at(7, 0), false},
+		{"22:00", "07:00", "UTC", at(21, 59), false},
+		{"22:00", "07:00", "UTC", at(22, 0), true},
+		{"12:00", "13:30", "UTC", at(13, 29), true},
+		{"12:00", "13:30", "UTC", at(13, 30), false},
+		{"12:00", "13:30", "UTC", at(11, 0), false},
+		//04:00 UTC is 23:00 the day before in Chicago
+		{"22:00", "07:00", "America/Chicago", at(4, 0), true},
+		{"22:00", "07:00", "America/Chicago", at(14, 0), false},
+		{"22:00", "07:00", "Not/AZone", at(23, 0), true},
+		{"", "07:00", "UTC", at(3, 0), false},
+		{"22:00", "", "UTC", at(23, 0), false},
+		{"09:00", "09:00", "UTC", at(9, 0), false},
+		{"9:00", "10:00", "UTC", at(9, 30), false},
+	}
+	for _, test := range tests {
+		settings := types.UserSettings{QuietStart: test.start, QuietEnd: test.end, Timezone: test.timezone}
+		if got := InQuietHours(settings, test.now); got != test.want {
+			t.Errorf("%s-%s %s at %s: got %v, want %v", test.start, test.end, test.timezone, test.now.Format("15:04"), got, test.want)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	msg, err := Message("Shale <shale@example.com>", Mail{To: "tom@example.com", Subject: "Reminder:\r\nBcc: eve@example.com", Text: "Taxes\nDue: tomorrow", HTML: "<p>Taxes</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("Bcc") != "" || parsed.Header.Get("Subject") != "Reminder: Bcc: eve@example.com" {
+		t.Errorf("subject broke into headers: %v", parsed.Header)
+	}
+	if !strings.HasSuffix(parsed.Header.Get("Message-Id"), "@example.com>") {
+		t.Errorf("Message-ID %q is not on the sender's domain", parsed.Header.Get("Message-Id"))
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type %q", parsed.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	var contentTypes []string
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			break
+		}
+		contentTypes = append(contentTypes, part.Header.Get("Content-Type"))
+	}
+	if strings.Join(contentTypes, ", ") != "text/plain; charset=utf-8, text/html; charset=utf-8" {
+		t.Errorf("parts %v, want plain text then HTML", contentTypes)
+	}
+
+	for _, m := range []Mail{{To: "not an address"}, {To: ""}} {
+		if _, err := Message("shale@example.com", m); err == nil {
+			t.Errorf("recipient %q was accepted", m.To)
+		}
+	}
+	if _, err := Message("shale", Mail{To: "tom@example.com"}); err == nil {
+		t.Errorf("bad sender was accepted")
+	}
+}
+
+//smtpServer is a fake SMTP server that accepts a single message
+type smtpServer struct {
+	ln       net.Listener
+	from, to string
+	data     chan string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{ln: ln, data: make(chan string, 1)}
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	in := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 fake ESMTP")
+	for {
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 fake")
+		case "MAIL":
+			s.from = line
+			reply("250 OK")
+		case "RCPT":
+			s.to = line
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				line, err := in.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.data <- data.String()
+			reply("250 Queued")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTPMailer(t *testing.T) {
+	server := newSMTPServer(t)
+	defer server.ln.Close()
+	mailer := &SMTPMailer{Addr: server.ln.Addr().String(), From: "shale@example.com"}
+	text := "Taxes\n.\nA line of more than seventy-six characters, which quoted-printable has to wrap in two."
+	if err := mailer.Send(Mail{To: "tom@example.com", Subject: "Reminder: Taxes", Text: text}); err != nil {
+		t.Fatal(err)
+	}
+	var data string
+	select {
+	case data = <-server.data:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	if server.from != "MAIL FROM:<shale@example.com>" || !strings.HasPrefix(server.to, "RCPT TO:<tom@example.com>") {
+		t.Errorf("envelope %q %q", server.from, server.to)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("To") != "<tom@example.com>" || parsed.Header.Get("Subject") != "Reminder: Taxes" {
+		t.Errorf("headers %v", parsed.Header)
+	}
+	body, err := ioutil.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//The client ends the data with a line break of its own
+	if want := strings.Replace(text, "\n", "\r\n", -1) + "\r\n"; string(body) != want {
+		t.Errorf("body %q, want %q", body, want)
+	}
+}
+
+func TestSMTPMailerRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	mailer := &SMTPMailer{Addr: addr, From: "shale@example.com"}
+	if err := mailer.Send(Mail{To: "tom@example.com", Subject: "x", Text: "x"}); err == nil {
+		t.Errorf("sent with no server listening")
+	}
+}
@@ -0,0 +1,152 @@
+package notify
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+)
+
+//Reminder statuses, as recorded by the store once a reminder has been claimed
+const (
+	Sending   = "sending"
+	Sent      = "sent"
+	Failed    = "failed"
+	Dead      = "dead"
+	Dismissed = "dismissed"
+)
+
+//Store holds the todo items with reminders and the record of the reminders sent
+type Store interface {
+	//SelectDueReminders returns up to limit reminders due between now-window and now that have not been sent, dismissed, or given up on, and were not claimed less than retry ago.  They are ordered by fire time and todo item ID, starting after the given reminder, or from the first if it is zero
+	SelectDueReminders(now time.Time, window time.Duration, retry time.Duration, after types.Reminder, limit int) ([]types.Reminder, error)
+
+	//ClaimReminder records that a reminder is being sent, unless another instance has sent it, is sending it, or failed to send it less than retry ago.  It returns the number of attempts including this one
+	ClaimReminder(reminder types.Reminder, now time.Time, retry time.Duration) (bool, int, error)
+
+	//FinishReminder records the outcome of sending a reminder
+	FinishReminder(reminder types.Reminder, status string, lastError string) error
+
+	SelectSettings(name string) (types.UserSettings, error)
+}
+
+//Options configure a scheduler.  Zero values select the defaults
+type Options struct {
+	//Interval is how often the store is polled for due reminders
+	Interval time.Duration
+
+	//Window is how late a reminder may be sent.  Reminders that came due longer ago, while the scheduler was not running, are skipped.  It must be longer than any quiet hours
+	Window time.Duration
+
+	//Retry is how long to wait before retrying a reminder that failed to send, and MaxAttempts how many times to try
+	Retry       time.Duration
+	MaxAttempts int
+}
+
+//maxErrorLen caps the length of a reminder's last error
+const maxErrorLen = 1024
+
+//dueBatch is the most reminders read in a single poll
+const dueBatch = 200
+
+//Scheduler sends due reminders through every notifier.  A reminder is claimed in the store before it is sent, so that it is sent once however many instances run a scheduler, and not again after a restart
+type Scheduler struct {
+	store     Store
+	notifiers []Notifier
+	opts      Options
+}
+
+//NewScheduler creates a scheduler for the reminders in store
+func NewScheduler(store Store, notifiers []Notifier, opts Options) *Scheduler {
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.Window <= 0 {
+		opts.Window = 24 * time.Hour
+	}
+	if opts.Retry <= 0 {
+		opts.Retry = 5 * time.Minute
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	return &Scheduler{store: store, notifiers: notifiers, opts: opts}
+}
+
+//Run sends due reminders every interval, until the process exits
+func (s *Scheduler) Run() {
+	for range time.Tick(s.opts.Interval) {
+		s.Poll(time.Now())
+	}
+}
+
+//Poll sends every reminder due at now, except those of accounts in their quiet hours, which wait until the quiet hours end.  It reads the due reminders a batch at a time, so however many are held back the rest are still sent
+func (s *Scheduler) Poll(now time.Time) {
+	settings := make(map[string]types.UserSettings)
+	var after types.Reminder
+	for {
+		reminders, err := s.store.SelectDueReminders(now, s.opts.Window, s.opts.Retry, after, dueBatch)
+		if err != nil {
+			log.Warnf("Error selecting due reminders: %v", err)
+			return
+		}
+		s.sendBatch(reminders, settings, now)
+		if len(reminders) < dueBatch {
+			return
+		}
+		after = reminders[len(reminders)-1]
+	}
+}
+
+//sendBatch claims and sends a batch of due reminders, looking up the settings of each account once per poll
+func (s *Scheduler) sendBatch(reminders []types.Reminder, settings map[string]types.UserSettings, now time.Time) {
+	for _, reminder := range reminders {
+		name := reminder.Todo.Name
+		account, ok := settings[name]
+		if !ok {
+			var err error
+			account, err = s.store.SelectSettings(name)
+			if err != nil {
+				log.Warnf("Error selecting settings of %s: %v", name, err)
+				continue
+			}
+			settings[name] = account
+		}
+		if InQuietHours(account, now) {
+			continue
+		}
+		claimed, attempts, err := s.store.ClaimReminder(reminder, now, s.opts.Retry)
+		if err != nil {
+			log.Warnf("Error claiming reminder for todo item %d: %v", reminder.Todo.ID, err)
+			continue
+		}
+		if claimed {
+			s.send(Notification{Settings: account, Reminder: reminder}, attempts)
+		}
+	}
+}
+
+//send notifies every notifier of a claimed reminder and records the outcome.  If any notifier fails the reminder is retried, through every notifier
+func (s *Scheduler) send(n Notification, attempts int) {
+	var failures []string
+	for _, notifier := range s.notifiers {
+		if err := notifier.Notify(n); err != nil {
+			failures = append(failures, notifier.Name()+": "+err.Error())
+		}
+	}
+	status, lastError := Sent, ""
+	if len(failures) > 0 {
+		status, lastError = Failed, strings.Join(failures, "; ")
+		if attempts >= s.opts.MaxAttempts {
+			status = Dead
+		}
+		if len(lastError) > maxErrorLen {
+			lastError = strings.ToValidUTF8(lastError[:maxErrorLen], "")
+		}
+		log.Warnf("Error sending reminder for todo item %d, attempt %d: %s", n.Reminder.Todo.ID, attempts, lastError)
+	}
+	if err := s.store.FinishReminder(n.Reminder, status, lastError); err != nil {
+		log.Errorf("Error recording reminder for todo item %d: %v", n.Reminder.Todo.ID, err)
+	}
+}
@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//record is the state of a claimed reminder
+type record struct {
+	status    string
+	attempts  int
+	claimedAt time.Time
+}
+
+//fakeStore holds reminders in memory, selecting them as the SQL store does
+type fakeStore struct {
+	reminders []types.Reminder
+	records   map[int]*record
+	settings  map[string]types.UserSettings
+	selects   int
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{records: make(map[int]*record), settings: make(map[string]types.UserSettings)}
+}
+
+func (s *fakeStore) add(id int, name string, fireAt time.Time) {
+	s.reminders = append(s.reminders, types.Reminder{Todo: types.TodoData{ID: id, Name: name, Title: fmt.Sprintf("Item %d", id)}, FireAt: fireAt})
+}
+
+func (s *fakeStore) SelectDueReminders(now time.Time, window time.Duration, retry time.Duration, after types.Reminder, limit int) ([]types.Reminder, error) {
+	s.selects++
+	sort.Slice(s.reminders, func(i, j int) bool {
+		a, b := s.reminders[i], s.reminders[j]
+		return a.FireAt.Before(b.FireAt) || a.FireAt.Equal(b.FireAt) && a.Todo.ID < b.Todo.ID
+	})
+	var due []types.Reminder
+	for _, reminder := range s.reminders {
+		if reminder.FireAt.After(now) || !reminder.FireAt.After(now.Add(-window)) {
+			continue
+		}
+		if r := s.records[reminder.Todo.ID]; r != nil && (r.status == Sent || r.status == Dismissed || r.status == Dead || r.claimedAt.After(now.Add(-retry))) {
+			continue
+		}
+		if !after.FireAt.IsZero() && (reminder.FireAt.Before(after.FireAt) || reminder.FireAt.Equal(after.FireAt) && reminder.Todo.ID <= after.Todo.ID) {
+			continue
+		}
+		if len(due) < limit {
+			due = append(due, reminder)
+		}
+	}
+	return due, nil
+}
+
+func (s *fakeStore) ClaimReminder(reminder types.Reminder, now time.Time, retry time.Duration) (bool, int, error) {
+	r := s.records[reminder.Todo.ID]
+	if r == nil {
+		r = &record{}
+		s.records[reminder.Todo.ID] = r
+	} else if (r.status != Sending && r.status != Failed) || r.claimedAt.After(now.Add(-retry)) {
+		return false, 0, nil
+	}
+	r.status, r.claimedAt = Sending, now
+	r.attempts++
+	return true, r.attempts, nil
+}
+
+func (s *fakeStore) FinishReminder(reminder types.Reminder, status string, lastError string) error {
+	s.records[reminder.Todo.ID].status = status
+	return nil
+}
+
+func (s *fakeStore) SelectSettings(name string) (types.UserSettings, error) {
+	return s.settings[name], nil
+}
+
+//fakeNotifier records the todo items it is notified of, failing while fail is set
+type fakeNotifier struct {
+	fail bool
+	got  []int
+}
+
+func (n *fakeNotifier) Name() string {
+	return "fake"
+}
+
+func (n *fakeNotifier) Notify(notification Notification) error {
+	if n.fail {
+		return errors.New("unavailable")
+	}
+	n.got = append(n.got, notification.Reminder.Todo.ID)
+	return nil
+}
+
+func TestSchedulerPagesPastQuietAccounts(t *testing.T) {
+	now := time.Date(2020, 5, 1, 3, 0, 0, 0, time.UTC)
+	store := newFakeStore()
+	store.settings["ann"] = types.UserSettings{Timezone: "UTC", QuietStart: "22:00", QuietEnd: "07:00"}
+	//More of ann's held back reminders than fit in a batch, all due before tom's
+	for i := 1; i <= dueBatch+50; i++ {
+		store.add(i, "ann", now.Add(-time.Hour))
+	}
+	store.add(1000, "tom", now.Add(-time.Minute))
+	notifier := &fakeNotifier{}
+	NewScheduler(store, []Notifier{notifier}, Options{}).Poll(now)
+	if len(notifier.got) != 1 || notifier.got[0] != 1000 {
+		t.Errorf("sent %v, want only tom's reminder", notifier.got)
+	}
+	if store.selects != 2 {
+		t.Errorf("read %d batches, want 2", store.selects)
+	}
+}
+
+func TestSchedulerRetries(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := newFakeStore()
+	store.add(1, "tom", now.Add(-time.Minute))
+	store.add(2, "tom", now.Add(-48*time.Hour))
+	store.add(3, "tom", now.Add(time.Hour))
+	notifier := &fakeNotifier{fail: true}
+	s := NewScheduler(store, []Notifier{notifier}, Options{Retry: 5 * time.Minute, MaxAttempts: 3})
+
+	s.Poll(now)
+	if r := store.records[1]; r == nil || r.status != Failed || r.attempts != 1 {
+		t.Fatalf("after a failure the reminder is %+v", r)
+	}
+	if store.records[2] != nil || store.records[3] != nil {
+		t.Errorf("claimed reminders outside the window")
+	}
+	s.Poll(now.Add(time.Minute))
+	if store.records[1].attempts != 1 {
+		t.Errorf("retried before the retry delay")
+	}
+	notifier.fail = false
+	s.Poll(now.Add(6 * time.Minute))
+	if r := store.records[1]; r.status != Sent || r.attempts != 2 {
+		t.Errorf("after the retry the reminder is %+v", r)
+	}
+	s.Poll(now.Add(20 * time.Minute))
+	if len(notifier.got) != 1 {
+		t.Errorf("sent %v, want the reminder once", notifier.got)
+	}
+}
+
+func TestSchedulerGivesUp(t *testing.T) {
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := newFakeStore()
+	store.add(1, "tom", now)
+	s := NewScheduler(store, []Notifier{&fakeNotifier{fail: true}}, Options{Retry: time.Minute, MaxAttempts: 2})
+	for i := 0; i < 4; i++ {
+		s.Poll(now.Add(time.Duration(i) * 2 * time.Minute))
+	}
+	if r := store.records[1]; r.status != Dead || r.attempts != 2 {
+		t.Errorf("reminder is %+v, want dead after 2 attempts", r)
+	}
+}
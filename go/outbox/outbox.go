@@ -0,0 +1,262 @@
+//Package outbox relays the events written to the outbox table, in the same transaction as the changes they describe, to the sinks that publish them
+package outbox
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/events"
+	"github.com/shale/go/types"
+)
+
+//relayLock is the name of the lock held by the instance relaying the outbox
+const relayLock = "shale_outbox_relay"
+
+//Relay metrics, published by expvar under "outbox"
+var (
+	metrics        = expvar.NewMap("outbox")
+	metricPending  = new(expvar.Int)
+	metricLag      = new(expvar.Float)
+	metricRelayed  = new(expvar.Int)
+	metricErrors   = new(expvar.Int)
+	metricLastPoll = new(expvar.String)
+)
+
+func init() {
+	metrics.Set("pending", metricPending)
+	metrics.Set("lag_seconds", metricLag)
+	metrics.Set("relayed", metricRelayed)
+	metrics.Set("errors", metricErrors)
+	metrics.Set("last_poll", metricLastPoll)
+}
+
+//Store holds the outbox
+type Store interface {
+	//SelectOutbox returns up to limit undelivered events, oldest first.  Their IDs are outbox IDs
+	SelectOutbox(limit int) ([]types.Event, error)
+
+	//MarkDelivered records that events have been published to every sink
+	MarkDelivered(ids []int64) error
+
+	//OutboxLag returns the number of undelivered events and the time the oldest of them was written, which is zero when there are none
+	OutboxLag() (int64, time.Time, error)
+
+	//TrimOutbox removes delivered events written before the given time
+	TrimOutbox(before time.Time) error
+
+	//TryLock takes a lock shared by every instance without waiting, returning false if another instance holds it
+	TryLock(name string) (release func(), ok bool, err error)
+}
+
+//Sink publishes events somewhere.  Send must either publish every event or return an error, after which the events are sent again
+type Sink interface {
+	Name() string
+	Send(evts []types.Event) error
+}
+
+//Options configure a relay.  Zero values select the defaults
+type Options struct {
+	//Interval is how often the outbox is polled
+	Interval time.Duration
+
+	//Batch is the most events read from the outbox at once
+	Batch int
+
+	//Retention is how long delivered events are kept
+	Retention time.Duration
+}
+
+//Relay publishes the events in the outbox to its sinks in order, and marks them delivered once every sink has them.  Events are delivered at least once: after a failure or crash, a sink may be sent an event again.  Only one instance relays at a time
+type Relay struct {
+	store Store
+	sinks []Sink
+	opts  Options
+
+	//sent holds the undelivered events each sink has been sent, so that a failing sink does not make the others see events twice.  It is a set rather than the last ID sent because IDs are taken before their transaction commits, so an event may appear after a later one
+	mu   sync.Mutex
+	sent map[string]map[int64]bool
+}
+
+//NewRelay creates a relay from the outbox in store to sinks
+func NewRelay(store Store, sinks []Sink, opts Options) *Relay {
+	if opts.Interval <= 0 {
+		opts.Interval = 250 * time.Millisecond
+	}
+	if opts.Batch <= 0 {
+		opts.Batch = 500
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	return &Relay{store: store, sinks: sinks, opts: opts, sent: make(map[string]map[int64]bool)}
+}
+
+//Run polls the outbox every interval, until the process exits
+func (r *Relay) Run() {
+	polls := 0
+	for range time.Tick(r.opts.Interval) {
+		r.Poll()
+		polls++
+		if polls%1000 == 0 {
+			if err := r.store.TrimOutbox(time.Now().Add(-r.opts.Retention)); err != nil {
+				log.Warnf("Error trimming outbox: %v", err)
+			}
+		}
+	}
+}
+
+//Poll relays every undelivered event, unless another instance is relaying, and updates the metrics
+func (r *Relay) Poll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	release, ok, err := r.store.TryLock(relayLock)
+	if err != nil {
+		metricErrors.Add(1)
+		log.Warnf("Error taking outbox relay lock: %v", err)
+		return
+	}
+	if ok {
+		r.relay()
+		release()
+	}
+	r.measure()
+}
+
+//relay sends undelivered events to the sinks until the outbox is empty or a sink fails
+func (r *Relay) relay() {
+	for {
+		evts, err := r.store.SelectOutbox(r.opts.Batch)
+		if err != nil {
+			metricErrors.Add(1)
+			log.Warnf("Error reading outbox: %v", err)
+			return
+		}
+		if len(evts) == 0 {
+			return
+		}
+		for _, sink := range r.sinks {
+			sent := r.sent[sink.Name()]
+			var unsent []types.Event
+			for _, event := range evts {
+				if !sent[event.ID] {
+					unsent = append(unsent, event)
+				}
+			}
+			if len(unsent) == 0 {
+				continue
+			}
+			if err := sink.Send(unsent); err != nil {
+				metricErrors.Add(1)
+				log.Warnf("Error sending events to %s: %v", sink.Name(), err)
+				return
+			}
+			if sent == nil {
+				sent = make(map[int64]bool)
+				r.sent[sink.Name()] = sent
+			}
+			for _, event := range unsent {
+				sent[event.ID] = true
+			}
+		}
+		ids := make([]int64, len(evts))
+		for i, event := range evts {
+			ids[i] = event.ID
+		}
+		if err := r.store.MarkDelivered(ids); err != nil {
+			metricErrors.Add(1)
+			log.Warnf("Error marking outbox events delivered: %v", err)
+			return
+		}
+		r.sent = make(map[string]map[int64]bool)
+		metricRelayed.Add(int64(len(evts)))
+		if len(evts) < r.opts.Batch {
+			return
+		}
+	}
+}
+
+//measure updates the pending and lag metrics
+func (r *Relay) measure() {
+	now := time.Now()
+	metricLastPoll.Set(now.UTC().Format(time.RFC3339Nano))
+	pending, oldest, err := r.store.OutboxLag()
+	if err != nil {
+		metricErrors.Add(1)
+		log.Warnf("Error measuring outbox lag: %v", err)
+		return
+	}
+	metricPending.Set(pending)
+	lag := 0.0
+	if pending > 0 {
+		lag = now.Sub(oldest).Seconds()
+	}
+	metricLag.Set(lag)
+}
+
+//Bus is a sink that publishes events to an event broker, such as the one event streams subscribe to
+type Bus struct {
+	Broker events.Broker
+}
+
+//Name names the sink in logs
+func (Bus) Name() string {
+	return "bus"
+}
+
+//Send publishes events to the broker
+func (b Bus) Send(evts []types.Event) error {
+	return b.Broker.Publish(evts)
+}
+
+//DeliveryQueue queues the deliveries of events to webhooks
+type DeliveryQueue interface {
+	EnqueueDeliveries(evts []types.Event) error
+}
+
+//Webhooks is a sink that queues the delivery of events to the webhooks subscribed to them
+type Webhooks struct {
+	Queue DeliveryQueue
+}
+
+//Name names the sink in logs
+func (Webhooks) Name() string {
+	return "webhooks"
+}
+
+//Send queues deliveries of events to webhooks
+func (w Webhooks) Send(evts []types.Event) error {
+	return w.Queue.EnqueueDeliveries(evts)
+}
+
+//NDJSON is a sink that writes each event to a stream as a line of JSON
+type NDJSON struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+//NewNDJSON creates a sink writing to out, such as os.Stdout
+func NewNDJSON(out io.Writer) *NDJSON {
+	return &NDJSON{out: out}
+}
+
+//Name names the sink in logs
+func (*NDJSON) Name() string {
+	return "ndjson"
+}
+
+//Send writes events as JSON lines
+func (n *NDJSON) Send(evts []types.Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	encoder := json.NewEncoder(n.out)
+	for i := range evts {
+		if err := encoder.Encode(&evts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package outbox
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//fakeStore is an outbox in memory.  Events in uncommitted are not visible yet
+type fakeStore struct {
+	events      []types.Event
+	delivered   map[int64]bool
+	uncommitted map[int64]bool
+	locked      bool
+	selects     int
+}
+
+func newFakeStore(n int) *fakeStore {
+	store := &fakeStore{delivered: make(map[int64]bool), uncommitted: make(map[int64]bool)}
+	for i := 1; i <= n; i++ {
+		store.events = append(store.events, types.Event{ID: int64(i), Name: "tom", Type: "created", Time: time.Now()})
+	}
+	return store
+}
+
+func (s *fakeStore) SelectOutbox(limit int) ([]types.Event, error) {
+	s.selects++
+	var evts []types.Event
+	for _, event := range s.events {
+		if !s.delivered[event.ID] && !s.uncommitted[event.ID] && len(evts) < limit {
+			evts = append(evts, event)
+		}
+	}
+	return evts, nil
+}
+
+func (s *fakeStore) MarkDelivered(ids []int64) error {
+	for _, id := range ids {
+		s.delivered[id] = true
+	}
+	return nil
+}
+
+func (s *fakeStore) OutboxLag() (int64, time.Time, error) {
+	var pending int64
+	var oldest time.Time
+	for _, event := range s.events {
+		if !s.delivered[event.ID] {
+			if pending == 0 {
+				oldest = event.Time
+			}
+			pending++
+		}
+	}
+	return pending, oldest, nil
+}
+
+func (s *fakeStore) TrimOutbox(before time.Time) error {
+	return nil
+}
+
+func (s *fakeStore) TryLock(name string) (func(), bool, error) {
+	if s.locked {
+		return nil, false, nil
+	}
+	s.locked = true
+	return func() { s.locked = false }, true, nil
+}
+
+//fakeSink records the events it is sent, failing the given number of sends first
+type fakeSink struct {
+	name  string
+	fails int
+	got   []int64
+}
+
+func (s *fakeSink) Name() string {
+	return s.name
+}
+
+func (s *fakeSink) Send(evts []types.Event) error {
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("sink unavailable")
+	}
+	for _, event := range evts {
+		s.got = append(s.got, event.ID)
+	}
+	return nil
+}
+
+//inOrder reports whether ids are exactly 1 to n
+func inOrder(ids []int64, n int) bool {
+	if len(ids) != n {
+		return false
+	}
+	for i, id := range ids {
+		if id != int64(i+1) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRelayDeliversInOrder(t *testing.T) {
+	store := newFakeStore(7)
+	bus, hooks := &fakeSink{name: "bus"}, &fakeSink{name: "webhooks"}
+	NewRelay(store, []Sink{bus, hooks}, Options{Batch: 3}).Poll()
+	if !inOrder(bus.got, 7) || !inOrder(hooks.got, 7) {
+		t.Errorf("sinks got %v and %v, want events 1 to 7 in order", bus.got, hooks.got)
+	}
+	if len(store.delivered) != 7 {
+		t.Errorf("%d events marked delivered, want 7", len(store.delivered))
+	}
+	if store.locked {
+		t.Errorf("relay lock was not released")
+	}
+	if metricPending.Value() != 0 || metricLag.Value() != 0 {
+		t.Errorf("metrics show %d pending with %vs lag, want none", metricPending.Value(), metricLag.Value())
+	}
+}
+
+func TestRelayRetriesOnlyTheFailingSink(t *testing.T) {
+	store := newFakeStore(4)
+	bus, hooks := &fakeSink{name: "bus"}, &fakeSink{name: "webhooks", fails: 1}
+	relay := NewRelay(store, []Sink{bus, hooks}, Options{})
+	relay.Poll()
+	if len(store.delivered) != 0 {
+		t.Fatalf("events were marked delivered although a sink failed")
+	}
+	if metricPending.Value() != 4 {
+		t.Errorf("metrics show %d pending, want 4", metricPending.Value())
+	}
+	relay.Poll()
+	if !inOrder(bus.got, 4) {
+		t.Errorf("bus got %v, want each event once", bus.got)
+	}
+	if !inOrder(hooks.got, 4) {
+		t.Errorf("webhooks got %v, want every event after the retry", hooks.got)
+	}
+	if len(store.delivered) != 4 {
+		t.Errorf("%d events marked delivered, want 4", len(store.delivered))
+	}
+}
+
+func TestRelayLateCommit(t *testing.T) {
+	store := newFakeStore(3)
+	store.uncommitted[2] = true
+	bus := &fakeSink{name: "bus"}
+	relay := NewRelay(store, []Sink{bus}, Options{})
+	relay.Poll()
+	if store.delivered[2] {
+		t.Fatalf("event 2 was marked delivered before it was committed")
+	}
+	delete(store.uncommitted, 2)
+	relay.Poll()
+	if want := []int64{1, 3, 2}; !reflect.DeepEqual(bus.got, want) {
+		t.Errorf("bus got %v, want %v", bus.got, want)
+	}
+	if len(store.delivered) != 3 {
+		t.Errorf("%d events marked delivered, want 3", len(store.delivered))
+	}
+}
+
+func TestRelayLateCommitAfterFailure(t *testing.T) {
+	store := newFakeStore(3)
+	store.uncommitted[2] = true
+	bus, hooks := &fakeSink{name: "bus"}, &fakeSink{name: "webhooks", fails: 1}
+	relay := NewRelay(store, []Sink{bus, hooks}, Options{})
+	relay.Poll()
+	//Event 2 commits while the webhooks sink is failing, after the bus was sent event 3
+	delete(store.uncommitted, 2)
+	relay.Poll()
+	if want := []int64{1, 3, 2}; !reflect.DeepEqual(bus.got, want) {
+		t.Errorf("bus got %v, want %v", bus.got, want)
+	}
+	if !inOrder(hooks.got, 3) {
+		t.Errorf("webhooks got %v, want events 1 to 3", hooks.got)
+	}
+	if len(store.delivered) != 3 {
+		t.Errorf("%d events marked delivered, want 3", len(store.delivered))
+	}
+}
+
+func TestRelayWaitsForTheLock(t *testing.T) {
+	store := newFakeStore(2)
+	store.locked = true
+	bus := &fakeSink{name: "bus"}
+	NewRelay(store, []Sink{bus}, Options{}).Poll()
+	if len(bus.got) != 0 || store.selects != 0 {
+		t.Errorf("relayed %v while another instance held the lock", bus.got)
+	}
+	if metricPending.Value() != 2 {
+		t.Errorf("metrics show %d pending, want 2", metricPending.Value())
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewNDJSON(&out)
+	if err := sink.Send(newFakeStore(2).events); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], `{"id":1,`) || !strings.HasPrefix(lines[1], `{"id":2,`) {
+		t.Errorf("wrote %q, want one JSON object per line", out.String())
+	}
+}
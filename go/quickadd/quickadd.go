@@ -0,0 +1,554 @@
+//Package quickadd parses a single line of text, such as "Buy milk tomorrow 5pm #shopping !2 every week", into the fields of a todo item.  Dates and times are read relative to a moment in the user's timezone
+package quickadd
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//ErrNoTitle is returned for a line that has nothing left for the title once the other fields are taken out of it
+var ErrNoTitle = errors.New("nothing is left for the title")
+
+//Times of day used when a line does not give one
+const (
+	//endHour and endMinute are when an item given only a date is due
+	endHour   = 23
+	endMinute = 59
+
+	//tonightHour is when an item due tonight is due
+	tonightHour = 20
+)
+
+//maxCount is the largest number accepted in phrases such as "in 3 days" and "every 2 weeks"
+const maxCount = 999
+
+//token is a word of the line.  Quoted text is a single literal token, which is never parsed
+type token struct {
+	text    string
+	word    string
+	literal bool
+	used    bool
+}
+
+//Kinds of phrase a match may be
+const (
+	dateKind = iota
+	timeKind
+	ruleKind
+)
+
+//match is a phrase of the line that gives a date, a time of day, or a recurrence
+type match struct {
+	kind       int
+	start, end int
+
+	//day is the date of a dateKind match, at midnight.  An exact match, such as "in 2 hours", gives the due time itself instead
+	day   time.Time
+	exact bool
+
+	//hour and minute are the time of a timeKind match, or the time a date such as "tonight" implies when hasTime is set
+	hour, minute int
+	hasTime      bool
+
+	rule Recurrence
+
+	//short is set for a date given only by a short weekday, such as "sun", which is also an ordinary word
+	short bool
+}
+
+var (
+	priorityToken = regexp.MustCompile(`^!(\d{1,9})$`)
+	hashtag       = regexp.MustCompile(`^#([^#,]+)$`)
+	clock12       = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm|a\.m\.?|p\.m\.?)?$`)
+	clock24       = regexp.MustCompile(`^(\d{1,2}):(\d{2})$`)
+	isoDate       = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	dayOfMonth    = regexp.MustCompile(`^(\d{1,2})(st|nd|rd|th)?$`)
+	yearNumber    = regexp.MustCompile(`^\d{4}$`)
+)
+
+//connectors are the words dropped from the title when they introduce the due date or time, as in "at 5pm"
+var connectors = map[string]bool{"at": true, "on": true, "by": true, "due": true}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+var months = map[string]time.Month{
+	"january": time.January, "jan": time.January,
+	"february": time.February, "feb": time.February,
+	"march": time.March, "mar": time.March,
+	"april": time.April, "apr": time.April,
+	"may":  time.May,
+	"june": time.June, "jun": time.June,
+	"july": time.July, "jul": time.July,
+	"august": time.August, "aug": time.August,
+	"september": time.September, "sep": time.September, "sept": time.September,
+	"october": time.October, "oct": time.October,
+	"november": time.November, "nov": time.November,
+	"december": time.December, "dec": time.December,
+}
+
+//units maps the units of "in 3 days" and "every 2 weeks" to their singular form
+var units = map[string]string{
+	"minute": "minute", "minutes": "minute", "min": "minute", "mins": "minute",
+	"hour": "hour", "hours": "hour", "hr": "hour", "hrs": "hour",
+	"day": "day", "days": "day",
+	"week": "week", "weeks": "week",
+	"month": "month", "months": "month",
+	"year": "year", "years": "year",
+}
+
+//frequencies maps the units a recurrence may repeat in to its frequency
+var frequencies = map[string]string{"day": Daily, "week": Weekly, "month": Monthly, "year": Yearly}
+
+//Parse reads a line into the fields of a todo item:
+//
+//	#word      the first is the category, the rest are tags
+//	!N         the priority
+//	dates      today, tonight, tomorrow, friday, next friday, this friday, next week, next month,
+//	           next year, in 3 days, in 2 hours, 2026-05-01, may 1, may 1st 2027
+//	times      5pm, 5:30pm, 5 pm, 17:00, noon, midnight
+//	repeats    daily, weekly, monthly, yearly, every day, every 2 weeks, every other month,
+//	           every monday, every mon and thu, every weekday, every weekend
+//
+//A short weekday on its own, such as "sat", is a date only after "on" or at the end of the line, so that "Sat exam prep" keeps its title.  Whatever is left, with quoted text kept as it is, is the title.  If a line gives several dates or times the last is used and the others stay in the title.  A date without a time is due at the end of the day, and a time without a date is due the next time that time comes round.  Dates and times are relative to now, in its location
+func Parse(line string, now time.Time) (types.QuickAddParsed, error) {
+	var result types.QuickAddParsed
+	tokens := tokenize(line)
+	for i := range tokens {
+		tok := &tokens[i]
+		if tok.literal {
+			continue
+		}
+		if m := priorityToken.FindStringSubmatch(tok.text); m != nil {
+			priority, _ := strconv.Atoi(m[1])
+			result.Priority = &priority
+			tok.used = true
+		} else if m := hashtag.FindStringSubmatch(trimPunct(tok.text)); m != nil {
+			addTag(&result, m[1])
+			tok.used = true
+		}
+	}
+
+	//Keep the last phrase of each kind
+	var chosen [3]*match
+	for i := 0; i < len(tokens); {
+		m, ok := matchAt(tokens, i, now)
+		if !ok {
+			i++
+			continue
+		}
+		chosen[m.kind] = &m
+		i = m.end
+	}
+	date, clock, rule := chosen[dateKind], chosen[timeKind], chosen[ruleKind]
+	if date != nil && date.exact {
+		//An exact date already has its time
+		clock = nil
+	}
+
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	hour, minute := endHour, endMinute
+	if date != nil && date.hasTime {
+		hour, minute = date.hour, date.minute
+	}
+	if clock != nil {
+		hour, minute = clock.hour, clock.minute
+	}
+	var due time.Time
+	switch {
+	case date != nil && date.exact:
+		due = date.day
+	case date != nil:
+		due = at(date.day, hour, minute)
+	case clock != nil || (rule != nil && len(rule.rule.ByDay) > 0):
+		//The first matching day, from today on, on which the time has not yet passed
+		for day := today; ; day = day.AddDate(0, 0, 1) {
+			if (rule == nil || len(rule.rule.ByDay) == 0 || hasDay(rule.rule.ByDay, day.Weekday())) && at(day, hour, minute).After(now) {
+				due = at(day, hour, minute)
+				break
+			}
+		}
+	}
+	if !due.IsZero() {
+		result.Due = &due
+	}
+	if rule != nil {
+		result.Recurrence = rule.rule.String()
+	}
+
+	for _, m := range []*match{date, clock} {
+		if m == nil {
+			continue
+		}
+		use(tokens, m)
+		for j := m.start - 1; j >= 0 && !tokens[j].used && !tokens[j].literal && connectors[tokens[j].word]; j-- {
+			tokens[j].used = true
+		}
+	}
+	if rule != nil {
+		use(tokens, rule)
+	}
+
+	var words []string
+	for _, tok := range tokens {
+		if !tok.used {
+			words = append(words, tok.text)
+		}
+	}
+	result.Title = strings.TrimSpace(strings.Join(words, " "))
+	if result.Title == "" {
+		return result, ErrNoTitle
+	}
+	return result, nil
+}
+
+//tokenize splits a line into words.  Text between double quotes is kept together as a literal token, without the quotes
+func tokenize(line string) []token {
+	var tokens []token
+	fields := strings.Fields(line)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if !strings.HasPrefix(field, `"`) {
+			tokens = append(tokens, token{text: field, word: strings.ToLower(trimPunct(field))})
+			continue
+		}
+		quoted := []string{field[1:]}
+		for !strings.HasSuffix(quoted[len(quoted)-1], `"`) && i+1 < len(fields) {
+			i++
+			quoted = append(quoted, fields[i])
+		}
+		text := strings.TrimSuffix(strings.Join(quoted, " "), `"`)
+		if text != "" {
+			tokens = append(tokens, token{text: text, literal: true})
+		}
+	}
+	return tokens
+}
+
+//trimPunct removes the punctuation that may follow a word in a sentence
+func trimPunct(s string) string {
+	return strings.TrimRight(s, ",;.?")
+}
+
+//addTag adds a hashtag to the result: the first is the category and the rest are tags
+func addTag(result *types.QuickAddParsed, tag string) {
+	if result.Category == "" {
+		result.Category = tag
+		return
+	}
+	if tag == result.Category {
+		return
+	}
+	for _, t := range result.Tags {
+		if t == tag {
+			return
+		}
+	}
+	result.Tags = append(result.Tags, tag)
+}
+
+//use marks the tokens of a match as taken out of the title
+func use(tokens []token, m *match) {
+	for i := m.start; i < m.end; i++ {
+		tokens[i].used = true
+	}
+}
+
+//matchAt matches a phrase starting at token i.  A phrase may not run into a literal token or one already used
+func matchAt(tokens []token, i int, now time.Time) (match, bool) {
+	var words []string
+	for j := i; j < len(tokens) && !tokens[j].used && !tokens[j].literal; j++ {
+		words = append(words, tokens[j].word)
+	}
+	if len(words) == 0 {
+		return match{}, false
+	}
+	for _, matcher := range []func([]string, time.Time) (match, int){matchRule, matchDate, matchTime} {
+		if m, n := matcher(words, now); n > 0 {
+			if m.short && !(i > 0 && !tokens[i-1].literal && tokens[i-1].word == "on") && !atEnd(tokens, i+n) {
+				return match{}, false
+			}
+			m.start, m.end = i, i+n
+			return m, true
+		}
+	}
+	return match{}, false
+}
+
+//atEnd reports whether nothing but hashtags and priorities follows token i
+func atEnd(tokens []token, i int) bool {
+	for ; i < len(tokens); i++ {
+		if !tokens[i].used {
+			return false
+		}
+	}
+	return true
+}
+
+//matchRule matches a recurrence such as "weekly", "every 2 weeks", or "every mon and thu"
+func matchRule(words []string, now time.Time) (match, int) {
+	m := match{kind: ruleKind}
+	switch words[0] {
+	case "daily":
+		m.rule = Recurrence{Freq: Daily, Interval: 1}
+		return m, 1
+	case "weekly":
+		m.rule = Recurrence{Freq: Weekly, Interval: 1}
+		return m, 1
+	case "monthly":
+		m.rule = Recurrence{Freq: Monthly, Interval: 1}
+		return m, 1
+	case "yearly", "annually":
+		m.rule = Recurrence{Freq: Yearly, Interval: 1}
+		return m, 1
+	case "every":
+	default:
+		return m, 0
+	}
+	if len(words) < 2 {
+		return m, 0
+	}
+	switch words[1] {
+	case "weekday", "weekdays":
+		m.rule = Recurrence{Freq: Weekly, Interval: 1, ByDay: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}}
+		return m, 2
+	case "weekend", "weekends":
+		m.rule = Recurrence{Freq: Weekly, Interval: 1, ByDay: []time.Weekday{time.Saturday, time.Sunday}}
+		return m, 2
+	}
+	if freq, ok := frequencies[words[1]]; ok {
+		m.rule = Recurrence{Freq: freq, Interval: 1}
+		return m, 2
+	}
+	if len(words) >= 3 {
+		interval, ok := count(words[1])
+		if words[1] == "other" {
+			interval, ok = 2, true
+		}
+		if freq, isFreq := frequencies[units[words[2]]]; ok && isFreq {
+			m.rule = Recurrence{Freq: freq, Interval: interval}
+			return m, 3
+		}
+	}
+	//A list of days, such as "every mon, wed and fri"
+	m.rule = Recurrence{Freq: Weekly, Interval: 1}
+	n := 1
+	for n < len(words) {
+		next := n
+		if words[next] == "and" && next+1 < len(words) {
+			next++
+		}
+		day, ok := weekdays[strings.TrimSuffix(words[next], "s")]
+		if !ok {
+			day, ok = weekdays[words[next]]
+		}
+		if !ok {
+			break
+		}
+		if !hasDay(m.rule.ByDay, day) {
+			m.rule.ByDay = append(m.rule.ByDay, day)
+		}
+		n = next + 1
+	}
+	if len(m.rule.ByDay) == 0 {
+		return m, 0
+	}
+	return m, n
+}
+
+//matchDate matches a date such as "tomorrow", "next friday", "in 3 days", or "may 1st"
+func matchDate(words []string, now time.Time) (match, int) {
+	m := match{kind: dateKind}
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	switch words[0] {
+	case "today":
+		m.day = today
+		return m, 1
+	case "tonight":
+		m.day, m.hour, m.hasTime = today, tonightHour, true
+		return m, 1
+	case "tomorrow", "tmrw", "tmr":
+		m.day = today.AddDate(0, 0, 1)
+		return m, 1
+	}
+	if day, ok := weekdays[words[0]]; ok {
+		m.day = nextWeekday(today, day, false)
+		m.short = !strings.HasSuffix(words[0], "day")
+		return m, 1
+	}
+	if len(words) >= 2 && (words[0] == "next" || words[0] == "this") {
+		if day, ok := weekdays[words[1]]; ok {
+			m.day = nextWeekday(today, day, words[0] == "this")
+			return m, 2
+		}
+		if words[0] == "next" {
+			switch words[1] {
+			case "week":
+				m.day = nextWeekday(today, time.Monday, false)
+				return m, 2
+			case "month":
+				m.day = time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, loc)
+				return m, 2
+			case "year":
+				m.day = time.Date(today.Year()+1, time.January, 1, 0, 0, 0, 0, loc)
+				return m, 2
+			}
+		}
+	}
+	if len(words) >= 3 && words[0] == "in" {
+		n, ok := count(words[1])
+		if !ok {
+			return m, 0
+		}
+		switch units[words[2]] {
+		case "minute":
+			m.day, m.exact = now.Add(time.Duration(n)*time.Minute).Truncate(time.Minute), true
+		case "hour":
+			m.day, m.exact = now.Add(time.Duration(n)*time.Hour).Truncate(time.Minute), true
+		case "day":
+			m.day = today.AddDate(0, 0, n)
+		case "week":
+			m.day = today.AddDate(0, 0, 7*n)
+		case "month":
+			m.day = today.AddDate(0, n, 0)
+		case "year":
+			m.day = today.AddDate(n, 0, 0)
+		default:
+			return m, 0
+		}
+		return m, 3
+	}
+	if isoDate.MatchString(words[0]) {
+		day, err := time.ParseInLocation("2006-01-02", words[0], loc)
+		if err != nil {
+			return m, 0
+		}
+		m.day = day
+		return m, 1
+	}
+	if month, ok := months[words[0]]; ok && len(words) >= 2 {
+		d := dayOfMonth.FindStringSubmatch(words[1])
+		if d == nil {
+			return m, 0
+		}
+		dom, _ := strconv.Atoi(d[1])
+		year, n := today.Year(), 2
+		if len(words) >= 3 && yearNumber.MatchString(words[2]) {
+			year, _ = strconv.Atoi(words[2])
+			n = 3
+		}
+		day := time.Date(year, month, dom, 0, 0, 0, 0, loc)
+		if n == 2 && day.Before(today) {
+			day = time.Date(year+1, month, dom, 0, 0, 0, 0, loc)
+		}
+		if day.Day() != dom {
+			//No such day in that month
+			return m, 0
+		}
+		m.day = day
+		return m, n
+	}
+	return m, 0
+}
+
+//matchTime matches a time of day such as "5pm", "5:30 pm", "17:00", or "noon"
+func matchTime(words []string, now time.Time) (match, int) {
+	m := match{kind: timeKind}
+	switch words[0] {
+	case "noon":
+		m.hour = 12
+		return m, 1
+	case "midnight":
+		//The end of the day, as a deadline
+		m.hour, m.minute = endHour, endMinute
+		return m, 1
+	}
+	if c := clock24.FindStringSubmatch(words[0]); c != nil {
+		hour, _ := strconv.Atoi(c[1])
+		minute, _ := strconv.Atoi(c[2])
+		if hour > 23 || minute > 59 {
+			return m, 0
+		}
+		m.hour, m.minute = hour, minute
+		return m, 1
+	}
+	c := clock12.FindStringSubmatch(words[0])
+	if c == nil {
+		return m, 0
+	}
+	meridiem, n := c[3], 1
+	if meridiem == "" && len(words) >= 2 {
+		switch words[1] {
+		case "am", "pm", "a.m", "p.m":
+			meridiem, n = words[1], 2
+		}
+	}
+	if meridiem == "" {
+		//A bare number is not a time
+		return m, 0
+	}
+	hour, _ := strconv.Atoi(c[1])
+	minute := 0
+	if c[2] != "" {
+		minute, _ = strconv.Atoi(c[2])
+	}
+	if hour < 1 || hour > 12 || minute > 59 {
+		return m, 0
+	}
+	hour %= 12
+	if meridiem[0] == 'p' {
+		hour += 12
+	}
+	m.hour, m.minute = hour, minute
+	return m, n
+}
+
+//count reads the number in phrases such as "in 3 days", where "a" and "an" count as one
+func count(word string) (int, bool) {
+	if word == "a" || word == "an" || word == "one" {
+		return 1, true
+	}
+	n, err := strconv.Atoi(word)
+	if err != nil || n < 1 || n > maxCount {
+		return 0, false
+	}
+	return n, true
+}
+
+//nextWeekday returns the next date after today that falls on day, or today itself if onOrAfter is set and today is that day
+func nextWeekday(today time.Time, day time.Weekday, onOrAfter bool) time.Time {
+	ahead := (int(day) - int(today.Weekday()) + 7) % 7
+	if ahead == 0 && !onOrAfter {
+		ahead = 7
+	}
+	return today.AddDate(0, 0, ahead)
+}
+
+//hasDay reports whether days includes day
+func hasDay(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+//at returns the given time of day on the date of day
+func at(day time.Time, hour int, minute int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+}
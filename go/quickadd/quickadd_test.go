@@ -0,0 +1,90 @@
+package quickadd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	//A Wednesday morning
+	now := time.Date(2020, 5, 6, 10, 0, 0, 0, loc)
+	tests := []struct {
+		line       string
+		title      string
+		due        string
+		category   string
+		tags       []string
+		priority   int
+		recurrence string
+	}{
+		{line: "Buy milk tomorrow 5pm #shopping !2 every week", title: "Buy milk", due: "2020-05-07 17:00", category: "shopping", priority: 2, recurrence: "FREQ=WEEKLY"},
+		{line: "Call mum today", title: "Call mum", due: "2020-05-06 23:59"},
+		{line: "Party tonight", title: "Party", due: "2020-05-06 20:00"},
+		{line: "Dentist friday at 3:30pm", title: "Dentist", due: "2020-05-08 15:30"},
+		{line: "Gym wednesday", title: "Gym", due: "2020-05-13 23:59"},
+		{line: "Review this wednesday", title: "Review", due: "2020-05-06 23:59"},
+		{line: "Plan next week", title: "Plan", due: "2020-05-11 23:59"},
+		{line: "Pay rent next month", title: "Pay rent", due: "2020-06-01 23:59"},
+		{line: "Call back in 2 hours", title: "Call back", due: "2020-05-06 12:00"},
+		{line: "Water plants in 3 days at noon", title: "Water plants", due: "2020-05-09 12:00"},
+		{line: "Taxes due 2020-05-15", title: "Taxes", due: "2020-05-15 23:59"},
+		{line: "Anniversary may 1st", title: "Anniversary", due: "2021-05-01 23:59"},
+		{line: "Trip jun 3 2021 8:15am", title: "Trip", due: "2021-06-03 08:15"},
+		{line: "Standup 9am", title: "Standup", due: "2020-05-07 09:00"},
+		{line: "Lunch 17:00", title: "Lunch", due: "2020-05-06 17:00"},
+		{line: "Read 5 pm", title: "Read", due: "2020-05-06 17:00"},
+		{line: "Meet at 5pm at 6pm", title: "Meet at 5pm", due: "2020-05-06 18:00"},
+		{line: "Room 12 at 3", title: "Room 12 at 3"},
+		{line: "Call feb 30", title: "Call feb 30"},
+
+		{line: "Bins every mon and thu", title: "Bins", due: "2020-05-07 23:59", recurrence: "FREQ=WEEKLY;BYDAY=MO,TH"},
+		{line: "Yoga every weekday 7am", title: "Yoga", due: "2020-05-07 07:00", recurrence: "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"},
+		{line: "Report every other month", title: "Report", recurrence: "FREQ=MONTHLY;INTERVAL=2"},
+		{line: "Backups daily 2am", title: "Backups", due: "2020-05-07 02:00", recurrence: "FREQ=DAILY"},
+
+		//Short weekdays are dates only after "on" or at the end of the line
+		{line: "Apply sun cream", title: "Apply sun cream"},
+		{line: "Sat exam prep", title: "Sat exam prep"},
+		{line: "Match on sat", title: "Match", due: "2020-05-09 23:59"},
+		{line: "Call mum sun", title: "Call mum", due: "2020-05-10 23:59"},
+		{line: "Call mum sun #family", title: "Call mum", due: "2020-05-10 23:59", category: "family"},
+
+		{line: `Watch "next friday" friday`, title: "Watch next friday", due: "2020-05-08 23:59"},
+		{line: `"Today" show`, title: "Today show"},
+		{line: "#work #urgent #work report, !1 !3", title: "report,", category: "work", tags: []string{"urgent"}, priority: 3},
+	}
+	for _, test := range tests {
+		result, err := Parse(test.line, now)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+		due := ""
+		if result.Due != nil {
+			due = result.Due.Format("2006-01-02 15:04")
+			if result.Due.Location() != loc {
+				t.Errorf("%q: due in %v, want %v", test.line, result.Due.Location(), loc)
+			}
+		}
+		priority := 0
+		if result.Priority != nil {
+			priority = *result.Priority
+		}
+		if result.Title != test.title || due != test.due || result.Category != test.category || !reflect.DeepEqual(result.Tags, test.tags) || priority != test.priority || result.Recurrence != test.recurrence {
+			t.Errorf("%q: got %q due %q #%s %v !%d %q, want %q due %q #%s %v !%d %q", test.line,
+				result.Title, due, result.Category, result.Tags, priority, result.Recurrence,
+				test.title, test.due, test.category, test.tags, test.priority, test.recurrence)
+		}
+	}
+}
+
+func TestParseNoTitle(t *testing.T) {
+	now := time.Date(2020, 5, 6, 10, 0, 0, 0, time.UTC)
+	for _, line := range []string{"", "   ", "tomorrow #home !1", "on friday at 5pm every week", `""`} {
+		if _, err := Parse(line, now); err != ErrNoTitle {
+			t.Errorf("%q: got %v, want ErrNoTitle", line, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package quickadd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Frequencies of a recurrence rule
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+	Yearly  = "YEARLY"
+)
+
+//maxInterval is the largest interval accepted in a recurrence rule
+const maxInterval = 999
+
+//dayCodes are the iCalendar abbreviations of the days of the week, indexed by time.Weekday
+var dayCodes = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+//Recurrence is the subset of an iCalendar RRULE that todo items use: a frequency, an interval, and for weekly rules the days of the week
+type Recurrence struct {
+	Freq     string
+	Interval int
+	ByDay    []time.Weekday
+}
+
+//String formats the recurrence as an RRULE value, such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH".  An interval of 1 is left out
+func (r Recurrence) String() string {
+	s := "FREQ=" + r.Freq
+	if r.Interval > 1 {
+		s += ";INTERVAL=" + strconv.Itoa(r.Interval)
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, day := range r.ByDay {
+			days[i] = dayCodes[day]
+		}
+		s += ";BYDAY=" + strings.Join(days, ",")
+	}
+	return s
+}
+
+//ParseRecurrence reads an RRULE value made of FREQ, which is required, INTERVAL, and BYDAY, which only weekly rules may have.  Other parts of RRULE are not supported
+func ParseRecurrence(rule string) (Recurrence, error) {
+	r := Recurrence{Interval: 1}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(rule, ";") {
+		eq := strings.IndexByte(part, '=')
+		if eq < 0 {
+			return r, fmt.Errorf("malformed rule part %q", part)
+		}
+		key, value := strings.ToUpper(part[:eq]), strings.ToUpper(part[eq+1:])
+		if seen[key] {
+			return r, fmt.Errorf("%s given twice", key)
+		}
+		seen[key] = true
+		switch key {
+		case "FREQ":
+			switch value {
+			case Daily, Weekly, Monthly, Yearly:
+				r.Freq = value
+			default:
+				return r, fmt.Errorf("unsupported frequency %q", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxInterval {
+				return r, fmt.Errorf("interval must be between 1 and %d", maxInterval)
+			}
+			r.Interval = n
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				day, ok := dayCode(code)
+				if !ok {
+					return r, fmt.Errorf("unknown day %q", code)
+				}
+				r.ByDay = append(r.ByDay, day)
+			}
+		default:
+			return r, fmt.Errorf("unsupported rule part %s", key)
+		}
+	}
+	if r.Freq == "" {
+		return r, errors.New("FREQ is required")
+	}
+	if len(r.ByDay) > 0 && r.Freq != Weekly {
+		return r, errors.New("BYDAY is only supported for weekly rules")
+	}
+	return r, nil
+}
+
+//dayCode looks up a day of the week by its iCalendar abbreviation
+func dayCode(code string) (time.Weekday, bool) {
+	for day, c := range dayCodes {
+		if c == code {
+			return time.Weekday(day), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/data"
+	"github.com/shale/go/types"
+)
+
+//store returns the data store to use for writes made while handling req, so that the audit log records who made them
+func (svr *ServerType) store(req *http.Request) *data.StoreType {
+	return svr.DAO.As(actor(req), requestID(req))
+}
+
+//actor names who a request acts for.  There are no user accounts yet, so this is the account named in the path
+func actor(req *http.Request) string {
+	return principal(req)
+}
+
+//GetHistory returns every recorded change to a todo item, oldest first
+func (svr *ServerType) GetHistory(id int, name string, resp http.ResponseWriter, req *http.Request) error {
+	result, err := svr.DAO.SelectHistory(id, name)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return NotFound("No history for todo item %d", id)
+	}
+	respond(resp, req, http.StatusOK, &result)
+	return nil
+}
+
+//authorizeAdmin checks that the request carries the admin token as a bearer token.  Admin endpoints are disabled when no token is configured
+func (svr *ServerType) authorizeAdmin(req *http.Request) error {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if svr.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(svr.AdminToken)) != 1 {
+		return Unauthorized("A valid admin bearer token is required")
+	}
+	return nil
+}
+
+//HandleAdmin routes requests to the admin API, which spans every account
+func (svr *ServerType) HandleAdmin(resp http.ResponseWriter, req *http.Request) {
+	pathArgs := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	log.Debugf("[%s] %s ADMIN PATHARGS %+v", requestID(req), req.Method, pathArgs)
+	if err := svr.authorizeAdmin(req); err != nil {
+		resp.Header().Set("WWW-Authenticate", `Bearer realm="shale-admin"`)
+		respondError(resp, req, err)
+		return
+	}
+	if len(pathArgs) != 2 || (pathArgs[1] != "audit" && pathArgs[1] != "metrics") {
+		respondHTTPErr(resp, req, http.StatusNotFound)
+		return
+	}
+	if req.Method != "GET" {
+		resp.Header().Set("Allow", "GET")
+		respondHTTPErr(resp, req, http.StatusMethodNotAllowed)
+		return
+	}
+	if pathArgs[1] == "metrics" {
+		expvar.Handler().ServeHTTP(resp, req)
+		return
+	}
+	if err := svr.GetAudit(resp, req); err != nil {
+		respondError(resp, req, err)
+	}
+}
+
+//timeParam parses an RFC 3339 timestamp query parameter, returning the zero time if it is absent
+func timeParam(req *http.Request, field string) (time.Time, error) {
+	value := req.URL.Query().Get(field)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, invalidField(field, "Must be an RFC 3339 timestamp, got %q", value)
+	}
+	return t, nil
+}
+
+//GetAudit returns audit records across every account, newest first, filtered by the user, actor, todo_id, from, and to query parameters
+func (svr *ServerType) GetAudit(resp http.ResponseWriter, req *http.Request) error {
+	query := req.URL.Query()
+	filter := types.AuditFilter{Name: query.Get("user"), Actor: query.Get("actor")}
+	var err error
+	if filter.From, err = timeParam(req, "from"); err != nil {
+		return err
+	}
+	if filter.To, err = timeParam(req, "to"); err != nil {
+		return err
+	}
+	if value := query.Get("todo_id"); value != "" {
+		if filter.TodoID, err = intParam("todo_id", value); err != nil {
+			return err
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 {
+			return invalidField("limit", "Must be a positive integer, got %q", value)
+		}
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return invalidField("to", "Must be after from")
+	}
+	result, err := svr.DAO.SelectAudit(filter)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		result = []types.AuditRecord{}
+	}
+	respond(resp, req, http.StatusOK, &result)
+	return nil
+}
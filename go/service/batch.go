@@ -0,0 +1,238 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/shale/go/data"
+	"github.com/shale/go/types"
+)
+
+//Batch modes
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best_effort"
+)
+
+//maxBatchOps caps the number of operations in a single batch
+const maxBatchOps = 1000
+
+//errNotApplied marks the operations of an atomic batch that were not applied because another operation failed
+var errNotApplied = &Error{Code: CodeNotApplied, Status: http.StatusFailedDependency, Message: "Not applied because another operation in the atomic batch failed"}
+
+//Batch runs a list of create, update, and delete operations in a single transaction and reports the outcome of each.  An atomic batch is applied only if every operation succeeds; a best_effort batch applies every operation that succeeds
+func (svr *ServerType) Batch(name string, resp http.ResponseWriter, req *http.Request) error {
+	var batch types.BatchRequest
+	if err := decodeBody(req, &batch); err != nil {
+		return err
+	}
+	if batch.Mode == "" {
+		batch.Mode = BatchAtomic
+	}
+	if batch.Mode != BatchAtomic && batch.Mode != BatchBestEffort {
+		return invalidField("mode", "Must be %q or %q, got %q", BatchAtomic, BatchBestEffort, batch.Mode)
+	}
+	if len(batch.Ops) == 0 || len(batch.Ops) > maxBatchOps {
+		return invalidField("ops", "Must hold between 1 and %d operations, got %d", maxBatchOps, len(batch.Ops))
+	}
+	quota, err := svr.quotaFor(name)
+	if err != nil {
+		return err
+	}
+	count, err := svr.DAO.CountTodos(name)
+	if err != nil {
+		return err
+	}
+
+	//Validate every operation first, so that invalid operations never reach the database
+	errs := make([]error, len(batch.Ops))
+	var ops []data.BatchOp
+	var indexes []int
+	for i, raw := range batch.Ops {
+		op, err := prepareBatchOp(raw, quota)
+		if err == nil && op.Op == data.BatchCreate && quota.MaxTodos > 0 {
+			if count >= quota.MaxTodos {
+				err = &QuotaError{Quota: QuotaMaxTodos, Limit: int64(quota.MaxTodos), Used: int64(count)}
+			} else {
+				count++
+			}
+		}
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		ops = append(ops, op)
+		indexes = append(indexes, i)
+	}
+
+	atomic := batch.Mode == BatchAtomic
+	todos := make([]*types.TodoData, len(batch.Ops))
+	failed := len(ops) < len(batch.Ops)
+	if len(ops) > 0 && !(atomic && failed) {
+		outcomes, err := svr.store(req).Batch(name, ops, atomic)
+		if err != nil {
+			return err
+		}
+		for j, outcome := range outcomes {
+			i := indexes[j]
+			if outcome.Err != nil {
+				errs[i] = outcome.Err
+				failed = true
+				continue
+			}
+			todo := outcome.Todo
+			todos[i] = &todo
+		}
+	}
+
+	result := types.BatchResponse{Mode: batch.Mode, Applied: !(atomic && failed)}
+	status := http.StatusOK
+	for i, raw := range batch.Ops {
+		op := types.BatchResult{Index: i, Op: raw.Op}
+		switch {
+		case errs[i] != nil:
+			op.Error = problemFor(req, errs[i])
+			op.Status = op.Error.Status
+			if atomic && status == http.StatusOK {
+				status = op.Status
+			}
+		case atomic && failed:
+			op.Error = problemFor(req, errNotApplied)
+			op.Status = op.Error.Status
+		default:
+			op.Todo = todos[i]
+			op.Status = http.StatusOK
+			if raw.Op == data.BatchCreate {
+				op.Status = http.StatusCreated
+			}
+		}
+		if op.Error == nil {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+		result.Results = append(result.Results, op)
+	}
+	if !atomic && failed {
+		status = http.StatusMultiStatus
+	}
+	respond(resp, req, status, &result)
+	return nil
+}
+
+//prepareBatchOp validates a single operation of a batch
+func prepareBatchOp(raw types.BatchOp, quota types.Quota) (data.BatchOp, error) {
+	op := data.BatchOp{Op: raw.Op, ID: raw.ID, Version: raw.Version}
+	hasTodo := len(bytes.TrimSpace(raw.Todo)) > 0 && !bytes.Equal(bytes.TrimSpace(raw.Todo), []byte("null"))
+	switch raw.Op {
+	case data.BatchCreate:
+		if raw.ID != 0 || raw.Version != 0 {
+			return op, invalidField("id", "Must not be given when creating a todo item")
+		}
+		if !hasTodo {
+			return op, invalidField("todo", "Is required")
+		}
+		if err := decodeSpec(raw.Todo, addSpec, &op.Todo); err != nil {
+			return op, withPrefix(err, "todo")
+		}
+		op.Todo.Active = true
+		return op, checkTags(quota, op.Todo.Tags)
+	case data.BatchUpdate, data.BatchDelete:
+		if raw.ID < 1 {
+			return op, invalidField("id", "Is required")
+		}
+		if raw.Version < 0 {
+			return op, invalidField("version", "Must not be negative")
+		}
+		if raw.Op == data.BatchDelete {
+			if hasTodo {
+				return op, invalidField("todo", "Is not accepted when deleting a todo item")
+			}
+			return op, nil
+		}
+		if !hasTodo {
+			return op, invalidField("todo", "Is required")
+		}
+		if err := decodeSpec(raw.Todo, patchSpec, &op.Patch); err != nil {
+			return op, withPrefix(err, "todo")
+		}
+		if op.Patch == (types.TodoPatch{}) {
+			return op, invalidField("todo", "Must set at least one field")
+		}
+		if op.Patch.Tags != nil {
+			return op, checkTags(quota, *op.Patch.Tags)
+		}
+		return op, nil
+	}
+	return op, invalidField("op", "Must be %q, %q, or %q, got %q", data.BatchCreate, data.BatchUpdate, data.BatchDelete, raw.Op)
+}
+
+//UpdateWhere sets the fields in the set object of the request on every todo item matching its filter object.  With ?dry_run=true it instead lists the items that would be updated, without updating anything
+func (svr *ServerType) UpdateWhere(name string, resp http.ResponseWriter, req *http.Request) error {
+	var raw struct {
+		Filter json.RawMessage `json:"filter"`
+		Set    json.RawMessage `json:"set"`
+	}
+	if err := decodeBody(req, &raw); err != nil {
+		return err
+	}
+	var update types.BulkUpdate
+	if len(raw.Filter) == 0 {
+		return invalidField("filter", "Is required")
+	}
+	if err := decodeSpec(raw.Filter, filterSpec, &update.Filter); err != nil {
+		return withPrefix(err, "filter")
+	}
+	if update.Filter == (types.Filter{}) {
+		return invalidField("filter", "Must have at least one condition")
+	}
+	if len(raw.Set) == 0 {
+		return invalidField("set", "Is required")
+	}
+	if err := decodeSpec(raw.Set, patchSpec, &update.Set); err != nil {
+		return withPrefix(err, "set")
+	}
+	if update.Set == (types.TodoPatch{}) {
+		return invalidField("set", "Must set at least one field")
+	}
+	if update.Set.Tags != nil {
+		quota, err := svr.quotaFor(name)
+		if err != nil {
+			return err
+		}
+		if err := checkTags(quota, *update.Set.Tags); err != nil {
+			return err
+		}
+	}
+
+	dryRun, err := dryRunParam(req)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		todos, err := svr.DAO.SelectWhere(update.Filter, name)
+		if err != nil {
+			return err
+		}
+		respond(resp, req, http.StatusOK, &types.ListStatus{
+			Status:   "Dry run",
+			Info:     "Todos updated",
+			Affected: int64(len(todos)),
+			DryRun:   true,
+			Todos:    todos,
+		})
+		return nil
+	}
+	todos, err := svr.store(req).UpdateWhere(update.Filter, update.Set, name)
+	if err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusOK, &types.ListStatus{
+		Status:   "Success",
+		Info:     "Todos updated",
+		Affected: int64(len(todos)),
+		Todos:    todos,
+	})
+	return nil
+}
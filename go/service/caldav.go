@@ -0,0 +1,489 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/data"
+	"github.com/shale/go/ical"
+	"github.com/shale/go/types"
+)
+
+//XML namespaces used by CalDAV
+const (
+	davNS       = "DAV:"
+	caldavNS    = "urn:ietf:params:xml:ns:caldav"
+	calserverNS = "http://calendarserver.org/ns/"
+)
+
+//calendarName is the name of the single calendar collection of each list
+const calendarName = "todos"
+
+//caldavRoot is the path under which CalDAV is served
+const caldavRoot = "/caldav/"
+
+//caldavTokenBytes is the number of random bytes in a CalDAV password
+const caldavTokenBytes = 32
+
+//caldavHome returns the path of an account's CalDAV principal, which is also its calendar home
+func caldavHome(name string) string {
+	return caldavRoot + url.PathEscape(name) + "/"
+}
+
+//caldavCalendar returns the path of an account's calendar collection
+func caldavCalendar(name string) string {
+	return caldavHome(name) + calendarName + "/"
+}
+
+//davProp is a single property of a DAV resource, with its value as XML
+type davProp struct {
+	name  string
+	value string
+}
+
+//davResource is a resource listed in a multistatus response
+type davResource struct {
+	href  string
+	props []davProp
+}
+
+//xmlText escapes s for use as XML character data
+func xmlText(s string) string {
+	var out bytes.Buffer
+	xml.EscapeText(&out, []byte(s))
+	return out.String()
+}
+
+//writeMultistatus writes a 207 Multi-Status response listing the properties of each resource
+func writeMultistatus(resp http.ResponseWriter, resources []davResource) {
+	var out bytes.Buffer
+	out.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	out.WriteString(`<D:multistatus xmlns:D="` + davNS + `" xmlns:C="` + caldavNS + `" xmlns:CS="` + calserverNS + `">`)
+	for _, resource := range resources {
+		out.WriteString(`<D:response><D:href>` + xmlText(resource.href) + `</D:href><D:propstat><D:prop>`)
+		for _, prop := range resource.props {
+			if prop.value == "" {
+				out.WriteString(`<` + prop.name + `/>`)
+			} else {
+				out.WriteString(`<` + prop.name + `>` + prop.value + `</` + prop.name + `>`)
+			}
+		}
+		out.WriteString(`</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`)
+	}
+	out.WriteString(`</D:multistatus>` + "\n")
+	resp.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	resp.WriteHeader(http.StatusMultiStatus)
+	resp.Write(out.Bytes())
+}
+
+//hrefProp returns a property whose value is a single href
+func hrefProp(name string, href string) davProp {
+	return davProp{name: name, value: `<D:href>` + xmlText(href) + `</D:href>`}
+}
+
+//IssueCalDAVToken creates a secret password giving read and write access to the list over CalDAV.  Any earlier password stops working
+func (svr *ServerType) IssueCalDAVToken(name string, resp http.ResponseWriter, req *http.Request) error {
+	buf := make([]byte, caldavTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(buf)
+	if err := svr.DAO.SetCalDAVToken(name, token); err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusCreated, &types.CalDAVToken{
+		Username:  name,
+		Password:  token,
+		CalDAVURL: baseURL(req) + caldavHome(name),
+	})
+	return nil
+}
+
+//RevokeCalDAVToken stops the list's CalDAV password from working
+func (svr *ServerType) RevokeCalDAVToken(name string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.DAO.DeleteCalDAVToken(name); err != nil {
+		if err == data.ErrNotFound {
+			return NotFound("The list has no CalDAV password")
+		}
+		return err
+	}
+	respond(resp, req, http.StatusOK, &types.ListStatus{
+		Status:   "Success",
+		Info:     "CalDAV password revoked",
+		Affected: 1,
+	})
+	return nil
+}
+
+//authorizeCalDAV checks the Basic credentials of a CalDAV request, where the password is the account's CalDAV password, and returns the account name.  The feed token is not accepted, as it only gives read access to the feed
+func (svr *ServerType) authorizeCalDAV(req *http.Request) (string, error) {
+	user, token, ok := req.BasicAuth()
+	if !ok || token == "" {
+		return "", Unauthorized("CalDAV requires the username and CalDAV password as Basic credentials")
+	}
+	owner, err := svr.DAO.SelectCalDAVOwner(token)
+	if err == data.ErrNotFound || err == nil && subtle.ConstantTimeCompare([]byte(owner), []byte(user)) != 1 {
+		return "", Unauthorized("The username or CalDAV password is not valid")
+	}
+	return owner, err
+}
+
+//HandleCalDAV serves a minimal CalDAV server with one calendar of VTODOs per list, so that calendar clients can sync todo items both ways.  The path is /caldav/<username>/todos/<item>.ics
+func (svr *ServerType) HandleCalDAV(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("DAV", "1, 3, calendar-access")
+	if req.Method == "OPTIONS" {
+		resp.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		resp.WriteHeader(http.StatusOK)
+		return
+	}
+	name, err := svr.authorizeCalDAV(req)
+	if err != nil {
+		resp.Header().Set("WWW-Authenticate", `Basic realm="shale", charset="UTF-8"`)
+		respondError(resp, req, err)
+		return
+	}
+	pathArgs := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, caldavRoot), "/"), "/")
+	log.Debugf("[%s] %s CALDAV PATHARGS %+v", requestID(req), req.Method, pathArgs)
+	switch {
+	case pathArgs[0] == "":
+		err = svr.davRoot(name, resp, req)
+	case pathArgs[0] != name:
+		err = NotFound("No such calendar home: %s", req.URL.Path)
+	case len(pathArgs) == 1:
+		err = svr.davHome(name, resp, req)
+	case pathArgs[1] != calendarName || len(pathArgs) > 3:
+		err = NotFound("No such calendar: %s", req.URL.Path)
+	case len(pathArgs) == 2:
+		err = svr.davCalendar(name, resp, req)
+	default:
+		err = svr.davItem(name, pathArgs[2], resp, req)
+	}
+	if err != nil {
+		respondError(resp, req, err)
+	}
+}
+
+//methodNotAllowed reports a method a DAV resource does not support
+func methodNotAllowed(resp http.ResponseWriter, allow string) error {
+	resp.Header().Set("Allow", allow)
+	return &Error{Code: CodeMethodNotAllowed, Status: http.StatusMethodNotAllowed, Message: http.StatusText(http.StatusMethodNotAllowed)}
+}
+
+//principalProps are the properties that lead a client from any resource to the account's calendars
+func principalProps(name string) []davProp {
+	return []davProp{
+		hrefProp("D:current-user-principal", caldavHome(name)),
+		hrefProp("D:principal-URL", caldavHome(name)),
+		hrefProp("C:calendar-home-set", caldavHome(name)),
+	}
+}
+
+//davRoot answers PROPFIND on /caldav/, pointing the client at the principal of the account it signed in as
+func (svr *ServerType) davRoot(name string, resp http.ResponseWriter, req *http.Request) error {
+	if req.Method != "PROPFIND" {
+		return methodNotAllowed(resp, "OPTIONS, PROPFIND")
+	}
+	props := append([]davProp{{name: "D:resourcetype", value: "<D:collection/>"}}, principalProps(name)...)
+	writeMultistatus(resp, []davResource{{href: caldavRoot, props: props}})
+	return nil
+}
+
+//davHome answers PROPFIND on an account's principal, which is also its calendar home and holds its one calendar
+func (svr *ServerType) davHome(name string, resp http.ResponseWriter, req *http.Request) error {
+	if req.Method != "PROPFIND" {
+		return methodNotAllowed(resp, "OPTIONS, PROPFIND")
+	}
+	props := append([]davProp{
+		{name: "D:resourcetype", value: "<D:collection/><D:principal/>"},
+		{name: "D:displayname", value: xmlText(name)},
+	}, principalProps(name)...)
+	resources := []davResource{{href: caldavHome(name), props: props}}
+	if req.Header.Get("Depth") != "0" {
+		calendar, err := svr.calendarResource(name)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, calendar)
+	}
+	writeMultistatus(resp, resources)
+	return nil
+}
+
+//calendarResource describes an account's calendar collection.  Its CTag changes whenever the list does, which tells clients to sync
+func (svr *ServerType) calendarResource(name string) (davResource, error) {
+	state, err := svr.DAO.SelectListState(name)
+	if err != nil {
+		return davResource{}, err
+	}
+	props := append([]davProp{
+		{name: "D:resourcetype", value: "<D:collection/><C:calendar/>"},
+		{name: "D:displayname", value: xmlText(name + " todos")},
+		{name: "C:supported-calendar-component-set", value: `<C:comp name="VTODO"/>`},
+		{name: "CS:getctag", value: strconv.FormatInt(state.Seq, 10)},
+		{name: "D:getetag", value: xmlText(listETag(state))},
+	}, principalProps(name)...)
+	return davResource{href: caldavCalendar(name), props: props}, nil
+}
+
+//itemResource describes a single calendar item, optionally with its calendar data
+func itemResource(name string, item types.CalendarItem, withData bool) (davResource, error) {
+	props := []davProp{
+		{name: "D:resourcetype"},
+		{name: "D:getcontenttype", value: xmlText(ical.ContentType + "; component=VTODO")},
+		{name: "D:getetag", value: xmlText(todoETag(item.Todo))},
+	}
+	if withData {
+		var out bytes.Buffer
+		if err := ical.WriteCalendar(&out, "", []types.CalendarItem{item}); err != nil {
+			return davResource{}, err
+		}
+		props = append(props, davProp{name: "C:calendar-data", value: xmlText(out.String())})
+	}
+	return davResource{href: caldavCalendar(name) + url.PathEscape(item.Href), props: props}, nil
+}
+
+//davCalendar answers PROPFIND and REPORT on an account's calendar collection
+func (svr *ServerType) davCalendar(name string, resp http.ResponseWriter, req *http.Request) error {
+	switch req.Method {
+	case "PROPFIND":
+		calendar, err := svr.calendarResource(name)
+		if err != nil {
+			return err
+		}
+		resources := []davResource{calendar}
+		if req.Header.Get("Depth") != "0" {
+			items, err := svr.DAO.SelectCalendar(name)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				resource, err := itemResource(name, item, false)
+				if err != nil {
+					return err
+				}
+				resources = append(resources, resource)
+			}
+		}
+		writeMultistatus(resp, resources)
+		return nil
+	case "REPORT":
+		return svr.davReport(name, resp, req)
+	}
+	return methodNotAllowed(resp, "OPTIONS, PROPFIND, REPORT")
+}
+
+//davReport answers the calendar-query and calendar-multiget reports with the calendar data of the items asked for.  A calendar-query returns every item, as the calendar holds only VTODOs
+func (svr *ServerType) davReport(name string, resp http.ResponseWriter, req *http.Request) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	var report struct {
+		XMLName xml.Name
+		Hrefs   []string `xml:"DAV: href"`
+	}
+	if err := xml.Unmarshal(body, &report); err != nil {
+		return invalidField("body", "Malformed XML body: %v", err)
+	}
+	if report.XMLName.Space != caldavNS || report.XMLName.Local != "calendar-query" && report.XMLName.Local != "calendar-multiget" {
+		return invalidField("body", "Unsupported report %s", report.XMLName.Local)
+	}
+	var items []types.CalendarItem
+	if report.XMLName.Local == "calendar-query" {
+		items, err = svr.DAO.SelectCalendar(name)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, href := range report.Hrefs {
+			href, err := url.PathUnescape(strings.TrimSpace(href))
+			if err != nil || path.Dir(href) != path.Clean(caldavRoot+name+"/"+calendarName) {
+				continue
+			}
+			item, err := svr.DAO.SelectCalendarItem(path.Base(href), name)
+			if err == data.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+	}
+	var resources []davResource
+	for _, item := range items {
+		resource, err := itemResource(name, item, true)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, resource)
+	}
+	writeMultistatus(resp, resources)
+	return nil
+}
+
+//davItem serves a single calendar item
+func (svr *ServerType) davItem(name string, href string, resp http.ResponseWriter, req *http.Request) error {
+	switch req.Method {
+	case "GET", "HEAD":
+		item, err := svr.DAO.SelectCalendarItem(href, name)
+		if err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		if err := ical.WriteCalendar(&out, "", []types.CalendarItem{item}); err != nil {
+			return err
+		}
+		resp.Header().Set("Content-Type", ical.ContentType)
+		resp.Header().Set("ETag", todoETag(item.Todo))
+		resp.WriteHeader(http.StatusOK)
+		if req.Method == "GET" {
+			resp.Write(out.Bytes())
+		}
+		return nil
+	case "PUT":
+		return svr.davPut(name, href, resp, req)
+	case "DELETE":
+		item, err := svr.DAO.SelectCalendarItem(href, name)
+		if err != nil {
+			return err
+		}
+		version, err := ifMatchVersion(req, item.Todo.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := svr.store(req).DeleteByID(item.Todo.ID, name, version); err != nil {
+			return err
+		}
+		resp.WriteHeader(http.StatusNoContent)
+		return nil
+	case "PROPFIND":
+		item, err := svr.DAO.SelectCalendarItem(href, name)
+		if err != nil {
+			return err
+		}
+		resource, err := itemResource(name, item, false)
+		if err != nil {
+			return err
+		}
+		writeMultistatus(resp, []davResource{resource})
+		return nil
+	}
+	return methodNotAllowed(resp, "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND")
+}
+
+//calendarPatch returns a patch setting the fields of current that the VTODO changes.  Fields are compared as they are rendered to iCalendar, so that a client sending back an unchanged VTODO does not overwrite details iCalendar cannot hold, such as priorities above 9
+func calendarPatch(current types.TodoData, vtodo ical.Todo) types.TodoPatch {
+	var rendered ical.Todo
+	rendered.Summary = current.Title
+	rendered.Description = current.Body
+	rendered.Categories = ical.Categories(current)
+	rendered.Priority = ical.Priority(current.Priority)
+	rendered.Status = ical.Status(current.Active)
+
+	var updated types.TodoData
+	vtodo.Apply(&updated)
+	var patch types.TodoPatch
+	if vtodo.Summary != rendered.Summary {
+		patch.Title = &updated.Title
+	}
+	if vtodo.Description != rendered.Description {
+		patch.Body = &updated.Body
+	}
+	if strings.Join(vtodo.Categories, ",") != strings.Join(rendered.Categories, ",") {
+		patch.Category = &updated.Category
+		patch.Tags = &updated.Tags
+	}
+	if vtodo.Priority != rendered.Priority {
+		patch.Priority = &updated.Priority
+	}
+	if updated.Active != current.Active {
+		patch.Active = &updated.Active
+	}
+	return patch
+}
+
+//davPut creates or updates a calendar item from the VTODO in the request body.  If-Match and If-None-Match: * make the write conditional
+func (svr *ServerType) davPut(name string, href string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.checkBodyQuota(name, req); err != nil {
+		return err
+	}
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	vtodo, err := ical.ParseTodo(bytes.NewReader(body))
+	if err != nil {
+		return invalidField("body", "Could not be read as a VTODO: %v", err)
+	}
+	var todo types.TodoData
+	vtodo.Apply(&todo)
+	todo.Name = name
+	if problems := checkTodo(importSpec, todo); len(problems) > 0 {
+		return Invalid(problems...)
+	}
+	quota, err := svr.quotaFor(name)
+	if err != nil {
+		return err
+	}
+	if err := checkTags(quota, todo.Tags); err != nil {
+		return err
+	}
+
+	current, err := svr.DAO.SelectCalendarItem(href, name)
+	if err == data.ErrNotFound {
+		if req.Header.Get("If-Match") != "" {
+			return PreconditionFailed("No calendar item exists at %s", req.URL.Path)
+		}
+		if err := svr.checkTodoQuota(name, todo); err != nil {
+			return err
+		}
+		uid := vtodo.UID
+		if uid == "" {
+			uid = strings.TrimSuffix(href, ".ics")
+		}
+		created, err := svr.store(req).InsertCalendarItem(types.CalendarItem{Todo: todo, UID: uid, Href: href})
+		if err != nil {
+			return err
+		}
+		resp.Header().Set("ETag", todoETag(created.Todo))
+		resp.WriteHeader(http.StatusCreated)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if req.Header.Get("If-None-Match") == "*" {
+		return PreconditionFailed("A calendar item already exists at %s", req.URL.Path)
+	}
+	version, err := ifMatchVersion(req, current.Todo.ID)
+	if err != nil {
+		return err
+	}
+	updated := current.Todo
+	if patch := calendarPatch(current.Todo, vtodo); patch != (types.TodoPatch{}) {
+		updated, err = svr.store(req).PatchByID(current.Todo.ID, patch, name, version)
+		if err != nil {
+			return err
+		}
+	} else if version != 0 && version != current.Todo.Version {
+		return data.ErrStale
+	}
+	resp.Header().Set("ETag", todoETag(updated))
+	resp.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+//WellKnownCalDAV redirects service discovery at /.well-known/caldav to the CalDAV root
+func WellKnownCalDAV(resp http.ResponseWriter, req *http.Request) {
+	http.Redirect(resp, req, caldavRoot, http.StatusMovedPermanently)
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shale/go/data"
+	"github.com/shale/go/ical"
+	"github.com/shale/go/types"
+)
+
+//calendarTodos are the items on tom's list.  The second was created by a CalDAV client, which named it abc.ics
+var calendarTodos = []types.CalendarItem{
+	{Todo: types.TodoData{ID: 1, Name: "tom", Title: "Buy milk", Category: "shopping", Priority: 2, Active: true, Version: 2}, Href: "1.ics"},
+	{Todo: types.TodoData{ID: 2, Name: "tom", Title: "Call mum", Active: false, Version: 1}, UID: "abc@example.org", Href: "abc.ics"},
+}
+
+//calendarRow returns an item as a row of calendarColumns
+func calendarRow(item types.CalendarItem) []driver.Value {
+	todo := item.Todo
+	var uid, href driver.Value
+	if item.UID != "" {
+		uid = item.UID
+		href = item.Href
+	}
+	return []driver.Value{int64(todo.ID), todo.Name, todo.Title, todo.Body, todo.Category, int64(todo.Priority), nil, todo.Active, strings.Join(todo.Tags, ","),
+		int64(todo.Version), nil, nil, nil, nil, nil, todo.Recurrence, uid, href}
+}
+
+//newCalDAVServer returns a server for tom's list, whose CalDAV password is "secret"
+func newCalDAVServer() (*ServerType, *fakeDB) {
+	hash := sha256.Sum256([]byte("secret"))
+	db := &fakeDB{answers: map[string]fakeAnswer{
+		"FROM CalDAVTokens": func(args []driver.Value) [][]driver.Value {
+			if args[0] == hex.EncodeToString(hash[:]) {
+				return [][]driver.Value{{"tom"}}
+			}
+			return nil
+		},
+		"FROM Quotas": func(args []driver.Value) [][]driver.Value {
+			return nil
+		},
+		"FROM Lists": func(args []driver.Value) [][]driver.Value {
+			return [][]driver.Value{{int64(5), time.Date(2020, 5, 6, 10, 0, 0, 0, time.UTC)}}
+		},
+		"ORDER BY id": func(args []driver.Value) [][]driver.Value {
+			var rows [][]driver.Value
+			for _, item := range calendarTodos {
+				rows = append(rows, calendarRow(item))
+			}
+			return rows
+		},
+		"dav_name = ?": func(args []driver.Value) [][]driver.Value {
+			for _, item := range calendarTodos {
+				if item.Href == args[1] {
+					return [][]driver.Value{calendarRow(item)}
+				}
+			}
+			return nil
+		},
+	}}
+	return &ServerType{DAO: &data.StoreType{DAO: openFakeDB(db)}}, db
+}
+
+//davRequest sends a CalDAV request signed in as tom
+func davRequest(svr *ServerType, method string, path string, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.SetBasicAuth("tom", "secret")
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	resp := httptest.NewRecorder()
+	svr.HandleCalDAV(resp, req)
+	return resp
+}
+
+func TestCalDAVAuthorization(t *testing.T) {
+	svr, _ := newCalDAVServer()
+	tests := []struct {
+		name           string
+		user, password string
+	}{
+		{"no credentials", "", ""},
+		{"wrong password", "tom", "feed-token"},
+		{"another user", "ann", "secret"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("PROPFIND", "/caldav/tom/", nil)
+		if test.user != "" {
+			req.SetBasicAuth(test.user, test.password)
+		}
+		resp := httptest.NewRecorder()
+		svr.HandleCalDAV(resp, req)
+		if resp.Code != http.StatusUnauthorized || resp.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: got %d, want a Basic challenge", test.name, resp.Code)
+		}
+	}
+}
+
+func TestCalDAVPropfind(t *testing.T) {
+	svr, _ := newCalDAVServer()
+	tests := []struct {
+		path   string
+		depth  string
+		status int
+		want   []string
+		absent []string
+	}{
+		{
+			path:   "/caldav/tom/todos/",
+			depth:  "1",
+			status: http.StatusMultiStatus,
+			want: []string{
+				"<D:href>/caldav/tom/todos/</D:href>", "<CS:getctag>5</CS:getctag>", `<D:getetag>W/&#34;list.5&#34;</D:getetag>`,
+				"<D:href>/caldav/tom/todos/1.ics</D:href>", `<D:getetag>&#34;1.2&#34;</D:getetag>`,
+				"<D:href>/caldav/tom/todos/abc.ics</D:href>", `<D:getetag>&#34;2.1&#34;</D:getetag>`,
+			},
+			absent: []string{"C:calendar-data"},
+		},
+		{
+			path:   "/caldav/tom/todos/",
+			depth:  "0",
+			status: http.StatusMultiStatus,
+			want:   []string{"<D:href>/caldav/tom/todos/</D:href>"},
+			absent: []string{"1.ics"},
+		},
+		{
+			path:   "/caldav/tom/",
+			depth:  "1",
+			status: http.StatusMultiStatus,
+			want:   []string{"<C:calendar-home-set><D:href>/caldav/tom/</D:href></C:calendar-home-set>", "<D:href>/caldav/tom/todos/</D:href>"},
+		},
+		{
+			path:   "/caldav/tom/todos/abc.ics",
+			status: http.StatusMultiStatus,
+			want:   []string{"<D:href>/caldav/tom/todos/abc.ics</D:href>", `<D:getetag>&#34;2.1&#34;</D:getetag>`},
+		},
+		{path: "/caldav/tom/todos/missing.ics", status: http.StatusNotFound},
+		{path: "/caldav/ann/", status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		resp := davRequest(svr, "PROPFIND", test.path, "", map[string]string{"Depth": test.depth})
+		body := resp.Body.String()
+		if resp.Code != test.status {
+			t.Errorf("%s depth %s: got %d, want %d: %s", test.path, test.depth, resp.Code, test.status, body)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s depth %s: %s is missing from %s", test.path, test.depth, want, body)
+			}
+		}
+		for _, absent := range test.absent {
+			if strings.Contains(body, absent) {
+				t.Errorf("%s depth %s: %s is in %s", test.path, test.depth, absent, body)
+			}
+		}
+	}
+}
+
+func TestCalDAVReport(t *testing.T) {
+	svr, _ := newCalDAVServer()
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   []string
+		absent []string
+	}{
+		{
+			name:   "calendar-query",
+			body:   `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><C:calendar-data/></D:prop></C:calendar-query>`,
+			status: http.StatusMultiStatus,
+			want:   []string{"/caldav/tom/todos/1.ics", "SUMMARY:Buy milk", "/caldav/tom/todos/abc.ics", "UID:abc@example.org", "STATUS:COMPLETED"},
+		},
+		{
+			name: "calendar-multiget",
+			body: `<C:calendar-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:prop><C:calendar-data/></D:prop>` +
+				`<D:href>/caldav/tom/todos/abc.ics</D:href><D:href>/caldav/tom/todos/missing.ics</D:href><D:href>/caldav/ann/todos/1.ics</D:href>` +
+				`</C:calendar-multiget>`,
+			status: http.StatusMultiStatus,
+			want:   []string{"/caldav/tom/todos/abc.ics", "SUMMARY:Call mum"},
+			absent: []string{"1.ics", "missing.ics", "Buy milk"},
+		},
+		{
+			name:   "unsupported report",
+			body:   `<D:sync-collection xmlns:D="DAV:"/>`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "malformed XML",
+			body:   `<C:calendar-query`,
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		resp := davRequest(svr, "REPORT", "/caldav/tom/todos/", test.body, map[string]string{"Depth": "1"})
+		body := resp.Body.String()
+		if resp.Code != test.status {
+			t.Errorf("%s: got %d, want %d: %s", test.name, resp.Code, test.status, body)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: %s is missing from %s", test.name, want, body)
+			}
+		}
+		for _, absent := range test.absent {
+			if strings.Contains(body, absent) {
+				t.Errorf("%s: %s is in %s", test.name, absent, body)
+			}
+		}
+	}
+}
+
+func TestCalDAVPutConflict(t *testing.T) {
+	//Sending back the item unchanged makes no write, so the only queries are reads
+	var unchanged bytes.Buffer
+	if err := ical.WriteCalendar(&unchanged, "", calendarTodos[:1]); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		status int
+		etag   string
+	}{
+		{name: "current version", path: "/caldav/tom/todos/1.ics", header: map[string]string{"If-Match": `"1.2"`}, status: http.StatusNoContent, etag: `"1.2"`},
+		{name: "unconditional", path: "/caldav/tom/todos/1.ics", status: http.StatusNoContent, etag: `"1.2"`},
+		{name: "stale version", path: "/caldav/tom/todos/1.ics", header: map[string]string{"If-Match": `"1.1"`}, status: http.StatusPreconditionFailed},
+		{name: "another item's tag", path: "/caldav/tom/todos/1.ics", header: map[string]string{"If-Match": `"2.1"`}, status: http.StatusPreconditionFailed},
+		{name: "create over an existing item", path: "/caldav/tom/todos/1.ics", header: map[string]string{"If-None-Match": "*"}, status: http.StatusPreconditionFailed},
+		{name: "update a missing item", path: "/caldav/tom/todos/new.ics", header: map[string]string{"If-Match": `"3.1"`}, status: http.StatusPreconditionFailed},
+	}
+	for _, test := range tests {
+		svr, db := newCalDAVServer()
+		resp := davRequest(svr, "PUT", test.path, unchanged.String(), test.header)
+		if resp.Code != test.status {
+			t.Errorf("%s: got %d, want %d: %s", test.name, resp.Code, test.status, resp.Body.String())
+		}
+		if etag := resp.Header().Get("ETag"); etag != test.etag {
+			t.Errorf("%s: got ETag %q, want %q", test.name, etag, test.etag)
+		}
+		if len(db.execs) > 0 {
+			t.Errorf("%s: wrote %q", test.name, db.execs)
+		}
+	}
+
+	svr, _ := newCalDAVServer()
+	if resp := davRequest(svr, "PUT", "/caldav/tom/todos/1.ics", "BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n", nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("got %d for a body without a VTODO, want %d", resp.Code, http.StatusBadRequest)
+	}
+}
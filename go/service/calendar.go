@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/shale/go/data"
+	"github.com/shale/go/ical"
+	"github.com/shale/go/types"
+)
+
+//feedTokenBytes is the number of random bytes in a feed token
+const feedTokenBytes = 32
+
+//baseURL returns the scheme and host the client used to reach the server
+func baseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host
+}
+
+//IssueFeedToken creates a secret token giving read access to the list's calendar feed.  Any earlier token stops working.  It gives no access over CalDAV, since feed URLs are pasted into calendar subscriptions and shared
+func (svr *ServerType) IssueFeedToken(name string, resp http.ResponseWriter, req *http.Request) error {
+	buf := make([]byte, feedTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(buf)
+	if err := svr.DAO.SetFeedToken(name, token); err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusCreated, &types.FeedToken{
+		Token:   token,
+		FeedURL: baseURL(req) + "/feed/" + token + ".ics",
+	})
+	return nil
+}
+
+//RevokeFeedToken stops the list's feed token from working
+func (svr *ServerType) RevokeFeedToken(name string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.DAO.DeleteFeedToken(name); err != nil {
+		if err == data.ErrNotFound {
+			return NotFound("The list has no feed token")
+		}
+		return err
+	}
+	respond(resp, req, http.StatusOK, &types.ListStatus{
+		Status:   "Success",
+		Info:     "Feed token revoked",
+		Affected: 1,
+	})
+	return nil
+}
+
+//HandleFeed serves GET /feed/<token>.ics, the read-only iCalendar feed of the list the token belongs to
+func (svr *ServerType) HandleFeed(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		resp.Header().Set("Allow", "GET, HEAD")
+		respondHTTPErr(resp, req, http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/feed/"), ".ics")
+	if err := svr.serveFeed(token, resp, req); err != nil {
+		respondError(resp, req, err)
+	}
+}
+
+//serveFeed writes the calendar of the list a feed token belongs to
+func (svr *ServerType) serveFeed(token string, resp http.ResponseWriter, req *http.Request) error {
+	name, err := svr.DAO.SelectFeedOwner(token)
+	if err == data.ErrNotFound {
+		return NotFound("No such feed")
+	}
+	if err != nil {
+		return err
+	}
+	if done, err := svr.respondIfListUnchanged(name, resp, req); done || err != nil {
+		return err
+	}
+	items, err := svr.DAO.SelectCalendar(name)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := ical.WriteCalendar(&out, name, items); err != nil {
+		return err
+	}
+	resp.Header().Set("Content-Type", ical.ContentType)
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name+".ics"))
+	resp.WriteHeader(http.StatusOK)
+	if req.Method != "HEAD" {
+		resp.Write(out.Bytes())
+	}
+	return nil
+}
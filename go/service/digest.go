@@ -0,0 +1,39 @@
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/shale/go/digest"
+)
+
+//PreviewDigest renders the account's digest for today as it would be emailed now, without sending it.  With ?format=html or ?format=text it returns just that body; otherwise the subject, both bodies, and the items listed
+func (svr *ServerType) PreviewDigest(name string, resp http.ResponseWriter, req *http.Request) error {
+	f := req.URL.Query().Get("format")
+	if f != "" && f != "json" && f != "html" && f != "text" {
+		return invalidField("format", "Must be one of json, html, text, got %q", f)
+	}
+	settings, err := svr.DAO.SelectSettings(name)
+	if err != nil {
+		return err
+	}
+	d, err := digest.Build(svr.DAO, settings, time.Now())
+	if err != nil {
+		return err
+	}
+	preview, err := digest.Render(d)
+	if err != nil {
+		return err
+	}
+	switch f {
+	case "html":
+		resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+		resp.Write([]byte(preview.HTML))
+	case "text":
+		resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		resp.Write([]byte(preview.Text))
+	default:
+		respond(resp, req, http.StatusOK, &preview)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/bdlm/log"
+	"github.com/go-sql-driver/mysql"
+	"github.com/shale/go/data"
+	"github.com/shale/go/types"
+)
+
+//Machine-readable error codes returned in the code field of a problem response
+const (
+	CodeNotFound         = "not_found"
+	CodeValidation       = "validation_failed"
+	CodeConflict         = "conflict"
+	CodePrecondition     = "precondition_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeInternal         = "internal"
+	CodeQuotaExceeded    = "quota_exceeded"
+	CodeRateLimited      = "rate_limited"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeNotApplied       = "not_applied"
+)
+
+//problemContentType is the media type of RFC 7807 error bodies
+const problemContentType = "application/problem+json"
+
+//Error is an API error carrying a code from the error taxonomy and the HTTP status it maps to
+type Error struct {
+	Code    string
+	Status  int
+	Message string
+	Fields  []types.FieldError
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+//NotFound creates a not_found error
+func NotFound(format string, args ...interface{}) *Error {
+	return &Error{Code: CodeNotFound, Status: http.StatusNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+//Invalid creates a validation_failed error listing the problems with each field
+func Invalid(fields ...types.FieldError) *Error {
+	return &Error{Code: CodeValidation, Status: http.StatusBadRequest, Message: "The request is not valid", Fields: fields}
+}
+
+//invalidField creates a validation_failed error for a single field
+func invalidField(field string, format string, args ...interface{}) *Error {
+	return Invalid(types.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+//Conflict creates a conflict error
+func Conflict(format string, args ...interface{}) *Error {
+	return &Error{Code: CodeConflict, Status: http.StatusConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+//PreconditionFailed creates a precondition_failed error
+func PreconditionFailed(format string, args ...interface{}) *Error {
+	return &Error{Code: CodePrecondition, Status: http.StatusPreconditionFailed, Message: fmt.Sprintf(format, args...)}
+}
+
+//Unauthorized creates an unauthorized error
+func Unauthorized(format string, args ...interface{}) *Error {
+	return &Error{Code: CodeUnauthorized, Status: http.StatusUnauthorized, Message: fmt.Sprintf(format, args...)}
+}
+
+//internalError is the only detail given to clients for errors that are not part of the taxonomy
+var internalError = &Error{Code: CodeInternal, Status: http.StatusInternalServerError, Message: "An internal error occurred"}
+
+//classify maps any error onto the taxonomy.  Errors that cannot be classified become internal errors so that driver and SQL text never reach the client
+func classify(err error) *Error {
+	if quotaErr, ok := asQuotaError(err); ok {
+		return &Error{Code: CodeQuotaExceeded, Status: quotaErr.status(), Message: quotaErr.Error()}
+	}
+	switch e := err.(type) {
+	case *Error:
+		return e
+	case *mysql.MySQLError:
+		switch e.Number {
+		case 1062:
+			return Conflict("A todo item with the same key already exists")
+		case 1406:
+			return Invalid(types.FieldError{Message: "A value is too long"})
+		}
+	}
+	if err == data.ErrNotFound || err == sql.ErrNoRows {
+		return NotFound("Todo item not found")
+	}
+	switch err {
+	case data.ErrStale:
+		return PreconditionFailed("Todo item has been modified since it was read")
+	case data.ErrNothingToUndo:
+		return NotFound("There is nothing to undo")
+	case data.ErrNothingToRedo:
+		return NotFound("There is nothing to redo")
+	case data.ErrConflict:
+		return Conflict("A todo item has been changed since the operation; it can no longer be replayed")
+	}
+	return internalError
+}
+
+//respondError writes err as an application/problem+json body with the status of its code
+func respondError(resp http.ResponseWriter, req *http.Request, err error) {
+	problem := problemFor(req, err)
+	resp.Header().Del("ETag")
+	resp.Header().Del("Last-Modified")
+	resp.Header().Set("Content-Type", problemContentType)
+	respond(resp, req, problem.Status, problem)
+}
+
+//problemFor logs err and describes it as a problem body
+func problemFor(req *http.Request, err error) *types.Problem {
+	apiErr := classify(err)
+	id := requestID(req)
+	if apiErr.Code == CodeInternal {
+		log.Errorf("[%s] %s %s: %v", id, req.Method, req.URL.Path, err)
+	} else {
+		log.Warnf("[%s] %s %s: %v", id, req.Method, req.URL.Path, err)
+	}
+	problem := &types.Problem{
+		Type:      "urn:shale:error:" + apiErr.Code,
+		Title:     http.StatusText(apiErr.Status),
+		Status:    apiErr.Status,
+		Detail:    apiErr.Message,
+		Instance:  req.URL.Path,
+		Code:      apiErr.Code,
+		RequestID: id,
+		Errors:    apiErr.Fields,
+	}
+	if quotaErr, ok := asQuotaError(err); ok {
+		problem.Quota = quotaErr.Quota
+		problem.Limit = quotaErr.Limit
+		problem.Used = quotaErr.Used
+	}
+	return problem
+}
+
+//respondHTTPErr writes a problem for a bare HTTP status
+func respondHTTPErr(resp http.ResponseWriter, req *http.Request, status int) {
+	code := CodeValidation
+	switch status {
+	case http.StatusNotFound:
+		code = CodeNotFound
+	case http.StatusMethodNotAllowed:
+		code = CodeMethodNotAllowed
+	case http.StatusTooManyRequests:
+		code = CodeRateLimited
+	}
+	respondError(resp, req, &Error{Code: code, Status: status, Message: http.StatusText(status)})
+}
+
+type contextKey string
+
+//requestIDKey is the context key holding the request ID
+const requestIDKey contextKey = "request_id"
+
+//validRequestID limits which client-supplied request IDs are echoed back
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+//requestID returns the ID assigned to a request by the RequestID middleware
+func requestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Errorf("Error generating request id: %v", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+//RequestID is middleware that assigns every request an ID, taken from the X-Request-ID header when the client supplies a valid one
+func (svr *ServerType) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		resp.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), requestIDKey, id)))
+	})
+}
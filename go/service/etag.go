@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//todoETag returns the entity tag of a single todo item, which changes with every write to it
+func todoETag(todo types.TodoData) string {
+	return fmt.Sprintf(`"%d.%d"`, todo.ID, todo.Version)
+}
+
+//ifMatchVersion returns the version the request's If-Match header requires for the todo item with the given id.  It returns 0 when there is no condition to apply, and a precondition_failed error when no tag in the header can match the item
+func ifMatchVersion(req *http.Request, id int) (int, error) {
+	header := strings.TrimSpace(req.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") || len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			//Weak tags never match under the strong comparison If-Match requires
+			continue
+		}
+		parts := strings.Split(tag[1:len(tag)-1], ".")
+		if len(parts) != 2 || parts[0] != strconv.Itoa(id) {
+			continue
+		}
+		version, err := strconv.Atoi(parts[1])
+		if err == nil && version > 0 {
+			return version, nil
+		}
+	}
+	return 0, PreconditionFailed("If-Match does not match todo item %d", id)
+}
+
+//listETag returns the entity tag of an account's list endpoints, which changes with every write to the list
+func listETag(state types.ListState) string {
+	return fmt.Sprintf(`W/"list.%d"`, state.Seq)
+}
+
+//etagMatches reports whether any tag in an If-None-Match header matches etag under weak comparison
+func etagMatches(header string, etag string) bool {
+	opaque := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+//setValidators sets the caching headers that let clients make conditional requests
+func setValidators(resp http.ResponseWriter, etag string, modified time.Time) {
+	resp.Header().Set("Cache-Control", "private, no-cache")
+	resp.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		resp.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+//notModified reports whether the client already holds the current representation.  If-None-Match takes precedence over If-Modified-Since, as in RFC 7232
+func notModified(req *http.Request, etag string, modified time.Time) bool {
+	if header := req.Header.Get("If-None-Match"); header != "" {
+		return etagMatches(header, etag)
+	}
+	if header := req.Header.Get("If-Modified-Since"); header != "" && !modified.IsZero() {
+		since, err := http.ParseTime(header)
+		return err == nil && !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+//respondIfListUnchanged sets the list's validators and answers with 304 Not Modified when the client's copy is current.  It reports whether a response was written, so that the list need not be queried at all
+func (svr *ServerType) respondIfListUnchanged(name string, resp http.ResponseWriter, req *http.Request) (bool, error) {
+	state, err := svr.DAO.SelectListState(name)
+	if err != nil {
+		return false, err
+	}
+	etag := listETag(state)
+	setValidators(resp, etag, state.UpdatedAt)
+	if notModified(req, etag, state.UpdatedAt) {
+		resp.WriteHeader(http.StatusNotModified)
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+//sseRetry is the reconnection delay, in milliseconds, suggested to event stream clients
+const sseRetry = 3000
+
+//defaultHeartbeat is used when the server has no heartbeat interval configured
+const defaultHeartbeat = 15 * time.Second
+
+//eventReset tells an event stream client that it missed events and should reload the list
+const eventReset = "reset"
+
+//lastEventID reads the ID of the last event a reconnecting client saw, from the Last-Event-ID header or, for clients that cannot set headers, the last_event_id query parameter
+func lastEventID(req *http.Request) (int64, error) {
+	value := req.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = req.URL.Query().Get("last_event_id")
+	}
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, invalidField("Last-Event-ID", "Must be an event ID, got %q", value)
+	}
+	return id, nil
+}
+
+//writeEvent writes a single server-sent event
+func writeEvent(resp http.ResponseWriter, event types.Event) error {
+	data, err := json.Marshal(&event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(resp, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
+
+//StreamEvents streams the changes to the list as server-sent events until the client disconnects.  A client reconnecting with Last-Event-ID is sent the events it missed, or a reset event if they are no longer buffered
+func (svr *ServerType) StreamEvents(name string, resp http.ResponseWriter, req *http.Request) error {
+	if svr.Events == nil {
+		return NotFound("Event streams are not enabled")
+	}
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("response writer does not support streaming")
+	}
+	lastID, err := lastEventID(req)
+	if err != nil {
+		return err
+	}
+	sub, err := svr.Events.Subscribe(name, lastID)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("X-Accel-Buffering", "no")
+	resp.WriteHeader(http.StatusOK)
+	fmt.Fprintf(resp, "retry: %d\n\n", sseRetry)
+	if sub.Missed {
+		fmt.Fprintf(resp, "event: %s\ndata: {}\n\n", eventReset)
+	}
+	for _, event := range sub.Replay {
+		if err := writeEvent(resp, event); err != nil {
+			return nil
+		}
+		lastID = event.ID
+	}
+	flusher.Flush()
+
+	interval := svr.Heartbeat
+	if interval <= 0 {
+		interval = defaultHeartbeat
+	}
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(resp, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				//Dropped for falling behind; the client reconnects and resumes
+				return nil
+			}
+			if event.ID <= lastID {
+				continue
+			}
+			if err := writeEvent(resp, event); err != nil {
+				return nil
+			}
+			lastID = event.ID
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+//fakeAnswer returns the rows of a query given its arguments
+type fakeAnswer func(args []driver.Value) [][]driver.Value
+
+//fakeDB answers queries containing each of its keys, so handlers can be tested without MySQL.  Any other query or statement fails
+type fakeDB struct {
+	answers map[string]fakeAnswer
+
+	mu    sync.Mutex
+	execs []string
+}
+
+//exec records a statement that was run
+func (db *fakeDB) exec(query string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.execs = append(db.execs, query)
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = make(map[string]*fakeDB)
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+//openFakeDB opens a database whose queries are answered by db
+func openFakeDB(db *fakeDB) *sql.DB {
+	fakeDBsMu.Lock()
+	dsn := fmt.Sprintf("fake%d", len(fakeDBs))
+	fakeDBs[dsn] = db
+	fakeDBsMu.Unlock()
+	conn, err := sql.Open("fakedb", dsn)
+	if err != nil {
+		panic(err)
+	}
+	return conn
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	db, ok := fakeDBs[dsn]
+	if !ok {
+		return nil, fmt.Errorf("no fake database %q", dsn)
+	}
+	return &fakeConn{db: db}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fakedb: transactions are not supported")
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.exec(s.query)
+	return nil, fmt.Errorf("fakedb: unexpected statement %q", s.query)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	for key, answer := range s.db.answers {
+		if strings.Contains(s.query, key) {
+			return &fakeRows{rows: answer(args)}, nil
+		}
+	}
+	return nil, fmt.Errorf("fakedb: unexpected query %q", s.query)
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/bdlm/log"
+)
+
+//maxIdempotentBody caps the request body buffered to fingerprint a request
+const maxIdempotentBody = 1 << 20
+
+//keyLen is the size of the idem_key column in mysql/sys.sql
+const keyLen = 255
+
+//CodeKeyReused is returned when an idempotency key is sent again with a different request
+const CodeKeyReused = "idempotency_key_reused"
+
+//replayedHeaders are the response headers stored with an idempotent response and replayed with it
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+//recorder passes a response through to the client while keeping a copy of it
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+//fingerprint identifies a request by its method, path, query, If-Match precondition, and body
+func fingerprint(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(req.Method + "\n" + req.URL.Path + "\n" + req.URL.RawQuery + "\n" + req.Header.Get("If-Match") + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+//Idempotent is middleware that makes mutating requests carrying an Idempotency-Key safe to retry.  The first request with a key runs normally and its response is stored; repeats of the same request replay that response, and reuse of the key for a different request is rejected
+func (svr *ServerType) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get("Idempotency-Key")
+		if key == "" || req.Method == "GET" || req.Method == "HEAD" || req.Method == "OPTIONS" {
+			next.ServeHTTP(resp, req)
+			return
+		}
+		if len(key) > keyLen {
+			respondError(resp, req, invalidField("Idempotency-Key", "Must be at most %d characters", keyLen))
+			return
+		}
+		name := principal(req)
+		body, err := ioutil.ReadAll(http.MaxBytesReader(resp, req.Body, maxIdempotentBody))
+		req.Body.Close()
+		if err != nil {
+			respondError(resp, req, invalidField("body", "Could not be read: %v", err))
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		fp := fingerprint(req, body)
+		rec, claimed, err := svr.DAO.ClaimIdempotencyKey(name, key, fp, svr.IdempotencyTTL)
+		if err != nil {
+			respondError(resp, req, err)
+			return
+		}
+		if !claimed {
+			switch {
+			case rec.Key == "":
+				respondError(resp, req, Conflict("The request with this Idempotency-Key failed and was released; retry it"))
+			case rec.Fingerprint != fp:
+				respondError(resp, req, &Error{
+					Code:    CodeKeyReused,
+					Status:  http.StatusUnprocessableEntity,
+					Message: "Idempotency-Key has already been used for a different request",
+				})
+			case rec.Status == 0:
+				respondError(resp, req, Conflict("A request with this Idempotency-Key is still in progress"))
+			default:
+				for field, value := range rec.Header {
+					resp.Header().Set(field, value)
+				}
+				resp.Header().Set("Idempotent-Replayed", "true")
+				resp.WriteHeader(rec.Status)
+				resp.Write(rec.Body)
+			}
+			return
+		}
+
+		out := &recorder{ResponseWriter: resp}
+		next.ServeHTTP(out, req)
+		if out.status == 0 {
+			out.status = http.StatusOK
+		}
+		if out.status >= 500 {
+			//Server errors are not stored, so the client's retry gets another attempt
+			svr.DAO.ReleaseIdempotencyKey(name, key)
+			return
+		}
+		header := make(map[string]string)
+		for _, field := range replayedHeaders {
+			if value := resp.Header().Get(field); value != "" {
+				header[field] = value
+			}
+		}
+		if err := svr.DAO.SaveIdempotentResponse(name, key, out.status, header, out.body.Bytes()); err != nil {
+			log.Errorf("[%s] Response for Idempotency-Key %q was not stored: %v", requestID(req), key, err)
+		}
+	})
+}
+
+//PurgeIdempotencyKeys removes expired idempotency keys every interval, until the process exits
+func (svr *ServerType) PurgeIdempotencyKeys(interval time.Duration) {
+	for range time.Tick(interval) {
+		purged, err := svr.DAO.PurgeIdempotencyKeys()
+		if err == nil && purged > 0 {
+			log.Infof("Purged %d expired idempotency keys", purged)
+		}
+	}
+}
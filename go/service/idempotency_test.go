@@ -0,0 +1,35 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	base := httptest.NewRequest("POST", "/todo/tom/add", nil)
+	body := []byte(`{"title": "Buy milk"}`)
+	fp := fingerprint(base, body)
+	if again := fingerprint(httptest.NewRequest("POST", "/todo/tom/add", nil), []byte(`{"title": "Buy milk"}`)); again != fp {
+		t.Errorf("the same request gave a different fingerprint")
+	}
+
+	ifMatch := httptest.NewRequest("POST", "/todo/tom/add", nil)
+	ifMatch.Header.Set("If-Match", `"3"`)
+	otherIfMatch := httptest.NewRequest("POST", "/todo/tom/add", nil)
+	otherIfMatch.Header.Set("If-Match", `"4"`)
+	differing := map[string]string{
+		"method":   fingerprint(httptest.NewRequest("DELETE", "/todo/tom/add", nil), body),
+		"path":     fingerprint(httptest.NewRequest("POST", "/todo/ann/add", nil), body),
+		"query":    fingerprint(httptest.NewRequest("POST", "/todo/tom/add?dry_run=true", nil), body),
+		"if-match": fingerprint(ifMatch, body),
+		"body":     fingerprint(base, []byte(`{"title": "Buy eggs"}`)),
+	}
+	for what, other := range differing {
+		if other == fp {
+			t.Errorf("changing the %s did not change the fingerprint", what)
+		}
+	}
+	if fingerprint(ifMatch, body) == fingerprint(otherIfMatch, body) {
+		t.Errorf("different If-Match values gave the same fingerprint")
+	}
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/data"
+	"github.com/shale/go/inbound"
+	"github.com/shale/go/types"
+)
+
+//inboundTokenBytes is the number of random bytes in an inbound email token, few enough that the address fits the 64 character limit on its local part
+const inboundTokenBytes = 16
+
+//noSubject is the title of todo items created from mail without a subject
+const noSubject = "(no subject)"
+
+//checkInboundEnabled fails if the server has no domain for inbound addresses
+func (svr *ServerType) checkInboundEnabled() error {
+	if svr.InboundDomain == "" {
+		return NotFound("Inbound email is not enabled")
+	}
+	return nil
+}
+
+//IssueInboundAddress creates a secret email address that adds the mail sent to it to the list.  Any earlier address stops working
+func (svr *ServerType) IssueInboundAddress(name string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.checkInboundEnabled(); err != nil {
+		return err
+	}
+	buf := make([]byte, inboundTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(buf)
+	if err := svr.DAO.SetInboundToken(name, token); err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusCreated, &types.InboundAddress{
+		Address: token + "@" + svr.InboundDomain,
+		Token:   token,
+	})
+	return nil
+}
+
+//RevokeInboundAddress stops the list's inbound email address from working
+func (svr *ServerType) RevokeInboundAddress(name string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.checkInboundEnabled(); err != nil {
+		return err
+	}
+	if err := svr.DAO.DeleteInboundToken(name); err != nil {
+		if err == data.ErrNotFound {
+			return NotFound("The list has no inbound address")
+		}
+		return err
+	}
+	respond(resp, req, http.StatusOK, &types.ListStatus{
+		Status:   "Success",
+		Info:     "Inbound address revoked",
+		Affected: 1,
+	})
+	return nil
+}
+
+//HandleInbound serves POST /inbound, which takes a raw RFC 5322 message and adds it to the list of the inbound address it was sent to.  The address is the "to" query parameter or, without one, the first inbound address in the message's headers
+func (svr *ServerType) HandleInbound(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		resp.Header().Set("Allow", "POST")
+		respondHTTPErr(resp, req, http.StatusMethodNotAllowed)
+		return
+	}
+	if err := svr.receiveInbound(resp, req); err != nil {
+		respondError(resp, req, err)
+	}
+}
+
+//receiveInbound creates a todo item from the message posted to /inbound
+func (svr *ServerType) receiveInbound(resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.checkInboundEnabled(); err != nil {
+		return err
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(req.Body, svr.InboundMaxBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(raw)) > svr.InboundMaxBytes {
+		return &Error{Code: CodeValidation, Status: http.StatusRequestEntityTooLarge, Message: "Message is too big"}
+	}
+	msg, err := inbound.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return invalidField("message", "Malformed message: %v", err)
+	}
+	recipients := msg.Recipients
+	if to := req.URL.Query().Get("to"); to != "" {
+		recipients = []string{to}
+	}
+	name, tag, err := svr.firstInboundOwner(recipients)
+	if err != nil {
+		return err
+	}
+	created, err := svr.addFromMail(name, tag, msg, svr.DAO.As(mailActor(msg.From), requestID(req)))
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("Location", todoPath(name, created.ID))
+	resp.Header().Set("ETag", todoETag(created))
+	respond(resp, req, http.StatusCreated, &created)
+	return nil
+}
+
+//firstInboundOwner returns the list and tag of the first inbound address among recipients
+func (svr *ServerType) firstInboundOwner(recipients []string) (string, string, error) {
+	for _, rcpt := range recipients {
+		name, tag, err := svr.inboundOwner(rcpt)
+		if err == nil {
+			return name, tag, nil
+		}
+		if classify(err).Code != CodeNotFound {
+			return "", "", err
+		}
+	}
+	return "", "", NotFound("No inbound address among the recipients")
+}
+
+//inboundOwner returns the list an inbound address adds to, and the tag after the "+" in it, which names a category
+func (svr *ServerType) inboundOwner(address string) (string, string, error) {
+	token, tag, domain, err := inbound.SplitAddress(address)
+	if err != nil || !strings.EqualFold(domain, svr.InboundDomain) {
+		return "", "", NotFound("No such inbound address")
+	}
+	name, err := svr.DAO.SelectInboundOwner(token)
+	if err == data.ErrNotFound {
+		return "", "", NotFound("No such inbound address")
+	}
+	return name, tag, err
+}
+
+//addFromMail adds a message to a list: the subject, less any priority token, is the title and the text is the body, both cut to fit
+func (svr *ServerType) addFromMail(name string, category string, msg *inbound.Message, store *data.StoreType) (types.TodoData, error) {
+	if n := utf8.RuneCountInString(category); n > categoryLen {
+		return types.TodoData{}, invalidField("category", "Must be at most %d characters, got %d", categoryLen, n)
+	}
+	title, priority, _ := inbound.ParseSubject(msg.Subject)
+	if title == "" {
+		title = noSubject
+	}
+	todo := types.TodoData{
+		Name:     name,
+		Title:    truncate(title, titleLen),
+		Body:     truncate(msg.Text, bodyLen),
+		Category: category,
+		Priority: priority,
+		Active:   true,
+	}
+	if err := svr.checkTodoQuota(name, todo); err != nil {
+		return todo, err
+	}
+	return store.InsertTodo(todo)
+}
+
+//truncate cuts s to at most n characters
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return strings.TrimSpace(string([]rune(s)[:n]))
+}
+
+//mailActor names the sender of a message in the audit log
+func mailActor(from string) string {
+	if from == "" {
+		return "mail"
+	}
+	return "mail:" + from
+}
+
+//InboundMail returns the handler an SMTP server passes mail to
+func (svr *ServerType) InboundMail() inbound.Handler {
+	return inboundMail{svr: svr}
+}
+
+//inboundMail adds the mail an SMTP server receives to lists
+type inboundMail struct {
+	svr *ServerType
+}
+
+//Accept refuses mail for anything but a current inbound address
+func (m inboundMail) Accept(rcpt string) error {
+	_, _, err := m.svr.inboundOwner(rcpt)
+	if err != nil && classify(err).Code == CodeNotFound {
+		return &inbound.SMTPError{Code: 550, Message: "5.1.1 No such mailbox"}
+	}
+	return err
+}
+
+//Deliver adds a message to the list of the address it was sent to.  Messages that could never be added are rejected; other failures are left for the sender to retry
+func (m inboundMail) Deliver(from string, rcpt string, raw []byte) error {
+	name, tag, err := m.svr.inboundOwner(rcpt)
+	if err != nil {
+		return err
+	}
+	msg, err := inbound.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return &inbound.SMTPError{Code: 554, Message: "5.6.0 Malformed message"}
+	}
+	if msg.From == "" {
+		msg.From = from
+	}
+	created, err := m.svr.addFromMail(name, tag, msg, m.svr.DAO.As(mailActor(msg.From), newRequestID()))
+	if err != nil {
+		switch apiErr := classify(err); apiErr.Code {
+		case CodeQuotaExceeded:
+			return &inbound.SMTPError{Code: 552, Message: "5.2.2 " + apiErr.Message}
+		case CodeValidation:
+			return &inbound.SMTPError{Code: 554, Message: "5.6.0 " + apiErr.Message}
+		}
+		return err
+	}
+	log.Infof("Added todo item %d to %s from mail by %s", created.ID, name, msg.From)
+	return nil
+}
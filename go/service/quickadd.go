@@ -0,0 +1,71 @@
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/shale/go/quickadd"
+	"github.com/shale/go/types"
+)
+
+//QuickAdd adds a todo item described by one line of text, such as "Buy milk tomorrow 5pm #shopping !2 every week", with dates read in the account's timezone.  It responds with what the line parsed to as well as the item added.  With ?dry_run=true it only parses the line
+func (svr *ServerType) QuickAdd(name string, resp http.ResponseWriter, req *http.Request) error {
+	var raw struct {
+		Text *string `json:"text"`
+	}
+	if err := decodeBody(req, &raw); err != nil {
+		return err
+	}
+	if raw.Text == nil {
+		return invalidField("text", "Is required")
+	}
+	dryRun, err := dryRunParam(req)
+	if err != nil {
+		return err
+	}
+	settings, err := svr.DAO.SelectSettings(name)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	parsed, err := quickadd.Parse(*raw.Text, time.Now().In(loc))
+	if err == quickadd.ErrNoTitle {
+		return invalidField("text", "Must leave a title once the date, tags, priority, and recurrence are taken out")
+	}
+	if err != nil {
+		return err
+	}
+	todo := types.TodoData{
+		Name:       name,
+		Title:      parsed.Title,
+		Category:   parsed.Category,
+		Tags:       parsed.Tags,
+		Active:     true,
+		Due:        parsed.Due,
+		Recurrence: parsed.Recurrence,
+	}
+	if parsed.Priority != nil {
+		todo.Priority = *parsed.Priority
+	}
+	if problems := checkTodo(addSpec, todo); len(problems) > 0 {
+		return Invalid(problems...)
+	}
+	if dryRun {
+		respond(resp, req, http.StatusOK, &types.QuickAddResult{Parsed: parsed, DryRun: true})
+		return nil
+	}
+	if err := svr.checkTodoQuota(name, todo); err != nil {
+		return err
+	}
+	created, err := svr.store(req).InsertTodo(todo)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("Location", todoPath(name, created.ID))
+	resp.Header().Set("ETag", todoETag(created))
+	respond(resp, req, http.StatusCreated, &types.QuickAddResult{Parsed: parsed, Todo: &created})
+	return nil
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/shale/go/data"
+	"github.com/shale/go/types"
+)
+
+//Names of the quotas reported in quota_exceeded errors
+const (
+	QuotaMaxTodos     = "max_todos"
+	QuotaMaxBodyBytes = "max_body_bytes"
+	QuotaMaxTags      = "max_tags"
+)
+
+//QuotaError is returned when a write would take an account past one of its quotas
+type QuotaError struct {
+	Quota string
+	Limit int64
+	Used  int64
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("quota %s exceeded: %d of %d", e.Quota, e.Used, e.Limit)
+}
+
+//status picks the HTTP status for the exceeded quota
+func (e *QuotaError) status() int {
+	if e.Quota == QuotaMaxBodyBytes {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusForbidden
+}
+
+//quotaFor returns the quotas for an account, using the server defaults for any limit the account does not override
+func (svr *ServerType) quotaFor(name string) (types.Quota, error) {
+	quota, err := svr.DAO.SelectQuota(name)
+	if err != nil {
+		return quota, err
+	}
+	if quota.MaxTodos == 0 {
+		quota.MaxTodos = svr.Quotas.MaxTodos
+	}
+	if quota.MaxBodyBytes == 0 {
+		quota.MaxBodyBytes = svr.Quotas.MaxBodyBytes
+	}
+	if quota.MaxTags == 0 {
+		quota.MaxTags = svr.Quotas.MaxTags
+	}
+	return quota, nil
+}
+
+//checkBodyQuota buffers the request body, failing if it is larger than the account allows
+func (svr *ServerType) checkBodyQuota(name string, req *http.Request) error {
+	quota, err := svr.quotaFor(name)
+	if err != nil {
+		return err
+	}
+	if quota.MaxBodyBytes <= 0 || req.Body == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, quota.MaxBodyBytes+1))
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > quota.MaxBodyBytes {
+		return &QuotaError{Quota: QuotaMaxBodyBytes, Limit: quota.MaxBodyBytes, Used: int64(len(body))}
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+//asQuotaError returns the quota that err reports exceeding, if any.  The todo count is checked again by the data layer inside the transaction that adds the items, so that concurrent adds cannot all pass it
+func asQuotaError(err error) (*QuotaError, bool) {
+	switch e := err.(type) {
+	case *QuotaError:
+		return e, true
+	case *data.TodoLimitError:
+		return &QuotaError{Quota: QuotaMaxTodos, Limit: int64(e.Limit), Used: int64(e.Used)}, true
+	}
+	return nil, false
+}
+
+//checkTodoQuota fails early if adding the todo item would exceed the account's todo count or tag limits
+func (svr *ServerType) checkTodoQuota(name string, todo types.TodoData) error {
+	quota, err := svr.quotaFor(name)
+	if err != nil {
+		return err
+	}
+	if err := checkTags(quota, todo.Tags); err != nil {
+		return err
+	}
+	if quota.MaxTodos > 0 {
+		count, err := svr.DAO.CountTodos(name)
+		if err != nil {
+			return err
+		}
+		if count >= quota.MaxTodos {
+			return &QuotaError{Quota: QuotaMaxTodos, Limit: int64(quota.MaxTodos), Used: int64(count)}
+		}
+	}
+	return nil
+}
+
+//checkTags fails if a todo item would carry more tags than the quota allows
+func checkTags(quota types.Quota, tags []string) error {
+	if quota.MaxTags > 0 && len(tags) > quota.MaxTags {
+		return &QuotaError{Quota: QuotaMaxTags, Limit: int64(quota.MaxTags), Used: int64(len(tags))}
+	}
+	return nil
+}
+
+//GetUsage returns the account's current usage against its quotas
+func (svr *ServerType) GetUsage(name string, resp http.ResponseWriter, req *http.Request) error {
+	quota, err := svr.quotaFor(name)
+	if err != nil {
+		return err
+	}
+	count, err := svr.DAO.CountTodos(name)
+	if err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusOK, &types.Usage{
+		Name:         name,
+		Todos:        types.UsageItem{Used: int64(count), Limit: int64(quota.MaxTodos)},
+		MaxBodyBytes: quota.MaxBodyBytes,
+		MaxTags:      quota.MaxTags,
+	})
+	return nil
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Route classes used to pick a rate limit for a request
+const (
+	ClassRead   = "read"
+	ClassWrite  = "write"
+	ClassDelete = "delete"
+)
+
+//bucketIdle is how long an untouched bucket is kept before being swept
+const bucketIdle = 10 * time.Minute
+
+//Limit describes a token bucket that refills at Rate tokens per second and holds at most Burst tokens.  A zero Rate disables limiting
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+//RateLimits holds the limit applied to each route class
+type RateLimits struct {
+	Read   Limit
+	Write  Limit
+	Delete Limit
+}
+
+//ParseLimit parses a limit of the form "<requests>/<s|m|h>" with an optional ":<burst>", e.g. "10/s" or "300/m:50".  The burst defaults to the request count
+func ParseLimit(value string) (Limit, error) {
+	var limit Limit
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" {
+		return limit, nil
+	}
+	spec, burst := value, ""
+	if i := strings.Index(value, ":"); i >= 0 {
+		spec, burst = value[:i], value[i+1:]
+	}
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return limit, fmt.Errorf("invalid rate limit %q", value)
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count < 0 {
+		return limit, fmt.Errorf("invalid request count in rate limit %q", value)
+	}
+	var period time.Duration
+	switch parts[1] {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return limit, fmt.Errorf("invalid period in rate limit %q", value)
+	}
+	limit.Rate = float64(count) / period.Seconds()
+	limit.Burst = count
+	if burst != "" {
+		limit.Burst, err = strconv.Atoi(burst)
+		if err != nil || limit.Burst < 1 {
+			return limit, fmt.Errorf("invalid burst in rate limit %q", value)
+		}
+	}
+	return limit, nil
+}
+
+//bucket is the token state for a single principal or client address
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+//RateLimiter enforces token-bucket limits per account and per client IP
+type RateLimiter struct {
+	limits    RateLimits
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+//NewRateLimiter creates a rate limiter with the given limits
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	return &RateLimiter{
+		limits:    limits,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+//decision is the outcome of checking a request against its buckets
+type decision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+//routeClass maps a request onto the read, write, or delete limit
+func routeClass(req *http.Request) string {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "PROPFIND", "REPORT":
+		return ClassRead
+	case "DELETE":
+		return ClassDelete
+	default:
+		return ClassWrite
+	}
+}
+
+func (rl *RateLimiter) limitFor(class string) Limit {
+	switch class {
+	case ClassRead:
+		return rl.limits.Read
+	case ClassDelete:
+		return rl.limits.Delete
+	default:
+		return rl.limits.Write
+	}
+}
+
+//refill brings a bucket up to date and returns it, creating a full bucket if none exists
+func (rl *RateLimiter) refill(key string, limit Limit, now time.Time) *bucket {
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		rl.buckets[key] = b
+		return b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	return b
+}
+
+//allow takes a token from every key's bucket, but only if all of them have one to give
+func (rl *RateLimiter) allow(class string, keys []string, now time.Time) decision {
+	limit := rl.limitFor(class)
+	if limit.Rate <= 0 {
+		return decision{allowed: true}
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.sweep(now)
+
+	dec := decision{allowed: true, limit: limit.Burst, remaining: limit.Burst}
+	buckets := make([]*bucket, 0, len(keys))
+	for _, key := range keys {
+		b := rl.refill(class+"|"+key, limit, now)
+		buckets = append(buckets, b)
+		if b.tokens < 1 {
+			dec.allowed = false
+			wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+			if wait > dec.retryAfter {
+				dec.retryAfter = wait
+			}
+		}
+	}
+	for _, b := range buckets {
+		if dec.allowed {
+			b.tokens--
+		}
+		if remaining := int(b.tokens); remaining < dec.remaining {
+			dec.remaining = remaining
+		}
+		reset := time.Duration((float64(limit.Burst) - b.tokens) / limit.Rate * float64(time.Second))
+		if reset > dec.reset {
+			dec.reset = reset
+		}
+	}
+	return dec
+}
+
+//sweep drops buckets that have not been used recently.  Callers must hold the lock
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < bucketIdle {
+		return
+	}
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) > bucketIdle {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+//clientIP returns the address of the remote end of the connection
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+//principal returns the account a request acts on, which is the username segment of /todo/<username>/... and /caldav/<username>/...
+func principal(req *http.Request) string {
+	pathArgs := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(pathArgs) < 2 {
+		return ""
+	}
+	return pathArgs[1]
+}
+
+//seconds rounds a duration up to whole seconds for use in headers
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+//RateLimit is middleware that rejects requests exceeding the limits of their route class with a 429
+func (svr *ServerType) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if svr.Limiter == nil {
+			next.ServeHTTP(resp, req)
+			return
+		}
+		keys := []string{"ip:" + clientIP(req)}
+		if name := principal(req); name != "" {
+			keys = append(keys, "user:"+name)
+		}
+		dec := svr.Limiter.allow(routeClass(req), keys, time.Now())
+		if dec.limit > 0 {
+			resp.Header().Set("RateLimit-Limit", strconv.Itoa(dec.limit))
+			resp.Header().Set("RateLimit-Remaining", strconv.Itoa(dec.remaining))
+			resp.Header().Set("RateLimit-Reset", seconds(dec.reset))
+		}
+		if !dec.allowed {
+			resp.Header().Set("Retry-After", seconds(dec.retryAfter))
+			respondError(resp, req, &Error{
+				Code:    CodeRateLimited,
+				Status:  http.StatusTooManyRequests,
+				Message: fmt.Sprintf("Rate limit exceeded, retry in %s seconds", seconds(dec.retryAfter)),
+			})
+			return
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Limit
+		err   bool
+	}{
+		{value: "", want: Limit{}},
+		{value: "0", want: Limit{}},
+		{value: "10/s", want: Limit{Rate: 10, Burst: 10}},
+		{value: " 300/m:50 ", want: Limit{Rate: 5, Burst: 50}},
+		{value: "3600/h", want: Limit{Rate: 1, Burst: 3600}},
+		{value: "10", err: true},
+		{value: "10/d", err: true},
+		{value: "x/s", err: true},
+		{value: "-1/s", err: true},
+		{value: "10/s:0", err: true},
+		{value: "10/s:x", err: true},
+	}
+	for _, test := range tests {
+		got, err := ParseLimit(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("ParseLimit(%q) = %+v, want an error", test.value, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ParseLimit(%q) = %+v, %v, want %+v", test.value, got, err, test.want)
+		}
+	}
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	rl := NewRateLimiter(RateLimits{Write: Limit{Rate: 2, Burst: 3}})
+	now := time.Now()
+	keys := []string{"user:tom"}
+	for i := 0; i < 3; i++ {
+		if dec := rl.allow(ClassWrite, keys, now); !dec.allowed || dec.remaining != 2-i {
+			t.Fatalf("request %d: got %+v, want allowed with %d remaining", i+1, dec, 2-i)
+		}
+	}
+	dec := rl.allow(ClassWrite, keys, now)
+	if dec.allowed {
+		t.Fatalf("request 4 was allowed past the burst")
+	}
+	if dec.retryAfter != 500*time.Millisecond {
+		t.Errorf("retryAfter = %v, want 500ms", dec.retryAfter)
+	}
+	if dec := rl.allow(ClassWrite, keys, now.Add(500*time.Millisecond)); !dec.allowed {
+		t.Errorf("request after refilling one token was refused")
+	}
+	if dec := rl.allow(ClassWrite, keys, now.Add(time.Hour)); !dec.allowed || dec.remaining != 2 {
+		t.Errorf("bucket did not refill to its burst: %+v", dec)
+	}
+}
+
+func TestRateLimiterAllKeysMustHaveTokens(t *testing.T) {
+	rl := NewRateLimiter(RateLimits{Write: Limit{Rate: 1, Burst: 1}})
+	now := time.Now()
+	if dec := rl.allow(ClassWrite, []string{"user:tom", "ip:10.0.0.1"}, now); !dec.allowed {
+		t.Fatalf("first request refused")
+	}
+	if dec := rl.allow(ClassWrite, []string{"user:ann", "ip:10.0.0.1"}, now); dec.allowed {
+		t.Fatalf("request from a drained IP was allowed")
+	}
+	//The refused request must not have taken ann's token
+	if dec := rl.allow(ClassWrite, []string{"user:ann", "ip:10.0.0.2"}, now); !dec.allowed {
+		t.Errorf("refused request spent a token")
+	}
+}
+
+func TestRateLimiterClassesAndDisabled(t *testing.T) {
+	rl := NewRateLimiter(RateLimits{Write: Limit{Rate: 1, Burst: 1}, Delete: Limit{Rate: 1, Burst: 1}})
+	now := time.Now()
+	keys := []string{"user:tom"}
+	rl.allow(ClassWrite, keys, now)
+	if dec := rl.allow(ClassDelete, keys, now); !dec.allowed {
+		t.Errorf("delete shared the write bucket")
+	}
+	for i := 0; i < 100; i++ {
+		if dec := rl.allow(ClassRead, keys, now); !dec.allowed {
+			t.Fatalf("read refused with reads unlimited")
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	rl := NewRateLimiter(RateLimits{Write: Limit{Rate: 1, Burst: 1}})
+	now := time.Now()
+	rl.allow(ClassWrite, []string{"user:tom"}, now)
+	rl.allow(ClassWrite, []string{"user:ann"}, now.Add(2*bucketIdle))
+	if _, ok := rl.buckets[ClassWrite+"|user:tom"]; ok {
+		t.Errorf("idle bucket was not swept")
+	}
+	if len(rl.buckets) != 1 {
+		t.Errorf("got %d buckets, want 1", len(rl.buckets))
+	}
+}
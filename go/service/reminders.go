@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/shale/go/notify"
+	"github.com/shale/go/types"
+)
+
+//Limits on settings and snoozes
+const (
+	emailLen      = 254
+	maxSnoozeMins = 366 * 24 * 60
+)
+
+//GetSettings returns the account's settings
+func (svr *ServerType) GetSettings(name string, resp http.ResponseWriter, req *http.Request) error {
+	settings, err := svr.DAO.SelectSettings(name)
+	if err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusOK, &settings)
+	return nil
+}
+
+//UpdateSettings changes the account's timezone, quiet hours, email address, or digest.  An empty email address turns off email reminders and digests, and empty quiet hours turn them off
+func (svr *ServerType) UpdateSettings(name string, resp http.ResponseWriter, req *http.Request) error {
+	var update types.SettingsUpdate
+	if err := decodeBody(req, &update); err != nil {
+		return err
+	}
+	if update == (types.SettingsUpdate{}) {
+		return invalidField("body", "Must set at least one field")
+	}
+	if update.Timezone != nil {
+		if *update.Timezone == "" || *update.Timezone == "Local" {
+			return invalidField("timezone", "Must be an IANA timezone name, got %q", *update.Timezone)
+		}
+		if _, err := time.LoadLocation(*update.Timezone); err != nil {
+			return invalidField("timezone", "Must be an IANA timezone name, got %q", *update.Timezone)
+		}
+	}
+	for field, value := range map[string]*string{"quiet_start": update.QuietStart, "quiet_end": update.QuietEnd} {
+		if value == nil || *value == "" {
+			continue
+		}
+		if _, ok := notify.ParseClock(*value); !ok {
+			return invalidField(field, "Must be a time of day written HH:MM, got %q", *value)
+		}
+	}
+	if update.DigestTime != nil {
+		if _, ok := notify.ParseClock(*update.DigestTime); !ok {
+			return invalidField("digest_time", "Must be a time of day written HH:MM, got %q", *update.DigestTime)
+		}
+	}
+	if update.DigestPriority != nil && (*update.DigestPriority < 1 || *update.DigestPriority > math.MaxInt32) {
+		return invalidField("digest_priority", "Must be between 1 and %d, got %d", math.MaxInt32, *update.DigestPriority)
+	}
+	if update.Email != nil && *update.Email != "" {
+		if len(*update.Email) > emailLen {
+			return invalidField("email", "Must be at most %d characters, got %d", emailLen, len(*update.Email))
+		}
+		address, err := mail.ParseAddress(*update.Email)
+		if err != nil || address.Name != "" {
+			return invalidField("email", "Must be an email address, got %q", *update.Email)
+		}
+	}
+	settings, err := svr.DAO.UpdateSettings(name, update)
+	if err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusOK, &settings)
+	return nil
+}
+
+//Snooze moves the reminder of a todo item to a later time, given as "until" or as "minutes" from now
+func (svr *ServerType) Snooze(id int, name string, resp http.ResponseWriter, req *http.Request) error {
+	var raw struct {
+		Until   *time.Time `json:"until"`
+		Minutes *int       `json:"minutes"`
+	}
+	if err := decodeBody(req, &raw); err != nil {
+		return err
+	}
+	now := time.Now()
+	var until time.Time
+	switch {
+	case raw.Until != nil && raw.Minutes != nil:
+		return invalidField("body", "Must set one of until or minutes, not both")
+	case raw.Until != nil:
+		until = *raw.Until
+		if !until.After(now) {
+			return invalidField("until", "Must be in the future, got %s", until.Format(time.RFC3339))
+		}
+	case raw.Minutes != nil:
+		if *raw.Minutes < 1 || *raw.Minutes > maxSnoozeMins {
+			return invalidField("minutes", "Must be between 1 and %d, got %d", maxSnoozeMins, *raw.Minutes)
+		}
+		until = now.Add(time.Duration(*raw.Minutes) * time.Minute)
+	default:
+		return invalidField("body", "Must set until or minutes")
+	}
+	//Reminders are stored to the second
+	until = until.Truncate(time.Second)
+
+	version, err := ifMatchVersion(req, id)
+	if err != nil {
+		return err
+	}
+	updated, err := svr.store(req).PatchByID(id, types.TodoPatch{RemindAt: types.PatchTime{Set: true, Value: &until}}, name, version)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("ETag", todoETag(updated))
+	respond(resp, req, http.StatusOK, &updated)
+	return nil
+}
+
+//Dismiss stops the current reminder of a todo item from being sent.  Setting a new reminder or due date sets a new reminder
+func (svr *ServerType) Dismiss(id int, name string, resp http.ResponseWriter, req *http.Request) error {
+	todo, err := svr.DAO.SelectByID(id, name)
+	if err != nil {
+		return err
+	}
+	fireAt, ok := todo.ReminderTime()
+	if !ok {
+		return Conflict("Todo item %d has no reminder", id)
+	}
+	if err := svr.DAO.DismissReminder(types.Reminder{Todo: todo, FireAt: fireAt}); err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusOK, &types.ListStatus{
+		Status:   "Success",
+		Info:     fmt.Sprintf("Reminder for todo with '%d' id at %s dismissed", id, fireAt.UTC().Format(time.RFC3339)),
+		Affected: 1,
+	})
+	return nil
+}
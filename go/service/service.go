@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/bdlm/log"
 	"github.com/shale/go/data"
+	"github.com/shale/go/events"
 	"github.com/shale/go/types"
 )
 
@@ -33,7 +35,28 @@ type Server interface {
 
 //ServerType is the server object with db connection
 type ServerType struct {
-	DAO *data.StoreType
+	DAO     *data.StoreType
+	Limiter *RateLimiter
+	Quotas  types.Quota
+
+	IdempotencyTTL time.Duration
+
+	//AdminToken is the bearer token required by the admin API, which is disabled when it is empty
+	AdminToken string
+
+	//Events is the broker event streams subscribe to, and Heartbeat how often an idle stream is sent a comment to keep it open
+	Events    events.Broker
+	Heartbeat time.Duration
+
+	//WebhookAllowPrivate lets webhooks post to loopback, link-local, and private addresses, for testing against a local receiver
+	WebhookAllowPrivate bool
+
+	//SocketOrigins lists the origins besides the server's own that browsers may open Live Editing WebSockets from
+	SocketOrigins []string
+
+	//InboundDomain is the domain of the secret addresses that add mail to lists, which is disabled when it is empty.  InboundMaxBytes caps the size of a message
+	InboundDomain   string
+	InboundMaxBytes int64
 }
 
 func encodeBody(resp http.ResponseWriter, req *http.Request, data interface{}) error {
@@ -41,157 +64,308 @@ func encodeBody(resp http.ResponseWriter, req *http.Request, data interface{}) e
 }
 
 func decodeBody(req *http.Request, data interface{}) error {
-	defer req.Body.Close()
-	return json.NewDecoder(req.Body).Decode(data)
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	if err := decodeStrict(body, data); err != nil {
+		return invalidField("body", "Malformed JSON body: %v", err)
+	}
+	return nil
 }
 
 func respond(resp http.ResponseWriter, req *http.Request, status int, data interface{}) {
+	if data != nil && resp.Header().Get("Content-Type") == "" {
+		resp.Header().Set("Content-Type", "application/json")
+	}
 	resp.WriteHeader(status)
 	if data != nil {
 		encodeBody(resp, req, data)
 	}
 }
 
-func respondErr(resp http.ResponseWriter, req *http.Request, status int, args ...interface{}) {
-	respond(resp, req, status, map[string]interface{}{
-		"error": map[string]interface{}{"message": fmt.Sprint(args...)},
-	})
+//todoPath returns the URL path of a single todo item
+func todoPath(name string, id int) string {
+	return fmt.Sprintf("/todo/%s/id/%d", url.PathEscape(name), id)
+}
+
+//intParam parses an integer path parameter
+func intParam(field string, value string) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, invalidField(field, "Must be an integer, got %q", value)
+	}
+	return i, nil
 }
 
-func respondHTTPErr(resp http.ResponseWriter, req *http.Request, status int) {
-	respondErr(resp, req, status, http.StatusText(status))
+//boolParam parses a boolean path parameter
+func boolParam(field string, value string) (bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, invalidField(field, "Must be a boolean, got %q", value)
+	}
+	return b, nil
 }
 
 //HandleTodos routes various API requests to the proper function
 func (svr *ServerType) HandleTodos(resp http.ResponseWriter, req *http.Request) {
 	pathArgs := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
-	numArgs := len(pathArgs)
-	fmt.Printf("\n%s: PATHARGS %+v\n", time.Now().String(), pathArgs)
-	if numArgs < 2 {
-		respondHTTPErr(resp, req, http.StatusBadRequest)
+	log.Debugf("[%s] %s PATHARGS %+v", requestID(req), req.Method, pathArgs)
+	if len(pathArgs) < 2 || pathArgs[1] == "" {
+		respondHTTPErr(resp, req, http.StatusNotFound)
 		return
 	}
 	name := pathArgs[1]
+	if err := validateName(name); err != nil {
+		respondError(resp, req, err)
+		return
+	}
+	if req.Method == "POST" || req.Method == "DELETE" {
+		if err := svr.checkBodyQuota(name, req); err != nil {
+			respondError(resp, req, err)
+			return
+		}
+	}
+	var err error
 	switch req.Method {
 	case "GET":
-		var err error
-		if numArgs == 2 {
-			err = svr.GetTodos(name, resp, req)
-			if err != nil {
-				respondErr(resp, req, http.StatusBadRequest, " GET Error: ", err)
-			}
-			return
-		} else if numArgs < 4 {
-			respondHTTPErr(resp, req, http.StatusBadRequest)
-			return
+		err = svr.routeGet(name, pathArgs[2:], resp, req)
+	case "POST":
+		err = svr.routePost(name, pathArgs[2:], resp, req)
+	case "DELETE":
+		err = svr.routeDelete(name, pathArgs[2:], resp, req)
+	default:
+		resp.Header().Set("Allow", "GET, POST, DELETE")
+		respondHTTPErr(resp, req, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		respondError(resp, req, err)
+	}
+}
+
+//routeGet dispatches GET /todo/<username>/<args...>
+func (svr *ServerType) routeGet(name string, args []string, resp http.ResponseWriter, req *http.Request) error {
+	if len(args) == 0 {
+		return svr.GetTodos(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "usage" {
+		return svr.GetUsage(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "trash" {
+		return svr.GetTrash(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "events" {
+		return svr.StreamEvents(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "ws" {
+		return svr.ServeSocket(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "webhooks" {
+		return svr.GetWebhooks(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "settings" {
+		return svr.GetSettings(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "digest" {
+		return svr.PreviewDigest(name, resp, req)
+	}
+	if len(args) == 3 && args[0] == "webhooks" && args[2] == "deliveries" {
+		id, err := intParam("id", args[1])
+		if err != nil {
+			return err
 		}
-		switch pathArgs[2] {
-		case "active":
-			active, err := strconv.ParseBool(pathArgs[3])
-			if err == nil {
-				err = svr.GetActives(active, name, resp, req)
-			}
-		case "highs":
-			priority, err := strconv.Atoi(pathArgs[3])
-			if err == nil {
-				err = svr.GetTodosByPriority(priority, name, resp, req)
-			}
-		case "cat":
-			err = svr.GetTodosByCategory(pathArgs[3], name, resp, req)
-		case "id":
-			id, err := strconv.Atoi(pathArgs[3])
-			if err == nil {
-				err = svr.GetTodosByID(id, name, resp, req)
-			}
+		return svr.GetDeliveries(id, name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "export" {
+		return svr.Export(name, resp, req)
+	}
+	if len(args) == 3 && args[0] == "id" && args[2] == "history" {
+		id, err := intParam("id", args[1])
+		if err != nil {
+			return err
 		}
+		return svr.GetHistory(id, name, resp, req)
+	}
+	if len(args) != 2 {
+		return NotFound("No such endpoint: %s", req.URL.Path)
+	}
+	switch args[0] {
+	case "active":
+		active, err := boolParam("active", args[1])
 		if err != nil {
-			respondErr(resp, req, http.StatusBadRequest, " GET Error: ", err)
-			log.Errorf("GET Error: %v", err)
+			return err
 		}
-		return
-	case "POST":
-		var err error
-		if numArgs == 3 && pathArgs[2] == "add" {
-			err = svr.AddTodo(name, resp, req)
-			if err != nil {
-				respondErr(resp, req, http.StatusBadRequest, " POST Error: ", err)
-				log.Errorf("POST Error: %v", err)
-			}
-			return
-		} else if numArgs < 4 {
-			respondHTTPErr(resp, req, http.StatusBadRequest)
-			return
+		return svr.GetActives(active, name, resp, req)
+	case "highs":
+		priority, err := intParam("priority", args[1])
+		if err != nil {
+			return err
 		}
-		switch pathArgs[2] {
-		case "ctitle":
-			id, err := strconv.Atoi(pathArgs[3])
-			if err == nil {
-				err = svr.ChangeTitle(id, name, resp, req)
-			}
-		case "cpri":
-			id, err := strconv.Atoi(pathArgs[3])
-			if err == nil {
-				err = svr.ChangePriority(id, name, resp, req)
-			}
-		case "cactive":
-			id, err := strconv.Atoi(pathArgs[3])
-			if err == nil {
-				err = svr.ChangeActive(id, name, resp, req)
-			}
+		return svr.GetTodosByPriority(priority, name, resp, req)
+	case "cat":
+		if n := len([]rune(args[1])); n > categoryLen {
+			return invalidField("category", "Must be at most %d characters, got %d", categoryLen, n)
 		}
+		return svr.GetTodosByCategory(args[1], name, resp, req)
+	case "id":
+		id, err := intParam("id", args[1])
 		if err != nil {
-			respondErr(resp, req, http.StatusBadRequest, " POST Error: ", err)
-			log.Errorf("POST Error: %v", err)
+			return err
 		}
-		return
-	case "DELETE":
-		var err error
-		if numArgs < 3 {
-			respondHTTPErr(resp, req, http.StatusBadRequest)
-			return
+		return svr.GetTodosByID(id, name, resp, req)
+	}
+	return NotFound("No such endpoint: %s", req.URL.Path)
+}
+
+//routePost dispatches POST /todo/<username>/<args...>
+func (svr *ServerType) routePost(name string, args []string, resp http.ResponseWriter, req *http.Request) error {
+	if len(args) == 1 && args[0] == "add" {
+		return svr.AddTodo(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "quickadd" {
+		return svr.QuickAdd(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "batch" {
+		return svr.Batch(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "feed" {
+		return svr.IssueFeedToken(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "caldav" {
+		return svr.IssueCalDAVToken(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "inbound" {
+		return svr.IssueInboundAddress(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "webhooks" {
+		return svr.AddWebhook(name, resp, req)
+	}
+	if len(args) == 2 && args[0] == "webhooks" {
+		id, err := intParam("id", args[1])
+		if err != nil {
+			return err
 		}
-		switch pathArgs[2] {
-		case "rmtitle":
-			err = svr.RemoveByTitle(name, resp, req)
-		case "rmpri":
-			err = svr.RemoveByPriority(name, resp, req)
-		case "rminactive":
-			err = svr.RemoveInactive(name, resp, req)
-		case "rmid":
-			err = svr.RemoveByID(name, resp, req)
+		return svr.UpdateWebhook(id, name, resp, req)
+	}
+	if len(args) == 5 && args[0] == "webhooks" && args[2] == "deliveries" && args[4] == "redeliver" {
+		id, err := intParam("id", args[1])
+		if err != nil {
+			return err
 		}
+		return svr.Redeliver(id, args[3], name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "settings" {
+		return svr.UpdateSettings(name, resp, req)
+	}
+	if len(args) == 3 && args[0] == "id" && (args[2] == "snooze" || args[2] == "dismiss") {
+		id, err := intParam("id", args[1])
 		if err != nil {
-			respondErr(resp, req, http.StatusBadRequest, " DELETE Error: ", err)
-			log.Errorf("DELETE Error: %v", err)
+			return err
 		}
-		return
-	default:
-		respondHTTPErr(resp, req, http.StatusBadRequest)
-		return
+		if args[2] == "snooze" {
+			return svr.Snooze(id, name, resp, req)
+		}
+		return svr.Dismiss(id, name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "import" {
+		return svr.Import(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "update" {
+		return svr.UpdateWhere(name, resp, req)
+	}
+	if len(args) == 1 && args[0] == "undo" {
+		return svr.Undo(name, resp, req)
 	}
+	if len(args) == 1 && args[0] == "redo" {
+		return svr.Redo(name, resp, req)
+	}
+	if len(args) == 3 && args[0] == "trash" && args[1] == "restore" {
+		id, err := intParam("id", args[2])
+		if err != nil {
+			return err
+		}
+		return svr.RestoreTodo(id, name, resp, req)
+	}
+	if len(args) != 2 {
+		return NotFound("No such endpoint: %s", req.URL.Path)
+	}
+	id, err := intParam("id", args[1])
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "ctitle":
+		return svr.ChangeTitle(id, name, resp, req)
+	case "cpri":
+		return svr.ChangePriority(id, name, resp, req)
+	case "cactive":
+		return svr.ChangeActive(id, name, resp, req)
+	}
+	return NotFound("No such endpoint: %s", req.URL.Path)
+}
+
+//routeDelete dispatches DELETE /todo/<username>/<args...>
+func (svr *ServerType) routeDelete(name string, args []string, resp http.ResponseWriter, req *http.Request) error {
+	if len(args) == 2 && args[0] == "webhooks" {
+		id, err := intParam("id", args[1])
+		if err != nil {
+			return err
+		}
+		return svr.RemoveWebhook(id, name, resp, req)
+	}
+	if len(args) != 1 {
+		return NotFound("No such endpoint: %s", req.URL.Path)
+	}
+	switch args[0] {
+	case "rmtitle":
+		return svr.RemoveByTitle(name, resp, req)
+	case "rmpri":
+		return svr.RemoveByPriority(name, resp, req)
+	case "rminactive":
+		return svr.RemoveInactive(name, resp, req)
+	case "rmid":
+		return svr.RemoveByID(name, resp, req)
+	case "trash":
+		return svr.EmptyTrash(name, resp, req)
+	case "feed":
+		return svr.RevokeFeedToken(name, resp, req)
+	case "caldav":
+		return svr.RevokeCalDAVToken(name, resp, req)
+	case "inbound":
+		return svr.RevokeInboundAddress(name, resp, req)
+	}
+	return NotFound("No such endpoint: %s", req.URL.Path)
 }
 
 //AddTodo adds a new to do item to the to do list
 func (svr *ServerType) AddTodo(name string, resp http.ResponseWriter, req *http.Request) error {
 	var todo types.TodoData
-	err := decodeBody(req, &todo)
+	err := decodeTodo(req, addSpec, &todo)
 	if err != nil {
 		return err
 	}
 	todo.Name = name
-	err = svr.DAO.InsertTodo(todo)
+	todo.Active = true
+	err = svr.checkTodoQuota(name, todo)
 	if err != nil {
 		return err
 	}
-	respond(resp, req, http.StatusOK, &types.ListStatus{
-		Status: "Success",
-		Info:   fmt.Sprintf("Title '%s' added", todo.Title),
-	})
+	created, err := svr.store(req).InsertTodo(todo)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("Location", todoPath(name, created.ID))
+	resp.Header().Set("ETag", todoETag(created))
+	respond(resp, req, http.StatusCreated, &created)
 	return nil
 }
 
 //GetTodos returns all of the todo items from the list
 func (svr *ServerType) GetTodos(name string, resp http.ResponseWriter, req *http.Request) error {
+	if done, err := svr.respondIfListUnchanged(name, resp, req); done || err != nil {
+		return err
+	}
 	result, err := svr.DAO.SelectAllTodos(name)
 	if err != nil {
 		return err
@@ -206,6 +380,9 @@ func (svr *ServerType) GetTodos(name string, resp http.ResponseWriter, req *http
 
 //GetActives returns all of the todo items from the list
 func (svr *ServerType) GetActives(active bool, name string, resp http.ResponseWriter, req *http.Request) error {
+	if done, err := svr.respondIfListUnchanged(name, resp, req); done || err != nil {
+		return err
+	}
 	result, err := svr.DAO.SelectActives(active, name)
 	if err != nil {
 		return err
@@ -220,6 +397,9 @@ func (svr *ServerType) GetActives(active bool, name string, resp http.ResponseWr
 
 //GetTodosByPriority returns all todo items that have a priority higher (lower number) or equal to the one provided
 func (svr *ServerType) GetTodosByPriority(priority int, name string, resp http.ResponseWriter, req *http.Request) error {
+	if done, err := svr.respondIfListUnchanged(name, resp, req); done || err != nil {
+		return err
+	}
 	var result []types.TodoData
 	var err error
 	if priority == 0 {
@@ -240,6 +420,9 @@ func (svr *ServerType) GetTodosByPriority(priority int, name string, resp http.R
 
 //GetTodosByCategory returns all todo items that exactly match the category provided
 func (svr *ServerType) GetTodosByCategory(category string, name string, resp http.ResponseWriter, req *http.Request) error {
+	if done, err := svr.respondIfListUnchanged(name, resp, req); done || err != nil {
+		return err
+	}
 	result, err := svr.DAO.SelectByCategory(category, name)
 	if err != nil {
 		return err
@@ -258,81 +441,127 @@ func (svr *ServerType) GetTodosByID(id int, name string, resp http.ResponseWrite
 	if err != nil {
 		return err
 	}
-	var empty types.TodoData
-	if result == empty {
-		respond(resp, req, http.StatusNoContent, &result)
-	} else {
-		respond(resp, req, http.StatusOK, &result)
+	etag := todoETag(result)
+	setValidators(resp, etag, result.UpdatedAt.Time)
+	if notModified(req, etag, result.UpdatedAt.Time) {
+		resp.WriteHeader(http.StatusNotModified)
+		return nil
 	}
+	respond(resp, req, http.StatusOK, &result)
 	return nil
 }
 
 //RemoveByTitle removes all todo items with the exact title
 func (svr *ServerType) RemoveByTitle(name string, resp http.ResponseWriter, req *http.Request) error {
 	var todo types.TodoData
-	err := decodeBody(req, &todo)
-	if err != nil {
-		return err
-	}
-
-	err = svr.DAO.DeleteByTitle(todo.Title, name)
+	err := decodeTodo(req, rmTitleSpec, &todo)
 	if err != nil {
 		return err
 	}
-	respond(resp, req, http.StatusOK, &types.ListStatus{
-		Status: "Success",
-		Info:   fmt.Sprintf("Todos with '%s' title removed", todo.Title),
-	})
-	return nil
+	info := fmt.Sprintf("Todos with '%s' title removed", todo.Title)
+	return svr.removeWhere(types.Filter{Title: &todo.Title}, info, name, resp, req)
 }
 
-//RemoveByPriority removes all todo items with the exact title
+//RemoveByPriority removes all todo items with the exact priority
 func (svr *ServerType) RemoveByPriority(name string, resp http.ResponseWriter, req *http.Request) error {
 	var todo types.TodoData
-	err := decodeBody(req, &todo)
+	err := decodeTodo(req, rmPrioritySpec, &todo)
 	if err != nil {
 		return err
 	}
+	info := fmt.Sprintf("Todos with '%d' priority removed", todo.Priority)
+	return svr.removeWhere(types.Filter{Priority: &todo.Priority}, info, name, resp, req)
+}
+
+//RemoveInactive removes all todo items that are no longer active
+func (svr *ServerType) RemoveInactive(name string, resp http.ResponseWriter, req *http.Request) error {
+	active := false
+	return svr.removeWhere(types.Filter{Active: &active}, "Todos with inactive status removed", name, resp, req)
+}
+
+//dryRunParam reads the dry_run query parameter
+func dryRunParam(req *http.Request) (bool, error) {
+	value := req.URL.Query().Get("dry_run")
+	if value == "" {
+		return false, nil
+	}
+	return boolParam("dry_run", value)
+}
 
-	err = svr.DAO.DeleteByPriority(todo.Priority, name)
+//removeWhere removes the todo items matching filter.  With ?dry_run=true it instead lists the items that would be removed, without removing anything
+func (svr *ServerType) removeWhere(filter types.Filter, info string, name string, resp http.ResponseWriter, req *http.Request) error {
+	dryRun, err := dryRunParam(req)
 	if err != nil {
 		return err
 	}
-	respond(resp, req, http.StatusOK, &types.ListStatus{
-		Status: "Success",
-		Info:   fmt.Sprintf("Todos with '%d' priority removed", todo.Priority),
-	})
-	return nil
-}
-
-//RemoveInactive removes the todo item with the given db id
-func (svr *ServerType) RemoveInactive(name string, resp http.ResponseWriter, req *http.Request) error {
-	err := svr.DAO.DeleteInactive(name)
+	if dryRun {
+		todos, err := svr.DAO.SelectWhere(filter, name)
+		if err != nil {
+			return err
+		}
+		respond(resp, req, http.StatusOK, &types.ListStatus{
+			Status:   "Dry run",
+			Info:     info,
+			Affected: int64(len(todos)),
+			DryRun:   true,
+			Todos:    todos,
+		})
+		return nil
+	}
+	affected, err := svr.store(req).DeleteWhere(filter, name)
 	if err != nil {
 		return err
 	}
 	respond(resp, req, http.StatusOK, &types.ListStatus{
-		Status: "Success",
-		Info:   fmt.Sprintf("Todos with inactive status removed"),
+		Status:   "Success",
+		Info:     info,
+		Affected: affected,
 	})
 	return nil
 }
 
-//RemoveByID removes the todo item with the given db id
+//RemoveByID removes the todo item with the given db id, or reports not_found if there is no such item
 func (svr *ServerType) RemoveByID(name string, resp http.ResponseWriter, req *http.Request) error {
 	var todo types.TodoData
-	err := decodeBody(req, &todo)
+	err := decodeTodo(req, rmIDSpec, &todo)
 	if err != nil {
 		return err
 	}
 
-	err = svr.DAO.DeleteByID(todo.ID, name)
+	version, err := ifMatchVersion(req, todo.ID)
+	if err != nil {
+		return err
+	}
+	info := fmt.Sprintf("Todo with '%d' id removed", todo.ID)
+	dryRun, err := dryRunParam(req)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		existing, err := svr.DAO.SelectByID(todo.ID, name)
+		if err != nil {
+			return err
+		}
+		if version != 0 && existing.Version != version {
+			return data.ErrStale
+		}
+		respond(resp, req, http.StatusOK, &types.ListStatus{
+			Status:   "Dry run",
+			Info:     info,
+			Affected: 1,
+			DryRun:   true,
+			Todos:    []types.TodoData{existing},
+		})
+		return nil
+	}
+	affected, err := svr.store(req).DeleteByID(todo.ID, name, version)
 	if err != nil {
 		return err
 	}
 	respond(resp, req, http.StatusOK, &types.ListStatus{
-		Status: "Success",
-		Info:   fmt.Sprintf("Todo with '%d' id removed", todo.ID),
+		Status:   "Success",
+		Info:     info,
+		Affected: affected,
 	})
 	return nil
 }
@@ -340,56 +569,62 @@ func (svr *ServerType) RemoveByID(name string, resp http.ResponseWriter, req *ht
 //ChangeTitle changes the title of the todo item by id
 func (svr *ServerType) ChangeTitle(id int, name string, resp http.ResponseWriter, req *http.Request) error {
 	var todo types.TodoData
-	err := decodeBody(req, &todo)
+	err := decodeTodo(req, changeTitleSpec, &todo)
 	if err != nil {
 		return err
 	}
 
-	err = svr.DAO.UpdateTitle(id, todo.Title, name)
+	version, err := ifMatchVersion(req, id)
 	if err != nil {
 		return err
 	}
-	respond(resp, req, http.StatusOK, &types.ListStatus{
-		Status: "Success",
-		Info:   fmt.Sprintf("Title changed to '%s' for id %d", todo.Title, id),
-	})
+	updated, err := svr.store(req).UpdateTitle(id, todo.Title, name, version)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("ETag", todoETag(updated))
+	respond(resp, req, http.StatusOK, &updated)
 	return nil
 }
 
 //ChangePriority changes the priority level of the todo item by id
 func (svr *ServerType) ChangePriority(id int, name string, resp http.ResponseWriter, req *http.Request) error {
 	var todo types.TodoData
-	err := decodeBody(req, &todo)
+	err := decodeTodo(req, changePriSpec, &todo)
 	if err != nil {
 		return err
 	}
 
-	err = svr.DAO.UpdatePriority(id, todo.Priority, name)
+	version, err := ifMatchVersion(req, id)
 	if err != nil {
 		return err
 	}
-	respond(resp, req, http.StatusOK, &types.ListStatus{
-		Status: "Success",
-		Info:   fmt.Sprintf("Priority changed to %d for id %d", todo.Priority, id),
-	})
+	updated, err := svr.store(req).UpdatePriority(id, todo.Priority, name, version)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("ETag", todoETag(updated))
+	respond(resp, req, http.StatusOK, &updated)
 	return nil
 }
 
 //ChangeActive changes whether a given todo item is active or not
 func (svr *ServerType) ChangeActive(id int, name string, resp http.ResponseWriter, req *http.Request) error {
 	var todo types.TodoData
-	err := decodeBody(req, &todo)
+	err := decodeTodo(req, changeActSpec, &todo)
 	if err != nil {
 		return err
 	}
 
-	err = svr.DAO.UpdateActive(id, todo.Active, name)
+	version, err := ifMatchVersion(req, id)
 	if err != nil {
 		return err
 	}
-	respond(resp, req, http.StatusOK, &types.ListStatus{
-		Status: "Success",
-		Info:   fmt.Sprintf("Active changed to '%t' for id %d", todo.Active, id),
-	})
+	updated, err := svr.store(req).UpdateActive(id, todo.Active, name, version)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("ETag", todoETag(updated))
+	respond(resp, req, http.StatusOK, &updated)
 	return nil
 }
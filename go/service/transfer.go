@@ -0,0 +1,254 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/shale/go/data"
+	"github.com/shale/go/format"
+	"github.com/shale/go/types"
+)
+
+//What an import does with a todo item whose title is already on the list
+const (
+	OnDuplicateSkip   = "skip"
+	OnDuplicateUpsert = "upsert"
+)
+
+//Import actions reported for each todo item of an imported file
+const (
+	importCreate = "create"
+	importUpdate = "update"
+	importSkip   = "skip"
+)
+
+//formatParam reads the format query parameter, which defaults to json
+func formatParam(req *http.Request) (string, error) {
+	f := req.URL.Query().Get("format")
+	if f == "" {
+		return format.JSON, nil
+	}
+	if !format.Valid(f) {
+		return "", invalidField("format", "Must be one of %s, got %q", strings.Join(format.Formats, ", "), f)
+	}
+	return f, nil
+}
+
+//columnsParam reads the map query parameter, a comma separated list of column:field pairs naming the todo item field each CSV column holds
+func columnsParam(req *http.Request) (map[string]string, error) {
+	value := req.URL.Query().Get("map")
+	if value == "" {
+		return nil, nil
+	}
+	columns := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, invalidField("map", "Must be a comma separated list of column:field pairs, got %q", pair)
+		}
+		columns[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return columns, nil
+}
+
+//Export returns every todo item on the list as a file in the format given by the format query parameter
+func (svr *ServerType) Export(name string, resp http.ResponseWriter, req *http.Request) error {
+	f, err := formatParam(req)
+	if err != nil {
+		return err
+	}
+	if done, err := svr.respondIfListUnchanged(name, resp, req); done || err != nil {
+		return err
+	}
+	todos, err := svr.DAO.SelectAllTodos(name)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := format.Encode(&out, f, todos); err != nil {
+		return err
+	}
+	resp.Header().Set("Content-Type", format.ContentType(f))
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", format.Filename(name, f)))
+	resp.WriteHeader(http.StatusOK)
+	resp.Write(out.Bytes())
+	return nil
+}
+
+//importPlan is a single write planned by an import.  current is the item on the list that an update replaces, and row is the item of the file that is written
+type importPlan struct {
+	action  string
+	current types.TodoData
+	todo    types.TodoData
+	row     int
+}
+
+//fullPatch returns a patch that sets every importable field of a todo item
+func fullPatch(todo types.TodoData) types.TodoPatch {
+	return types.TodoPatch{
+		Title:      &todo.Title,
+		Body:       &todo.Body,
+		Category:   &todo.Category,
+		Priority:   &todo.Priority,
+		Active:     &todo.Active,
+		Tags:       &todo.Tags,
+		Recurrence: &todo.Recurrence,
+
+		Due:          types.PatchTime{Set: true, Value: todo.Due},
+		RemindAt:     types.PatchTime{Set: true, Value: todo.RemindAt},
+		RemindBefore: types.PatchInt{Set: true, Value: todo.RemindBefore},
+	}
+}
+
+//Import adds the todo items in a file in the format given by the format query parameter.  Items whose title is already on the list are skipped, or with ?on_duplicate=upsert update the item with that title.  The whole file is validated first and imported in a single transaction.  With ?dry_run=true it instead reports what would be imported, without changing anything
+func (svr *ServerType) Import(name string, resp http.ResponseWriter, req *http.Request) error {
+	f, err := formatParam(req)
+	if err != nil {
+		return err
+	}
+	onDuplicate := req.URL.Query().Get("on_duplicate")
+	if onDuplicate == "" {
+		onDuplicate = OnDuplicateSkip
+	}
+	if onDuplicate != OnDuplicateSkip && onDuplicate != OnDuplicateUpsert {
+		return invalidField("on_duplicate", "Must be %q or %q, got %q", OnDuplicateSkip, OnDuplicateUpsert, onDuplicate)
+	}
+	columns, err := columnsParam(req)
+	if err != nil {
+		return err
+	}
+	dryRun, err := dryRunParam(req)
+	if err != nil {
+		return err
+	}
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	rows, err := format.Decode(bytes.NewReader(body), f, format.Options{Columns: columns})
+	if err != nil {
+		return invalidField("body", "Could not be read as %s: %v", f, err)
+	}
+	if len(rows) == 0 {
+		return invalidField("body", "Holds no todo items")
+	}
+
+	//Validate every item before importing any
+	quota, err := svr.quotaFor(name)
+	if err != nil {
+		return err
+	}
+	var problems []types.FieldError
+	for _, row := range rows {
+		rowProblems := row.Errors
+		if len(rowProblems) == 0 {
+			rowProblems = checkTodo(importSpec, row.Todo)
+		}
+		for _, problem := range rowProblems {
+			problem.Field = strings.TrimSuffix(row.Ref+": "+problem.Field, ": ")
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return Invalid(problems...)
+	}
+	for _, row := range rows {
+		if err := checkTags(quota, row.Todo.Tags); err != nil {
+			return err
+		}
+	}
+
+	//Plan the writes.  A title repeated within the file is a duplicate like any other: the later item is skipped, or with upsert replaces the earlier one
+	existing, err := svr.DAO.SelectAllTodos(name)
+	if err != nil {
+		return err
+	}
+	byTitle := make(map[string]types.TodoData)
+	for _, todo := range existing {
+		if first, ok := byTitle[todo.Title]; !ok || todo.ID < first.ID {
+			byTitle[todo.Title] = todo
+		}
+	}
+	result := types.ImportResult{Format: f, DryRun: dryRun, Rows: make([]types.ImportRow, len(rows))}
+	var plan []importPlan
+	planned := make(map[string]int)
+	for i, row := range rows {
+		todo := row.Todo
+		todo.Name = name
+		result.Rows[i] = types.ImportRow{Ref: row.Ref, Action: importSkip}
+		j, ok := planned[todo.Title]
+		if !ok {
+			current, exists := byTitle[todo.Title]
+			if exists && onDuplicate == OnDuplicateSkip {
+				continue
+			}
+			j = len(plan)
+			planned[todo.Title] = j
+			plan = append(plan, importPlan{action: importCreate, current: current})
+			if exists {
+				plan[j].action = importUpdate
+			}
+		} else if onDuplicate == OnDuplicateSkip {
+			continue
+		} else {
+			//A later item with the same title replaces the earlier one
+			result.Rows[plan[j].row].Action = importSkip
+		}
+		plan[j].todo = todo
+		plan[j].row = i
+		result.Rows[i].Action = plan[j].action
+	}
+	for _, row := range result.Rows {
+		switch row.Action {
+		case importCreate:
+			result.Created++
+		case importUpdate:
+			result.Updated++
+		default:
+			result.Skipped++
+		}
+	}
+	if quota.MaxTodos > 0 && result.Created > 0 {
+		count, err := svr.DAO.CountTodos(name)
+		if err != nil {
+			return err
+		}
+		if count+result.Created > quota.MaxTodos {
+			return &QuotaError{Quota: QuotaMaxTodos, Limit: int64(quota.MaxTodos), Used: int64(count)}
+		}
+	}
+
+	ops := make([]data.BatchOp, len(plan))
+	for j, p := range plan {
+		ops[j] = data.BatchOp{Op: data.BatchCreate, Todo: p.todo}
+		if p.action == importUpdate {
+			ops[j] = data.BatchOp{Op: data.BatchUpdate, ID: p.current.ID, Patch: fullPatch(p.todo)}
+		}
+	}
+	if dryRun {
+		for _, p := range plan {
+			preview := p.todo
+			if p.action == importUpdate {
+				preview = p.current
+				fullPatch(p.todo).Apply(&preview)
+			}
+			result.Rows[p.row].Todo = &preview
+		}
+	} else if len(ops) > 0 {
+		outcomes, err := svr.store(req).Import(name, ops)
+		if err != nil {
+			return err
+		}
+		for j, outcome := range outcomes {
+			if outcome.Err != nil {
+				return outcome.Err
+			}
+			todo := outcome.Todo
+			result.Rows[plan[j].row].Todo = &todo
+		}
+	}
+	respond(resp, req, http.StatusOK, &result)
+	return nil
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+)
+
+//GetTrash returns the todo items that have been removed but not yet purged
+func (svr *ServerType) GetTrash(name string, resp http.ResponseWriter, req *http.Request) error {
+	result, err := svr.DAO.SelectTrash(name)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		respond(resp, req, http.StatusNoContent, &result)
+	} else {
+		respond(resp, req, http.StatusOK, &result)
+	}
+	return nil
+}
+
+//RestoreTodo moves a todo item out of the trash and back onto the list
+func (svr *ServerType) RestoreTodo(id int, name string, resp http.ResponseWriter, req *http.Request) error {
+	err := svr.checkTodoQuota(name, types.TodoData{})
+	if err != nil {
+		return err
+	}
+	restored, err := svr.store(req).RestoreByID(id, name)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("ETag", todoETag(restored))
+	respond(resp, req, http.StatusOK, &restored)
+	return nil
+}
+
+//EmptyTrash permanently removes every todo item in the trash
+func (svr *ServerType) EmptyTrash(name string, resp http.ResponseWriter, req *http.Request) error {
+	affected, err := svr.store(req).EmptyTrash(name)
+	if err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusOK, &types.ListStatus{
+		Status:   "Success",
+		Info:     "Trash emptied",
+		Affected: affected,
+	})
+	return nil
+}
+
+//PurgeTrash permanently removes todo items that have been in the trash longer than retention, checking every interval until the process exits
+func (svr *ServerType) PurgeTrash(retention time.Duration, interval time.Duration) {
+	for range time.Tick(interval) {
+		purged, err := svr.DAO.PurgeTrash(time.Now().Add(-retention))
+		if err == nil && purged > 0 {
+			log.Infof("Purged %d todo items from the trash", purged)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package service
+
+import "net/http"
+
+//Undo reverts the most recent change made to the list
+func (svr *ServerType) Undo(name string, resp http.ResponseWriter, req *http.Request) error {
+	result, err := svr.store(req).Undo(name)
+	if err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusOK, &result)
+	return nil
+}
+
+//Redo reapplies the change most recently undone
+func (svr *ServerType) Redo(name string, resp http.ResponseWriter, req *http.Request) error {
+	result, err := svr.store(req).Redo(name)
+	if err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusOK, &result)
+	return nil
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/shale/go/quickadd"
+	"github.com/shale/go/types"
+)
+
+//Column sizes from mysql/sys.sql
+const (
+	nameLen     = 255
+	titleLen    = 32
+	bodyLen     = 255
+	categoryLen = 255
+	tagsLen     = 255
+	ruleLen     = 255
+)
+
+//Kinds of JSON value a field may hold
+const (
+	kindString = iota
+	kindInt
+	kindBool
+	kindStrings
+	kindTime
+	kindRecurrence
+)
+
+var kindNames = map[int]string{
+	kindString:     "a string",
+	kindInt:        "an integer",
+	kindBool:       "a boolean",
+	kindStrings:    "an array of strings",
+	kindTime:       "an RFC 3339 timestamp",
+	kindRecurrence: "a recurrence rule such as \"FREQ=WEEKLY;INTERVAL=2\"",
+}
+
+//fieldRule describes how a single JSON field of a request is validated
+type fieldRule struct {
+	name     string
+	kind     int
+	required bool
+	nullable bool
+	notEmpty bool
+	maxLen   int
+	min      int64
+	max      int64
+}
+
+//requestSpec lists every field a request type accepts.  Fields not in the spec are rejected
+type requestSpec []fieldRule
+
+//Rule templates for the todo item fields
+var (
+	titleRule    = fieldRule{name: "title", kind: kindString, notEmpty: true, maxLen: titleLen}
+	bodyRule     = fieldRule{name: "body", kind: kindString, maxLen: bodyLen}
+	categoryRule = fieldRule{name: "category", kind: kindString, maxLen: categoryLen}
+	priorityRule = fieldRule{name: "item_priority", kind: kindInt, min: math.MinInt32, max: math.MaxInt32}
+	activeRule   = fieldRule{name: "active", kind: kindBool}
+	idRule       = fieldRule{name: "id", kind: kindInt, min: 1, max: math.MaxInt32}
+	tagsRule     = fieldRule{name: "tags", kind: kindStrings, nullable: true, maxLen: tagsLen}
+	dueRule      = fieldRule{name: "due", kind: kindTime, nullable: true}
+	remindAtRule = fieldRule{name: "remind_at", kind: kindTime, nullable: true}
+
+	//remindBeforeRule is in minutes, up to a year
+	remindBeforeRule = fieldRule{name: "remind_before", kind: kindInt, nullable: true, min: 0, max: 366 * 24 * 60}
+
+	//recurrenceRule may be empty, for an item that does not repeat
+	recurrenceRule = fieldRule{name: "recurrence", kind: kindRecurrence, maxLen: ruleLen}
+)
+
+//requiredRule returns a copy of the rule that must be present
+func requiredRule(rule fieldRule) fieldRule {
+	rule.required = true
+	return rule
+}
+
+//Specs for each request type that carries a todo item
+var (
+	addSpec         = requestSpec{requiredRule(titleRule), bodyRule, categoryRule, priorityRule, tagsRule, dueRule, remindAtRule, remindBeforeRule, recurrenceRule}
+	changeTitleSpec = requestSpec{requiredRule(titleRule)}
+	changePriSpec   = requestSpec{requiredRule(priorityRule)}
+	changeActSpec   = requestSpec{requiredRule(activeRule)}
+	rmTitleSpec     = requestSpec{requiredRule(titleRule)}
+	rmPrioritySpec  = requestSpec{requiredRule(priorityRule)}
+	rmIDSpec        = requestSpec{requiredRule(idRule)}
+	patchSpec       = requestSpec{titleRule, bodyRule, categoryRule, priorityRule, activeRule, tagsRule, dueRule, remindAtRule, remindBeforeRule, recurrenceRule}
+	filterSpec      = requestSpec{titleRule, categoryRule, priorityRule, activeRule}
+	importSpec      = requestSpec{requiredRule(titleRule), bodyRule, categoryRule, priorityRule, activeRule, tagsRule, dueRule, remindAtRule, remindBeforeRule, recurrenceRule}
+)
+
+//readBody reads the whole request body
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	return ioutil.ReadAll(req.Body)
+}
+
+//decodeStrict decodes exactly one JSON value, rejecting unknown fields and trailing data
+func decodeStrict(body []byte, data interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(data); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the JSON body")
+	}
+	return nil
+}
+
+//decodeTodo validates the request body against spec and decodes it into todo.  Every problem found is reported in a single validation error
+func decodeTodo(req *http.Request, spec requestSpec, todo *types.TodoData) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	return decodeSpec(body, spec, todo)
+}
+
+//decodeSpec validates a JSON object against spec and decodes it into data
+func decodeSpec(body []byte, spec requestSpec, data interface{}) error {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return invalidField("body", "A JSON body is required")
+	}
+	var raw map[string]json.RawMessage
+	if err := decodeStrict(body, &raw); err != nil {
+		return invalidField("body", "Malformed JSON body: %v", err)
+	}
+	if raw == nil {
+		return invalidField("body", "The JSON body must be an object")
+	}
+	problems := spec.check(raw)
+	if len(problems) > 0 {
+		return Invalid(problems...)
+	}
+	if err := decodeStrict(body, data); err != nil {
+		return invalidField("body", "Malformed JSON body: %v", err)
+	}
+	return nil
+}
+
+//checkTodo validates a todo item read from somewhere other than a JSON request body, such as an imported file, against spec
+func checkTodo(spec requestSpec, todo types.TodoData) []types.FieldError {
+	values := map[string]interface{}{
+		titleRule.name:        todo.Title,
+		bodyRule.name:         todo.Body,
+		categoryRule.name:     todo.Category,
+		priorityRule.name:     todo.Priority,
+		activeRule.name:       todo.Active,
+		tagsRule.name:         todo.Tags,
+		dueRule.name:          todo.Due,
+		remindAtRule.name:     todo.RemindAt,
+		remindBeforeRule.name: todo.RemindBefore,
+		recurrenceRule.name:   todo.Recurrence,
+	}
+	raw := make(map[string]json.RawMessage, len(spec))
+	for _, rule := range spec {
+		value, ok := values[rule.name]
+		if !ok {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return []types.FieldError{{Field: rule.name, Message: err.Error()}}
+		}
+		raw[rule.name] = encoded
+	}
+	return spec.check(raw)
+}
+
+//withPrefix qualifies the field names of a validation error with the name of the object holding them
+func withPrefix(err error, prefix string) error {
+	apiErr, ok := err.(*Error)
+	if !ok || apiErr.Code != CodeValidation {
+		return err
+	}
+	fields := make([]types.FieldError, len(apiErr.Fields))
+	for i, field := range apiErr.Fields {
+		fields[i] = field
+		if field.Field == "body" {
+			fields[i].Field = prefix
+		} else if field.Field != "" {
+			fields[i].Field = prefix + "." + field.Field
+		}
+	}
+	return Invalid(fields...)
+}
+
+//check validates each field of a decoded JSON object against the spec
+func (spec requestSpec) check(raw map[string]json.RawMessage) []types.FieldError {
+	var problems []types.FieldError
+	known := make(map[string]bool, len(spec))
+	for _, rule := range spec {
+		known[rule.name] = true
+		value, ok := raw[rule.name]
+		if !ok {
+			if rule.required {
+				problems = append(problems, types.FieldError{Field: rule.name, Message: "Is required"})
+			}
+			continue
+		}
+		if msg := rule.check(value); msg != "" {
+			problems = append(problems, types.FieldError{Field: rule.name, Message: msg})
+		}
+	}
+	var unknown []string
+	for field := range raw {
+		if !known[field] {
+			unknown = append(unknown, field)
+		}
+	}
+	sort.Strings(unknown)
+	for _, field := range unknown {
+		problems = append(problems, types.FieldError{Field: field, Message: "Is not accepted by this endpoint"})
+	}
+	return problems
+}
+
+//check validates a single JSON value, returning a message describing the problem or "" if there is none
+func (rule fieldRule) check(value json.RawMessage) string {
+	wrongKind := "Must be " + kindNames[rule.kind]
+	if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+		if rule.nullable {
+			return ""
+		}
+		return wrongKind
+	}
+	switch rule.kind {
+	case kindString:
+		var s string
+		if json.Unmarshal(value, &s) != nil {
+			return wrongKind
+		}
+		return rule.checkString(s)
+	case kindInt:
+		var n json.Number
+		dec := json.NewDecoder(bytes.NewReader(value))
+		dec.UseNumber()
+		if dec.Decode(&n) != nil {
+			return wrongKind
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return wrongKind
+		}
+		if i < rule.min || i > rule.max {
+			return fmt.Sprintf("Must be between %d and %d", rule.min, rule.max)
+		}
+	case kindBool:
+		var b bool
+		if json.Unmarshal(value, &b) != nil {
+			return wrongKind
+		}
+	case kindTime:
+		var s string
+		if json.Unmarshal(value, &s) != nil {
+			return wrongKind
+		}
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			return wrongKind
+		}
+	case kindRecurrence:
+		var s string
+		if json.Unmarshal(value, &s) != nil {
+			return wrongKind
+		}
+		if msg := rule.checkString(s); msg != "" {
+			return msg
+		}
+		if _, err := quickadd.ParseRecurrence(s); s != "" && err != nil {
+			return wrongKind
+		}
+	case kindStrings:
+		var list []string
+		if json.Unmarshal(value, &list) != nil {
+			return wrongKind
+		}
+		for _, s := range list {
+			if strings.TrimSpace(s) == "" || strings.Contains(s, ",") {
+				return "Entries must be non-empty and may not contain commas"
+			}
+		}
+		if n := utf8.RuneCountInString(strings.Join(list, ",")); rule.maxLen > 0 && n > rule.maxLen {
+			return fmt.Sprintf("Must total at most %d characters, got %d", rule.maxLen, n)
+		}
+	}
+	return ""
+}
+
+//checkString applies the emptiness and length rules to a string value
+func (rule fieldRule) checkString(s string) string {
+	if rule.notEmpty && strings.TrimSpace(s) == "" {
+		return "May not be empty"
+	}
+	if n := utf8.RuneCountInString(s); rule.maxLen > 0 && n > rule.maxLen {
+		return fmt.Sprintf("Must be at most %d characters, got %d", rule.maxLen, n)
+	}
+	return ""
+}
+
+//validateName checks the username taken from the path
+func validateName(name string) error {
+	if n := utf8.RuneCountInString(name); n > nameLen {
+		return invalidField("username", "Must be at most %d characters, got %d", nameLen, n)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shale/go/types"
+)
+
+func TestSpecCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		spec requestSpec
+		body string
+		want []types.FieldError
+	}{
+		{
+			name: "valid add",
+			spec: addSpec,
+			body: `{"title": "Buy milk", "item_priority": 2, "tags": ["a", "b"], "due": "2020-05-01T17:00:00Z", "remind_before": 30, "recurrence": "FREQ=WEEKLY;BYDAY=MO"}`,
+		},
+		{
+			name: "nullable fields",
+			spec: addSpec,
+			body: `{"title": "Buy milk", "due": null, "remind_at": null, "remind_before": null}`,
+		},
+		{
+			name: "every problem is reported",
+			spec: addSpec,
+			body: `{"title": "", "item_priority": "high", "tags": ["a,b"], "colour": "red", "alpha": 1}`,
+			want: []types.FieldError{
+				{Field: "title", Message: "May not be empty"},
+				{Field: "item_priority", Message: "Must be an integer"},
+				{Field: "tags", Message: "Entries must be non-empty and may not contain commas"},
+				{Field: "alpha", Message: "Is not accepted by this endpoint"},
+				{Field: "colour", Message: "Is not accepted by this endpoint"},
+			},
+		},
+		{
+			name: "required field missing",
+			spec: changeTitleSpec,
+			body: `{}`,
+			want: []types.FieldError{{Field: "title", Message: "Is required"}},
+		},
+		{
+			name: "title too long",
+			spec: changeTitleSpec,
+			body: `{"title": "` + strings.Repeat("é", titleLen+1) + `"}`,
+			want: []types.FieldError{{Field: "title", Message: "Must be at most 32 characters, got 33"}},
+		},
+		{
+			name: "out of range",
+			spec: rmIDSpec,
+			body: `{"id": 0}`,
+			want: []types.FieldError{{Field: "id", Message: "Must be between 1 and 2147483647"}},
+		},
+		{
+			name: "not an integer",
+			spec: changePriSpec,
+			body: `{"item_priority": 1.5}`,
+			want: []types.FieldError{{Field: "item_priority", Message: "Must be an integer"}},
+		},
+		{
+			name: "null where not nullable",
+			spec: changeActSpec,
+			body: `{"active": null}`,
+			want: []types.FieldError{{Field: "active", Message: "Must be a boolean"}},
+		},
+		{
+			name: "bad time and recurrence",
+			spec: patchSpec,
+			body: `{"due": "tomorrow", "recurrence": "FREQ=HOURLY"}`,
+			want: []types.FieldError{
+				{Field: "due", Message: "Must be an RFC 3339 timestamp"},
+				{Field: "recurrence", Message: `Must be a recurrence rule such as "FREQ=WEEKLY;INTERVAL=2"`},
+			},
+		},
+		{
+			name: "empty recurrence",
+			spec: patchSpec,
+			body: `{"recurrence": ""}`,
+		},
+		{
+			name: "tags too long",
+			spec: patchSpec,
+			body: `{"tags": ["` + strings.Repeat("x", 200) + `", "` + strings.Repeat("y", 60) + `"]}`,
+			want: []types.FieldError{{Field: "tags", Message: "Must total at most 255 characters, got 261"}},
+		},
+	}
+	for _, test := range tests {
+		var raw map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(test.body), &raw); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := test.spec.check(raw); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDecodeSpec(t *testing.T) {
+	var todo types.TodoData
+	if err := decodeSpec([]byte(`{"title": "Buy milk", "item_priority": 3}`), addSpec, &todo); err != nil {
+		t.Fatalf("valid body: %v", err)
+	}
+	if todo.Title != "Buy milk" || todo.Priority != 3 {
+		t.Errorf("decoded %+v", todo)
+	}
+	for _, body := range []string{``, `  `, `[1]`, `null`, `{"title": "a"} {}`, `{"title": }`} {
+		err := decodeSpec([]byte(body), addSpec, &todo)
+		apiErr, ok := err.(*Error)
+		if !ok || apiErr.Code != CodeValidation || len(apiErr.Fields) != 1 || apiErr.Fields[0].Field != "body" {
+			t.Errorf("body %q: got %v, want a validation error on body", body, err)
+		}
+	}
+}
+
+func TestCheckTodo(t *testing.T) {
+	if problems := checkTodo(addSpec, types.TodoData{Title: "Buy milk", Tags: []string{"a"}, Recurrence: "FREQ=DAILY"}); len(problems) != 0 {
+		t.Errorf("valid item: %+v", problems)
+	}
+	before := -5
+	if problems := checkTodo(importSpec, types.TodoData{Title: "Taxes", RemindBefore: &before}); len(problems) != 1 || problems[0].Field != "remind_before" {
+		t.Errorf("negative remind_before: got %+v", problems)
+	}
+	problems := checkTodo(addSpec, types.TodoData{Title: " ", Active: true})
+	want := []types.FieldError{{Field: "title", Message: "May not be empty"}}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("got %+v, want %+v", problems, want)
+	}
+}
+
+func TestWithPrefix(t *testing.T) {
+	err := withPrefix(Invalid(types.FieldError{Field: "body", Message: "a"}, types.FieldError{Field: "title", Message: "b"}, types.FieldError{Message: "c"}), "ops[2]")
+	want := []types.FieldError{{Field: "ops[2]", Message: "a"}, {Field: "ops[2].title", Message: "b"}, {Message: "c"}}
+	if apiErr, ok := err.(*Error); !ok || !reflect.DeepEqual(apiErr.Fields, want) {
+		t.Errorf("got %v, want fields %+v", err, want)
+	}
+	other := Conflict("x")
+	if withPrefix(other, "ops[0]") != other {
+		t.Errorf("non-validation error was changed")
+	}
+}
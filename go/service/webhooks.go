@@ -0,0 +1,236 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/shale/go/data"
+	"github.com/shale/go/events"
+	"github.com/shale/go/types"
+	"github.com/shale/go/webhook"
+)
+
+//webhookSecretBytes is the number of random bytes in a generated webhook secret
+const webhookSecretBytes = 32
+
+//Limits on webhook fields
+const (
+	webhookURLLen       = 2048
+	webhookSecretMinLen = 16
+	webhookSecretMaxLen = 255
+)
+
+//checkWebhookURL fails unless value is an absolute http or https URL.  Unless WebhookAllowPrivate is set, URLs naming a private address are refused up front; the dispatcher checks the address of every connection as well, which catches host names resolving to one
+func (svr *ServerType) checkWebhookURL(value string) error {
+	if len(value) > webhookURLLen {
+		return invalidField("url", "Must be at most %d characters, got %d", webhookURLLen, len(value))
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return invalidField("url", "Must be an absolute http or https URL, got %q", value)
+	}
+	if svr.WebhookAllowPrivate {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if ip := net.ParseIP(host); host == "localhost" || strings.HasSuffix(host, ".localhost") || ip != nil && webhook.IsPrivate(ip) {
+		return invalidField("url", "Must not be a loopback, link-local, or private address, got %q", value)
+	}
+	return nil
+}
+
+//checkWebhookSecret fails unless value is long enough to sign with and short enough to store
+func checkWebhookSecret(value string) error {
+	if len(value) < webhookSecretMinLen || len(value) > webhookSecretMaxLen {
+		return invalidField("secret", "Must be between %d and %d characters, got %d", webhookSecretMinLen, webhookSecretMaxLen, len(value))
+	}
+	return nil
+}
+
+//checkWebhookEvents fails unless every value is an event type, and removes repeats
+func checkWebhookEvents(values []string) ([]string, error) {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, value := range values {
+		switch value {
+		case events.Created, events.Updated, events.Deleted:
+		default:
+			return nil, invalidField("events", "Must hold only %q, %q, or %q, got %q", events.Created, events.Updated, events.Deleted, value)
+		}
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
+
+//checkWebhooksEnabled fails if the server does not queue webhook deliveries
+func (svr *ServerType) checkWebhooksEnabled() error {
+	if !svr.DAO.Webhooks {
+		return NotFound("Webhooks are not enabled")
+	}
+	return nil
+}
+
+//AddWebhook subscribes a URL to the changes to the list.  A secret for signing deliveries is generated if none is given, and is only shown in this response
+func (svr *ServerType) AddWebhook(name string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.checkWebhooksEnabled(); err != nil {
+		return err
+	}
+	var raw struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+	if err := decodeBody(req, &raw); err != nil {
+		return err
+	}
+	if raw.URL == "" {
+		return invalidField("url", "Is required")
+	}
+	if err := svr.checkWebhookURL(raw.URL); err != nil {
+		return err
+	}
+	eventTypes, err := checkWebhookEvents(raw.Events)
+	if err != nil {
+		return err
+	}
+	if raw.Secret == "" {
+		buf := make([]byte, webhookSecretBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return err
+		}
+		raw.Secret = hex.EncodeToString(buf)
+	} else if err := checkWebhookSecret(raw.Secret); err != nil {
+		return err
+	}
+	created, err := svr.DAO.InsertWebhook(types.Webhook{Name: name, URL: raw.URL, Secret: raw.Secret, Events: eventTypes})
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("Location", fmt.Sprintf("/todo/%s/webhooks/%d", url.PathEscape(name), created.ID))
+	respond(resp, req, http.StatusCreated, &created)
+	return nil
+}
+
+//GetWebhooks returns the list's webhooks, without their secrets
+func (svr *ServerType) GetWebhooks(name string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.checkWebhooksEnabled(); err != nil {
+		return err
+	}
+	result, err := svr.DAO.SelectWebhooks(name)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		result = []types.Webhook{}
+	}
+	respond(resp, req, http.StatusOK, &result)
+	return nil
+}
+
+//UpdateWebhook changes the URL, secret, event types, or active flag of a webhook.  Deliveries to an inactive webhook wait until it is active again
+func (svr *ServerType) UpdateWebhook(id int, name string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.checkWebhooksEnabled(); err != nil {
+		return err
+	}
+	var update types.WebhookUpdate
+	if err := decodeBody(req, &update); err != nil {
+		return err
+	}
+	if update == (types.WebhookUpdate{}) {
+		return invalidField("body", "Must set at least one field")
+	}
+	if update.URL != nil {
+		if err := svr.checkWebhookURL(*update.URL); err != nil {
+			return err
+		}
+	}
+	if update.Secret != nil {
+		if err := checkWebhookSecret(*update.Secret); err != nil {
+			return err
+		}
+	}
+	if update.Events != nil {
+		eventTypes, err := checkWebhookEvents(*update.Events)
+		if err != nil {
+			return err
+		}
+		update.Events = &eventTypes
+	}
+	updated, err := svr.DAO.UpdateWebhook(id, name, update)
+	if err == data.ErrNotFound {
+		return NotFound("No webhook with id %d", id)
+	}
+	if err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusOK, &updated)
+	return nil
+}
+
+//RemoveWebhook removes a webhook and its delivery log
+func (svr *ServerType) RemoveWebhook(id int, name string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.checkWebhooksEnabled(); err != nil {
+		return err
+	}
+	err := svr.DAO.DeleteWebhook(id, name)
+	if err == data.ErrNotFound {
+		return NotFound("No webhook with id %d", id)
+	}
+	if err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusOK, &types.ListStatus{
+		Status:   "Success",
+		Info:     fmt.Sprintf("Webhook with '%d' id removed", id),
+		Affected: 1,
+	})
+	return nil
+}
+
+//GetDeliveries returns the delivery log of a webhook, newest first
+func (svr *ServerType) GetDeliveries(id int, name string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.checkWebhooksEnabled(); err != nil {
+		return err
+	}
+	result, err := svr.DAO.SelectDeliveries(id, name)
+	if err == data.ErrNotFound {
+		return NotFound("No webhook with id %d", id)
+	}
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		result = []types.WebhookDelivery{}
+	}
+	respond(resp, req, http.StatusOK, &result)
+	return nil
+}
+
+//Redeliver queues the payload of an earlier delivery to be posted again
+func (svr *ServerType) Redeliver(id int, value string, name string, resp http.ResponseWriter, req *http.Request) error {
+	if err := svr.checkWebhooksEnabled(); err != nil {
+		return err
+	}
+	deliveryID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || deliveryID < 1 {
+		return invalidField("delivery", "Must be a positive integer, got %q", value)
+	}
+	delivery, err := svr.DAO.Redeliver(deliveryID, id, name)
+	if err == data.ErrNotFound {
+		return NotFound("No delivery with id %d for webhook %d", deliveryID, id)
+	}
+	if err != nil {
+		return err
+	}
+	respond(resp, req, http.StatusAccepted, &delivery)
+	return nil
+}
@@ -0,0 +1,24 @@
+package service
+
+import "testing"
+
+func TestCheckWebhookURL(t *testing.T) {
+	svr := &ServerType{}
+	for _, url := range []string{"https://hooks.example.com/x", "http://8.8.8.8:8080/hook"} {
+		if err := svr.checkWebhookURL(url); err != nil {
+			t.Errorf("%s: %v", url, err)
+		}
+	}
+	private := []string{"http://localhost/hook", "http://LOCALHOST./hook", "http://api.localhost/", "http://127.0.0.1:8080/", "http://[::1]/", "http://10.0.0.5/", "http://169.254.169.254/latest/meta-data/", "http://192.168.1.1/"}
+	for _, url := range append(private, "ftp://example.com/", "/relative", "http://") {
+		if err := svr.checkWebhookURL(url); err == nil {
+			t.Errorf("%s was accepted", url)
+		}
+	}
+	svr.WebhookAllowPrivate = true
+	for _, url := range private {
+		if err := svr.checkWebhookURL(url); err != nil {
+			t.Errorf("%s with private addresses allowed: %v", url, err)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/data"
+	"github.com/shale/go/events"
+	"github.com/shale/go/types"
+	"github.com/shale/go/websocket"
+)
+
+//Types of the messages exchanged over the WebSocket API
+const (
+	SocketSubscribe   = "subscribe"
+	SocketUnsubscribe = "unsubscribe"
+	SocketAdd         = "add"
+	SocketPatch       = "patch"
+	SocketDelete      = "delete"
+
+	SocketAck   = "ack"
+	SocketError = "error"
+	SocketEvent = "event"
+	SocketReset = "reset"
+)
+
+//maxSocketLists caps the number of lists a single connection may subscribe to
+const maxSocketLists = 16
+
+//socket is a client connected to the WebSocket API.  Messages are handled one at a time, so replies go out in the order the client sent its messages
+type socket struct {
+	svr  *ServerType
+	conn *websocket.Conn
+	req  *http.Request
+	name string
+
+	mu   sync.Mutex
+	subs map[string]*events.Subscription
+	done chan struct{}
+}
+
+//ServeSocket upgrades the request to a WebSocket over which the client subscribes to lists and edits them.  Every mutation goes through the same validation, quota, and rate limit checks as the HTTP API
+func (svr *ServerType) ServeSocket(name string, resp http.ResponseWriter, req *http.Request) error {
+	if !websocket.IsUpgrade(req) {
+		return invalidField("Upgrade", "Must be websocket")
+	}
+	quota, err := svr.quotaFor(name)
+	if err != nil {
+		return err
+	}
+	conn, err := websocket.Upgrade(resp, req, svr.SocketOrigins)
+	if err == websocket.ErrBadOrigin {
+		return invalidField("Origin", "%s may not open WebSockets to this server", req.Header.Get("Origin"))
+	}
+	if err != nil {
+		return invalidField("Upgrade", "%v", err)
+	}
+	interval := svr.Heartbeat
+	if interval <= 0 {
+		interval = defaultHeartbeat
+	}
+	conn.MaxMessageSize = quota.MaxBodyBytes
+	conn.ReadTimeout = 2 * interval
+
+	s := &socket{svr: svr, conn: conn, req: req, name: name, subs: make(map[string]*events.Subscription), done: make(chan struct{})}
+	defer s.close()
+	go s.heartbeat(interval)
+	for {
+		messageType, message, err := conn.ReadMessage()
+		if err != nil {
+			log.Debugf("[%s] WebSocket for %s closed: %v", requestID(req), name, err)
+			return nil
+		}
+		if messageType != websocket.TextMessage {
+			conn.Close(websocket.CloseUnsupportedData, "messages must be JSON text")
+			return nil
+		}
+		s.handle(message)
+	}
+}
+
+//heartbeat pings the client every interval, so that dead connections time out and proxies keep live ones open
+func (s *socket) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.conn.Ping(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+//close ends every subscription and the connection
+func (s *socket) close() {
+	close(s.done)
+	s.mu.Lock()
+	for list, sub := range s.subs {
+		sub.Close()
+		delete(s.subs, list)
+	}
+	s.mu.Unlock()
+	s.conn.Close(websocket.CloseNormal, "")
+}
+
+//send writes a reply to the client
+func (s *socket) send(reply types.SocketReply) error {
+	body, err := json.Marshal(&reply)
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(websocket.TextMessage, body)
+}
+
+//fail replies to a message with an error, attaching the item's current state when the error is a version conflict
+func (s *socket) fail(msg types.SocketRequest, err error) {
+	reply := types.SocketReply{Type: SocketError, ID: msg.ID, List: msg.List, Error: problemFor(s.req, err)}
+	if err == data.ErrStale {
+		if current, err := s.svr.DAO.SelectByID(msg.TodoID, msg.List); err == nil {
+			reply.Current = &current
+		}
+	}
+	s.send(reply)
+}
+
+//handle runs a single message from the client and replies to it
+func (s *socket) handle(message []byte) {
+	var msg types.SocketRequest
+	if err := decodeStrict(message, &msg); err != nil {
+		s.fail(msg, err)
+		return
+	}
+	if msg.List == "" {
+		msg.List = s.name
+	}
+	if err := validateName(msg.List); err != nil {
+		s.fail(msg, withPrefix(err, "list"))
+		return
+	}
+	var err error
+	switch msg.Type {
+	case SocketSubscribe:
+		err = s.subscribe(msg)
+	case SocketUnsubscribe:
+		err = s.unsubscribe(msg)
+	case SocketAdd, SocketPatch, SocketDelete:
+		err = s.mutate(msg)
+	default:
+		err = invalidField("type", "Must be one of %q, %q, %q, %q, or %q, got %q", SocketSubscribe, SocketUnsubscribe, SocketAdd, SocketPatch, SocketDelete, msg.Type)
+	}
+	if err != nil {
+		s.fail(msg, err)
+	}
+}
+
+//subscribe acknowledges with the list's current items, then forwards every later change to it as an event.  A change may be sent both in the snapshot and as an event; clients keep the highest version of each item
+func (s *socket) subscribe(msg types.SocketRequest) error {
+	if s.svr.Events == nil {
+		return NotFound("Event streams are not enabled")
+	}
+	s.mu.Lock()
+	_, subscribed := s.subs[msg.List]
+	count := len(s.subs)
+	s.mu.Unlock()
+	if subscribed {
+		return Conflict("Already subscribed to %s", msg.List)
+	}
+	if count >= maxSocketLists {
+		return invalidField("list", "Must not exceed %d subscriptions per connection", maxSocketLists)
+	}
+	//Subscribe before reading the list, so no change can fall between the snapshot and the first event
+	sub, err := s.svr.Events.Subscribe(msg.List, 0)
+	if err != nil {
+		return err
+	}
+	todos, err := s.svr.DAO.SelectAllTodos(msg.List)
+	if err != nil {
+		sub.Close()
+		return err
+	}
+	s.mu.Lock()
+	s.subs[msg.List] = sub
+	s.mu.Unlock()
+	if err := s.send(types.SocketReply{Type: SocketAck, ID: msg.ID, List: msg.List, Todos: todos}); err != nil {
+		return nil
+	}
+	go s.forward(msg.List, sub)
+	return nil
+}
+
+//forward sends a subscription's events to the client until it is closed.  A subscription dropped for falling behind is reported with a reset message, after which the client subscribes again to reload the list
+func (s *socket) forward(list string, sub *events.Subscription) {
+	for event := range sub.C {
+		if err := s.send(types.SocketReply{Type: SocketEvent, List: list, Event: &event}); err != nil {
+			return
+		}
+	}
+	s.mu.Lock()
+	dropped := s.subs[list] == sub
+	if dropped {
+		delete(s.subs, list)
+	}
+	s.mu.Unlock()
+	if dropped {
+		s.send(types.SocketReply{Type: SocketReset, List: list})
+	}
+}
+
+//unsubscribe stops the events of a list
+func (s *socket) unsubscribe(msg types.SocketRequest) error {
+	s.mu.Lock()
+	sub, ok := s.subs[msg.List]
+	delete(s.subs, msg.List)
+	s.mu.Unlock()
+	if !ok {
+		return NotFound("Not subscribed to %s", msg.List)
+	}
+	sub.Close()
+	return s.send(types.SocketReply{Type: SocketAck, ID: msg.ID, List: msg.List})
+}
+
+//allow applies the rate limit of the HTTP API to a single mutation of list
+func (s *socket) allow(class string, list string) error {
+	if s.svr.Limiter == nil {
+		return nil
+	}
+	dec := s.svr.Limiter.allow(class, []string{"ip:" + clientIP(s.req), "user:" + list}, time.Now())
+	if !dec.allowed {
+		return &Error{
+			Code:    CodeRateLimited,
+			Status:  http.StatusTooManyRequests,
+			Message: fmt.Sprintf("Rate limit exceeded, retry in %s seconds", seconds(dec.retryAfter)),
+		}
+	}
+	return nil
+}
+
+//mutate adds, patches, or deletes a todo item and acknowledges with its new state.  Patches and deletes must carry the version the client last saw, and are rejected with the current item if it has changed since
+func (s *socket) mutate(msg types.SocketRequest) error {
+	class := ClassWrite
+	if msg.Type == SocketDelete {
+		class = ClassDelete
+	}
+	if err := s.allow(class, msg.List); err != nil {
+		return err
+	}
+	if msg.Type == SocketAdd {
+		if msg.TodoID != 0 || msg.Version != 0 {
+			return invalidField("todo_id", "Must not be given when adding a todo item")
+		}
+		if len(msg.Todo) == 0 {
+			return invalidField("todo", "Is required")
+		}
+		var todo types.TodoData
+		if err := decodeSpec(msg.Todo, addSpec, &todo); err != nil {
+			return withPrefix(err, "todo")
+		}
+		todo.Name = msg.List
+		todo.Active = true
+		if err := s.svr.checkTodoQuota(msg.List, todo); err != nil {
+			return err
+		}
+		created, err := s.svr.store(s.req).InsertTodo(todo)
+		if err != nil {
+			return err
+		}
+		return s.send(types.SocketReply{Type: SocketAck, ID: msg.ID, List: msg.List, Todo: &created})
+	}
+
+	if msg.TodoID < 1 {
+		return invalidField("todo_id", "Is required")
+	}
+	if msg.Version < 1 {
+		return invalidField("version", "Is required")
+	}
+	if msg.Type == SocketDelete {
+		if len(msg.Set) > 0 || len(msg.Todo) > 0 {
+			return invalidField("set", "Is not accepted when deleting a todo item")
+		}
+		if _, err := s.svr.store(s.req).DeleteByID(msg.TodoID, msg.List, msg.Version); err != nil {
+			return err
+		}
+		return s.send(types.SocketReply{Type: SocketAck, ID: msg.ID, List: msg.List})
+	}
+
+	if len(msg.Set) == 0 {
+		return invalidField("set", "Is required")
+	}
+	var patch types.TodoPatch
+	if err := decodeSpec(msg.Set, patchSpec, &patch); err != nil {
+		return withPrefix(err, "set")
+	}
+	if patch == (types.TodoPatch{}) {
+		return invalidField("set", "Must set at least one field")
+	}
+	if patch.Tags != nil {
+		quota, err := s.svr.quotaFor(msg.List)
+		if err != nil {
+			return err
+		}
+		if err := checkTags(quota, *patch.Tags); err != nil {
+			return err
+		}
+	}
+	updated, err := s.svr.store(s.req).PatchByID(msg.TodoID, patch, msg.List, msg.Version)
+	if err != nil {
+		return err
+	}
+	return s.send(types.SocketReply{Type: SocketAck, ID: msg.ID, List: msg.List, Todo: &updated})
+}
@@ -1,6 +1,11 @@
 package types
 
-import "github.com/go-sql-driver/mysql"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
 
 //TodoData is the JSON-relatable object used for API call
 type TodoData struct {
@@ -12,10 +17,457 @@ type TodoData struct {
 	PublishDate mysql.NullTime `json:"publish_date"`
 	Active      bool           `json:"active"`
 	ID          int            `json:"id"`
+	Tags        []string       `json:"tags"`
+	Version     int            `json:"version"`
+	UpdatedAt   mysql.NullTime `json:"updated_at"`
+	DeletedAt   mysql.NullTime `json:"deleted_at"`
+
+	//Due is when the item is due.  A reminder is sent at RemindAt or, if that is not set, RemindBefore minutes before Due
+	Due          *time.Time `json:"due"`
+	RemindAt     *time.Time `json:"remind_at"`
+	RemindBefore *int       `json:"remind_before"`
+
+	//Recurrence is how often the item repeats, as an iCalendar RRULE such as "FREQ=WEEKLY;INTERVAL=2".  It is recorded for clients; completing the item does not create the next one
+	Recurrence string `json:"recurrence"`
+}
+
+//ReminderTime returns when the item's reminder is due, if it has one
+func (todo TodoData) ReminderTime() (time.Time, bool) {
+	if todo.RemindAt != nil {
+		return *todo.RemindAt, true
+	}
+	if todo.Due != nil && todo.RemindBefore != nil {
+		return todo.Due.Add(-time.Duration(*todo.RemindBefore) * time.Minute), true
+	}
+	return time.Time{}, false
 }
 
 //ListStatus prides a status response for changes made to the todo list
 type ListStatus struct {
-	Status string `json:"status"`
-	Info   string `json:"info"`
+	Status   string     `json:"status"`
+	Info     string     `json:"info"`
+	Affected int64      `json:"affected"`
+	DryRun   bool       `json:"dry_run,omitempty"`
+	Todos    []TodoData `json:"todos,omitempty"`
+}
+
+//Filter selects the todo items of a list that a bulk operation applies to.  Nil fields match every item
+type Filter struct {
+	Title    *string `json:"title,omitempty"`
+	Category *string `json:"category,omitempty"`
+	Priority *int    `json:"item_priority,omitempty"`
+	Active   *bool   `json:"active,omitempty"`
+}
+
+//TodoPatch holds the fields to change on a todo item.  Nil fields are left as they are.  The due date and reminder fields are cleared by setting them to null
+type TodoPatch struct {
+	Title        *string   `json:"title,omitempty"`
+	Body         *string   `json:"body,omitempty"`
+	Category     *string   `json:"category,omitempty"`
+	Priority     *int      `json:"item_priority,omitempty"`
+	Active       *bool     `json:"active,omitempty"`
+	Tags         *[]string `json:"tags,omitempty"`
+	Due          PatchTime `json:"due"`
+	RemindAt     PatchTime `json:"remind_at"`
+	RemindBefore PatchInt  `json:"remind_before"`
+	Recurrence   *string   `json:"recurrence,omitempty"`
+}
+
+//PatchTime is a time field of a patch, which may be left as it is, set, or cleared with null
+type PatchTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+//UnmarshalJSON marks the field as set, to null or a time
+func (p *PatchTime) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	p.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &p.Value)
+}
+
+//MarshalJSON writes the value of the field, which is null when it is cleared or not set
+func (p PatchTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Value)
+}
+
+//PatchInt is an integer field of a patch, which may be left as it is, set, or cleared with null
+type PatchInt struct {
+	Set   bool
+	Value *int
+}
+
+//UnmarshalJSON marks the field as set, to null or an integer
+func (p *PatchInt) UnmarshalJSON(data []byte) error {
+	p.Set = true
+	p.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &p.Value)
+}
+
+//MarshalJSON writes the value of the field, which is null when it is cleared or not set
+func (p PatchInt) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Value)
+}
+
+//Apply sets the fields of the patch on todo
+func (patch TodoPatch) Apply(todo *TodoData) {
+	if patch.Title != nil {
+		todo.Title = *patch.Title
+	}
+	if patch.Body != nil {
+		todo.Body = *patch.Body
+	}
+	if patch.Category != nil {
+		todo.Category = *patch.Category
+	}
+	if patch.Priority != nil {
+		todo.Priority = *patch.Priority
+	}
+	if patch.Active != nil {
+		todo.Active = *patch.Active
+	}
+	if patch.Tags != nil {
+		todo.Tags = *patch.Tags
+	}
+	if patch.Due.Set {
+		todo.Due = patch.Due.Value
+	}
+	if patch.RemindAt.Set {
+		todo.RemindAt = patch.RemindAt.Value
+	}
+	if patch.RemindBefore.Set {
+		todo.RemindBefore = patch.RemindBefore.Value
+	}
+	if patch.Recurrence != nil {
+		todo.Recurrence = *patch.Recurrence
+	}
+}
+
+//BulkUpdate sets the fields in Set on every todo item matching Filter
+type BulkUpdate struct {
+	Filter Filter    `json:"filter"`
+	Set    TodoPatch `json:"set"`
+}
+
+//BatchRequest is a list of writes to run in a single transaction.  Mode is "atomic" (the default) or "best_effort"
+type BatchRequest struct {
+	Mode string    `json:"mode"`
+	Ops  []BatchOp `json:"ops"`
+}
+
+//BatchOp is a single create, update, or delete in a batch.  Todo holds the item to create or the fields to update
+type BatchOp struct {
+	Op      string          `json:"op"`
+	ID      int             `json:"id,omitempty"`
+	Version int             `json:"version,omitempty"`
+	Todo    json.RawMessage `json:"todo,omitempty"`
+}
+
+//BatchResult is the outcome of a single operation in a batch
+type BatchResult struct {
+	Index  int       `json:"index"`
+	Op     string    `json:"op"`
+	Status int       `json:"status"`
+	Todo   *TodoData `json:"todo,omitempty"`
+	Error  *Problem  `json:"error,omitempty"`
+}
+
+//BatchResponse reports the outcome of a batch.  Applied is false when an atomic batch was rolled back
+type BatchResponse struct {
+	Mode      string        `json:"mode"`
+	Applied   bool          `json:"applied"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+//ImportRow reports what an import did, or would do in a dry run, with a single todo item of the imported file.  Action is "create", "update", or "skip"
+type ImportRow struct {
+	Ref    string    `json:"ref"`
+	Action string    `json:"action"`
+	Todo   *TodoData `json:"todo,omitempty"`
+}
+
+//ImportResult reports the outcome of importing a file
+type ImportResult struct {
+	Format  string      `json:"format"`
+	DryRun  bool        `json:"dry_run,omitempty"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Skipped int         `json:"skipped"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+//CalendarItem is a todo item as a CalDAV resource.  UID and Href are those chosen by the CalDAV client that created it, and are empty for items created any other way
+type CalendarItem struct {
+	Todo TodoData
+	UID  string
+	Href string
+}
+
+//FeedToken is a newly issued calendar feed token, with the read-only feed URL it gives access to.  The token is only ever shown when it is issued
+type FeedToken struct {
+	Token   string `json:"token"`
+	FeedURL string `json:"feed_url"`
+}
+
+//CalDAVToken is a newly issued CalDAV password, with the username and URL to sign in with.  It is only ever shown when it is issued
+type CalDAVToken struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	CalDAVURL string `json:"caldav_url"`
+}
+
+//InboundAddress is a newly issued secret email address that creates todo items on a list.  It is only ever shown when it is issued
+type InboundAddress struct {
+	Address string `json:"address"`
+	Token   string `json:"token"`
+}
+
+//Quota holds the per-account limits checked before todo items are written.  A zero value means no limit
+type Quota struct {
+	MaxTodos     int   `json:"max_todos"`
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	MaxTags      int   `json:"max_tags"`
+}
+
+//UsageItem reports how much of a single quota has been used
+type UsageItem struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+//Usage reports an account's current usage against its quotas
+type Usage struct {
+	Name         string    `json:"acct_name"`
+	Todos        UsageItem `json:"todos"`
+	MaxBodyBytes int64     `json:"max_body_bytes"`
+	MaxTags      int       `json:"max_tags"`
+}
+
+//FieldError describes a problem with a single field of a request
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+//Problem is an RFC 7807 problem details body returned for every failed request
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	Quota     string       `json:"quota,omitempty"`
+	Limit     int64        `json:"limit,omitempty"`
+	Used      int64        `json:"used,omitempty"`
+}
+
+//ListState tracks changes to an account's todo list.  Seq increases with every write to the list
+type ListState struct {
+	Seq       int64
+	UpdatedAt time.Time
+}
+
+//IdempotencyRecord is the stored outcome of a request made with an Idempotency-Key.  A zero Status means the request is still in progress
+type IdempotencyRecord struct {
+	Key         string
+	Fingerprint string
+	Status      int
+	Header      map[string]string
+	Body        []byte
+}
+
+//Change is the state of a todo item before and after a single write.  Before is nil for newly added items
+type Change struct {
+	Before *TodoData `json:"before"`
+	After  *TodoData `json:"after"`
+}
+
+//OpResult reports an operation that was undone or redone, with the resulting state of each todo item it touched
+type OpResult struct {
+	Op    string     `json:"op"`
+	Todos []TodoData `json:"todos"`
+}
+
+//AuditRecord is one field of a todo item changed by a write, with who made the change and when
+type AuditRecord struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"acct_name"`
+	TodoID    int       `json:"todo_id"`
+	Op        string    `json:"op"`
+	Field     string    `json:"field"`
+	OldValue  *string   `json:"old_value"`
+	NewValue  *string   `json:"new_value"`
+	Actor     string    `json:"actor"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+//AuditFilter selects audit records.  Zero fields match everything
+type AuditFilter struct {
+	Name   string
+	Actor  string
+	TodoID int
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+//Event reports a change to a single todo item on an account's list.  Type is "created", "updated", or "deleted", and Todo is the item after the change
+type Event struct {
+	ID   int64     `json:"id"`
+	Type string    `json:"type"`
+	Name string    `json:"acct_name"`
+	Todo TodoData  `json:"todo"`
+	Time time.Time `json:"time"`
+}
+
+//SocketRequest is a message sent by a client over the WebSocket API.  Type is "subscribe", "unsubscribe", "add", "patch", or "delete", and ID is an identifier chosen by the client that is echoed in the reply
+type SocketRequest struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	List    string          `json:"list,omitempty"`
+	TodoID  int             `json:"todo_id,omitempty"`
+	Version int             `json:"version,omitempty"`
+	Todo    json.RawMessage `json:"todo,omitempty"`
+	Set     json.RawMessage `json:"set,omitempty"`
+}
+
+//SocketReply is a message sent by the server over the WebSocket API.  Type is "ack", "error", or "event".  Current holds the item as it is now when a mutation was rejected because the item had changed
+type SocketReply struct {
+	Type    string     `json:"type"`
+	ID      string     `json:"id,omitempty"`
+	List    string     `json:"list,omitempty"`
+	Todo    *TodoData  `json:"todo,omitempty"`
+	Todos   []TodoData `json:"todos,omitempty"`
+	Event   *Event     `json:"event,omitempty"`
+	Error   *Problem   `json:"error,omitempty"`
+	Current *TodoData  `json:"current,omitempty"`
+}
+
+//Webhook is a subscription that posts the changes to an account's list to a URL.  Events lists the event types sent, and is empty to send every type.  The secret signing each delivery is only shown when it is set
+type Webhook struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"acct_name"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+//WebhookUpdate changes the fields of a webhook that are given
+type WebhookUpdate struct {
+	URL    *string   `json:"url"`
+	Secret *string   `json:"secret"`
+	Events *[]string `json:"events"`
+	Active *bool     `json:"active"`
+}
+
+//WebhookPayload is the JSON body posted to a webhook for a single change
+type WebhookPayload struct {
+	Event string    `json:"event"`
+	Name  string    `json:"acct_name"`
+	Todo  TodoData  `json:"todo"`
+	Time  time.Time `json:"time"`
+}
+
+//WebhookDelivery is one payload posted, or to be posted, to a webhook.  Status is "pending" until the receiver answers with a 2xx status, and becomes "delivered", or "dead" once every attempt has failed
+type WebhookDelivery struct {
+	ID            int64           `json:"id"`
+	WebhookID     int             `json:"webhook_id"`
+	Name          string          `json:"acct_name"`
+	EventType     string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	ResponseCode  int             `json:"response_code,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+
+	//URL and Secret are the target of the delivery, filled in for the dispatcher
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+//UserSettings are an account's preferences.  Quiet hours, from QuietStart to QuietEnd as "HH:MM" in Timezone, hold back reminders until they end; they are off when either is empty.  With Digest on, a digest of the items needing attention is emailed every day at DigestTime, listing items at DigestPriority or higher
+type UserSettings struct {
+	Name           string `json:"acct_name"`
+	Timezone       string `json:"timezone"`
+	QuietStart     string `json:"quiet_start"`
+	QuietEnd       string `json:"quiet_end"`
+	Email          string `json:"email"`
+	Digest         bool   `json:"digest"`
+	DigestTime     string `json:"digest_time"`
+	DigestPriority int    `json:"digest_priority"`
+}
+
+//SettingsUpdate changes the settings that are given
+type SettingsUpdate struct {
+	Timezone       *string `json:"timezone"`
+	QuietStart     *string `json:"quiet_start"`
+	QuietEnd       *string `json:"quiet_end"`
+	Email          *string `json:"email"`
+	Digest         *bool   `json:"digest"`
+	DigestTime     *string `json:"digest_time"`
+	DigestPriority *int    `json:"digest_priority"`
+}
+
+//Reminder is a todo item whose reminder is due at FireAt
+type Reminder struct {
+	Todo   TodoData  `json:"todo"`
+	FireAt time.Time `json:"remind_at"`
+}
+
+//Digest is the summary of an account's active todo items needing attention on a day, in the account's timezone.  An item appears in only the first section it belongs to
+type Digest struct {
+	Name         string     `json:"acct_name"`
+	Date         string     `json:"date"`
+	Timezone     string     `json:"timezone"`
+	Priority     int        `json:"priority"`
+	Overdue      []TodoData `json:"overdue"`
+	DueToday     []TodoData `json:"due_today"`
+	HighPriority []TodoData `json:"high_priority"`
+}
+
+//Empty reports whether the digest lists no items
+func (d Digest) Empty() bool {
+	return len(d.Overdue) == 0 && len(d.DueToday) == 0 && len(d.HighPriority) == 0
+}
+
+//DigestPreview is a digest rendered as it would be emailed
+type DigestPreview struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+	Digest  Digest `json:"digest"`
+}
+
+//QuickAddParsed is what a quick add line parses to.  Fields the line does not mention are left empty
+type QuickAddParsed struct {
+	Title      string     `json:"title"`
+	Due        *time.Time `json:"due,omitempty"`
+	Category   string     `json:"category,omitempty"`
+	Tags       []string   `json:"tags,omitempty"`
+	Priority   *int       `json:"item_priority,omitempty"`
+	Recurrence string     `json:"recurrence,omitempty"`
+}
+
+//QuickAddResult is the response to a quick add: what the line parsed to, and the item added from it unless it was a dry run
+type QuickAddResult struct {
+	Parsed QuickAddParsed `json:"parsed"`
+	Todo   *TodoData      `json:"todo,omitempty"`
+	DryRun bool           `json:"dry_run,omitempty"`
 }
@@ -0,0 +1,281 @@
+//Package webhook posts the changes to todo lists to the URLs accounts subscribe, signing every delivery and retrying failed ones with exponential backoff
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bdlm/log"
+	"github.com/shale/go/types"
+)
+
+//Delivery statuses
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Dead      = "dead"
+)
+
+//Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Shale-Event"
+	HeaderDelivery  = "X-Shale-Delivery"
+	HeaderTimestamp = "X-Shale-Timestamp"
+	HeaderSignature = "X-Shale-Signature"
+)
+
+//claimBatch is the most deliveries claimed in a single poll
+const claimBatch = 20
+
+//maxBodyLen caps the receiver's response read before its connection is reused
+const maxBodyLen = 512
+
+//maxErrorLen caps the length of a delivery's last error
+const maxErrorLen = 1024
+
+//ErrPrivateAddress is the error of an attempt to post to a loopback, link-local, or private address
+var ErrPrivateAddress = errors.New("webhook: refusing to post to a loopback, link-local, or private address")
+
+//IsPrivate reports whether ip is an address webhooks may not post to unless AllowPrivate is set: loopback, link-local, private, or unspecified
+func IsPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified()
+}
+
+//NewClient returns the HTTP client deliveries are posted with.  Unless allowPrivate is set it refuses to connect to private addresses, checking the address every connection is made to so that DNS cannot point a webhook inside the network after it was added.  Redirects are not followed
+func NewClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || IsPrivate(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: timeout,
+		//A proxy would make the connection on the dispatcher's behalf, out of reach of the address check
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+//Store holds the deliveries waiting to be posted
+type Store interface {
+	//ClaimDeliveries returns up to limit pending deliveries that are due, hiding them from other dispatchers for lease
+	ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]types.WebhookDelivery, error)
+
+	//FinishAttempt records the outcome of an attempt to post a delivery
+	FinishAttempt(delivery types.WebhookDelivery) error
+
+	//PurgeDeliveries removes delivered and dead deliveries last updated before the given time
+	PurgeDeliveries(before time.Time) error
+}
+
+//Options configure a dispatcher.  Zero values select the defaults
+type Options struct {
+	//Interval is how often the store is polled for due deliveries
+	Interval time.Duration
+
+	//MaxAttempts is the number of attempts after which a delivery is dead
+	MaxAttempts int
+
+	//RetryBase is the delay before the first retry, which doubles with every attempt up to RetryMax
+	RetryBase time.Duration
+	RetryMax  time.Duration
+
+	//Timeout limits each attempt
+	Timeout time.Duration
+
+	//Retention is how long delivered and dead deliveries are kept in the delivery log
+	Retention time.Duration
+
+	//AllowPrivate lets deliveries reach loopback, link-local, and private addresses, for testing against a local receiver
+	AllowPrivate bool
+}
+
+//Dispatcher posts pending deliveries and schedules the retries of failed ones.  Any number of dispatchers, on any number of instances, can share a store
+type Dispatcher struct {
+	store  Store
+	client *http.Client
+	opts   Options
+}
+
+//NewDispatcher creates a dispatcher for the deliveries in store
+func NewDispatcher(store Store, opts Options) *Dispatcher {
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.RetryBase <= 0 {
+		opts.RetryBase = 30 * time.Second
+	}
+	if opts.RetryMax <= 0 {
+		opts.RetryMax = 6 * time.Hour
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 7 * 24 * time.Hour
+	}
+	return &Dispatcher{store: store, client: NewClient(opts.Timeout, opts.AllowPrivate), opts: opts}
+}
+
+//Run posts due deliveries every interval, until the process exits
+func (d *Dispatcher) Run() {
+	polls := 0
+	for range time.Tick(d.opts.Interval) {
+		d.Poll()
+		polls++
+		if polls%1000 == 0 {
+			d.Purge()
+		}
+	}
+}
+
+//Purge removes the delivered and dead deliveries that are older than the retention.  Pending deliveries are kept however old they are
+func (d *Dispatcher) Purge() {
+	if err := d.store.PurgeDeliveries(time.Now().Add(-d.opts.Retention)); err != nil {
+		log.Warnf("Error purging webhook deliveries: %v", err)
+	}
+}
+
+//Poll posts every delivery that is due, returning once they have all been attempted
+func (d *Dispatcher) Poll() {
+	for {
+		//The lease outlasts every attempt of the batch, so a claimed delivery is not posted twice
+		deliveries, err := d.store.ClaimDeliveries(time.Now(), 2*d.opts.Timeout, claimBatch)
+		if err != nil {
+			log.Warnf("Error claiming webhook deliveries: %v", err)
+			return
+		}
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery types.WebhookDelivery) {
+				defer wg.Done()
+				d.attempt(delivery)
+			}(delivery)
+		}
+		wg.Wait()
+		if len(deliveries) < claimBatch {
+			return
+		}
+	}
+}
+
+//attempt posts a delivery and records the outcome
+func (d *Dispatcher) attempt(delivery types.WebhookDelivery) {
+	code, err := d.post(delivery)
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	delivery.LastError = ""
+	if err != nil {
+		delivery.LastError = err.Error()
+		if len(delivery.LastError) > maxErrorLen {
+			delivery.LastError = strings.ToValidUTF8(delivery.LastError[:maxErrorLen], "")
+		}
+	}
+	switch {
+	case err == nil:
+		delivery.Status = Delivered
+	case delivery.Attempts >= d.opts.MaxAttempts:
+		delivery.Status = Dead
+		log.Warnf("Webhook delivery %d to %s is dead after %d attempts: %v", delivery.ID, delivery.URL, delivery.Attempts, err)
+	default:
+		delivery.Status = Pending
+		delivery.NextAttemptAt = time.Now().Add(Backoff(d.opts.RetryBase, d.opts.RetryMax, delivery.Attempts))
+	}
+	if err := d.store.FinishAttempt(delivery); err != nil {
+		log.Errorf("Error recording webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+//post sends a delivery to its webhook, failing unless the receiver answers with a 2xx status.  Only the status line of a failure is kept, since the receiver's body is not the account's to read
+func (d *Dispatcher) post(delivery types.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shale-webhook")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBodyLen))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+//Backoff returns the delay before the retry that follows the given number of attempts, doubling from base up to max
+func Backoff(base time.Duration, max time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+//Sign returns the signature header of a payload: the hex HMAC-SHA256, keyed with the webhook secret, of the timestamp header, a dot, and the payload
+func Sign(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//Verify checks the signature of a delivery received by a webhook, and that its timestamp is within tolerance of now
+func Verify(secret string, header http.Header, payload []byte, tolerance time.Duration) error {
+	timestamp := header.Get(HeaderTimestamp)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad %s header %q", HeaderTimestamp, timestamp)
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("delivery timestamp is %s old", age)
+	}
+	if !hmac.Equal([]byte(header.Get(HeaderSignature)), []byte(Sign(secret, timestamp, payload))) {
+		return fmt.Errorf("bad %s header", HeaderSignature)
+	}
+	return nil
+}
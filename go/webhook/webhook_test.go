@@ -0,0 +1,271 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shale/go/types"
+)
+
+const testSecret = "0123456789abcdef"
+
+func TestSign(t *testing.T) {
+	got := Sign(testSecret, "1588352531", []byte(`{"id":1}`))
+	if want := "sha256=b92953cdaada9b19e4b60f602d752f12583fb65aa4b0697b137177e89b6477ab"; got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	payload := []byte(`{"id":1}`)
+	signed := func(secret string, at time.Time) http.Header {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		header := http.Header{}
+		header.Set(HeaderTimestamp, timestamp)
+		header.Set(HeaderSignature, Sign(secret, timestamp, payload))
+		return header
+	}
+	if err := Verify(testSecret, signed(testSecret, time.Now()), payload, time.Minute); err != nil {
+		t.Errorf("valid delivery: %v", err)
+	}
+	if err := Verify(testSecret, signed("fedcba9876543210", time.Now()), payload, time.Minute); err == nil {
+		t.Errorf("delivery signed with another secret was accepted")
+	}
+	if err := Verify(testSecret, signed(testSecret, time.Now().Add(-time.Hour)), payload, time.Minute); err == nil {
+		t.Errorf("old delivery was accepted")
+	}
+	if err := Verify(testSecret, signed(testSecret, time.Now()), []byte(`{"id":2}`), time.Minute); err == nil {
+		t.Errorf("altered payload was accepted")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{10, time.Hour},
+		{100, time.Hour},
+	}
+	for _, test := range tests {
+		if got := Backoff(30*time.Second, time.Hour, test.attempts); got != test.want {
+			t.Errorf("Backoff after %d attempts = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestIsPrivate(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "0.0.0.0"} {
+		if !IsPrivate(net.ParseIP(addr)) {
+			t.Errorf("%s is not private", addr)
+		}
+	}
+	for _, addr := range []string{"8.8.8.8", "172.32.0.1", "2001:4860:4860::8888"} {
+		if IsPrivate(net.ParseIP(addr)) {
+			t.Errorf("%s is private", addr)
+		}
+	}
+}
+
+//fakeStore hands out its deliveries whenever they are pending and due, and records every attempt
+type fakeStore struct {
+	mu         sync.Mutex
+	deliveries []types.WebhookDelivery
+	attempts   []types.WebhookDelivery
+}
+
+func (s *fakeStore) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]types.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed []types.WebhookDelivery
+	for i, delivery := range s.deliveries {
+		if delivery.Status == Pending && !delivery.NextAttemptAt.After(now) && len(claimed) < limit {
+			s.deliveries[i].NextAttemptAt = now.Add(lease)
+			claimed = append(claimed, delivery)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *fakeStore) FinishAttempt(delivery types.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == delivery.ID {
+			s.deliveries[i] = delivery
+		}
+	}
+	s.attempts = append(s.attempts, delivery)
+	return nil
+}
+
+func (s *fakeStore) PurgeDeliveries(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.deliveries[:0]
+	for _, delivery := range s.deliveries {
+		if delivery.Status == Pending || !delivery.UpdatedAt.Before(before) {
+			kept = append(kept, delivery)
+		}
+	}
+	s.deliveries = kept
+	return nil
+}
+
+//due makes every pending delivery due now, skipping its backoff
+func (s *fakeStore) due() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.deliveries {
+		s.deliveries[i].NextAttemptAt = time.Time{}
+	}
+}
+
+func newDelivery(url string) types.WebhookDelivery {
+	return types.WebhookDelivery{ID: 42, EventType: "updated", Payload: []byte(`{"id":1}`), Status: Pending, URL: url, Secret: testSecret}
+}
+
+func TestDispatcherSignsAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	var received []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if err := Verify(testSecret, req.Header, body, time.Minute); err != nil {
+			t.Errorf("delivery does not verify: %v", err)
+		}
+		mu.Lock()
+		received = append(received, req)
+		n := len(received)
+		mu.Unlock()
+		if n < 3 {
+			http.Error(resp, "database password is hunter2", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	store := &fakeStore{deliveries: []types.WebhookDelivery{newDelivery(srv.URL + "/hook")}}
+	d := NewDispatcher(store, Options{MaxAttempts: 5, RetryBase: time.Minute, RetryMax: time.Hour, AllowPrivate: true})
+
+	start := time.Now()
+	d.Poll()
+	d.Poll()
+	if len(store.attempts) != 1 {
+		t.Fatalf("got %d attempts, want a single attempt before the backoff ends", len(store.attempts))
+	}
+	first := store.attempts[0]
+	if first.Status != Pending || first.Attempts != 1 || first.ResponseCode != 500 {
+		t.Errorf("first attempt recorded as %+v", first)
+	}
+	if first.LastError != "receiver answered 500 Internal Server Error" {
+		t.Errorf("last error %q, want only the status line", first.LastError)
+	}
+	if wait := first.NextAttemptAt.Sub(start); wait < time.Minute || wait > time.Minute+5*time.Second {
+		t.Errorf("retry scheduled after %v, want 1m", wait)
+	}
+
+	store.due()
+	d.Poll()
+	if second := store.attempts[1]; second.NextAttemptAt.Sub(time.Now()) < 119*time.Second {
+		t.Errorf("second retry scheduled at %v, want the backoff doubled", second.NextAttemptAt)
+	}
+	store.due()
+	d.Poll()
+	last := store.attempts[len(store.attempts)-1]
+	if len(store.attempts) != 3 || last.Status != Delivered || last.ResponseCode != 200 || last.LastError != "" {
+		t.Errorf("got %d attempts ending in %+v, want delivered on the third", len(store.attempts), last)
+	}
+	if req := received[0]; req.Header.Get(HeaderEvent) != "updated" || req.Header.Get(HeaderDelivery) != "42" || req.URL.Path != "/hook" {
+		t.Errorf("delivery posted with headers %v to %s", req.Header, req.URL.Path)
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	store := &fakeStore{deliveries: []types.WebhookDelivery{newDelivery(srv.URL)}}
+	d := NewDispatcher(store, Options{MaxAttempts: 2, AllowPrivate: true})
+	d.Poll()
+	store.due()
+	d.Poll()
+	store.due()
+	d.Poll()
+	if len(store.attempts) != 2 || store.attempts[1].Status != Dead {
+		t.Errorf("got attempts %+v, want dead after 2", store.attempts)
+	}
+}
+
+func TestDispatcherDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hook", func(resp http.ResponseWriter, req *http.Request) {
+		http.Redirect(resp, req, "/internal", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/internal", func(resp http.ResponseWriter, req *http.Request) {
+		followed = true
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	store := &fakeStore{deliveries: []types.WebhookDelivery{newDelivery(srv.URL + "/hook")}}
+	NewDispatcher(store, Options{AllowPrivate: true}).Poll()
+	if followed {
+		t.Errorf("redirect was followed")
+	}
+	if got := store.attempts[0]; got.Status != Pending || got.ResponseCode != http.StatusTemporaryRedirect {
+		t.Errorf("redirect recorded as %+v, want a failed attempt", got)
+	}
+}
+
+func TestDispatcherRefusesPrivateAddresses(t *testing.T) {
+	hit := false
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		hit = true
+	}))
+	defer srv.Close()
+	//localhost resolves to a loopback address, so the check must happen when connecting rather than on the URL
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	store := &fakeStore{deliveries: []types.WebhookDelivery{newDelivery(srv.URL), newDelivery(url)}}
+	store.deliveries[1].ID = 43
+	NewDispatcher(store, Options{}).Poll()
+	if hit {
+		t.Errorf("delivery reached a loopback address")
+	}
+	for _, attempt := range store.attempts {
+		if attempt.Status != Pending || !strings.Contains(attempt.LastError, ErrPrivateAddress.Error()) {
+			t.Errorf("attempt recorded as %+v, want refused", attempt)
+		}
+	}
+}
+
+func TestDispatcherPurge(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	store := &fakeStore{}
+	for i, status := range []string{Delivered, Dead, Pending, Delivered} {
+		delivery := newDelivery("http://example.com")
+		delivery.ID = int64(i + 1)
+		delivery.Status = status
+		delivery.UpdatedAt = old
+		store.deliveries = append(store.deliveries, delivery)
+	}
+	store.deliveries[3].UpdatedAt = time.Now()
+	NewDispatcher(store, Options{Retention: 24 * time.Hour}).Purge()
+	var kept []int64
+	for _, delivery := range store.deliveries {
+		kept = append(kept, delivery.ID)
+	}
+	if !reflect.DeepEqual(kept, []int64{3, 4}) {
+		t.Errorf("kept deliveries %v, want the pending one and the recent one", kept)
+	}
+}
@@ -0,0 +1,360 @@
+//Package websocket is a minimal server side implementation of the WebSocket protocol, RFC 6455
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+//Message types, which are the opcodes of their first frame
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+//Control and continuation opcodes
+const (
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+//Close status codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+//acceptGUID is appended to the client's key to compute Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+//maxControlPayload is the largest payload a control frame may carry
+const maxControlPayload = 125
+
+//maxMessageSize is the largest message read from a peer, whatever the connection's limit
+const maxMessageSize = 16 << 20
+
+//ErrMessageTooBig is returned by ReadMessage when a message is larger than the connection's limit
+var ErrMessageTooBig = errors.New("websocket: message too big")
+
+//ErrBadOrigin is returned by Upgrade when a browser opens the connection from a page on an origin that is not allowed
+var ErrBadOrigin = errors.New("websocket: origin not allowed")
+
+//CloseError is returned by ReadMessage when the peer closes the connection
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with status %d %s", e.Code, e.Reason)
+}
+
+//protocolError is a violation of the protocol by the peer
+type protocolError struct {
+	code int
+	msg  string
+}
+
+func (e *protocolError) Error() string {
+	return "websocket: " + e.msg
+}
+
+//Conn is a WebSocket connection.  Reads must come from a single goroutine; writes may come from any number
+type Conn struct {
+	conn net.Conn
+	in   *bufio.Reader
+
+	//MaxMessageSize limits the size of a message read from the peer.  Zero means the package limit of 16 MiB, which also caps larger values
+	MaxMessageSize int64
+
+	//ReadTimeout is how long the peer may go without sending a frame, pongs included.  Zero means no timeout
+	ReadTimeout time.Duration
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+//headerContains reports whether a comma separated header holds a token, ignoring case
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//IsUpgrade reports whether a request asks to upgrade to a WebSocket
+func IsUpgrade(req *http.Request) bool {
+	return headerContains(req.Header, "Connection", "upgrade") && headerContains(req.Header, "Upgrade", "websocket")
+}
+
+//originAllowed reports whether a request may open a WebSocket given its Origin header.  Requests without one do not come from a browser and are allowed; browser requests must come from the server's own origin or one of allowed
+func originAllowed(req *http.Request, allowed []string) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host != "" && strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+	for _, o := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+//Upgrade completes the opening handshake of a WebSocket request and takes over its connection.  allowedOrigins lists the origins, such as "https://app.example.com", besides the server's own that browsers may connect from; any other Origin fails with ErrBadOrigin.  If the request is not a valid WebSocket handshake an error is returned and nothing is written, so the caller can respond with it
+func Upgrade(resp http.ResponseWriter, req *http.Request, allowedOrigins []string) (*Conn, error) {
+	if req.Method != "GET" {
+		return nil, errors.New("a WebSocket handshake must use GET")
+	}
+	if !IsUpgrade(req) {
+		return nil, errors.New("the request does not ask to upgrade to a WebSocket")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		resp.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, errors.New("only WebSocket version 13 is supported")
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, errors.New("Sec-WebSocket-Key is not valid")
+	}
+	if !originAllowed(req, allowedOrigins) {
+		return nil, ErrBadOrigin
+	}
+	hijacker, ok := resp.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("the connection cannot be taken over")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	handshake := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, in: rw.Reader}, nil
+}
+
+//readFrame reads a single frame, unmasking its payload.  limit caps the payload length
+func (c *Conn) readFrame(limit int64) (fin bool, opcode int, payload []byte, err error) {
+	if c.ReadTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout))
+	}
+	var head [2]byte
+	if _, err = io.ReadFull(c.in, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	opcode = int(head[0] & 0x0f)
+	if head[0]&0x70 != 0 {
+		err = &protocolError{CloseProtocolError, "reserved bits set"}
+		return
+	}
+	if head[1]&0x80 == 0 {
+		err = &protocolError{CloseProtocolError, "client frames must be masked"}
+		return
+	}
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.in, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.in, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			err = &protocolError{CloseProtocolError, "bad frame length"}
+			return
+		}
+	}
+	if opcode >= opClose && (length > maxControlPayload || !fin) {
+		err = &protocolError{CloseProtocolError, "bad control frame"}
+		return
+	}
+	if length > limit {
+		err = ErrMessageTooBig
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.in, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.in, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+//messageLimit returns the largest message the connection reads
+func (c *Conn) messageLimit() int64 {
+	if c.MaxMessageSize > 0 && c.MaxMessageSize < maxMessageSize {
+		return c.MaxMessageSize
+	}
+	return maxMessageSize
+}
+
+//ReadMessage reads the next data message, answering pings and reassembling fragmented messages along the way.  When the peer closes the connection it returns a *CloseError.  Protocol errors close the connection
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	messageType := 0
+	var message []byte
+	for {
+		limit := c.messageLimit() - int64(len(message))
+		if limit < maxControlPayload {
+			limit = maxControlPayload
+		}
+		fin, opcode, payload, err := c.readFrame(limit)
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			closeErr := &CloseError{Code: CloseNormal}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			c.Close(CloseNormal, "")
+			return 0, nil, closeErr
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(&protocolError{CloseProtocolError, "new message before the last one finished"})
+			}
+			messageType = opcode
+		case opContinuation:
+			if messageType == 0 {
+				return 0, nil, c.fail(&protocolError{CloseProtocolError, "continuation without a message"})
+			}
+		default:
+			return 0, nil, c.fail(&protocolError{CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode)})
+		}
+		message = append(message, payload...)
+		if int64(len(message)) > c.messageLimit() {
+			return 0, nil, c.fail(ErrMessageTooBig)
+		}
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(message) {
+				return 0, nil, c.fail(&protocolError{CloseInvalidPayload, "text message is not valid UTF-8"})
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+//fail closes the connection with the status matching a read error, and returns the error
+func (c *Conn) fail(err error) error {
+	switch e := err.(type) {
+	case *protocolError:
+		c.Close(e.code, e.msg)
+	default:
+		if err == ErrMessageTooBig {
+			c.Close(CloseMessageTooBig, "message too big")
+		} else {
+			c.conn.Close()
+		}
+	}
+	return err
+}
+
+//writeFrame writes a single unfragmented frame
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errors.New("websocket: connection closed")
+	}
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(opcode)
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+//WriteMessage writes a text or binary message
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	return c.writeFrame(messageType, data)
+}
+
+//Ping sends a ping, which the peer answers with a pong that ReadMessage consumes
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+//Close sends a close frame with the given status and closes the connection.  Closing more than once has no effect
+func (c *Conn) Close(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	c.writeFrame(opClose, append(payload, reason...))
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
@@ -7,5 +7,185 @@ CREATE TABLE Todos (
     item_priority INT default 0,
     publish_date DATE NOT NULL,
     active BOOLEAN NOT NULL,
-    PRIMARY KEY (id)
+    tags VARCHAR(255),
+    version INT NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at DATETIME NULL,
+    ical_uid VARCHAR(255),
+    dav_name VARCHAR(255),
+    due_at DATETIME NULL,
+    remind_at DATETIME NULL,
+    remind_before INT NULL,
+    recurrence VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (id),
+    KEY (acct_name, deleted_at),
+    UNIQUE KEY (acct_name, dav_name)
+);
+
+CREATE TABLE Lists (
+    acct_name VARCHAR(255) NOT NULL,
+    change_seq BIGINT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (acct_name)
+);
+
+CREATE TABLE Quotas (
+    acct_name VARCHAR(255) NOT NULL,
+    max_todos INT,
+    max_body_bytes INT,
+    max_tags INT,
+    PRIMARY KEY (acct_name)
+);
+
+CREATE TABLE IdempotencyKeys (
+    acct_name VARCHAR(255) NOT NULL,
+    idem_key VARCHAR(255) NOT NULL,
+    fingerprint CHAR(64) NOT NULL,
+    status_code INT NOT NULL DEFAULT 0,
+    response_headers TEXT,
+    response_body MEDIUMBLOB,
+    expires_at DATETIME NOT NULL,
+    PRIMARY KEY (acct_name, idem_key),
+    KEY (expires_at)
+);
+
+CREATE TABLE OpLog (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    acct_name VARCHAR(255) NOT NULL,
+    op VARCHAR(32) NOT NULL,
+    changes MEDIUMTEXT NOT NULL,
+    undone BOOLEAN NOT NULL,
+    undo_state MEDIUMTEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY (acct_name, id)
+);
+
+CREATE TABLE Audit (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    acct_name VARCHAR(255) NOT NULL,
+    todo_id INT NOT NULL,
+    op VARCHAR(32) NOT NULL,
+    field VARCHAR(32) NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    actor VARCHAR(255) NOT NULL,
+    request_id VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY (acct_name, todo_id),
+    KEY (created_at)
+);
+
+CREATE TABLE FeedTokens (
+    acct_name VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (acct_name),
+    UNIQUE KEY (token_hash)
+);
+
+CREATE TABLE CalDAVTokens (
+    acct_name VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (acct_name),
+    UNIQUE KEY (token_hash)
+);
+
+CREATE TABLE InboundTokens (
+    acct_name VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (acct_name),
+    UNIQUE KEY (token_hash)
+);
+
+CREATE TABLE Events (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    acct_name VARCHAR(255) NOT NULL,
+    event_type VARCHAR(16) NOT NULL,
+    todo MEDIUMTEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY (acct_name, id)
+);
+
+CREATE TABLE Webhooks (
+    id INT NOT NULL AUTO_INCREMENT,
+    acct_name VARCHAR(255) NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    event_types VARCHAR(64) NOT NULL DEFAULT '',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY (acct_name)
+);
+
+CREATE TABLE WebhookDeliveries (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    webhook_id INT NOT NULL,
+    acct_name VARCHAR(255) NOT NULL,
+    event_type VARCHAR(16) NOT NULL,
+    payload MEDIUMTEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME(3) NOT NULL,
+    response_code INT,
+    last_error VARCHAR(1024),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY (status, next_attempt_at),
+    KEY (status, updated_at),
+    KEY (webhook_id, id),
+    FOREIGN KEY (webhook_id) REFERENCES Webhooks (id) ON DELETE CASCADE
+);
+
+CREATE TABLE Outbox (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    acct_name VARCHAR(255) NOT NULL,
+    event_type VARCHAR(16) NOT NULL,
+    todo MEDIUMTEXT NOT NULL,
+    created_at DATETIME(6) NOT NULL,
+    delivered_at DATETIME(6),
+    PRIMARY KEY (id),
+    KEY (delivered_at, id)
+);
+
+CREATE TABLE UserSettings (
+    acct_name VARCHAR(255) NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    quiet_start CHAR(5) NOT NULL DEFAULT '',
+    quiet_end CHAR(5) NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    digest BOOLEAN NOT NULL DEFAULT FALSE,
+    digest_time CHAR(5) NOT NULL DEFAULT '08:00',
+    digest_priority INT NOT NULL DEFAULT 1,
+    PRIMARY KEY (acct_name),
+    KEY (digest)
+);
+
+CREATE TABLE Reminders (
+    todo_id INT NOT NULL,
+    fire_at DATETIME NOT NULL,
+    acct_name VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    claimed_at DATETIME(3) NOT NULL,
+    sent_at DATETIME NULL,
+    last_error VARCHAR(1024),
+    PRIMARY KEY (todo_id, fire_at)
+);
+
+CREATE TABLE Digests (
+    acct_name VARCHAR(255) NOT NULL,
+    digest_date DATE NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    claimed_at DATETIME(3) NOT NULL,
+    sent_at DATETIME NULL,
+    last_error VARCHAR(1024),
+    PRIMARY KEY (acct_name, digest_date)
 );